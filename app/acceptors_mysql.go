@@ -1,123 +1,292 @@
 package app
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"time"
 )
 
+//acceptorColumns are read from acceptorsWithPersons, the name belongs to the person
+const acceptorColumns = `acceptors.id, personId, name, lastName, bloodGroup, city, bloodCenter, regDate, urgent`
+
+const acceptorsWithPersons = `acceptors JOIN persons ON persons.id=acceptors.personId`
+
 //AcceptorsMySQL mysql repo
 type AcceptorsMySQL struct {
-	db *sql.DB
+	db         *sql.DB
+	cluster    *DBCluster
+	statements *statements
+
+	savePerson         *stmt
+	createPerson       *stmt
+	create             *stmt
+	getAll             *stmt
+	getPage            *stmt
+	getMatching        *stmt
+	getByID            *stmt
+	getByPersonID      *stmt
+	getForUpdate       *stmt
+	update             *stmt
+	getByBloodGroup    *stmt
+	countByBloodGroup  *stmt
+	deleteByID         *stmt
+	deleteOrphanPerson *stmt
+	appendEvent        *stmt
 }
 
-//NewAcceptorsMySQL create new repository
-func NewAcceptorsMySQL(db *sql.DB) *AcceptorsMySQL {
-	return &AcceptorsMySQL{
-		db: db,
+//NewAcceptorsMySQL create new repository, preparing its statements on the primary
+func NewAcceptorsMySQL(cluster *DBCluster) (*AcceptorsMySQL, error) {
+	s := newStatements(cluster.Primary())
+	r := &AcceptorsMySQL{
+		db:         cluster.Primary(),
+		cluster:    cluster,
+		statements: s,
+
+		savePerson: s.prepare(`INSERT INTO persons (id, name, lastName, createdAt) VALUES (?,?,?,?)
+		ON DUPLICATE KEY UPDATE name=VALUES(name), lastName=VALUES(lastName);`),
+		createPerson: s.prepare(`INSERT IGNORE INTO persons (id, name, lastName, createdAt) VALUES (?,?,?,?);`),
+		create: s.prepare(`INSERT INTO acceptors (id, personId, bloodGroup, city, bloodCenter, regDate, urgent)
+		VALUES (?,?,?,?,?,?,?);`),
+		getAll:  s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + `;`),
+		getPage: s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + ` ORDER BY regDate, acceptors.id LIMIT ? OFFSET ?;`),
+		getMatching: s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + `
+		WHERE (name LIKE ? OR lastName LIKE ? OR city LIKE ? OR bloodCenter LIKE ? OR name LIKE ? OR lastName LIKE ? OR city LIKE ? OR bloodCenter LIKE ?) LIMIT ?;`),
+		getByID:            s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + ` WHERE acceptors.id=?`),
+		getByPersonID:      s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + ` WHERE personId=?`),
+		getForUpdate:       s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + ` WHERE acceptors.id=? FOR UPDATE`),
+		update:             s.prepare(`UPDATE acceptors SET city=?,bloodCenter=?,urgent=? WHERE id=?;`),
+		getByBloodGroup:    s.prepare(`SELECT ` + acceptorColumns + ` FROM ` + acceptorsWithPersons + ` WHERE bloodGroup=?`),
+		countByBloodGroup:  s.prepare(`SELECT bloodGroup, COUNT(*) FROM acceptors GROUP BY bloodGroup`),
+		appendEvent:        s.prepare(appendEventSQL),
+		deleteByID:         s.prepare(`DELETE FROM acceptors WHERE id=?`),
+		deleteOrphanPerson: s.prepare(deleteOrphanPersonSQL),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
 	}
+	return r, nil
 }
 
-//Create new acceptor
-func (r *AcceptorsMySQL) Create(acceptor Acceptor) error {
-	stmt, err := r.db.Prepare(
-		`INSERT INTO acceptors (id, name, lastName, bloodGroup, city, bloodCenter, regDate)
-		VALUES (?,?,?,?,?,?,?);`)
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(acceptor.ID, acceptor.FirstName, acceptor.LastName, acceptor.BloodGroup, acceptor.City, acceptor.BloodCenter, acceptor.RegistrationDate)
+//Close the prepared statements of the repository
+func (r *AcceptorsMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//scanAcceptor reads a single acceptor row selected with acceptorColumns
+func scanAcceptor(row rowScanner) (Acceptor, error) {
+	acceptor := Acceptor{}
+	err := row.Scan(
+		&acceptor.ID,
+		&acceptor.PersonID,
+		&acceptor.FirstName,
+		&acceptor.LastName,
+		&acceptor.BloodGroup,
+		&acceptor.City,
+		&acceptor.BloodCenter,
+		&acceptor.RegistrationDate,
+		&acceptor.Urgent)
+
+	return acceptor, err
+}
+
+//savePersonOf updates the name of the person of the acceptor, creating the person when it is missing
+func (r *AcceptorsMySQL) savePersonOf(ctx context.Context, acceptor Acceptor) error {
+	_, err := r.savePerson.exec(ctx, acceptor.PersonID, acceptor.FirstName, acceptor.LastName, acceptor.RegistrationDate)
 	return err
 }
 
+//Create new acceptor, as a role of the person with acceptor.PersonID which is created when it does not exist
+//and left unchanged when it does. A person already holding the role is refused with a duplicate key error.
+func (r *AcceptorsMySQL) Create(ctx context.Context, acceptor Acceptor) (err error) {
+	defer observeRepo("AcceptorsMySQL", "Create", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		_, err := r.createPerson.exec(ctx, acceptor.PersonID, acceptor.FirstName, acceptor.LastName, acceptor.RegistrationDate)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.create.exec(ctx,
+			acceptor.ID, acceptor.PersonID, acceptor.BloodGroup, acceptor.City, acceptor.BloodCenter, acceptor.RegistrationDate, acceptor.Urgent)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, AcceptorRegistered, acceptorAggregate, acceptor.ID, acceptor)
+	})
+}
+
 //GetAll acceptors
-func (r *AcceptorsMySQL) GetAll() ([]Acceptor, error) {
+func (r *AcceptorsMySQL) GetAll(ctx context.Context) (_ []Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetAll", time.Now(), &err)
 	acceptors := make([]Acceptor, 0)
-	rows, err := r.db.Query(`SELECT * FROM acceptors;`)
+	rows, err := r.cluster.queryReplica(ctx, r.getAll)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "AcceptorsMySQL.GetAll failed", err)
+		return acceptors, err
 	}
 	defer rows.Close()
 
-	acceptorsCount := 0
 	for rows.Next() {
-		var id, name, lastname, bloodGroup, city, bloodCenter, regDate string
-		err := rows.Scan(&id, &name, &lastname, &bloodGroup, &city, &bloodCenter, &regDate)
+		acceptor, err := scanAcceptor(rows)
 		if err != nil {
-			log.Printf(err.Error())
+			logError(ctx, "AcceptorsMySQL.GetAll failed", err)
 			return acceptors, err
 		}
 
-		acceptors = append(acceptors, Acceptor{
-			ID:               id,
-			FirstName:        name,
-			LastName:         lastname,
-			BloodGroup:       bloodGroup,
-			City:             city,
-			BloodCenter:      bloodCenter,
-			RegistrationDate: regDate})
-		acceptorsCount++
+		acceptors = append(acceptors, acceptor)
 	}
 
-	return acceptors, nil
+	return acceptors, rows.Err()
+}
+
+//GetMatching at most limit acceptors whose name, city or blood center is LIKE pattern or LIKE spelling, the
+//pattern written in the other alphabet
+func (r *AcceptorsMySQL) GetMatching(ctx context.Context, pattern, spelling string, limit int) (_ []Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetMatching", time.Now(), &err)
+	acceptors := make([]Acceptor, 0)
+	rows, err := r.cluster.queryReplica(ctx, r.getMatching, pattern, pattern, pattern, pattern, spelling, spelling, spelling, spelling, limit)
+	if err != nil {
+		logError(ctx, "AcceptorsMySQL.GetMatching failed", err)
+		return acceptors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		acceptor, err := scanAcceptor(rows)
+		if err != nil {
+			return acceptors, err
+		}
+
+		acceptors = append(acceptors, acceptor)
+	}
+
+	return acceptors, rows.Err()
+}
+
+//GetPage acceptors ordered by registration, at most limit of them starting at offset
+func (r *AcceptorsMySQL) GetPage(ctx context.Context, limit, offset int) (_ []Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetPage", time.Now(), &err)
+	acceptors := make([]Acceptor, 0)
+	rows, err := r.cluster.queryReplica(ctx, r.getPage, limit, offset)
+	if err != nil {
+		logError(ctx, "AcceptorsMySQL.GetPage failed", err)
+		return acceptors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		acceptor, err := scanAcceptor(rows)
+		if err != nil {
+			return acceptors, err
+		}
+
+		acceptors = append(acceptors, acceptor)
+	}
+
+	return acceptors, rows.Err()
 }
 
 //GetByID Retrieve an acceptor by Id
-func (r *AcceptorsMySQL) GetByID(id string) (Acceptor, error) {
-	acceptor := Acceptor{}
-	err := r.db.QueryRow(`SELECT * FROM acceptors WHERE id=?`, id).Scan(
-		&acceptor.ID,
-		&acceptor.FirstName,
-		&acceptor.LastName,
-		&acceptor.BloodGroup,
-		&acceptor.City,
-		&acceptor.BloodCenter,
-		&acceptor.RegistrationDate)
+func (r *AcceptorsMySQL) GetByID(ctx context.Context, id string) (_ Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetByID", time.Now(), &err)
+	return scanAcceptor(r.getByID.queryRow(ctx, id))
+}
 
-	return acceptor, err
+//GetByPersonID Retrieve the acceptor role of a person
+func (r *AcceptorsMySQL) GetByPersonID(ctx context.Context, personID string) (_ Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetByPersonID", time.Now(), &err)
+	return scanAcceptor(r.getByPersonID.queryRow(ctx, personID))
 }
 
-//Update acceptor by ID
-func (r *AcceptorsMySQL) Update(acceptor Acceptor) error {
-	stmt, err := r.db.Prepare(`UPDATE acceptors SET name=?,lastName=?,city=?,bloodCenter=? WHERE id=?;`)
+//Update acceptor by ID with the name of its person, which callers leave unchanged for persons holding the donor role
+func (r *AcceptorsMySQL) Update(ctx context.Context, acceptor Acceptor) (_ Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "Update", time.Now(), &err)
+	err = WithTx(ctx, r.db, func(ctx context.Context) error {
+		if err := r.savePersonOf(ctx, acceptor); err != nil {
+			return err
+		}
+
+		_, err := r.update.exec(ctx,
+			acceptor.City, acceptor.BloodCenter, acceptor.Urgent, acceptor.ID)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, AcceptorUpdated, acceptorAggregate, acceptor.ID, acceptor)
+	})
 	if err != nil {
-		return err
+		logError(ctx, "AcceptorsMySQL.Update failed", err)
 	}
 
-	_, err = stmt.Exec(acceptor.FirstName, acceptor.LastName, acceptor.City, acceptor.BloodCenter, acceptor.ID)
-	return err
+	return acceptor, err
 }
 
 //GetByBloodGroup search for acceptors with specific blood group
-func (r *AcceptorsMySQL) GetByBloodGroup(bloodGroup string) ([]Acceptor, error) {
+func (r *AcceptorsMySQL) GetByBloodGroup(ctx context.Context, bloodGroup string) (_ []Acceptor, err error) {
+	defer observeRepo("AcceptorsMySQL", "GetByBloodGroup", time.Now(), &err)
 	acceptors := make([]Acceptor, 0)
-	rows, err := r.db.Query(`SELECT * FROM acceptors WHERE bloodGroup=?`, bloodGroup)
+	rows, err := r.cluster.queryReplica(ctx, r.getByBloodGroup, bloodGroup)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "AcceptorsMySQL.GetByBloodGroup failed", err)
+		return acceptors, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id, name, lastname, bloodGroup, city, bloodCenter, regDate string
-		err := rows.Scan(&id, &name, &lastname, &bloodGroup, &city, &bloodCenter, &regDate)
+		acceptor, err := scanAcceptor(rows)
 		if err != nil {
-			log.Printf(err.Error())
+			logError(ctx, "AcceptorsMySQL.GetByBloodGroup failed", err)
+			return acceptors, err
 		}
 
-		acceptors = append(acceptors, Acceptor{
-			ID:               id,
-			FirstName:        name,
-			LastName:         lastname,
-			BloodGroup:       bloodGroup,
-			City:             city,
-			BloodCenter:      bloodCenter,
-			RegistrationDate: regDate})
+		acceptors = append(acceptors, acceptor)
 	}
 
-	return acceptors, err
+	return acceptors, rows.Err()
 }
 
-//DeleteByID check whether donor exists and remove
-func (r *AcceptorsMySQL) DeleteByID(id string) error {
-	_, err := r.db.Query(`DELETE FROM acceptors WHERE id=?`, id)
-	return err
+//CountByBloodGroup number of acceptors per blood group
+func (r *AcceptorsMySQL) CountByBloodGroup(ctx context.Context) (_ map[string]int, err error) {
+	defer observeRepo("AcceptorsMySQL", "CountByBloodGroup", time.Now(), &err)
+	counts := map[string]int{}
+	rows, err := r.cluster.queryReplica(ctx, r.countByBloodGroup)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bloodGroup sql.NullString
+		var count int
+		if err := rows.Scan(&bloodGroup, &count); err != nil {
+			return counts, err
+		}
+		counts[bloodGroup.String] += count
+	}
+
+	return counts, rows.Err()
+}
+
+//DeleteByID check whether acceptor exists and remove, with its person unless it is also a donor
+func (r *AcceptorsMySQL) DeleteByID(ctx context.Context, id string) (err error) {
+	defer observeRepo("AcceptorsMySQL", "DeleteByID", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		// the deleted acceptor is published so consumers can tell which blood center it belonged to
+		acceptor, err := scanAcceptor(r.getForUpdate.queryRow(ctx, id))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.deleteByID.exec(ctx, id); err != nil {
+			return err
+		}
+		if err := deleteOrphanPerson(ctx, r.deleteOrphanPerson, acceptor.PersonID); err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, AcceptorDeleted, acceptorAggregate, id, acceptor)
+	})
 }
@@ -1,26 +1,202 @@
 package app
 
-// Donor is a struct used to represent the first account type in LifeBlood system - blood donors
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// formatTimestamp renders the times stored as text with events and webhooks, RFC 3339 in UTC so they
+// read the same on every host
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// dateLayout is the format of calendar days, the full-date of RFC 3339
+const dateLayout = "2006-01-02"
+
+// Roles a person can hold
+const (
+	RoleDonor    = "donor"
+	RoleAcceptor = "acceptor"
+)
+
+// Person is the identity of one human, shared by the donor and the acceptor account it may hold
+type Person struct {
+	ID          string    `json:"id"`
+	FirstName   string    `json:"name"`
+	LastName    string    `json:"lastName"`
+	PhoneNumber string    `json:"phone"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// PersonAccounts is a person with the accounts of the roles it holds
+type PersonAccounts struct {
+	Person
+	Roles    []string  `json:"roles"`
+	Donor    *Donor    `json:"donor,omitempty"`
+	Acceptor *Acceptor `json:"acceptor,omitempty"`
+}
+
+// Donor is a struct used to represent the first account type in LifeBlood system - blood donors.
+// The name, phone and e-mail belong to the person holding the account, see Person.
+// The age is not stored, it is computed from DateOfBirth whenever a donor is written as JSON.
 type Donor struct {
-	ID               string `json:"id"`
-	FirstName        string `json:"name"`
-	LastName         string `json:"lastName"`
-	PhoneNumber      string `json:"phone"`
-	Email            string `json:"email"`
-	Age              string `json:"age"`
-	Gender           string `json:"gender"`
-	BloodGroup       string `json:"bloodGroup"`
-	City             string `json:"city"`
-	RegistrationDate string `json:"regDate"`
-}
-
-// Acceptor is a struct used to represent the second account type in LifeBlood system - blood acceptors
+	ID                 string     `json:"id"`
+	PersonID           string     `json:"personId"`
+	FirstName          string     `json:"name"`
+	LastName           string     `json:"lastName"`
+	PhoneNumber        string     `json:"phone"`
+	Email              string     `json:"email"`
+	DateOfBirth        *Date      `json:"dateOfBirth,omitempty"`
+	Gender             string     `json:"gender"`
+	BloodGroup         string     `json:"bloodGroup"`
+	City               string     `json:"city"`
+	RegistrationDate   time.Time  `json:"regDate"`
+	EmailVerified      bool       `json:"emailVerified"`
+	EmailVerifiedAt    *time.Time `json:"emailVerifiedAt,omitempty"`
+	PhoneVerified      bool       `json:"phoneVerified"`
+	PhoneVerifiedAt    *time.Time `json:"phoneVerifiedAt,omitempty"`
+	NotificationsOptIn bool       `json:"notificationsOptIn"`
+}
+
+// Age of the donor in whole years at the given time, false when the date of birth is unknown
+func (d Donor) Age(at time.Time) (int, bool) {
+	if d.DateOfBirth == nil {
+		return 0, false
+	}
+
+	birth := d.DateOfBirth.Time
+	at = at.In(time.UTC)
+	age := at.Year() - birth.Year()
+	if at.Month() < birth.Month() || (at.Month() == birth.Month() && at.Day() < birth.Day()) {
+		age--
+	}
+	return age, true
+}
+
+// MarshalJSON adds the age computed from the date of birth
+func (d Donor) MarshalJSON() ([]byte, error) {
+	type donor Donor
+	out := struct {
+		donor
+		Age *int `json:"age,omitempty"`
+	}{donor: donor(d)}
+	if age, ok := d.Age(time.Now()); ok {
+		out.Age = &age
+	}
+	return json.Marshal(out)
+}
+
+// Acceptor is a struct used to represent the second account type in LifeBlood system - blood acceptors.
+// The name belongs to the person holding the account, see Person.
 type Acceptor struct {
-	ID               string `json:"id"`
-	FirstName        string `json:"name"`
-	LastName         string `json:"lastName"`
-	BloodGroup       string `json:"bloodGroup"`
-	City             string `json:"city"`
-	BloodCenter      string `json:"bloodCenter"`
-	RegistrationDate string `json:"regDate"`
+	ID               string    `json:"id"`
+	PersonID         string    `json:"personId"`
+	FirstName        string    `json:"name"`
+	LastName         string    `json:"lastName"`
+	BloodGroup       string    `json:"bloodGroup"`
+	City             string    `json:"city"`
+	BloodCenter      string    `json:"bloodCenter"`
+	RegistrationDate time.Time `json:"regDate"`
+	Urgent           bool      `json:"urgent"`
+}
+
+// AttachTo makes the donor a role of the person, taking over its identity
+func (d *Donor) AttachTo(person Person) {
+	d.PersonID = person.ID
+	d.FirstName = person.FirstName
+	d.LastName = person.LastName
+	d.PhoneNumber = person.PhoneNumber
+	d.Email = person.Email
+}
+
+// AttachTo makes the acceptor a role of the person, taking over its name
+func (a *Acceptor) AttachTo(person Person) {
+	a.PersonID = person.ID
+	a.FirstName = person.FirstName
+	a.LastName = person.LastName
+}
+
+// Date is a calendar day without a time zone, written as 2006-01-02 and stored in DATE columns
+type Date struct {
+	time.Time
+}
+
+// ParseDate reads a day written as 2006-01-02
+func ParseDate(value string) (Date, error) {
+	day, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return Date{}, fmt.Errorf("%q is not a date such as 1990-04-21", value)
+	}
+	return Date{day}, nil
+}
+
+func (d Date) String() string {
+	return d.Format(dateLayout)
+}
+
+// MarshalJSON writes the day as a string
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a day written as 2006-01-02
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Scan reads a DATE column, scanned as time.Time with parseTime or as text otherwise
+func (d *Date) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*d = Date{time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC)}
+		return nil
+	case []byte:
+		parsed, err := ParseDate(string(v))
+		*d = parsed
+		return err
+	case string:
+		parsed, err := ParseDate(v)
+		*d = parsed
+		return err
+	default:
+		return fmt.Errorf("cannot scan %T into a date", value)
+	}
+}
+
+// Value stores the day as text, so no time zone conversion of the driver can move it to another day
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// ParseDateOfBirth reads a date of birth, which must lie in the past and within a human lifetime
+func ParseDateOfBirth(value string, now time.Time) (*Date, error) {
+	day, err := ParseDate(value)
+	if err != nil {
+		return nil, fmt.Errorf("dateOfBirth must be a date such as 1990-04-21")
+	}
+	if !day.Before(now) || day.Before(now.AddDate(-maxLifetime, 0, 0)) {
+		return nil, fmt.Errorf("dateOfBirth must lie in the past %d years", maxLifetime)
+	}
+	return &day, nil
+}
+
+// maxLifetime bounds dates of birth, in years
+const maxLifetime = 120
+
+// nowUTC is the current time as stored with accounts: UTC, whole seconds like DATETIME columns
+func nowUTC() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
 }
@@ -0,0 +1,456 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lithammer/shortuuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned when no donor has the e-mail address with the password
+	ErrInvalidCredentials = errors.New("invalid e-mail or password")
+	// ErrNoVerifiedDonor is returned when registering an e-mail address no donor has verified
+	ErrNoVerifiedDonor = errors.New("no donor has verified this e-mail address")
+	// ErrAmbiguousEmail is returned when registering an e-mail address several donors have verified
+	ErrAmbiguousEmail = errors.New("several donors have verified this e-mail address")
+	// ErrAlreadyRegistered is returned when the donor already has a password
+	ErrAlreadyRegistered = errors.New("the donor already has a password")
+	// ErrInvalidToken is returned for unknown, expired and logged out tokens
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrTokenReused is returned when a refresh token is exchanged a second time, which revokes its session
+	ErrTokenReused = errors.New("refresh token was already used, the session is revoked")
+)
+
+const (
+	// MinPasswordLength is the lowest minimum length the password policy may be configured with
+	MinPasswordLength = 8
+	// maxPasswordBytes is the part of a password bcrypt takes into account, longer ones are refused
+	maxPasswordBytes = 72
+	// MinBcryptCost and MaxBcryptCost bound the work factor between too weak and too slow for a login
+	MinBcryptCost = 10
+	MaxBcryptCost = 15
+	// tokenBytes of randomness in every access, refresh and registration token
+	tokenBytes = 32
+)
+
+// commonPasswords are refused whatever the policy, they are the first ones tried by attackers
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true, "qwerty": true, "qwerty123": true,
+	"qwertyuiop": true, "123456": true, "12345678": true, "123456789": true, "1234567890": true, "0123456789": true,
+	"111111": true, "11111111": true, "iloveyou": true, "letmein": true, "welcome": true, "welcome1": true,
+	"admin": true, "abc123": true, "monkey": true, "dragon": true, "football": true, "sunshine": true,
+	"lifeblood": true, "lifeblood1": true,
+}
+
+// PasswordPolicyError tells why a password was refused
+type PasswordPolicyError struct {
+	Problem string
+}
+
+func (e PasswordPolicyError) Error() string {
+	return "password " + e.Problem
+}
+
+// TokenPair is the answer to a registration, login or refresh. The access token identifies the donor for
+// ExpiresIn seconds, the refresh token can be exchanged once for a new pair.
+type TokenPair struct {
+	DonorID      string `json:"donorId"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// Authenticator keeps donor passwords as bcrypt hashes and opens sessions for them. A password is only set
+// with a registration token mailed to the verified e-mail address of the donor. Tokens are random and
+// only their SHA-256 hashes are stored, every refresh replaces both tokens of the session and a refresh
+// token exchanged twice revokes the session, as one of the two parties holding it is not the donor.
+type Authenticator struct {
+	repo            *AuthMySQL
+	accessTTL       time.Duration
+	refreshTTL      time.Duration
+	registrationTTL time.Duration
+	minLength       int
+	cost            int
+	now             func() time.Time
+
+	dummyOnce sync.Once
+	dummyHash []byte
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewAuthenticator creates the service, access tokens are valid for accessTTL, refresh tokens for refreshTTL
+// and registration tokens for registrationTTL after they were issued. Passwords must have minLength
+// characters and are hashed with the bcrypt cost.
+func NewAuthenticator(repo *AuthMySQL, accessTTL, refreshTTL, registrationTTL time.Duration, minLength, cost int) *Authenticator {
+	return &Authenticator{
+		repo:            repo,
+		accessTTL:       accessTTL,
+		refreshTTL:      refreshTTL,
+		registrationTTL: registrationTTL,
+		minLength:       minLength,
+		cost:            cost,
+		now:             nowUTC,
+	}
+}
+
+// CheckPassword applies the password policy: a minimum length, the bcrypt limit of 72 bytes, and neither
+// a common password nor one made of the e-mail address
+func (a *Authenticator) CheckPassword(password, email string) error {
+	if utf8.RuneCountInString(password) < a.minLength {
+		return PasswordPolicyError{Problem: "must have at least " + strconv.Itoa(a.minLength) + " characters"}
+	}
+	if len(password) > maxPasswordBytes {
+		return PasswordPolicyError{Problem: "must not be longer than " + strconv.Itoa(maxPasswordBytes) + " bytes"}
+	}
+	folded := strings.ToLower(password)
+	first, _ := utf8.DecodeRuneInString(folded)
+	if commonPasswords[folded] || strings.Trim(folded, string(first)) == "" {
+		return PasswordPolicyError{Problem: "is too easy to guess"}
+	}
+	if local := strings.ToLower(strings.SplitN(email, "@", 2)[0]); len(local) >= 3 && strings.Contains(folded, local) {
+		return PasswordPolicyError{Problem: "must not contain the e-mail address"}
+	}
+	return nil
+}
+
+// StartRegistration creates a single-use token for the donor who verified the e-mail address, replacing any
+// earlier one. The token is to be mailed to the address, only ConfirmRegistration with it sets the password.
+func (a *Authenticator) StartRegistration(ctx context.Context, email string) (donorID, token string, err error) {
+	ids, err := a.repo.GetVerifiedDonorIDs(ctx, email)
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case len(ids) == 0:
+		return "", "", ErrNoVerifiedDonor
+	case len(ids) > 1:
+		return "", "", ErrAmbiguousEmail
+	}
+	credentials, err := a.repo.GetCredentialsByEmail(ctx, email)
+	if err != nil {
+		return "", "", err
+	}
+	for _, c := range credentials {
+		if c.DonorID == ids[0] {
+			return "", "", ErrAlreadyRegistered
+		}
+	}
+
+	token, err = newSecret()
+	if err != nil {
+		return "", "", err
+	}
+	now := a.now()
+	err = WithTx(ctx, a.repo.db, func(ctx context.Context) error {
+		if err := a.repo.DeleteRegistrationTokens(ctx, ids[0]); err != nil {
+			return err
+		}
+		return a.repo.CreateRegistrationToken(ctx, RegistrationToken{
+			TokenHash: hashToken(token),
+			DonorID:   ids[0],
+			Email:     email,
+			CreatedAt: now,
+			ExpiresAt: now.Add(a.registrationTTL),
+		})
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return ids[0], token, nil
+}
+
+// ConfirmRegistration redeems a registration token: it sets the password of its donor, deletes the token
+// and logs the donor in
+func (a *Authenticator) ConfirmRegistration(ctx context.Context, token, password string) (TokenPair, error) {
+	now := a.now()
+	a.sweep(ctx, now)
+
+	var pair TokenPair
+	err := WithTx(ctx, a.repo.db, func(ctx context.Context) error {
+		stored, err := a.repo.GetRegistrationToken(ctx, hashToken(token))
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		if !now.Before(stored.ExpiresAt) {
+			return ErrInvalidToken
+		}
+		if err := a.CheckPassword(password, stored.Email); err != nil {
+			return err
+		}
+		// the donor may have changed the address, or another donor verified it, since the token was mailed
+		ids, err := a.repo.GetVerifiedDonorIDs(ctx, stored.Email)
+		if err != nil {
+			return err
+		}
+		if len(ids) != 1 || ids[0] != stored.DonorID {
+			return ErrInvalidToken
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), a.cost)
+		if err != nil {
+			return err
+		}
+		created, err := a.repo.CreateCredentials(ctx, Credentials{DonorID: stored.DonorID, PasswordHash: string(hash)}, now)
+		if err != nil {
+			return err
+		}
+		if !created {
+			return ErrAlreadyRegistered
+		}
+		if err := a.repo.DeleteRegistrationTokens(ctx, stored.DonorID); err != nil {
+			return err
+		}
+		pair, err = a.open(ctx, stored.DonorID)
+		return err
+	})
+	return pair, err
+}
+
+// Login opens a session for the donor with the verified e-mail address and the password
+func (a *Authenticator) Login(ctx context.Context, email, password string) (TokenPair, error) {
+	credentials, err := a.repo.GetCredentialsByEmail(ctx, email)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if len(credentials) == 0 {
+		// an unknown address takes as long as a wrong password, so it cannot be told apart
+		bcrypt.CompareHashAndPassword(a.dummy(), []byte(password))
+		return TokenPair{}, ErrInvalidCredentials
+	}
+
+	for _, c := range credentials {
+		if bcrypt.CompareHashAndPassword([]byte(c.PasswordHash), []byte(password)) == nil {
+			return a.open(ctx, c.DonorID)
+		}
+	}
+	return TokenPair{}, ErrInvalidCredentials
+}
+
+// Refresh exchanges a refresh token for a new token pair of the same session
+func (a *Authenticator) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	now := a.now()
+	a.sweep(ctx, now)
+
+	var pair TokenPair
+	var revoked string
+	err := WithTx(ctx, a.repo.db, func(ctx context.Context) error {
+		stored, err := a.repo.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		if stored.UsedAt != nil {
+			revoked = stored.SessionID
+			return a.repo.DeleteSession(ctx, stored.SessionID)
+		}
+		if !now.Before(stored.ExpiresAt) {
+			return ErrInvalidToken
+		}
+		session, err := a.repo.GetSessionByID(ctx, stored.SessionID)
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := a.repo.MarkRefreshTokenUsed(ctx, stored.TokenHash, now); err != nil {
+			return err
+		}
+		pair, err = a.issue(ctx, session, now)
+		return err
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if revoked != "" {
+		logWarn(ctx, "refresh token reused, session revoked", Fields{"sessionId": revoked})
+		return TokenPair{}, ErrTokenReused
+	}
+	return pair, nil
+}
+
+// Authenticate returns the session of a valid access token
+func (a *Authenticator) Authenticate(ctx context.Context, accessToken string) (Session, error) {
+	session, err := a.sessionOf(ctx, accessToken)
+	if err != nil {
+		return Session{}, err
+	}
+	if !a.now().Before(session.AccessExpiresAt) {
+		return Session{}, ErrInvalidToken
+	}
+	return session, nil
+}
+
+// Logout ends the session of an access or refresh token, an expired access token still ends its session
+func (a *Authenticator) Logout(ctx context.Context, token string) error {
+	session, err := a.sessionOf(ctx, token)
+	if err == ErrInvalidToken {
+		refresh, refreshErr := a.repo.GetRefreshToken(ctx, hashToken(token))
+		if refreshErr == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		if refreshErr != nil {
+			return refreshErr
+		}
+		session.ID, err = refresh.SessionID, nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.repo.DeleteSession(ctx, session.ID)
+}
+
+// sessionOf finds the session an access token was issued for, whether the token expired or not
+func (a *Authenticator) sessionOf(ctx context.Context, accessToken string) (Session, error) {
+	parts := strings.SplitN(accessToken, ".", 2)
+	if len(parts) != 2 {
+		return Session{}, ErrInvalidToken
+	}
+	session, err := a.repo.GetSessionByID(ctx, parts[0])
+	if err == sql.ErrNoRows {
+		return Session{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.AccessHash), []byte(hashToken(accessToken))) != 1 {
+		return Session{}, ErrInvalidToken
+	}
+	return session, nil
+}
+
+// open starts a session of the donor
+func (a *Authenticator) open(ctx context.Context, donorID string) (TokenPair, error) {
+	now := a.now()
+	session := Session{ID: shortuuid.New(), DonorID: donorID, CreatedAt: now}
+	access, err := newToken(session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := newToken(session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	session.AccessHash = hashToken(access)
+	session.AccessExpiresAt = now.Add(a.accessTTL)
+
+	err = a.repo.CreateSession(ctx, session, RefreshToken{
+		TokenHash: hashToken(refresh),
+		SessionID: session.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.refreshTTL),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return a.pair(donorID, access, refresh), nil
+}
+
+// issue replaces the access token of the session and adds a new refresh token
+func (a *Authenticator) issue(ctx context.Context, session Session, now time.Time) (TokenPair, error) {
+	access, err := newToken(session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := newToken(session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := a.repo.UpdateAccess(ctx, session.ID, hashToken(access), now.Add(a.accessTTL)); err != nil {
+		return TokenPair{}, err
+	}
+	err = a.repo.CreateRefreshToken(ctx, RefreshToken{
+		TokenHash: hashToken(refresh),
+		SessionID: session.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.refreshTTL),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return a.pair(session.DonorID, access, refresh), nil
+}
+
+func (a *Authenticator) pair(donorID, access, refresh string) TokenPair {
+	return TokenPair{
+		DonorID:      donorID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(a.accessTTL / time.Second),
+	}
+}
+
+// dummy is a hash of the configured cost compared against when the e-mail address is unknown
+func (a *Authenticator) dummy() []byte {
+	a.dummyOnce.Do(func() {
+		a.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password of anyone"), a.cost)
+	})
+	return a.dummyHash
+}
+
+// sweep deletes the expired refresh and registration tokens and sessions, at most once a minute
+func (a *Authenticator) sweep(ctx context.Context, now time.Time) {
+	a.mu.Lock()
+	if now.Sub(a.lastSweep) < time.Minute {
+		a.mu.Unlock()
+		return
+	}
+	a.lastSweep = now
+	a.mu.Unlock()
+
+	deleted, err := a.repo.DeleteExpired(ctx, now)
+	if err != nil {
+		logError(ctx, "could not delete expired sessions", err)
+		return
+	}
+	if deleted > 0 {
+		logInfo(ctx, "expired sessions deleted", Fields{"count": deleted})
+	}
+}
+
+// newToken is the session id followed by random bytes, the id finds the session of an access token
+func newToken(sessionID string) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	return sessionID + "." + secret, nil
+}
+
+// newSecret is tokenBytes of randomness, encoded to be used in URLs
+func newSecret() (string, error) {
+	secret := make([]byte, tokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
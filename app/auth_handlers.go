@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// bearerToken is the token of the Authorization header, empty without one
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// readCredentials decodes the e-mail and password of a login, answering the request when
+// one is missing
+func readCredentials(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	reqData := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || reqData["email"] == "" || reqData["password"] == "" {
+		writeError(w, http.StatusBadRequest, "email and password are required")
+		return "", "", false
+	}
+	return strings.TrimSpace(reqData["email"]), reqData["password"], true
+}
+
+// register mails a registration token to the e-mail address when exactly one donor verified it and has no
+// password yet. The answer is the same either way, so it does not tell which addresses have accounts.
+func (app *App) register(w http.ResponseWriter, r *http.Request) {
+	reqData := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || strings.TrimSpace(reqData["email"]) == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+	email := strings.TrimSpace(reqData["email"])
+
+	donorID, token, err := app.Authenticator.StartRegistration(r.Context(), email)
+	switch err {
+	case nil:
+		app.sendRegistrationEmail(r.Context(), donorID, email, token)
+	case ErrNoVerifiedDonor, ErrAmbiguousEmail, ErrAlreadyRegistered:
+		logInfo(r.Context(), "registration not started", Fields{"reason": err.Error()})
+	default:
+		logError(r.Context(), "could not start registration", err)
+		writeError(w, http.StatusInternalServerError, "could not start registration")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmRegistration sets the password of the donor a registration token was mailed to
+func (app *App) confirmRegistration(w http.ResponseWriter, r *http.Request) {
+	reqData := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || reqData["token"] == "" || reqData["password"] == "" {
+		writeError(w, http.StatusBadRequest, "token and password are required")
+		return
+	}
+
+	pair, err := app.Authenticator.ConfirmRegistration(r.Context(), reqData["token"], reqData["password"])
+	if policyErr, ok := err.(PasswordPolicyError); ok {
+		writeError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+	switch err {
+	case nil:
+	case ErrInvalidToken:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case ErrAlreadyRegistered:
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		logError(r.Context(), "could not register password", err)
+		writeError(w, http.StatusInternalServerError, "could not register password")
+		return
+	}
+
+	logInfo(r.Context(), "donor password registered", Fields{"donorId": pair.DonorID})
+	writeJSON(w, http.StatusCreated, pair)
+}
+
+// sendRegistrationEmail mails the registration token, failures are logged only
+func (app *App) sendRegistrationEmail(ctx context.Context, donorID, email, token string) {
+	if app.Mailer == nil {
+		logWarn(ctx, "no mailer configured, registration token not sent", Fields{"donorId": donorID})
+		return
+	}
+
+	err := app.Mailer.Send(Message{
+		To:      email,
+		Subject: "Set the password of your LifeBlood account",
+		Body:    fmt.Sprintf("Hello,\r\n\r\nUse the code below once to set the password of your LifeBlood account:\r\n\r\n%s\r\n\r\nIf you did not ask for it, ignore this message.\r\n", token),
+	})
+	if err != nil {
+		DefaultLogger.Error(ctx, "sending registration email failed", Fields{"donorId": donorID, "error": err})
+	}
+}
+
+func (app *App) login(w http.ResponseWriter, r *http.Request) {
+	email, password, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	pair, err := app.Authenticator.Login(r.Context(), email, password)
+	if err == ErrInvalidCredentials {
+		logInfo(r.Context(), "login failed", nil)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not log in", err)
+		writeError(w, http.StatusInternalServerError, "could not log in")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+func (app *App) refresh(w http.ResponseWriter, r *http.Request) {
+	reqData := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || reqData["refreshToken"] == "" {
+		writeError(w, http.StatusBadRequest, "refreshToken is required")
+		return
+	}
+
+	pair, err := app.Authenticator.Refresh(r.Context(), reqData["refreshToken"])
+	switch err {
+	case nil:
+	case ErrInvalidToken, ErrTokenReused:
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	default:
+		logError(r.Context(), "could not refresh session", err)
+		writeError(w, http.StatusInternalServerError, "could not refresh session")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// logout ends the session of the bearer access token, or of the refresh token in the body when the
+// access token is gone
+func (app *App) logout(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		reqData := make(map[string]string)
+		json.NewDecoder(r.Body).Decode(&reqData)
+		token = reqData["refreshToken"]
+	}
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "an access or refresh token is required")
+		return
+	}
+
+	err := app.Authenticator.Logout(r.Context(), token)
+	if err == ErrInvalidToken {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not log out", err)
+		writeError(w, http.StatusInternalServerError, "could not log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCurrentDonor answers the donor the bearer access token was issued to
+func (app *App) getCurrentDonor(w http.ResponseWriter, r *http.Request) {
+	session, err := app.Authenticator.Authenticate(r.Context(), bearerToken(r))
+	if err == ErrInvalidToken {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not check access token", err)
+		writeError(w, http.StatusInternalServerError, "could not check access token")
+		return
+	}
+
+	donor, err := app.DonorsRepo.GetByID(r.Context(), session.DonorID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusUnauthorized, ErrInvalidToken.Error())
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, donor)
+}
+
+// requireDonor only lets through requests carrying an access token of the donor {id} of the path, or the
+// ADMIN_TOKEN
+func (app *App) requireDonor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isAdmin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if app.Authenticator == nil {
+			writeError(w, http.StatusUnauthorized, ErrInvalidToken.Error())
+			return
+		}
+
+		session, err := app.Authenticator.Authenticate(r.Context(), bearerToken(r))
+		if err == ErrInvalidToken {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			logError(r.Context(), "could not check access token", err)
+			writeError(w, http.StatusInternalServerError, "could not check access token")
+			return
+		}
+		if session.DonorID != mux.Vars(r)["id"] {
+			writeError(w, http.StatusForbidden, "the access token belongs to another donor")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
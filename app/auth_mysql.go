@@ -0,0 +1,281 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//deleteCredentialsSQL removes the password of a donor with its sessions, prepared by the donor repository
+//and run when the donor is deleted
+const deleteCredentialsSQL = `DELETE credentials, auth_sessions, auth_refresh_tokens FROM credentials
+		LEFT JOIN auth_sessions ON auth_sessions.donorId=credentials.donorId
+		LEFT JOIN auth_refresh_tokens ON auth_refresh_tokens.sessionId=auth_sessions.id
+		WHERE credentials.donorId=?;`
+
+//deleteRegistrationTokensSQL removes the registration tokens mailed to a donor, prepared by the donor repository too
+const deleteRegistrationTokensSQL = `DELETE FROM registration_tokens WHERE donorId=?;`
+
+//Credentials password hash of a donor, found by the verified e-mail of the donor
+type Credentials struct {
+	DonorID      string
+	PasswordHash string
+}
+
+//Session opened by a login, its access token is replaced on every refresh
+type Session struct {
+	ID              string
+	DonorID         string
+	AccessHash      string
+	AccessExpiresAt time.Time
+	CreatedAt       time.Time
+}
+
+//RefreshToken one-time token of a session, UsedAt is set once it was exchanged
+type RefreshToken struct {
+	TokenHash string
+	SessionID string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+//RegistrationToken single-use token mailed to the verified e-mail address of a donor, redeeming it sets the password
+type RegistrationToken struct {
+	TokenHash string
+	DonorID   string
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+//AuthMySQL mysql repo
+type AuthMySQL struct {
+	db         *sql.DB
+	statements *statements
+
+	getVerifiedDonorIDs   *stmt
+	getCredentialsByEmail *stmt
+	createCredentials     *stmt
+	createSession         *stmt
+	getSessionByID        *stmt
+	updateAccess          *stmt
+	deleteSession         *stmt
+	createRefreshToken    *stmt
+	getRefreshToken       *stmt
+	markRefreshTokenUsed  *stmt
+	deleteExpiredTokens   *stmt
+	deleteExpiredSessions *stmt
+
+	createRegistrationToken   *stmt
+	getRegistrationToken      *stmt
+	deleteRegistrationTokens  *stmt
+	deleteExpiredRegistration *stmt
+}
+
+//NewAuthMySQL create new repository, preparing its statements
+func NewAuthMySQL(db *sql.DB) (*AuthMySQL, error) {
+	s := newStatements(db)
+	r := &AuthMySQL{
+		db:         db,
+		statements: s,
+
+		getVerifiedDonorIDs: s.prepare(`SELECT donors.id FROM ` + donorsWithPersons + ` WHERE email=? AND emailVerified=true`),
+		getCredentialsByEmail: s.prepare(`SELECT credentials.donorId, passwordHash FROM credentials
+		JOIN donors ON donors.id=credentials.donorId JOIN persons ON persons.id=donors.personId
+		WHERE email=? AND emailVerified=true`),
+		createCredentials: s.prepare(`INSERT IGNORE INTO credentials (donorId, passwordHash, createdAt, updatedAt) VALUES (?,?,?,?);`),
+		createSession: s.prepare(`INSERT INTO auth_sessions (id, donorId, accessHash, accessExpiresAt, createdAt)
+		VALUES (?,?,?,?,?);`),
+		getSessionByID: s.prepare(`SELECT id, donorId, accessHash, accessExpiresAt, createdAt FROM auth_sessions WHERE id=?`),
+		updateAccess:   s.prepare(`UPDATE auth_sessions SET accessHash=?, accessExpiresAt=? WHERE id=?;`),
+		deleteSession: s.prepare(`DELETE auth_sessions, auth_refresh_tokens FROM auth_sessions
+		LEFT JOIN auth_refresh_tokens ON auth_refresh_tokens.sessionId=auth_sessions.id WHERE auth_sessions.id=?;`),
+		createRefreshToken: s.prepare(`INSERT INTO auth_refresh_tokens (tokenHash, sessionId, createdAt, expiresAt) VALUES (?,?,?,?);`),
+		getRefreshToken: s.prepare(`SELECT tokenHash, sessionId, createdAt, expiresAt, usedAt FROM auth_refresh_tokens
+		WHERE tokenHash=? FOR UPDATE`),
+		markRefreshTokenUsed: s.prepare(`UPDATE auth_refresh_tokens SET usedAt=? WHERE tokenHash=?;`),
+		deleteExpiredTokens:  s.prepare(`DELETE FROM auth_refresh_tokens WHERE expiresAt<=?;`),
+		deleteExpiredSessions: s.prepare(`DELETE FROM auth_sessions WHERE accessExpiresAt<=?
+		AND NOT EXISTS (SELECT 1 FROM auth_refresh_tokens WHERE auth_refresh_tokens.sessionId=auth_sessions.id);`),
+
+		createRegistrationToken: s.prepare(`INSERT INTO registration_tokens (tokenHash, donorId, email, createdAt, expiresAt)
+		VALUES (?,?,?,?,?);`),
+		getRegistrationToken: s.prepare(`SELECT tokenHash, donorId, email, createdAt, expiresAt FROM registration_tokens
+		WHERE tokenHash=? FOR UPDATE`),
+		deleteRegistrationTokens:  s.prepare(deleteRegistrationTokensSQL),
+		deleteExpiredRegistration: s.prepare(`DELETE FROM registration_tokens WHERE expiresAt<=?;`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *AuthMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//GetVerifiedDonorIDs Retrieve the donors having verified the e-mail address
+func (r *AuthMySQL) GetVerifiedDonorIDs(ctx context.Context, email string) (_ []string, err error) {
+	defer observeRepo("AuthMySQL", "GetVerifiedDonorIDs", time.Now(), &err)
+	ids := make([]string, 0)
+	rows, err := r.getVerifiedDonorIDs.query(ctx, email)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+//GetCredentialsByEmail Retrieve the passwords of the donors having verified the e-mail address
+func (r *AuthMySQL) GetCredentialsByEmail(ctx context.Context, email string) (_ []Credentials, err error) {
+	defer observeRepo("AuthMySQL", "GetCredentialsByEmail", time.Now(), &err)
+	credentials := make([]Credentials, 0)
+	rows, err := r.getCredentialsByEmail.query(ctx, email)
+	if err != nil {
+		return credentials, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Credentials{}
+		if err := rows.Scan(&c.DonorID, &c.PasswordHash); err != nil {
+			return credentials, err
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+//CreateCredentials stores the password of a donor, false when the donor already has one
+func (r *AuthMySQL) CreateCredentials(ctx context.Context, credentials Credentials, now time.Time) (_ bool, err error) {
+	defer observeRepo("AuthMySQL", "CreateCredentials", time.Now(), &err)
+	result, err := r.createCredentials.exec(ctx, credentials.DonorID, credentials.PasswordHash, now, now)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted == 1, err
+}
+
+//CreateSession stores a new session with its first refresh token
+func (r *AuthMySQL) CreateSession(ctx context.Context, session Session, token RefreshToken) (err error) {
+	defer observeRepo("AuthMySQL", "CreateSession", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		_, err := r.createSession.exec(ctx, session.ID, session.DonorID, session.AccessHash, session.AccessExpiresAt, session.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return r.CreateRefreshToken(ctx, token)
+	})
+}
+
+//GetSessionByID Retrieve a session by Id
+func (r *AuthMySQL) GetSessionByID(ctx context.Context, id string) (_ Session, err error) {
+	defer observeRepo("AuthMySQL", "GetSessionByID", time.Now(), &err)
+	session := Session{}
+	err = r.getSessionByID.queryRow(ctx, id).Scan(
+		&session.ID,
+		&session.DonorID,
+		&session.AccessHash,
+		&session.AccessExpiresAt,
+		&session.CreatedAt)
+
+	return session, err
+}
+
+//UpdateAccess replaces the access token of a session
+func (r *AuthMySQL) UpdateAccess(ctx context.Context, id, accessHash string, expiresAt time.Time) (err error) {
+	defer observeRepo("AuthMySQL", "UpdateAccess", time.Now(), &err)
+	_, err = r.updateAccess.exec(ctx, accessHash, expiresAt, id)
+	return err
+}
+
+//DeleteSession removes a session with all its refresh tokens
+func (r *AuthMySQL) DeleteSession(ctx context.Context, id string) (err error) {
+	defer observeRepo("AuthMySQL", "DeleteSession", time.Now(), &err)
+	_, err = r.deleteSession.exec(ctx, id)
+	return err
+}
+
+//CreateRefreshToken stores a refresh token of a session
+func (r *AuthMySQL) CreateRefreshToken(ctx context.Context, token RefreshToken) (err error) {
+	defer observeRepo("AuthMySQL", "CreateRefreshToken", time.Now(), &err)
+	_, err = r.createRefreshToken.exec(ctx, token.TokenHash, token.SessionID, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+//GetRefreshToken Retrieve a refresh token by its hash, locking it until the transaction ends
+func (r *AuthMySQL) GetRefreshToken(ctx context.Context, tokenHash string) (_ RefreshToken, err error) {
+	defer observeRepo("AuthMySQL", "GetRefreshToken", time.Now(), &err)
+	token := RefreshToken{}
+	err = r.getRefreshToken.queryRow(ctx, tokenHash).Scan(
+		&token.TokenHash,
+		&token.SessionID,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt)
+
+	return token, err
+}
+
+//MarkRefreshTokenUsed records the exchange of a refresh token, it stays stored to recognize its reuse
+func (r *AuthMySQL) MarkRefreshTokenUsed(ctx context.Context, tokenHash string, usedAt time.Time) (err error) {
+	defer observeRepo("AuthMySQL", "MarkRefreshTokenUsed", time.Now(), &err)
+	_, err = r.markRefreshTokenUsed.exec(ctx, usedAt, tokenHash)
+	return err
+}
+
+//CreateRegistrationToken stores a registration token mailed to a donor
+func (r *AuthMySQL) CreateRegistrationToken(ctx context.Context, token RegistrationToken) (err error) {
+	defer observeRepo("AuthMySQL", "CreateRegistrationToken", time.Now(), &err)
+	_, err = r.createRegistrationToken.exec(ctx, token.TokenHash, token.DonorID, token.Email, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+//GetRegistrationToken Retrieve a registration token by its hash, locking it until the transaction ends
+func (r *AuthMySQL) GetRegistrationToken(ctx context.Context, tokenHash string) (_ RegistrationToken, err error) {
+	defer observeRepo("AuthMySQL", "GetRegistrationToken", time.Now(), &err)
+	token := RegistrationToken{}
+	err = r.getRegistrationToken.queryRow(ctx, tokenHash).Scan(
+		&token.TokenHash,
+		&token.DonorID,
+		&token.Email,
+		&token.CreatedAt,
+		&token.ExpiresAt)
+
+	return token, err
+}
+
+//DeleteRegistrationTokens removes every registration token of the donor
+func (r *AuthMySQL) DeleteRegistrationTokens(ctx context.Context, donorID string) (err error) {
+	defer observeRepo("AuthMySQL", "DeleteRegistrationTokens", time.Now(), &err)
+	_, err = r.deleteRegistrationTokens.exec(ctx, donorID)
+	return err
+}
+
+//DeleteExpired removes the refresh and registration tokens expired before now and the sessions left without any
+func (r *AuthMySQL) DeleteExpired(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer observeRepo("AuthMySQL", "DeleteExpired", time.Now(), &err)
+	if _, err = r.deleteExpiredTokens.exec(ctx, now); err != nil {
+		return 0, err
+	}
+	if _, err = r.deleteExpiredRegistration.exec(ctx, now); err != nil {
+		return 0, err
+	}
+	result, err := r.deleteExpiredSessions.exec(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
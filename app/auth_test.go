@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+// newRegistrationAuthenticator registers passwords on an in-memory database holding donor d1, who verified
+// ivan@example.com, with a clock the test moves
+func newRegistrationAuthenticator(t *testing.T, now *time.Time) (*Authenticator, *sqlfake.Store) {
+	t.Helper()
+	store := sqlfake.NewStore()
+	registered := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	store.Insert("persons", []string{"id", "name", "lastName", "phone", "email", "createdAt"},
+		"p1", "Ivan", "Petrov", "+359888123456", "ivan@example.com", registered)
+	store.Insert("donors", []string{"id", "personId", "bloodGroup", "city", "regDate", "emailVerified", "phoneVerified"},
+		"d1", "p1", "A+", "Sofia", registered, true, false)
+
+	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		if strings.Contains(query, "NOT EXISTS") {
+			return sqlfake.Result{}
+		}
+		return store.Handle(query, args)
+	})
+	repo, err := NewAuthMySQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(repo, time.Hour, 24*time.Hour, time.Hour, 10, 4)
+	authenticator.now = func() time.Time { return *now }
+	return authenticator, store
+}
+
+func TestConfirmRegistration(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	authenticator, store := newRegistrationAuthenticator(t, &now)
+
+	if _, _, err := authenticator.StartRegistration(ctx, "nobody@example.com"); err != ErrNoVerifiedDonor {
+		t.Errorf("StartRegistration of an unknown address = %v, want ErrNoVerifiedDonor", err)
+	}
+
+	donorID, first, err := authenticator.StartRegistration(ctx, "ivan@example.com")
+	if err != nil || donorID != "d1" {
+		t.Fatalf("StartRegistration = %s, %v", donorID, err)
+	}
+	if rows := store.Rows("registration_tokens"); len(rows) != 1 || rows[0]["tokenHash"] != driver.Value(hashToken(first)) {
+		t.Fatalf("registration tokens %v, want the hash of the token only", rows)
+	}
+	if rows := store.Rows("credentials"); len(rows) != 0 {
+		t.Fatalf("credentials %v set before the token was redeemed", rows)
+	}
+
+	// asking again replaces the earlier token
+	_, second, err := authenticator.StartRegistration(ctx, "ivan@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.ConfirmRegistration(ctx, first, "correct horse battery"); err != ErrInvalidToken {
+		t.Errorf("ConfirmRegistration with a replaced token = %v, want ErrInvalidToken", err)
+	}
+
+	// a refused password leaves the token to be used again
+	if _, err := authenticator.ConfirmRegistration(ctx, second, "ivan.petrov1"); err == nil {
+		t.Error("password containing the e-mail address was accepted")
+	}
+	pair, err := authenticator.ConfirmRegistration(ctx, second, "correct horse battery")
+	if err != nil || pair.DonorID != "d1" {
+		t.Fatalf("ConfirmRegistration = %+v, %v", pair, err)
+	}
+	if _, err := authenticator.ConfirmRegistration(ctx, second, "another horse battery"); err != ErrInvalidToken {
+		t.Errorf("ConfirmRegistration with a used token = %v, want ErrInvalidToken", err)
+	}
+	if rows := store.Rows("registration_tokens"); len(rows) != 0 {
+		t.Errorf("registration tokens %v left after the password was set", rows)
+	}
+
+	if _, _, err := authenticator.StartRegistration(ctx, "ivan@example.com"); err != ErrAlreadyRegistered {
+		t.Errorf("StartRegistration of a registered donor = %v, want ErrAlreadyRegistered", err)
+	}
+}
+
+func TestConfirmRegistrationRefusesStaleTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	authenticator, store := newRegistrationAuthenticator(t, &now)
+
+	_, token, err := authenticator.StartRegistration(ctx, "ivan@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := authenticator.ConfirmRegistration(ctx, token, "correct horse battery"); err != ErrInvalidToken {
+		t.Errorf("ConfirmRegistration with an expired token = %v, want ErrInvalidToken", err)
+	}
+
+	// the donor changed the address after the token was mailed
+	_, token, err = authenticator.StartRegistration(ctx, "ivan@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Handle("UPDATE persons SET email=? WHERE id=?", []driver.Value{"ivan@example.org", "p1"})
+	if _, err := authenticator.ConfirmRegistration(ctx, token, "correct horse battery"); err != ErrInvalidToken {
+		t.Errorf("ConfirmRegistration for a changed address = %v, want ErrInvalidToken", err)
+	}
+	if rows := store.Rows("credentials"); len(rows) != 0 {
+		t.Errorf("credentials %v set with a stale token", rows)
+	}
+}
+
+func TestRegisterAnswersAlike(t *testing.T) {
+	now := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	authenticator, _ := newRegistrationAuthenticator(t, &now)
+	mailer := &recordingMailer{}
+	app := &App{Authenticator: authenticator, Mailer: mailer}
+
+	for _, email := range []string{"nobody@example.com", "ivan@example.com"} {
+		w := httptest.NewRecorder()
+		app.register(w, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(`{"email": "`+email+`"}`)))
+		if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+			t.Errorf("register %s = %d %s, want an empty 202", email, w.Code, w.Body)
+		}
+	}
+	if len(mailer.messages) != 1 || mailer.messages[0].To != "ivan@example.com" {
+		t.Errorf("mailed %+v, want a single token to ivan@example.com", mailer.messages)
+	}
+}
+
+type recordingMailer struct {
+	messages []Message
+}
+
+func (m *recordingMailer) Send(msg Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestRequireDonor(t *testing.T) {
+	app := &App{AdminToken: "admin-secret", Authenticator: newTestAuthenticator(t)}
+	router := mux.NewRouter()
+	router.Path("/accounts/donors/{id}").Handler(app.requireDonor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"s1.forged", http.StatusUnauthorized},
+		{"s1.secret", http.StatusNoContent},
+		{"s2.secret", http.StatusForbidden},
+		{"admin-secret", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodDelete, "/accounts/donors/d1", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %q = %d, want %d", tt.token, w.Code, tt.want)
+		}
+	}
+}
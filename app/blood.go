@@ -0,0 +1,61 @@
+package app
+
+import "strings"
+
+// bloodType is a parsed blood group, rh is empty when the Rh factor is unknown
+type bloodType struct {
+	abo string
+	rh  string
+}
+
+// parseBloodGroup understands groups such as "A-", "AB+", "0", "O Rh-" and "Bpos"
+func parseBloodGroup(group string) (bloodType, bool) {
+	g := strings.ToUpper(strings.Replace(group, " ", "", -1))
+	g = strings.Replace(g, "RH", "", 1)
+
+	t := bloodType{}
+	switch {
+	case strings.HasSuffix(g, "+"), strings.HasSuffix(g, "POS"):
+		t.rh = "+"
+	case strings.HasSuffix(g, "-"), strings.HasSuffix(g, "NEG"):
+		t.rh = "-"
+	}
+	g = strings.TrimRight(g, "+-")
+	g = strings.TrimSuffix(strings.TrimSuffix(g, "POS"), "NEG")
+
+	switch g {
+	case "0", "O":
+		t.abo = "O"
+	case "A", "B", "AB":
+		t.abo = g
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+// CanDonate reports whether blood of the donor group can be transfused to the recipient group.
+// When the Rh factor of either side is unknown only the ABO groups are compared.
+func CanDonate(donorGroup, recipientGroup string) bool {
+	donor, ok := parseBloodGroup(donorGroup)
+	if !ok {
+		return false
+	}
+	recipient, ok := parseBloodGroup(recipientGroup)
+	if !ok {
+		return false
+	}
+
+	if donor.rh == "+" && recipient.rh == "-" {
+		return false
+	}
+
+	switch donor.abo {
+	case "O":
+		return true
+	case "AB":
+		return recipient.abo == "AB"
+	default:
+		return recipient.abo == donor.abo || recipient.abo == "AB"
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import "strings"
+
+// nearbyCities lists the towns whose donors are asked to help patients in a given city.
+// The relation is symmetric, see NearbyCities.
+var nearbyCities = map[string][]string{
+	"sofia":          {"Pernik", "Samokov", "Botevgrad", "Ihtiman", "Svoge", "Elin Pelin", "Kostinbrod"},
+	"plovdiv":        {"Asenovgrad", "Pazardzhik", "Karlovo", "Rakovski", "Stamboliyski", "Parvomay"},
+	"varna":          {"Devnya", "Provadia", "Aksakovo", "Dobrich", "Shumen"},
+	"burgas":         {"Pomorie", "Sozopol", "Aytos", "Nesebar", "Kameno", "Sredets"},
+	"ruse":           {"Razgrad", "Byala", "Tutrakan", "Silistra"},
+	"stara zagora":   {"Kazanlak", "Chirpan", "Nova Zagora", "Haskovo"},
+	"pleven":         {"Lovech", "Levski", "Cherven Bryag", "Nikopol"},
+	"veliko tarnovo": {"Gorna Oryahovitsa", "Lyaskovets", "Gabrovo", "Sevlievo", "Svishtov"},
+	"blagoevgrad":    {"Dupnitsa", "Kyustendil", "Razlog", "Sandanski", "Petrich"},
+	"vratsa":         {"Montana", "Mezdra", "Kozloduy", "Byala Slatina"},
+}
+
+// NearbyCities returns the city itself followed by the cities close to it
+func NearbyCities(city string) []string {
+	key := strings.ToLower(strings.TrimSpace(city))
+	cities := []string{strings.TrimSpace(city)}
+	seen := map[string]bool{key: true}
+
+	add := func(name string) {
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			cities = append(cities, name)
+		}
+	}
+
+	for _, name := range nearbyCities[key] {
+		add(name)
+	}
+	for hub, names := range nearbyCities {
+		for _, name := range names {
+			if strings.ToLower(name) == key {
+				add(strings.Title(hub))
+			}
+		}
+	}
+
+	return cities
+}
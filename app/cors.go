@@ -0,0 +1,131 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig decides which browser origins may call the API
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://lifeblood.bg, "*" for any origin,
+	// or wildcard subdomains such as https://*.lifeblood.bg
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// allowsOrigin reports whether a browser page served from origin may call the API
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		// https://*.lifeblood.bg matches https://donors.lifeblood.bg but not https://lifeblood.bg
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := strings.ToLower(allowed[:i]), strings.ToLower(allowed[i+1:])
+			candidate := strings.ToLower(origin)
+			if strings.HasPrefix(candidate, prefix) && strings.HasSuffix(candidate, suffix) && len(candidate) > len(prefix)+len(suffix) {
+				subdomain := candidate[len(prefix) : len(candidate)-len(suffix)]
+				if !strings.ContainsAny(subdomain, "/:@") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowsAnyOrigin() bool {
+	return containsString(c.AllowedOrigins, "*")
+}
+
+// withCORS adds the CORS headers for allowed origins and answers preflight requests for every route
+func (app *App) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if !app.CORS.allowsOrigin(origin) {
+			if preflight {
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.CORS.allowsAnyOrigin() && !app.CORS.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if app.CORS.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(app.CORS.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(app.CORS.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(app.CORS.AllowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(app.CORS.AllowedHeaders, ", "))
+		if app.CORS.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(app.CORS.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// routePreflights adds an OPTIONS route for every path template of the router, so withCORS runs for
+// preflight requests to the API while paths it does not have are answered 404
+func (app *App) routePreflights() {
+	templates := make([]string, 0)
+	seen := map[string]bool{}
+	app.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || seen[template] {
+			return nil
+		}
+		if _, err := route.GetMethods(); err != nil {
+			// path prefixes of subrouters, their routes are walked themselves
+			return nil
+		}
+		seen[template] = true
+		templates = append(templates, template)
+		return nil
+	})
+
+	for _, template := range templates {
+		app.Router.
+			Methods("OPTIONS").
+			Path(template).
+			HandlerFunc(app.preflight)
+	}
+}
+
+// preflight answers OPTIONS requests to the paths of the API. Requests reaching it are not CORS preflight
+// requests or come from origins that are not allowed.
+func (app *App) preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(app.CORS.AllowedMethods, ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
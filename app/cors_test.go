@@ -0,0 +1,139 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCORSAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{[]string{"https://lifeblood.bg"}, "https://lifeblood.bg", true},
+		{[]string{"https://lifeblood.bg"}, "HTTPS://LifeBlood.bg", true},
+		{[]string{"https://lifeblood.bg"}, "http://lifeblood.bg", false},
+		{[]string{"https://lifeblood.bg"}, "https://lifeblood.bg.evil.com", false},
+		{[]string{"https://lifeblood.bg"}, "https://lifeblood.bg:8443", false},
+		{[]string{"*"}, "https://anything.example", true},
+		{[]string{"https://*.lifeblood.bg"}, "https://donors.lifeblood.bg", true},
+		{[]string{"https://*.lifeblood.bg"}, "https://a.b.lifeblood.bg", true},
+		{[]string{"https://*.lifeblood.bg"}, "https://lifeblood.bg", false},
+		{[]string{"https://*.lifeblood.bg"}, "https://.lifeblood.bg", false},
+		{[]string{"https://*.lifeblood.bg"}, "http://donors.lifeblood.bg", false},
+		{[]string{"https://*.lifeblood.bg"}, "https://evil.com/.lifeblood.bg", false},
+		{[]string{"https://*.lifeblood.bg"}, "https://user@evil.com:.lifeblood.bg", false},
+		{[]string{"https://*.lifeblood.bg"}, "https://donors.lifeblood.bg.evil.com", false},
+		{[]string{"http://localhost:3000", "https://*.lifeblood.bg"}, "http://localhost:3000", true},
+		{nil, "https://lifeblood.bg", false},
+	}
+
+	for _, tt := range tests {
+		config := CORSConfig{AllowedOrigins: tt.allowed}
+		if got := config.allowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("%v allows %q = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	restricted := CORSConfig{
+		AllowedOrigins: []string{"https://*.lifeblood.bg"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"Location"},
+		MaxAge:         10 * time.Minute,
+	}
+	credentials := restricted
+	credentials.AllowedOrigins = []string{"*"}
+	credentials.AllowCredentials = true
+	anyOrigin := restricted
+	anyOrigin.AllowedOrigins = []string{"*"}
+
+	tests := []struct {
+		name          string
+		config        CORSConfig
+		method        string
+		path          string
+		origin        string
+		requestMethod string
+		status        int
+		headers       map[string]string
+		vary          []string
+	}{
+		{name: "same origin request", config: restricted, method: "GET", status: http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "allowed origin", config: restricted, method: "GET", origin: "https://donors.lifeblood.bg", status: http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://donors.lifeblood.bg", "Access-Control-Expose-Headers": "Location",
+				"Access-Control-Allow-Methods": ""},
+			vary: []string{"Origin"}},
+		{name: "other origin", config: restricted, method: "GET", origin: "https://evil.com", status: http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""}, vary: []string{"Origin"}},
+		{name: "preflight", config: restricted, method: "OPTIONS", origin: "https://donors.lifeblood.bg", requestMethod: "POST", status: http.StatusNoContent,
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://donors.lifeblood.bg", "Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization", "Access-Control-Max-Age": "600", "Access-Control-Expose-Headers": ""},
+			vary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{name: "preflight of other origin", config: restricted, method: "OPTIONS", origin: "https://evil.com", requestMethod: "POST", status: http.StatusForbidden,
+			headers: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""}, vary: []string{"Origin"}},
+		{name: "OPTIONS without preflight", config: restricted, method: "OPTIONS", origin: "https://donors.lifeblood.bg", status: http.StatusNoContent,
+			headers: map[string]string{"Allow": "GET, POST", "Access-Control-Allow-Methods": ""}, vary: []string{"Origin"}},
+		{name: "preflight of a route with an id", config: restricted, method: "OPTIONS", path: "/accounts/donors/d1", origin: "https://donors.lifeblood.bg", requestMethod: "PUT",
+			status: http.StatusNoContent, headers: map[string]string{"Access-Control-Allow-Origin": "https://donors.lifeblood.bg"},
+			vary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{name: "preflight of an admin route", config: restricted, method: "OPTIONS", path: "/admin/webhooks", origin: "https://donors.lifeblood.bg", requestMethod: "GET",
+			status: http.StatusNoContent, headers: map[string]string{"Access-Control-Allow-Origin": "https://donors.lifeblood.bg"},
+			vary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{name: "preflight of an unknown path", config: restricted, method: "OPTIONS", path: "/no/such/path", origin: "https://donors.lifeblood.bg", requestMethod: "POST",
+			status: http.StatusNotFound, headers: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "OPTIONS of an unknown path", config: restricted, method: "OPTIONS", path: "/accounts/donors/d1/nothing", status: http.StatusNotFound,
+			headers: map[string]string{"Allow": ""}},
+		{name: "any origin", config: anyOrigin, method: "GET", origin: "https://example.com", status: http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""}, vary: []string{"Origin"}},
+		{name: "any origin with credentials", config: credentials, method: "GET", origin: "https://example.com", status: http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Credentials": "true"}, vary: []string{"Origin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{Router: mux.NewRouter(), CORS: tt.config}
+			app.SetupRouter()
+
+			path := tt.path
+			if path == "" {
+				path = "/"
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			rec := httptest.NewRecorder()
+			app.Router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			for name, want := range tt.headers {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			vary := rec.Header()["Vary"]
+			if len(vary) != len(tt.vary) {
+				t.Fatalf("Vary = %v, want %v", vary, tt.vary)
+			}
+			for i := range vary {
+				if vary[i] != tt.vary[i] {
+					t.Errorf("Vary = %v, want %v", vary, tt.vary)
+				}
+			}
+		})
+	}
+}
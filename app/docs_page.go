@@ -0,0 +1,76 @@
+package app
+
+// docsPage renders /openapi.json in the browser without any external assets
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>LifeBlood accounts API</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h1 { color: #b00020; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: .4em 0; }
+summary { cursor: pointer; padding: .5em; }
+.method { display: inline-block; width: 5em; font-weight: bold; text-transform: uppercase; }
+.get { color: #0a6ebd; } .post { color: #2e7d32; } .put { color: #ef6c00; } .delete { color: #c62828; }
+.body { padding: 0 1em 1em; }
+pre { background: #f6f6f6; padding: .5em; overflow-x: auto; }
+table { border-collapse: collapse; } td, th { border: 1px solid #ddd; padding: .2em .5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>LifeBlood accounts API</h1>
+<p>Raw document: <a href="/openapi.json">/openapi.json</a></p>
+<div id="operations">Loading...</div>
+<h2>Schemas</h2>
+<div id="schemas"></div>
+<script>
+function el(tag, attrs, children) {
+  var node = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (k) { node.setAttribute(k, attrs[k]); });
+  (children || []).forEach(function (c) { node.appendChild(typeof c === "string" ? document.createTextNode(c) : c); });
+  return node;
+}
+function schemaName(schema) {
+  if (!schema) return "";
+  if (schema.$ref) return schema.$ref.split("/").pop();
+  if (schema.type === "array") return schemaName(schema.items) + "[]";
+  return schema.type;
+}
+fetch("/openapi.json").then(function (r) { return r.json(); }).then(function (spec) {
+  var ops = document.getElementById("operations");
+  ops.textContent = "";
+  Object.keys(spec.paths).sort().forEach(function (path) {
+    Object.keys(spec.paths[path]).forEach(function (method) {
+      var op = spec.paths[path][method];
+      var body = el("div", {"class": "body"});
+      if (op.parameters.length) {
+        body.appendChild(el("p", {}, ["Parameters: " + op.parameters.map(function (p) { return p.name + " (" + p.in + ")"; }).join(", ")]));
+      }
+      if (op.requestBody) {
+        body.appendChild(el("p", {}, ["Request body: " + schemaName(op.requestBody.content["application/json"].schema)]));
+      }
+      var rows = Object.keys(op.responses).map(function (status) {
+        var resp = op.responses[status], content = resp.content || {};
+        var type = Object.keys(content)[0];
+        return el("tr", {}, [el("td", {}, [status]), el("td", {}, [resp.description]), el("td", {}, [type ? schemaName(content[type].schema) : ""])]);
+      });
+      body.appendChild(el("table", {}, [el("tr", {}, [el("th", {}, ["Status"]), el("th", {}, ["Description"]), el("th", {}, ["Body"])])].concat(rows)));
+      ops.appendChild(el("details", {}, [
+        el("summary", {}, [el("span", {"class": "method " + method}, [method]), path + " - " + op.summary]),
+        body
+      ]));
+    });
+  });
+  var schemas = document.getElementById("schemas");
+  Object.keys(spec.components.schemas).sort().forEach(function (name) {
+    schemas.appendChild(el("details", {}, [
+      el("summary", {}, [name]),
+      el("div", {"class": "body"}, [el("pre", {}, [JSON.stringify(spec.components.schemas[name], null, 2)])])
+    ]));
+  });
+});
+</script>
+</body>
+</html>
+`
@@ -1,139 +1,388 @@
 package app
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"strings"
+	"time"
 )
 
+//donorColumns are read from donorsWithPersons, the name, phone and email belong to the person
+const donorColumns = `donors.id, personId, name, lastName, phone, email, dateOfBirth, gender, bloodGroup, city, regDate, emailVerified, emailVerifiedAt, phoneVerified, phoneVerifiedAt, notificationsOptIn`
+
+const donorsWithPersons = `donors JOIN persons ON persons.id=donors.personId`
+
 //DonorsMySQL mysql repo
 type DonorsMySQL struct {
-	db *sql.DB
+	db         *sql.DB
+	cluster    *DBCluster
+	statements *statements
+
+	savePerson         *stmt
+	createPerson       *stmt
+	create             *stmt
+	getAll             *stmt
+	getPage            *stmt
+	getMatching        *stmt
+	getByID            *stmt
+	getByPersonID      *stmt
+	getPersonID        *stmt
+	update             *stmt
+	markEmailVerified  *stmt
+	markPhoneVerified  *stmt
+	getByBloodGroup    *stmt
+	countByBloodGroup  *stmt
+	deleteByID         *stmt
+	deleteOrphanPerson *stmt
+	deleteCredentials  *stmt
+	deleteRegistration *stmt
+	appendEvent        *stmt
 }
 
-//NewDonorsMySQL create new repository
-func NewDonorsMySQL(db *sql.DB) *DonorsMySQL {
-	return &DonorsMySQL{
-		db: db,
+//NewDonorsMySQL create new repository, preparing its statements on the primary
+func NewDonorsMySQL(cluster *DBCluster) (*DonorsMySQL, error) {
+	s := newStatements(cluster.Primary())
+	r := &DonorsMySQL{
+		db:         cluster.Primary(),
+		cluster:    cluster,
+		statements: s,
+
+		savePerson: s.prepare(`INSERT INTO persons (id, name, lastName, phone, email, createdAt) VALUES (?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE name=VALUES(name), lastName=VALUES(lastName), phone=VALUES(phone), email=VALUES(email);`),
+		createPerson: s.prepare(`INSERT IGNORE INTO persons (id, name, lastName, phone, email, createdAt) VALUES (?,?,?,?,?,?);`),
+		create: s.prepare(`INSERT INTO donors (id, personId, dateOfBirth, gender, bloodGroup, city, regDate, notificationsOptIn)
+		VALUES (?,?,?,?,?,?,?,?);`),
+		getAll:  s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + `;`),
+		getPage: s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + ` ORDER BY regDate, donors.id LIMIT ? OFFSET ?;`),
+		getMatching: s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + `
+		WHERE (name LIKE ? OR lastName LIKE ? OR email LIKE ? OR phone LIKE ? OR city LIKE ? OR name LIKE ? OR lastName LIKE ? OR city LIKE ?) LIMIT ?;`),
+		getByID:            s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + ` WHERE donors.id=?`),
+		getByPersonID:      s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + ` WHERE personId=?`),
+		getPersonID:        s.prepare(`SELECT personId FROM donors WHERE id=? FOR UPDATE`),
+		update:             s.prepare(`UPDATE donors SET dateOfBirth=?,gender=?,city=?,emailVerified=?,emailVerifiedAt=?,phoneVerified=?,phoneVerifiedAt=?,notificationsOptIn=? WHERE id=?;`),
+		markEmailVerified:  s.prepare(`UPDATE donors SET emailVerified=true, emailVerifiedAt=? WHERE id=?;`),
+		markPhoneVerified:  s.prepare(`UPDATE donors SET phoneVerified=true, phoneVerifiedAt=? WHERE id=?;`),
+		getByBloodGroup:    s.prepare(`SELECT ` + donorColumns + ` FROM ` + donorsWithPersons + ` WHERE bloodGroup=?`),
+		countByBloodGroup:  s.prepare(`SELECT bloodGroup, COUNT(*) FROM donors GROUP BY bloodGroup`),
+		appendEvent:        s.prepare(appendEventSQL),
+		deleteByID:         s.prepare(`DELETE FROM donors WHERE id=?`),
+		deleteOrphanPerson: s.prepare(deleteOrphanPersonSQL),
+		deleteCredentials:  s.prepare(deleteCredentialsSQL),
+		deleteRegistration: s.prepare(deleteRegistrationTokensSQL),
 	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
-//Create a Donor
-func (r *DonorsMySQL) Create(donor Donor) error {
-	stmt, err := r.db.Prepare(`
-		INSERT INTO donors (id, name, lastName, phone, email, age, gender, bloodGroup, city, regDate)
-		VALUES (?,?,?,?,?,?,?,?,?,?);`)
-	if err != nil {
-		return err
-	}
+//Close the prepared statements of the repository
+func (r *DonorsMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanDonor reads a single donor row selected with donorColumns
+func scanDonor(row rowScanner) (Donor, error) {
+	donor := Donor{}
+	err := row.Scan(
+		&donor.ID,
+		&donor.PersonID,
+		&donor.FirstName,
+		&donor.LastName,
+		&donor.PhoneNumber,
+		&donor.Email,
+		&donor.DateOfBirth,
+		&donor.Gender,
+		&donor.BloodGroup,
+		&donor.City,
+		&donor.RegistrationDate,
+		&donor.EmailVerified,
+		&donor.EmailVerifiedAt,
+		&donor.PhoneVerified,
+		&donor.PhoneVerifiedAt,
+		&donor.NotificationsOptIn)
 
-	_, err = stmt.Exec(
-		donor.ID, donor.FirstName, donor.LastName, donor.PhoneNumber, donor.Email, donor.Age, donor.Gender, donor.BloodGroup, donor.City, donor.RegistrationDate,
-	)
+	return donor, err
+}
+
+//savePersonOf updates the identity of the person of the donor, creating the person when it is missing
+func (r *DonorsMySQL) savePersonOf(ctx context.Context, donor Donor) error {
+	_, err := r.savePerson.exec(ctx,
+		donor.PersonID, donor.FirstName, donor.LastName, donor.PhoneNumber, donor.Email, donor.RegistrationDate)
 	return err
 }
 
+//Create a Donor, as a role of the person with donor.PersonID which is created when it does not exist and
+//left unchanged when it does. A person already holding the role is refused with a duplicate key error.
+func (r *DonorsMySQL) Create(ctx context.Context, donor Donor) (err error) {
+	defer observeRepo("DonorsMySQL", "Create", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		_, err := r.createPerson.exec(ctx,
+			donor.PersonID, donor.FirstName, donor.LastName, donor.PhoneNumber, donor.Email, donor.RegistrationDate)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.create.exec(ctx,
+			donor.ID, donor.PersonID, donor.DateOfBirth, donor.Gender, donor.BloodGroup, donor.City, donor.RegistrationDate, donor.NotificationsOptIn,
+		)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, DonorRegistered, donorAggregate, donor.ID, donor)
+	})
+}
+
 //GetAll donors
-func (r *DonorsMySQL) GetAll() ([]Donor, error) {
+func (r *DonorsMySQL) GetAll(ctx context.Context) (_ []Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetAll", time.Now(), &err)
 	donors := make([]Donor, 0)
-	rows, err := r.db.Query(`SELECT * FROM donors;`)
+	rows, err := r.cluster.queryReplica(ctx, r.getAll)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "DonorsMySQL.GetAll failed", err)
 		return donors, err
 	}
 	defer rows.Close()
 
-	donorsCount := 0
 	for rows.Next() {
-		var id, name, lastname, phone, email, age, gender, bloodGroup, city, regDate string
-		err := rows.Scan(&id, &name, &lastname, &phone, &email, &age, &gender, &bloodGroup, &city, &regDate)
+		donor, err := scanDonor(rows)
 		if err != nil {
-			log.Printf(err.Error())
+			logError(ctx, "DonorsMySQL.GetAll failed", err)
+			return donors, err
 		}
 
-		donors = append(donors, Donor{
-			ID:               id,
-			FirstName:        name,
-			LastName:         lastname,
-			PhoneNumber:      phone,
-			Email:            email,
-			Age:              age,
-			Gender:           gender,
-			BloodGroup:       bloodGroup,
-			City:             city,
-			RegistrationDate: regDate})
-		donorsCount++
+		donors = append(donors, donor)
 	}
 
-	return donors, nil
+	return donors, rows.Err()
 }
 
-//GetByID Retrieve a donor by Id
-func (r *DonorsMySQL) GetByID(id string) (Donor, error) {
-	donor := Donor{}
-	err := r.db.QueryRow(`SELECT * FROM donors WHERE id=?`, id).Scan(
-		&donor.ID,
-		&donor.FirstName,
-		&donor.LastName,
-		&donor.PhoneNumber,
-		&donor.Email,
-		&donor.Age,
-		&donor.Gender,
-		&donor.BloodGroup,
-		&donor.City,
-		&donor.RegistrationDate)
+//GetMatching at most limit donors whose name, e-mail, phone or city is LIKE pattern, or whose name or city
+//is LIKE spelling, the pattern written in the other alphabet
+func (r *DonorsMySQL) GetMatching(ctx context.Context, pattern, spelling string, limit int) (_ []Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetMatching", time.Now(), &err)
+	donors := make([]Donor, 0)
+	rows, err := r.cluster.queryReplica(ctx, r.getMatching, pattern, pattern, pattern, pattern, pattern, spelling, spelling, spelling, limit)
+	if err != nil {
+		logError(ctx, "DonorsMySQL.GetMatching failed", err)
+		return donors, err
+	}
+	defer rows.Close()
 
-	return donor, err
+	for rows.Next() {
+		donor, err := scanDonor(rows)
+		if err != nil {
+			return donors, err
+		}
+
+		donors = append(donors, donor)
+	}
+
+	return donors, rows.Err()
 }
 
-//Update donor by ID
-func (r *DonorsMySQL) Update(donor Donor) (Donor, error) {
-	stmt, err := r.db.Prepare(`UPDATE donors SET name=?,lastName=?,phone=?,email=?,age=?,gender=?,city=? WHERE id=?;`)
+//GetPage donors ordered by registration, at most limit of them starting at offset
+func (r *DonorsMySQL) GetPage(ctx context.Context, limit, offset int) (_ []Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetPage", time.Now(), &err)
+	donors := make([]Donor, 0)
+	rows, err := r.cluster.queryReplica(ctx, r.getPage, limit, offset)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "DonorsMySQL.GetPage failed", err)
+		return donors, err
 	}
+	defer rows.Close()
 
-	_, err = stmt.Exec(donor.FirstName, donor.LastName, donor.PhoneNumber, donor.Email, donor.Age, donor.Gender, donor.City, donor.ID)
+	for rows.Next() {
+		donor, err := scanDonor(rows)
+		if err != nil {
+			return donors, err
+		}
+
+		donors = append(donors, donor)
+	}
+
+	return donors, rows.Err()
+}
+
+//GetByID Retrieve a donor by Id
+func (r *DonorsMySQL) GetByID(ctx context.Context, id string) (_ Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetByID", time.Now(), &err)
+	return scanDonor(r.getByID.queryRow(ctx, id))
+}
+
+//GetByPersonID Retrieve the donor role of a person
+func (r *DonorsMySQL) GetByPersonID(ctx context.Context, personID string) (_ Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetByPersonID", time.Now(), &err)
+	return scanDonor(r.getByPersonID.queryRow(ctx, personID))
+}
+
+//Update donor by ID, the name, phone and email change for every role of the person
+func (r *DonorsMySQL) Update(ctx context.Context, donor Donor) (_ Donor, err error) {
+	defer observeRepo("DonorsMySQL", "Update", time.Now(), &err)
+	err = WithTx(ctx, r.db, func(ctx context.Context) error {
+		if err := r.savePersonOf(ctx, donor); err != nil {
+			return err
+		}
+
+		_, err := r.update.exec(ctx,
+			donor.DateOfBirth, donor.Gender, donor.City,
+			donor.EmailVerified, donor.EmailVerifiedAt, donor.PhoneVerified, donor.PhoneVerifiedAt, donor.NotificationsOptIn, donor.ID)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, DonorUpdated, donorAggregate, donor.ID, donor)
+	})
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "DonorsMySQL.Update failed", err)
 	}
 
 	return donor, err
 }
 
+//MarkEmailVerified flags the donor email as verified at the given time
+func (r *DonorsMySQL) MarkEmailVerified(ctx context.Context, id string, verifiedAt time.Time) (err error) {
+	defer observeRepo("DonorsMySQL", "MarkEmailVerified", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		_, err := r.markEmailVerified.exec(ctx, verifiedAt, id)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, DonorEmailVerified, donorAggregate, id, map[string]interface{}{"id": id, "emailVerifiedAt": verifiedAt})
+	})
+}
+
+//MarkPhoneVerified flags the donor phone number as verified at the given time
+func (r *DonorsMySQL) MarkPhoneVerified(ctx context.Context, id string, verifiedAt time.Time) (err error) {
+	defer observeRepo("DonorsMySQL", "MarkPhoneVerified", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		_, err := r.markPhoneVerified.exec(ctx, verifiedAt, id)
+		if err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, DonorPhoneVerified, donorAggregate, id, map[string]interface{}{"id": id, "phoneVerifiedAt": verifiedAt})
+	})
+}
+
 //GetByBloodGroup search for donors with specific blood group
-func (r *DonorsMySQL) GetByBloodGroup(bloodGroup string) ([]Donor, error) {
+func (r *DonorsMySQL) GetByBloodGroup(ctx context.Context, bloodGroup string) (_ []Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetByBloodGroup", time.Now(), &err)
 	donors := make([]Donor, 0)
-	rows, err := r.db.Query(`SELECT * FROM donors WHERE bloodGroup=?`, bloodGroup)
+	rows, err := r.cluster.queryReplica(ctx, r.getByBloodGroup, bloodGroup)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(ctx, "DonorsMySQL.GetByBloodGroup failed", err)
+		return donors, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id, name, lastname, phone, email, age, gender, bloodGroup, city, regDate string
-		err := rows.Scan(&id, &name, &lastname, &phone, &email, &age, &gender, &bloodGroup, &city, &regDate)
+		donor, err := scanDonor(rows)
 		if err != nil {
-			log.Printf(err.Error())
+			logError(ctx, "DonorsMySQL.GetByBloodGroup failed", err)
+			return donors, err
 		}
 
-		donors = append(donors, Donor{
-			ID:               id,
-			FirstName:        name,
-			LastName:         lastname,
-			PhoneNumber:      phone,
-			Email:            email,
-			Age:              age,
-			Gender:           gender,
-			BloodGroup:       bloodGroup,
-			City:             city,
-			RegistrationDate: regDate})
+		donors = append(donors, donor)
 	}
 
-	return donors, err
+	return donors, rows.Err()
 }
 
-//DeleteByID check whether donor exists and remove
-func (r *DonorsMySQL) DeleteByID(id string) error {
-	_, err := r.db.Query(`DELETE FROM donors WHERE id=?`, id)
-	return err
+//GetNotificationCandidates list the donors living in one of the cities who agreed to be notified
+func (r *DonorsMySQL) GetNotificationCandidates(ctx context.Context, cities []string) (_ []Donor, err error) {
+	defer observeRepo("DonorsMySQL", "GetNotificationCandidates", time.Now(), &err)
+	donors := make([]Donor, 0)
+	if len(cities) == 0 {
+		return donors, nil
+	}
+
+	args := make([]interface{}, len(cities))
+	for i, city := range cities {
+		args[i] = city
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(cities)), ",")
+
+	rows, err := querySQL(ctx, conn(ctx, r.db), `SELECT `+donorColumns+` FROM `+donorsWithPersons+` WHERE notificationsOptIn=true AND city IN (`+placeholders+`)`, args...)
+	if err != nil {
+		logError(ctx, "DonorsMySQL.GetNotificationCandidates failed", err)
+		return donors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		donor, err := scanDonor(rows)
+		if err != nil {
+			logError(ctx, "DonorsMySQL.GetNotificationCandidates failed", err)
+			return donors, err
+		}
+
+		donors = append(donors, donor)
+	}
+
+	return donors, rows.Err()
+}
+
+//CountByBloodGroup number of donors per blood group
+func (r *DonorsMySQL) CountByBloodGroup(ctx context.Context) (_ map[string]int, err error) {
+	defer observeRepo("DonorsMySQL", "CountByBloodGroup", time.Now(), &err)
+	counts := map[string]int{}
+	rows, err := r.cluster.queryReplica(ctx, r.countByBloodGroup)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bloodGroup sql.NullString
+		var count int
+		if err := rows.Scan(&bloodGroup, &count); err != nil {
+			return counts, err
+		}
+		counts[bloodGroup.String] += count
+	}
+
+	return counts, rows.Err()
+}
+
+//DeleteByID check whether donor exists and remove, with its password, its sessions and its person unless it is also an acceptor
+func (r *DonorsMySQL) DeleteByID(ctx context.Context, id string) (err error) {
+	defer observeRepo("DonorsMySQL", "DeleteByID", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		var personID string
+		err := r.getPersonID.queryRow(ctx, id).Scan(&personID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.deleteByID.exec(ctx, id); err != nil {
+			return err
+		}
+		if err := deleteOrphanPerson(ctx, r.deleteOrphanPerson, personID); err != nil {
+			return err
+		}
+		if _, err := r.deleteCredentials.exec(ctx, id); err != nil {
+			return err
+		}
+		if _, err := r.deleteRegistration.exec(ctx, id); err != nil {
+			return err
+		}
+
+		return appendEvent(ctx, r.appendEvent, DonorDeleted, donorAggregate, id, map[string]string{"id": id})
+	})
+}
+
+//nullString maps an empty string to SQL NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+)
+
+// Domain event types published when accounts change
+const (
+	DonorRegistered    = "DonorRegistered"
+	DonorUpdated       = "DonorUpdated"
+	DonorDeleted       = "DonorDeleted"
+	DonorEmailVerified = "DonorEmailVerified"
+	DonorPhoneVerified = "DonorPhoneVerified"
+	AcceptorRegistered = "AcceptorRegistered"
+	AcceptorUpdated    = "AcceptorUpdated"
+	AcceptorDeleted    = "AcceptorDeleted"
+)
+
+// eventTypes lists every event type, in the order they were introduced
+var eventTypes = []string{
+	DonorRegistered, DonorUpdated, DonorDeleted, DonorEmailVerified, DonorPhoneVerified,
+	AcceptorRegistered, AcceptorUpdated, AcceptorDeleted,
+}
+
+func isEventType(eventType string) bool {
+	return containsString(eventTypes, eventType)
+}
+
+// Aggregate types the events refer to
+const (
+	donorAggregate    = "donor"
+	acceptorAggregate = "acceptor"
+)
+
+// Event is a change to an account, stored in the outbox together with the change itself
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregateType"`
+	AggregateID   string          `json:"aggregateId"`
+	Payload       json.RawMessage `json:"payload"`
+	OccurredAt    string          `json:"occurredAt"`
+}
+
+// newEvent creates an event carrying the JSON representation of payload
+func newEvent(eventType, aggregateType, aggregateID string, payload interface{}) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:            shortuuid.New(),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Payload:       data,
+		OccurredAt:    formatTimestamp(time.Now()),
+	}, nil
+}
@@ -1,12 +1,16 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -16,17 +20,116 @@ import (
 // App is a wrapper struct over Router and Database used to manage db connections and endpoint requests centrally
 type App struct {
 	Router        *mux.Router
+	Database      *sql.DB
+	Cluster       *DBCluster
 	DonorsRepo    *DonorsMySQL
 	AcceptorsRepo *AcceptorsMySQL
+	PersonsRepo   *PersonsMySQL
+	Mailer        Mailer
+	EmailVerifier *EmailVerifier
+	PhoneVerifier *PhoneVerifier
+	PhoneRegion   string
+	Notifier      *Notifier
+	Notifications *NotificationsMySQL
+	WebhooksRepo  *WebhooksMySQL
+	AdminToken    string
+	BaseURL       string
+	CORS          CORSConfig
+	RateLimiter   *RateLimiter
+	Idempotency   *Idempotency
+	Authenticator *Authenticator
+	// RequestTimeout bounds the handling of a single request, zero means no limit
+	RequestTimeout time.Duration
+	// ReadinessChecks must all pass for /readyz to report the service ready
+	ReadinessChecks  []HealthCheck
+	ReadinessTimeout time.Duration
+
+	background sync.WaitGroup
+}
+
+// backgroundTimeout bounds work a request leaves running after it is answered
+const backgroundTimeout = 2 * time.Minute
+
+// accountFlags holds the boolean fields of a request body, which the string maps used for the other fields skip
+type accountFlags struct {
+	NotificationsOptIn *bool `json:"notificationsOptIn"`
+	Urgent             *bool `json:"urgent"`
 }
 
 // SetupRouter is used to provide mapping between different endpoints hit and handler functions
 func (app *App) SetupRouter() {
+	app.Router.Use(app.withRequestID, app.withReadYourWrites, app.withTracing, app.withAccessLog, app.withMetrics, app.withCORS, app.withRateLimit, app.withRequestTimeout, app.withIdempotency)
+
 	app.Router.
 		Methods("GET").
 		Path("/").
 		HandlerFunc(app.homePage)
 
+	app.Router.
+		Methods("GET").
+		Path("/healthz").
+		HandlerFunc(app.getLiveness)
+
+	app.Router.
+		Methods("GET").
+		Path("/readyz").
+		HandlerFunc(app.getReadiness)
+
+	app.Router.
+		Methods("GET").
+		Path("/metrics").
+		HandlerFunc(app.getMetrics)
+
+	app.Router.
+		Methods("GET").
+		Path("/openapi.json").
+		HandlerFunc(app.getOpenAPISpec)
+
+	app.Router.
+		Methods("GET").
+		Path("/docs").
+		HandlerFunc(app.getDocs)
+
+	app.Router.
+		Methods("POST").
+		Path("/auth/register").
+		HandlerFunc(app.register)
+
+	app.Router.
+		Methods("POST").
+		Path("/auth/register/confirm").
+		HandlerFunc(app.confirmRegistration)
+
+	app.Router.
+		Methods("POST").
+		Path("/auth/login").
+		HandlerFunc(app.login)
+
+	app.Router.
+		Methods("POST").
+		Path("/auth/refresh").
+		HandlerFunc(app.refresh)
+
+	app.Router.
+		Methods("POST").
+		Path("/auth/logout").
+		HandlerFunc(app.logout)
+
+	app.Router.
+		Methods("GET").
+		Path("/auth/me").
+		HandlerFunc(app.getCurrentDonor)
+
+	app.Router.
+		Methods("GET").
+		Path("/accounts/search").
+		HandlerFunc(app.searchAccounts)
+
+	app.Router.
+		Methods("GET").
+		Path("/accounts/persons/{id:[a-zA-Z0-9]+}").
+		HandlerFunc(app.getPersonByID)
+
 	app.Router.
 		Methods("GET").
 		Path("/accounts/donors").
@@ -48,9 +151,9 @@ func (app *App) SetupRouter() {
 		HandlerFunc(app.getDonorByID)
 
 	app.Router.
-		Methods("DELETE", "OPTIONS").
+		Methods("DELETE").
 		Path("/accounts/donors/{id:[a-zA-Z0-9]+}").
-		HandlerFunc(app.deleteDonorByID)
+		Handler(app.requireDonor(http.HandlerFunc(app.deleteDonorByID)))
 
 	app.Router.
 		Methods("GET").
@@ -58,7 +161,7 @@ func (app *App) SetupRouter() {
 		HandlerFunc(app.getAcceptorByID)
 
 	app.Router.
-		Methods("DELETE", "OPTIONS").
+		Methods("DELETE").
 		Path("/accounts/acceptors/{id:[a-zA-Z0-9]+}").
 		HandlerFunc(app.deleteAcceptorByID)
 
@@ -68,15 +171,40 @@ func (app *App) SetupRouter() {
 		HandlerFunc(app.getAllAcceptors)
 
 	app.Router.
-		Methods("PUT", "OPTIONS").
+		Methods("PUT").
 		Path("/accounts/donors/{id:[a-zA-Z0-9]+}").
-		HandlerFunc(app.updateDonorByID)
+		Handler(app.requireDonor(http.HandlerFunc(app.updateDonorByID)))
 
 	app.Router.
-		Methods("PUT", "OPTIONS").
+		Methods("PUT").
 		Path("/accounts/acceptors/{id:[a-zA-Z0-9]+}").
 		HandlerFunc(app.updateAcceptorByID)
 
+	app.Router.
+		Methods("POST").
+		Path("/accounts/donors/{id:[a-zA-Z0-9]+}/phone/verification").
+		HandlerFunc(app.sendPhoneCode)
+
+	app.Router.
+		Methods("POST").
+		Path("/accounts/donors/{id:[a-zA-Z0-9]+}/phone/verify").
+		HandlerFunc(app.verifyPhone)
+
+	app.Router.
+		Methods("GET").
+		Path("/accounts/acceptors/{id:[a-zA-Z0-9]+}/notifications").
+		HandlerFunc(app.getAcceptorNotifications)
+
+	app.Router.
+		Methods("POST").
+		Path("/accounts/acceptors/{id:[a-zA-Z0-9]+}/notifications").
+		Handler(app.requireAdmin(http.HandlerFunc(app.notifyAcceptorDonors)))
+
+	app.Router.
+		Methods("GET").
+		Path("/accounts/verify-email").
+		HandlerFunc(app.verifyEmail)
+
 	app.Router.
 		Methods("GET").
 		Path("/accounts/donors/bloodtype/{bloodGroup:[a-zA-Z0-9]+}").
@@ -86,127 +214,181 @@ func (app *App) SetupRouter() {
 		Methods("GET").
 		Path("/accounts/acceptors/bloodtype/{bloodGroup:[a-zA-Z0-9]+}").
 		HandlerFunc(app.getAcceptorsByBloodGroup)
+
+	admin := app.Router.PathPrefix("/admin").Subrouter()
+	admin.Use(app.requireAdmin)
+
+	admin.
+		Methods("GET").
+		Path("/webhooks").
+		HandlerFunc(app.getWebhooks)
+
+	admin.
+		Methods("POST").
+		Path("/webhooks").
+		HandlerFunc(app.addWebhook)
+
+	admin.
+		Methods("GET").
+		Path("/webhooks/{id:[a-zA-Z0-9]+}").
+		HandlerFunc(app.getWebhookByID)
+
+	admin.
+		Methods("PUT").
+		Path("/webhooks/{id:[a-zA-Z0-9]+}").
+		HandlerFunc(app.updateWebhookByID)
+
+	admin.
+		Methods("DELETE").
+		Path("/webhooks/{id:[a-zA-Z0-9]+}").
+		HandlerFunc(app.deleteWebhookByID)
+
+	admin.
+		Methods("GET").
+		Path("/webhooks/{id:[a-zA-Z0-9]+}/deliveries").
+		HandlerFunc(app.getWebhookDeliveries)
+
+	admin.
+		Methods("GET").
+		Path("/webhooks/deliveries/{id:[a-zA-Z0-9]+}").
+		HandlerFunc(app.getWebhookDeliveryByID)
+
+	admin.
+		Methods("POST").
+		Path("/webhooks/deliveries/{id:[a-zA-Z0-9]+}/retry").
+		HandlerFunc(app.retryWebhookDelivery)
+
+	app.routePreflights()
 }
 
 func (app *App) homePage(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "Welcome to the HomePage!")
-	log.Printf("Endpoint Hit: GET /")
 }
 
 func (app *App) getAllDonors(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/donors")
-
-	setupCORS(&w, r)
+	limit, offset, paged, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	donors, err := app.DonorsRepo.GetAll()
+	var donors []Donor
+	if paged {
+		donors, err = app.DonorsRepo.GetPage(r.Context(), limit, offset)
+	} else {
+		donors, err = app.DonorsRepo.GetAll(r.Context())
+	}
 
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		logError(r.Context(), "could not load donors", err)
+		writeError(w, http.StatusInternalServerError, "could not load donors")
 	} else {
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(donors); err != nil {
-			log.Printf(err.Error())
+			logError(r.Context(), "could not write response", err)
 		}
 	}
 }
 
 func (app *App) getAllAcceptors(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/acceptors")
-	setupCORS(&w, r)
+	limit, offset, paged, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	acceptors, err := app.AcceptorsRepo.GetAll()
+	var acceptors []Acceptor
+	if paged {
+		acceptors, err = app.AcceptorsRepo.GetPage(r.Context(), limit, offset)
+	} else {
+		acceptors, err = app.AcceptorsRepo.GetAll(r.Context())
+	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		log.Printf("Could not load acceptors.")
+		logError(r.Context(), "could not load acceptors", err)
+		writeError(w, http.StatusInternalServerError, "could not load acceptors")
 	} else {
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(acceptors); err != nil {
-			log.Printf(err.Error())
+			logError(r.Context(), "could not write response", err)
 		}
 	}
 }
 
 func (app *App) getDonorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/donors/:id")
-	setupCORS(&w, r)
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		log.Printf("No ID in the path for GET /accounts/donors/:id")
+		logWarn(r.Context(), "No ID in the path for GET /accounts/donors/:id", nil)
 	}
 
-	donor, err := app.DonorsRepo.GetByID(id)
+	donor, err := app.DonorsRepo.GetByID(r.Context(), id)
 
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "donor not found")
+		return
+	}
 	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(donor); err != nil {
-		log.Printf(err.Error())
+		logError(r.Context(), "could not write response", err)
 	}
 }
 
 func (app *App) getAcceptorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/acceptors/:id")
-	setupCORS(&w, r)
-
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		log.Printf("No ID in the path for GET /accounts/acceptors/:id")
+		logWarn(r.Context(), "No ID in the path for GET /accounts/acceptors/:id", nil)
 	}
 
-	acceptor, err := app.AcceptorsRepo.GetByID(id)
+	acceptor, err := app.AcceptorsRepo.GetByID(r.Context(), id)
 
-	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database SELECT from acceptors failed for GET /accounts/acceptors/:id")
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "acceptor not found")
+	} else if err != nil {
+		logError(r.Context(), "could not load acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not load acceptor")
 	} else {
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(acceptor); err != nil {
-			log.Printf(err.Error())
+			logError(r.Context(), "could not write response", err)
 		}
 	}
 }
 
 func (app *App) updateDonorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: PUT /accounts/donors/:id")
-
-	setupCORS(&w, r)
-	if (*r).Method == "OPTIONS" {
-		return
-	}
-
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		log.Printf("No ID in the path for PUT /accounts/donors/:id")
+		logWarn(r.Context(), "No ID in the path for PUT /accounts/donors/:id", nil)
 	}
 
-	donor, err := app.DonorsRepo.GetByID(id)
+	donor, err := app.DonorsRepo.GetByID(r.Context(), id)
 
 	if err == sql.ErrNoRows {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusNotFound)
-		log.Printf("Cannot update unexisting donor.")
+		writeError(w, http.StatusNotFound, "donor not found")
+		return
 	}
 	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database SELECT from donors failed for PUT /accounts/donors/:id")
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(r.Context(), "could not read request body", err)
 	}
 	reqData := make(map[string]string)
 	json.Unmarshal(body, &reqData)
+	flags := accountFlags{}
+	json.Unmarshal(body, &flags)
 
 	if firstName, exists := reqData["name"]; exists {
 		donor.FirstName = firstName
@@ -215,13 +397,31 @@ func (app *App) updateDonorByID(w http.ResponseWriter, r *http.Request) {
 		donor.LastName = lastName
 	}
 	if phone, exists := reqData["phone"]; exists {
-		donor.PhoneNumber = phone
+		phone, err = NormalizePhone(phone, app.PhoneRegion)
+		if err != nil {
+			logInfo(r.Context(), "Invalid phone number for PUT /accounts/donors/:id", nil)
+			writeError(w, http.StatusBadRequest, ErrInvalidPhone.Error())
+			return
+		}
+		if phone != donor.PhoneNumber {
+			donor.PhoneNumber = phone
+			donor.PhoneVerified = false
+			donor.PhoneVerifiedAt = nil
+		}
 	}
-	if email, exists := reqData["email"]; exists {
+	emailChanged := false
+	if email, exists := reqData["email"]; exists && email != donor.Email {
 		donor.Email = email
-	}
-	if age, exists := reqData["age"]; exists {
-		donor.Age = age
+		donor.EmailVerified = false
+		donor.EmailVerifiedAt = nil
+		emailChanged = true
+	}
+	if value, exists := reqData["dateOfBirth"]; exists {
+		donor.DateOfBirth, err = ParseDateOfBirth(value, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 	if gender, exists := reqData["gender"]; exists {
 		donor.Gender = gender
@@ -229,221 +429,560 @@ func (app *App) updateDonorByID(w http.ResponseWriter, r *http.Request) {
 	if city, exists := reqData["city"]; exists {
 		donor.City = city
 	}
+	if flags.NotificationsOptIn != nil {
+		donor.NotificationsOptIn = *flags.NotificationsOptIn
+	}
 
-	_, err = app.DonorsRepo.Update(donor)
+	donor, err = app.DonorsRepo.Update(r.Context(), donor)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		logError(r.Context(), "could not update donor", err)
+		writeError(w, http.StatusInternalServerError, "could not update donor")
+		return
 	}
-}
-
-func (app *App) updateAcceptorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: PUT /accounts/acceptors/:id")
-	setupCORS(&w, r)
 
-	if (*r).Method == "OPTIONS" {
-		return
+	writeJSON(w, http.StatusOK, donor)
+	if emailChanged {
+		app.sendVerificationEmail(r.Context(), donor)
 	}
+}
 
+func (app *App) updateAcceptorByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		log.Printf("No ID in the path PUT /accounts/acceptors/:id")
+		logWarn(r.Context(), "No ID in the path PUT /accounts/acceptors/:id", nil)
 	}
-	acceptor, err := app.AcceptorsRepo.GetByID(id)
+	acceptor, err := app.AcceptorsRepo.GetByID(r.Context(), id)
 
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "acceptor not found")
+		return
+	}
 	if err != nil {
-		log.Printf(err.Error())
-		log.Printf("Cannot update unexisting acceptor.")
+		logError(r.Context(), "could not load acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not load acceptor")
+		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(r.Context(), "could not read request body", err)
 	}
 
 	reqData := make(map[string]string)
 	json.Unmarshal(body, &reqData)
+	flags := accountFlags{}
+	json.Unmarshal(body, &flags)
 
+	before := acceptor
 	if firstName, exists := reqData["name"]; exists {
 		acceptor.FirstName = firstName
 	}
 	if lastName, exists := reqData["lastName"]; exists {
 		acceptor.LastName = lastName
 	}
+	if acceptor.FirstName != before.FirstName || acceptor.LastName != before.LastName {
+		// the name is shared with the donor role, which only the donor may change
+		_, err := app.DonorsRepo.GetByPersonID(r.Context(), acceptor.PersonID)
+		if err == nil {
+			writeError(w, http.StatusForbidden, "the person is a donor, the name is changed with PUT /accounts/donors/{id}")
+			return
+		}
+		if err != sql.ErrNoRows {
+			logError(r.Context(), "could not load donor of the person", err)
+			writeError(w, http.StatusInternalServerError, "could not update acceptor")
+			return
+		}
+	}
 	if city, exists := reqData["city"]; exists {
 		acceptor.City = city
 	}
 	if bloodCenter, exists := reqData["bloodCenter"]; exists {
 		acceptor.BloodCenter = bloodCenter
 	}
+	becameUrgent := false
+	if flags.Urgent != nil {
+		becameUrgent = *flags.Urgent && !acceptor.Urgent
+		acceptor.Urgent = *flags.Urgent
+	}
 
-	err = app.AcceptorsRepo.Update(acceptor)
-
+	acceptor, err = app.AcceptorsRepo.Update(r.Context(), acceptor)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		logError(r.Context(), "could not update acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not update acceptor")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, acceptor)
+	if becameUrgent {
+		app.notifyDonors(r.Context(), acceptor)
 	}
 }
 
 func (app *App) getDonorsByBloodGroup(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/donors/bloodtype/:bloodGroup")
-	setupCORS(&w, r)
 	vars := mux.Vars(r)
 	bloodGroup, ok := vars["bloodGroup"]
 	if !ok {
-		log.Printf("No bloodGroup in the path for GET /accounts/donors/bloodtype/:bloodGroup")
+		logWarn(r.Context(), "No bloodGroup in the path for GET /accounts/donors/bloodtype/:bloodGroup", nil)
 	}
 
-	donors, err := app.DonorsRepo.GetByBloodGroup(bloodGroup)
+	donors, err := app.DonorsRepo.GetByBloodGroup(r.Context(), bloodGroup)
 
 	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		logError(r.Context(), "could not load donors", err)
+		writeError(w, http.StatusInternalServerError, "could not load donors")
 	} else {
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(donors); err != nil {
-			log.Printf(err.Error())
+			logError(r.Context(), "could not write response", err)
 		}
 	}
 }
 
 func (app *App) getAcceptorsByBloodGroup(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: GET /accounts/acceptors/bloodtype/:bloodGroup")
-	setupCORS(&w, r)
 	vars := mux.Vars(r)
 	bloodGroup, ok := vars["bloodGroup"]
 	if !ok {
-		log.Printf("No bloodGroup in the path for GET /accounts/acceptors/bloodtype/:bloodGroup")
+		logWarn(r.Context(), "No bloodGroup in the path for GET /accounts/acceptors/bloodtype/:bloodGroup", nil)
 	}
 
-	acceptors, err := app.AcceptorsRepo.GetByBloodGroup(bloodGroup)
+	acceptors, err := app.AcceptorsRepo.GetByBloodGroup(r.Context(), bloodGroup)
 
 	if err != nil {
-		log.Printf("No donors with the specific bloodGroup found.")
-		w.WriteHeader(http.StatusNotFound)
+		logError(r.Context(), "could not load acceptors", err)
+		writeError(w, http.StatusInternalServerError, "could not load acceptors")
 	} else {
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(acceptors); err != nil {
-			log.Printf(err.Error())
+			logError(r.Context(), "could not write response", err)
 		}
 	}
 }
 
 func (app *App) addDonor(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: POST /accounts/donors")
-	setupCORS(&w, r)
-
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(r.Context(), "could not read request body", err)
 	}
 	reqData := make(map[string]string)
 	json.Unmarshal(body, &reqData)
+	flags := accountFlags{}
+	json.Unmarshal(body, &flags)
 	donor := Donor{}
 	donor.ID = shortuuid.New()
-	donor.FirstName = reqData["name"]
-	donor.LastName = reqData["lastName"]
+	donor.PersonID = shortuuid.New()
+	var person *Person
+	if personID, exists := reqData["personId"]; exists {
+		existing, ok := app.loadPersonForRole(w, r, personID, RoleDonor)
+		if !ok {
+			return
+		}
+		person = &existing
+	}
+	if firstName, exists := reqData["name"]; exists {
+		donor.FirstName = firstName
+	}
+	if lastName, exists := reqData["lastName"]; exists {
+		donor.LastName = lastName
+	}
 	donor.BloodGroup = reqData["bloodGroup"]
 	donor.City = reqData["city"]
-	donor.PhoneNumber = reqData["phone"]
-	donor.Email = reqData["email"]
-	donor.Age = reqData["age"]
+	if phone, exists := reqData["phone"]; exists {
+		donor.PhoneNumber, err = NormalizePhone(phone, app.PhoneRegion)
+		if err != nil {
+			logInfo(r.Context(), "Invalid phone number for POST /accounts/donors", nil)
+			writeError(w, http.StatusBadRequest, ErrInvalidPhone.Error())
+			return
+		}
+	}
+	if email, exists := reqData["email"]; exists {
+		donor.Email = email
+	}
+	if value, exists := reqData["dateOfBirth"]; exists {
+		donor.DateOfBirth, err = ParseDateOfBirth(value, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	donor.Gender = reqData["gender"]
 	donor.City = reqData["city"]
-	timeNow := time.Now()
-	donor.RegistrationDate = timeNow.Format("2006-01-02 15:04:05")
+	if flags.NotificationsOptIn != nil {
+		donor.NotificationsOptIn = *flags.NotificationsOptIn
+	}
+	donor.RegistrationDate = nowUTC()
+	// the role of an existing person takes over its identity, the person is not changed by registering
+	if person != nil {
+		donor.AttachTo(*person)
+	}
 
-	app.DonorsRepo.Create(donor)
+	err = app.DonorsRepo.Create(r.Context(), donor)
 
-	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database INSERT to donors failed")
+	if isDuplicateKey(err) {
+		// another request made the person a donor since loadPersonForRole looked
+		writeError(w, http.StatusConflict, "the person already is a "+RoleDonor)
+	} else if err != nil {
+		logError(r.Context(), "could not create donor", err)
+		writeError(w, http.StatusInternalServerError, "could not create donor")
 	} else {
-		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Location", "/accounts/donors/"+donor.ID)
+		writeJSON(w, http.StatusCreated, donor)
+		app.sendVerificationEmail(r.Context(), donor)
 	}
 }
 
 func (app *App) addAcceptor(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: POST /accounts/acceptors")
-	setupCORS(&w, r)
-
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf(err.Error())
+		logError(r.Context(), "could not read request body", err)
 	}
 	reqData := make(map[string]string)
 	json.Unmarshal(body, &reqData)
+	flags := accountFlags{}
+	json.Unmarshal(body, &flags)
 	acceptor := Acceptor{}
 	acceptor.ID = shortuuid.New()
-	acceptor.FirstName = reqData["name"]
-	acceptor.LastName = reqData["lastName"]
+	acceptor.PersonID = shortuuid.New()
+	var person *Person
+	if personID, exists := reqData["personId"]; exists {
+		existing, ok := app.loadPersonForRole(w, r, personID, RoleAcceptor)
+		if !ok {
+			return
+		}
+		person = &existing
+	}
+	if firstName, exists := reqData["name"]; exists {
+		acceptor.FirstName = firstName
+	}
+	if lastName, exists := reqData["lastName"]; exists {
+		acceptor.LastName = lastName
+	}
 	acceptor.BloodCenter = reqData["bloodCenter"]
 	acceptor.BloodGroup = reqData["bloodGroup"]
 	acceptor.City = reqData["city"]
-	timeNow := time.Now()
-	acceptor.RegistrationDate = timeNow.Format("2006-01-02 15:04:05")
+	if flags.Urgent != nil {
+		acceptor.Urgent = *flags.Urgent
+	}
+	acceptor.RegistrationDate = nowUTC()
+	if person != nil {
+		acceptor.AttachTo(*person)
+	}
 
-	err = app.AcceptorsRepo.Create(acceptor)
+	err = app.AcceptorsRepo.Create(r.Context(), acceptor)
 
-	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database INSERT to acceptors failed")
+	if isDuplicateKey(err) {
+		writeError(w, http.StatusConflict, "the person already is a "+RoleAcceptor)
+	} else if err != nil {
+		logError(r.Context(), "could not create acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not create acceptor")
 	} else {
-		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Location", "/accounts/acceptors/"+acceptor.ID)
+		writeJSON(w, http.StatusCreated, acceptor)
+		app.notifyDonors(r.Context(), acceptor)
 	}
 }
 
-func (app *App) deleteDonorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: DELETE /accounts/donors/:id")
+func (app *App) getAcceptorNotifications(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := app.Notifications.GetByAcceptorID(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		logError(r.Context(), "could not load notifications", err)
+		writeError(w, http.StatusInternalServerError, "could not load notifications")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		logError(r.Context(), "could not write response", err)
+	}
+}
 
-	setupCORS(&w, r)
-	if (*r).Method == "OPTIONS" {
+func (app *App) notifyAcceptorDonors(w http.ResponseWriter, r *http.Request) {
+	acceptor, err := app.AcceptorsRepo.GetByID(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "acceptor not found")
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not load acceptor")
+		return
+	}
+	if app.Notifier == nil {
+		writeError(w, http.StatusServiceUnavailable, "donor notifications are disabled")
 		return
 	}
 
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		log.Printf("No ID in the path for DELETE /accounts/donors/:id")
+	deliveries, err := app.Notifier.NotifyCompatibleDonors(r.Context(), acceptor)
+	if err != nil {
+		logError(r.Context(), "could not notify donors", err)
+		writeError(w, http.StatusInternalServerError, "could not notify donors")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		logError(r.Context(), "could not write response", err)
+	}
+}
+
+// notifyDonors runs the donor notification for an acceptor in the background, failures are logged only.
+// It is detached from the request, which is usually answered first, and tracked so shutdown can wait for it.
+func (app *App) notifyDonors(ctx context.Context, acceptor Acceptor) {
+	if app.Notifier == nil {
+		return
+	}
+
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+
+		ctx, cancel := context.WithTimeout(detachedContext(ctx), backgroundTimeout)
+		defer cancel()
+
+		ctx, span := DefaultTracer.Start(ctx, "notify donors", SpanInternal)
+		defer span.End()
+		span.SetAttribute("acceptor.id", acceptor.ID)
+
+		deliveries, err := app.Notifier.NotifyCompatibleDonors(ctx, acceptor)
+		if err != nil {
+			span.SetError(err)
+			DefaultLogger.Error(ctx, "notifying donors failed", Fields{"acceptorId": acceptor.ID, "error": err})
+			return
+		}
+		logInfo(ctx, "notified donors", Fields{"acceptorId": acceptor.ID, "deliveries": len(deliveries)})
+	}()
+}
+
+// detachedContext keeps the request ID and trace of ctx but none of its deadline or cancellation
+func detachedContext(ctx context.Context) context.Context {
+	return ContextWithSpan(WithRequestID(context.Background(), RequestID(ctx)), SpanFromContext(ctx))
+}
+
+// WaitBackground waits for the background work started by requests, giving up when ctx is done
+func (app *App) WaitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		app.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (app *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" || app.EmailVerifier == nil {
+		writeError(w, http.StatusBadRequest, ErrTokenInvalid.Error())
+		return
+	}
+
+	claims, err := app.EmailVerifier.ParseToken(token)
+	if err == ErrTokenExpired {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	err := app.DonorsRepo.DeleteByID(id)
+	donor, err := app.DonorsRepo.GetByID(r.Context(), claims.DonorID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "donor not found")
+		return
+	}
 	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database DELETE failed for DELETE /accounts/donors/:id")
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("X"))
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
+	}
+	if donor.Email != claims.Email {
+		logInfo(r.Context(), "verification token was issued for a previous email", Fields{"donorId": donor.ID})
+		writeError(w, http.StatusBadRequest, ErrTokenInvalid.Error())
+		return
+	}
+
+	if !donor.EmailVerified {
+		verifiedAt := nowUTC()
+		donor.EmailVerified = true
+		donor.EmailVerifiedAt = &verifiedAt
+		if err := app.DonorsRepo.MarkEmailVerified(r.Context(), donor.ID, verifiedAt); err != nil {
+			logError(r.Context(), "could not verify email", err)
+			writeError(w, http.StatusInternalServerError, "could not verify email")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(donor); err != nil {
+		logError(r.Context(), "could not write response", err)
 	}
 }
 
-func (app *App) deleteAcceptorByID(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint Hit: DELETE /accounts/acceptor/:id")
+func (app *App) sendPhoneCode(w http.ResponseWriter, r *http.Request) {
+	donor, err := app.DonorsRepo.GetByID(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "donor not found")
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
+	}
+	if donor.PhoneNumber == "" || donor.PhoneVerified {
+		writeError(w, http.StatusConflict, "phone number is missing or already verified")
+		return
+	}
+
+	err = app.PhoneVerifier.Start(r.Context(), donor)
+	if err == ErrCodeRecentlySent || err == ErrTooManyAttempts {
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not send verification code", err)
+		writeError(w, http.StatusInternalServerError, "could not send verification code")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *App) verifyPhone(w http.ResponseWriter, r *http.Request) {
+	donor, err := app.DonorsRepo.GetByID(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "donor not found")
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load donor")
+		return
+	}
+
+	reqData := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || reqData["code"] == "" {
+		writeError(w, http.StatusBadRequest, "verification code is required")
+		return
+	}
+
+	// consuming the code and flagging the phone commit together, a rejected code still counts as an attempt
+	var checkErr error
+	verifiedAt := nowUTC()
+	donor.PhoneVerified = true
+	donor.PhoneVerifiedAt = &verifiedAt
+	err = WithTx(r.Context(), app.Database, func(ctx context.Context) error {
+		if checkErr = app.PhoneVerifier.Check(ctx, donor, reqData["code"]); checkErr != nil {
+			return nil
+		}
+		return app.DonorsRepo.MarkPhoneVerified(ctx, donor.ID, verifiedAt)
+	})
+	if err != nil {
+		logError(r.Context(), "could not verify phone number", err)
+		writeError(w, http.StatusInternalServerError, "could not verify phone number")
+		return
+	}
+
+	switch err := checkErr; err {
+	case nil:
+	case ErrNoPendingCode:
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case ErrCodeExpired:
+		writeError(w, http.StatusGone, err.Error())
+		return
+	case ErrCodeMismatch:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case ErrTooManyAttempts:
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	default:
+		logError(r.Context(), "could not check verification code", err)
+		writeError(w, http.StatusInternalServerError, "could not check verification code")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(donor); err != nil {
+		logError(r.Context(), "could not write response", err)
+	}
+}
 
-	setupCORS(&w, r)
-	if (*r).Method == "OPTIONS" {
+// sendVerificationEmail mails the donor a link to confirm the e-mail address, failures are logged only
+func (app *App) sendVerificationEmail(ctx context.Context, donor Donor) {
+	if app.Mailer == nil || app.EmailVerifier == nil || donor.Email == "" {
 		return
 	}
 
+	link := fmt.Sprintf("%s/accounts/verify-email?token=%s", app.BaseURL, url.QueryEscape(app.EmailVerifier.NewToken(donor.ID, donor.Email)))
+	err := app.Mailer.Send(Message{
+		To:      donor.Email,
+		Subject: "Confirm your LifeBlood e-mail address",
+		Body:    fmt.Sprintf("Hello %s,\r\n\r\nPlease confirm your e-mail address by opening the link below:\r\n\r\n%s\r\n", donor.FirstName, link),
+	})
+	if err != nil {
+		DefaultLogger.Error(ctx, "sending verification email failed", Fields{"donorId": donor.ID, "error": err})
+	}
+}
+
+func (app *App) deleteDonorByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		log.Printf("No ID in the path for DELETE /accounts/acceptor/:id")
+		logWarn(r.Context(), "No ID in the path for DELETE /accounts/donors/:id", nil)
 	}
-	err := app.AcceptorsRepo.DeleteByID(id)
-	if err != nil {
-		log.Printf(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Database DELETE failed for DELETE /accounts/acceptor/:id")
+
+	if err := app.DonorsRepo.DeleteByID(r.Context(), id); err != nil {
+		logError(r.Context(), "could not delete donor", err)
+		writeError(w, http.StatusInternalServerError, "could not delete donor")
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *App) deleteAcceptorByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		logWarn(r.Context(), "No ID in the path for DELETE /accounts/acceptor/:id", nil)
+	}
+	if err := app.AcceptorsRepo.DeleteByID(r.Context(), id); err != nil {
+		logError(r.Context(), "could not delete acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not delete acceptor")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func setupCORS(w *http.ResponseWriter, req *http.Request) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+// maxPageSize caps the limit query parameter of list endpoints
+const maxPageSize = 500
+
+// pagination reads the optional limit and offset query parameters, paged is false when limit is absent
+func pagination(r *http.Request) (limit int, offset int, paged bool, err error) {
+	query := r.URL.Query()
+	if query.Get("limit") == "" {
+		return 0, 0, false, nil
+	}
+
+	limit, err = strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, 0, false, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	if query.Get("offset") != "" {
+		offset, err = strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return 0, 0, false, errors.New("offset must be a non-negative number")
+		}
+	}
+
+	return limit, offset, true, nil
 }
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SchemaVersion is the version of the tables created by config.InitializeDatabase, bump it whenever they change
+const SchemaVersion = 7
+
+// Health check statuses
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthCheck is a dependency the service needs to serve requests
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a single health check
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Health is the body of the probe endpoints
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// backgroundWorker is satisfied by the relay and the webhook worker
+type backgroundWorker interface {
+	Running() bool
+}
+
+// DatabaseCheck pings the database
+func DatabaseCheck(db *sql.DB) HealthCheck {
+	return HealthCheck{Name: "database", Check: db.PingContext}
+}
+
+// SchemaCheck compares the schema version stored in the database with SchemaVersion
+func SchemaCheck(db *sql.DB) HealthCheck {
+	return HealthCheck{Name: "schema", Check: func(ctx context.Context) error {
+		var version int
+		if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+			return err
+		}
+		if version != SchemaVersion {
+			return fmt.Errorf("schema version is %d, %d is expected", version, SchemaVersion)
+		}
+		return nil
+	}}
+}
+
+// WorkerCheck reports whether a background worker is running
+func WorkerCheck(name string, worker backgroundWorker) HealthCheck {
+	return HealthCheck{Name: name, Check: func(ctx context.Context) error {
+		if !worker.Running() {
+			return errors.New("not running")
+		}
+		return nil
+	}}
+}
+
+// getLiveness answers as long as the process is able to serve requests
+func (app *App) getLiveness(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, Health{Status: HealthOK})
+}
+
+// getReadiness runs the readiness checks concurrently and answers 503 if any of them fails
+func (app *App) getReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.ReadinessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, app.ReadinessTimeout)
+		defer cancel()
+	}
+
+	type namedResult struct {
+		name   string
+		result CheckResult
+	}
+	results := make(chan namedResult, len(app.ReadinessChecks))
+	for _, check := range app.ReadinessChecks {
+		go func(check HealthCheck) {
+			started := time.Now()
+			result := CheckResult{Status: HealthOK}
+			if err := check.Check(ctx); err != nil {
+				result.Status = HealthUnavailable
+				result.Error = err.Error()
+			}
+			result.DurationMs = int64(time.Since(started) / time.Millisecond)
+			results <- namedResult{check.Name, result}
+		}(check)
+	}
+
+	health := Health{Status: HealthOK, Checks: map[string]CheckResult{}}
+	for range app.ReadinessChecks {
+		named := <-results
+		health.Checks[named.name] = named.result
+		if named.result.Status != HealthOK {
+			health.Status = HealthUnavailable
+		}
+	}
+
+	status := http.StatusOK
+	if health.Status != HealthOK {
+		logWarn(r.Context(), "readiness check failed", Fields{"checks": health.Checks})
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+type fakeWorker bool
+
+func (w fakeWorker) Running() bool {
+	return bool(w)
+}
+
+func TestGetLiveness(t *testing.T) {
+	app := &App{ReadinessChecks: []HealthCheck{WorkerCheck("outboxRelay", fakeWorker(false))}}
+	w := httptest.NewRecorder()
+	app.getLiveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	var health Health
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil || w.Code != http.StatusOK || health.Status != HealthOK || len(health.Checks) != 0 {
+		t.Errorf("GET /healthz = %d %+v, %v, want ok whatever the dependencies", w.Code, health, err)
+	}
+}
+
+func TestGetReadiness(t *testing.T) {
+	_, restore := captureLog()
+	defer restore()
+
+	store := sqlfake.NewStore()
+	store.Insert("schema_version", []string{"version"}, int64(SchemaVersion-1))
+	store.Insert("schema_version", []string{"version"}, int64(SchemaVersion))
+	database := sqlfake.Open(store.Handle)
+	outdated := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		return sqlfake.Rows([]string{"MAX(version)"}, []driver.Value{int64(SchemaVersion - 1)})
+	})
+	down := sqlfake.Open(store.Handle)
+	down.Close()
+	hanging := HealthCheck{Name: "hanging", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	tests := []struct {
+		name   string
+		checks []HealthCheck
+		want   map[string]string
+	}{
+		{"ready", []HealthCheck{DatabaseCheck(database), SchemaCheck(database), WorkerCheck("outboxRelay", fakeWorker(true))},
+			map[string]string{"database": HealthOK, "schema": HealthOK, "outboxRelay": HealthOK}},
+		{"database down", []HealthCheck{DatabaseCheck(down), SchemaCheck(down), WorkerCheck("outboxRelay", fakeWorker(true))},
+			map[string]string{"database": HealthUnavailable, "schema": HealthUnavailable, "outboxRelay": HealthOK}},
+		{"outdated schema", []HealthCheck{DatabaseCheck(outdated), SchemaCheck(outdated)},
+			map[string]string{"database": HealthOK, "schema": HealthUnavailable}},
+		{"stopped worker", []HealthCheck{WorkerCheck("webhookWorker", fakeWorker(false))},
+			map[string]string{"webhookWorker": HealthUnavailable}},
+		{"check timing out", []HealthCheck{DatabaseCheck(database), hanging},
+			map[string]string{"database": HealthOK, "hanging": HealthUnavailable}},
+	}
+
+	for _, tt := range tests {
+		app := &App{ReadinessChecks: tt.checks, ReadinessTimeout: 50 * time.Millisecond}
+		w := httptest.NewRecorder()
+		app.getReadiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var health Health
+		if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		wantStatus, wantCode := HealthOK, http.StatusOK
+		for name, status := range tt.want {
+			if health.Checks[name].Status != status {
+				t.Errorf("%s: check %s = %+v, want %s", tt.name, name, health.Checks[name], status)
+			}
+			if status != HealthOK {
+				wantStatus, wantCode = HealthUnavailable, http.StatusServiceUnavailable
+				if health.Checks[name].Error == "" {
+					t.Errorf("%s: check %s failed without an error", tt.name, name)
+				}
+			}
+		}
+		if w.Code != wantCode || health.Status != wantStatus || len(health.Checks) != len(tt.want) {
+			t.Errorf("%s: GET /readyz = %d %+v, want %d %s", tt.name, w.Code, health, wantCode, wantStatus)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader lets clients retry a POST without repeating its effect
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks an answer replayed from the first request with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLength bounds keys, a UUID is the expected value
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLockTimeout is how long a request may hold its key; a key claimed by an instance that
+// stopped before answering is given up after it
+const idempotencyLockTimeout = 5 * time.Minute
+
+// Idempotency stores the first answer to every POST sent with an Idempotency-Key for TTL and replays
+// it to retries of the same request
+type Idempotency struct {
+	repo *IdempotencyMySQL
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewIdempotency creates the store, keys are kept for ttl after the first request
+func NewIdempotency(repo *IdempotencyMySQL, ttl time.Duration) *Idempotency {
+	return &Idempotency{repo: repo, ttl: ttl, now: nowUTC}
+}
+
+// sweep deletes the expired keys, at most once a minute
+func (i *Idempotency) sweep(ctx context.Context, now time.Time) {
+	i.mu.Lock()
+	if now.Sub(i.lastSweep) < time.Minute {
+		i.mu.Unlock()
+		return
+	}
+	i.lastSweep = now
+	i.mu.Unlock()
+
+	deleted, err := i.repo.DeleteExpired(ctx, now)
+	if err != nil {
+		logError(ctx, "could not delete expired idempotency keys", err)
+		return
+	}
+	if deleted > 0 {
+		logInfo(ctx, "expired idempotency keys deleted", Fields{"count": deleted})
+	}
+}
+
+// idempotencyID scopes the key to the route and the principal, the subject of a verified token or else the
+// client address. Anonymous callers behind one address share keys, a retry with another payload gets 422
+// rather than the answer to someone else's request, and a retry from another address is a new request.
+func idempotencyID(principal, method, path, key string) string {
+	sum := sha256.Sum256([]byte(principal + "\n" + method + " " + path + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotentRoute tells the requests an Idempotency-Key applies to: every POST but the ones to /auth,
+// whose answers carry tokens that must not be stored
+func idempotentRoute(method, path string) bool {
+	return method == http.MethodPost && !strings.HasPrefix(path, "/auth/")
+}
+
+// withIdempotency answers a POST carrying an Idempotency-Key once: the first answer is stored and
+// replayed to retries with the same payload, a different payload gets 422 and a retry arriving while
+// the first request is still handled gets 409. Server errors are not stored, so they can be retried.
+func (app *App) withIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if app.Idempotency == nil || !idempotentRoute(r.Method, r.URL.Path) || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key must not be longer than "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "could not read the request body")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		store := app.Idempotency
+		now := store.now()
+		store.sweep(ctx, now)
+
+		principal := app.principal(r)
+		claim := IdempotentResponse{
+			ID:          idempotencyID(principal, r.Method, r.URL.Path, key),
+			RequestHash: requestHash(body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(store.ttl),
+		}
+		claimed, err := store.repo.Claim(ctx, claim, now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			logError(ctx, "could not claim idempotency key", err)
+			writeError(w, http.StatusInternalServerError, "could not check the Idempotency-Key")
+			return
+		}
+		if !claimed {
+			app.replay(w, r, claim)
+			return
+		}
+
+		recorder := newResponseCapture(w)
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.WriteHeader(http.StatusOK)
+		}
+
+		// the answer is stored even when the client gave up waiting for it
+		saveCtx, cancel := context.WithTimeout(detachedContext(ctx), backgroundTimeout)
+		defer cancel()
+		if recorder.status >= http.StatusInternalServerError {
+			err = store.repo.Release(saveCtx, claim.ID)
+		} else {
+			err = store.repo.Complete(saveCtx, claim.ID, recorder.status, recorder.added, recorder.body.Bytes())
+		}
+		if err != nil {
+			logError(ctx, "could not store the idempotent response", err)
+		}
+	})
+}
+
+// replay answers a retry with the stored response of the request that claimed the key
+func (app *App) replay(w http.ResponseWriter, r *http.Request, claim IdempotentResponse) {
+	ctx := r.Context()
+	stored, err := app.Idempotency.repo.GetByID(ctx, claim.ID)
+	switch {
+	case err == sql.ErrNoRows:
+		// the first request failed and released the key in the meantime
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, "a request with this Idempotency-Key is being processed, retry later")
+		return
+	case err != nil:
+		logError(ctx, "could not read idempotent response", err)
+		writeError(w, http.StatusInternalServerError, "could not check the Idempotency-Key")
+		return
+	}
+
+	if stored.RequestHash != claim.RequestHash {
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+		return
+	}
+	if stored.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, "a request with this Idempotency-Key is being processed, retry later")
+		return
+	}
+
+	header := w.Header()
+	for name, values := range stored.Header {
+		header[name] = values
+	}
+	header.Set(IdempotentReplayedHeader, "true")
+	logInfo(ctx, "idempotent response replayed", Fields{"status": stored.Status})
+	w.WriteHeader(stored.Status)
+	if _, err := w.Write(stored.Body); err != nil {
+		logError(ctx, "could not write response", err)
+	}
+}
+
+// responseCapture passes the answer of a handler on and keeps a copy of its status, body and the headers
+// the handler set, the headers of the outer middleware belong to the request they were written for
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	added  http.Header
+	body   bytes.Buffer
+
+	before http.Header
+}
+
+func newResponseCapture(w http.ResponseWriter) *responseCapture {
+	return &responseCapture{ResponseWriter: w, before: w.Header().Clone()}
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = status
+	c.added = http.Header{}
+	for name, values := range c.Header() {
+		if previous, ok := c.before[name]; !ok || !equalValues(previous, values) {
+			c.added[name] = values
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
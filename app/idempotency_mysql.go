@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//IdempotentResponse the first answer to a request sent with an Idempotency-Key, Status is 0 while it is being handled
+type IdempotentResponse struct {
+	ID          string
+	RequestHash string
+	Status      int
+	Header      http.Header
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+//IdempotencyMySQL mysql repo
+type IdempotencyMySQL struct {
+	statements *statements
+
+	deleteStale   *stmt
+	claim         *stmt
+	getByID       *stmt
+	complete      *stmt
+	deleteByID    *stmt
+	deleteExpired *stmt
+}
+
+//NewIdempotencyMySQL create new repository, preparing its statements
+func NewIdempotencyMySQL(db *sql.DB) (*IdempotencyMySQL, error) {
+	s := newStatements(db)
+	r := &IdempotencyMySQL{
+		statements: s,
+
+		deleteStale: s.prepare(`DELETE FROM idempotency_keys WHERE id=? AND (expiresAt<=? OR (status=0 AND createdAt<=?));`),
+		claim: s.prepare(`INSERT IGNORE INTO idempotency_keys (id, requestHash, status, createdAt, expiresAt)
+		VALUES (?,?,0,?,?);`),
+		getByID:       s.prepare(`SELECT id, requestHash, status, headers, body, createdAt, expiresAt FROM idempotency_keys WHERE id=?`),
+		complete:      s.prepare(`UPDATE idempotency_keys SET status=?, headers=?, body=? WHERE id=?;`),
+		deleteByID:    s.prepare(`DELETE FROM idempotency_keys WHERE id=?;`),
+		deleteExpired: s.prepare(`DELETE FROM idempotency_keys WHERE expiresAt<=?;`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *IdempotencyMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//Claim reserves the key for a request, false when another request holds it. An expired key, or one claimed
+//before abandonedBefore and never completed, is given up first.
+func (r *IdempotencyMySQL) Claim(ctx context.Context, claim IdempotentResponse, abandonedBefore time.Time) (_ bool, err error) {
+	defer observeRepo("IdempotencyMySQL", "Claim", time.Now(), &err)
+	if _, err = r.deleteStale.exec(ctx, claim.ID, claim.CreatedAt, abandonedBefore); err != nil {
+		return false, err
+	}
+
+	result, err := r.claim.exec(ctx, claim.ID, claim.RequestHash, claim.CreatedAt, claim.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted == 1, err
+}
+
+//GetByID Retrieve the response stored under a key
+func (r *IdempotencyMySQL) GetByID(ctx context.Context, id string) (_ IdempotentResponse, err error) {
+	defer observeRepo("IdempotencyMySQL", "GetByID", time.Now(), &err)
+	response := IdempotentResponse{}
+	var header sql.NullString
+	err = r.getByID.queryRow(ctx, id).Scan(
+		&response.ID,
+		&response.RequestHash,
+		&response.Status,
+		&header,
+		&response.Body,
+		&response.CreatedAt,
+		&response.ExpiresAt)
+	if err != nil {
+		return response, err
+	}
+
+	if header.Valid {
+		err = json.Unmarshal([]byte(header.String), &response.Header)
+	}
+	return response, err
+}
+
+//Complete stores the response of the request holding the key
+func (r *IdempotencyMySQL) Complete(ctx context.Context, id string, status int, header http.Header, body []byte) (err error) {
+	defer observeRepo("IdempotencyMySQL", "Complete", time.Now(), &err)
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = r.complete.exec(ctx, status, string(encoded), body, id)
+	return err
+}
+
+//Release gives up the key, so a retry is handled again
+func (r *IdempotencyMySQL) Release(ctx context.Context, id string) (err error) {
+	defer observeRepo("IdempotencyMySQL", "Release", time.Now(), &err)
+	_, err = r.deleteByID.exec(ctx, id)
+	return err
+}
+
+//DeleteExpired removes the keys that expired before now
+func (r *IdempotencyMySQL) DeleteExpired(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer observeRepo("IdempotencyMySQL", "DeleteExpired", time.Now(), &err)
+	result, err := r.deleteExpired.exec(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
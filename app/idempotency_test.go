@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestWithIdempotencyScopesKeysToCaller(t *testing.T) {
+	repo, err := NewIdempotencyMySQL(sqlfake.Open(sqlfake.NewStore().Handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Idempotency: NewIdempotency(repo, time.Hour), Authenticator: newTestAuthenticator(t)}
+	handled := 0
+	handler := app.withIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		writeJSON(w, http.StatusCreated, map[string]string{"id": fmt.Sprintf("d%d", handled)})
+	}))
+
+	tests := []struct {
+		name     string
+		address  string
+		token    string
+		body     string
+		status   int
+		replayed bool
+		handled  int
+	}{
+		{"first request", "203.0.113.7:4000", "", `{"name":"Ivan"}`, http.StatusCreated, false, 1},
+		{"retry", "203.0.113.7:4001", "", `{"name":"Ivan"}`, http.StatusCreated, true, 1},
+		{"retry with another payload", "203.0.113.7:4000", "", `{"name":"Maria"}`, http.StatusUnprocessableEntity, false, 1},
+		{"anonymous caller elsewhere", "198.51.100.1:4000", "", `{"name":"Ivan"}`, http.StatusCreated, false, 2},
+		{"donor at the same address", "203.0.113.7:4000", "s1.secret", `{"name":"Ivan"}`, http.StatusCreated, false, 3},
+		{"donor retrying from elsewhere", "198.51.100.1:4000", "s1.secret", `{"name":"Ivan"}`, http.StatusCreated, true, 3},
+		{"another donor", "198.51.100.1:4000", "s2.secret", `{"name":"Ivan"}`, http.StatusCreated, false, 4},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/accounts/donors", strings.NewReader(tt.body))
+		r.RemoteAddr = tt.address
+		r.Header.Set(IdempotencyKeyHeader, "4f1c2a9e-key")
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status || (w.Header().Get(IdempotentReplayedHeader) == "true") != tt.replayed || handled != tt.handled {
+			t.Errorf("%s = %d, replayed %q, %d handled, want %d, replayed %v, %d handled",
+				tt.name, w.Code, w.Header().Get(IdempotentReplayedHeader), handled, tt.status, tt.replayed, tt.handled)
+		}
+	}
+}
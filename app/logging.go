@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Log levels, entries below the level of the logger are dropped
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name such as "info"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Fields are the structured values of a log entry
+type Fields map[string]interface{}
+
+// RequestIDHeader carries the request ID, it is accepted from clients and echoed in every response
+const RequestIDHeader = "X-Request-ID"
+
+// Logger writes one JSON object per line, with the request ID of the context and PII redacted
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+	now   func() time.Time
+}
+
+// NewLogger creates a logger writing entries of at least level to out
+func NewLogger(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, level: level, now: time.Now}
+}
+
+// DefaultLogger is used by the whole service
+var DefaultLogger = NewLogger(os.Stderr, LevelInfo)
+
+// Debug logs details only needed when troubleshooting
+func (l *Logger) Debug(ctx context.Context, msg string, fields Fields) {
+	l.log(ctx, LevelDebug, msg, fields)
+}
+
+// Info logs normal operation
+func (l *Logger) Info(ctx context.Context, msg string, fields Fields) {
+	l.log(ctx, LevelInfo, msg, fields)
+}
+
+// Warn logs problems the service recovered from
+func (l *Logger) Warn(ctx context.Context, msg string, fields Fields) {
+	l.log(ctx, LevelWarn, msg, fields)
+}
+
+// Error logs failures
+func (l *Logger) Error(ctx context.Context, msg string, fields Fields) {
+	l.log(ctx, LevelError, msg, fields)
+}
+
+func (l *Logger) log(ctx context.Context, level Level, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+4)
+	for key, value := range fields {
+		entry[key] = redactField(key, value)
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = redactText(msg)
+	if id := RequestID(ctx); id != "" {
+		entry["requestId"] = id
+	}
+	if sc := SpanFromContext(ctx); sc.IsValid() {
+		entry["traceId"] = sc.TraceID
+		entry["spanId"] = sc.SpanID
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": LevelError.String(), "msg": "could not encode log entry: " + err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// StdWriter adapts the logger for the standard log package, every line becomes an info entry
+func (l *Logger) StdWriter() io.Writer {
+	return stdLogWriter{l}
+}
+
+type stdLogWriter struct {
+	logger *Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.log(context.Background(), LevelInfo, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+func logInfo(ctx context.Context, msg string, fields Fields) {
+	DefaultLogger.Info(ctx, msg, fields)
+}
+
+func logWarn(ctx context.Context, msg string, fields Fields) {
+	DefaultLogger.Warn(ctx, msg, fields)
+}
+
+func logError(ctx context.Context, msg string, err error) {
+	DefaultLogger.Error(ctx, msg, Fields{"error": err})
+}
+
+// piiFields are redacted whatever their value
+var piiFields = map[string]bool{
+	"name": true, "firstname": true, "lastname": true, "email": true, "phone": true, "phonenumber": true,
+	"to": true, "code": true, "token": true, "password": true, "secret": true, "authorization": true,
+	"dateofbirth": true, "gender": true, "bloodgroup": true, "city": true,
+}
+
+const redacted = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+\d{7,15}|\b\d{9,15}\b`)
+)
+
+func redactField(key string, value interface{}) interface{} {
+	if piiFields[strings.ToLower(key)] {
+		return redacted
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		var decoded interface{}
+		if err := json.Unmarshal(v, &decoded); err != nil {
+			return redacted
+		}
+		return redactField("", decoded)
+	case map[string]interface{}:
+		clean := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			clean[key] = redactField(key, nested)
+		}
+		return clean
+	case []interface{}:
+		clean := make([]interface{}, len(v))
+		for i, nested := range v {
+			clean[i] = redactField("", nested)
+		}
+		return clean
+	case error:
+		return redactText(v.Error())
+	case string:
+		return redactText(v)
+	case fmt.Stringer:
+		return redactText(v.String())
+	default:
+		return redactValue(v)
+	}
+}
+
+// redactValue redacts structs, maps and slices through their JSON form, so their fields are masked by name
+func redactValue(value interface{}) interface{} {
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return redacted
+		}
+		return redactField("", json.RawMessage(encoded))
+	default:
+		return value
+	}
+}
+
+// redactText masks e-mail addresses and phone numbers in free text such as driver errors
+func redactText(text string) string {
+	text = emailPattern.ReplaceAllString(text, redacted)
+	return phonePattern.ReplaceAllString(text, redacted)
+}
+
+type requestIDKey struct{}
+
+// RequestID is the ID of the request the context belongs to, empty outside requests
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns a context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// withRequestID takes the request ID sent by the client, or generates one, and echoes it in the response
+func (app *App) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = shortuuid.New()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// withAccessLog logs every request with its status and latency once it is answered
+func (app *App) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		fields := Fields{
+			"method":     r.Method,
+			"route":      routeTemplate(r),
+			"status":     recorder.status,
+			"bytes":      recorder.bytes,
+			"durationMs": float64(time.Since(started).Microseconds()) / 1000,
+		}
+		switch {
+		case recorder.status >= 500:
+			DefaultLogger.Error(r.Context(), "request failed", fields)
+		case recorder.status >= 400:
+			DefaultLogger.Warn(r.Context(), "request rejected", fields)
+		default:
+			DefaultLogger.Info(r.Context(), "request served", fields)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// personalValues are the values of testDonor that must never be logged
+var personalValues = []string{"Ivan", "Petrov", "ivan@example.com", "888123456", "1990-04-12", "male", "A+", "Sofia"}
+
+func testDonor() Donor {
+	birth := Date{Time: time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC)}
+	return Donor{
+		ID: "d1", PersonID: "p1", FirstName: "Ivan", LastName: "Petrov", PhoneNumber: "+359888123456",
+		Email: "ivan@example.com", DateOfBirth: &birth, Gender: "male", BloodGroup: "A+", City: "Sofia",
+	}
+}
+
+// captureLog makes DefaultLogger write to the returned buffer until restore is called
+func captureLog() (out *bytes.Buffer, restore func()) {
+	previous := DefaultLogger
+	out = &bytes.Buffer{}
+	DefaultLogger = NewLogger(out, LevelDebug)
+	return out, func() { DefaultLogger = previous }
+}
+
+func assertRedacted(t *testing.T, out string) {
+	t.Helper()
+	for _, value := range personalValues {
+		if strings.Contains(out, value) {
+			t.Errorf("log output reveals %q: %s", value, out)
+		}
+	}
+}
+
+func TestLoggerRedactsPersonalData(t *testing.T) {
+	out, restore := captureLog()
+	defer restore()
+
+	donor := testDonor()
+	payload, err := json.Marshal(donor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		fields Fields
+	}{
+		{"fields", Fields{"name": "Ivan", "lastName": "Petrov", "email": "ivan@example.com", "phone": "+359888123456",
+			"dateOfBirth": donor.DateOfBirth, "gender": "male", "bloodGroup": "A+", "city": "Sofia"}},
+		{"struct", Fields{"donor": donor}},
+		{"pointer", Fields{"donor": &donor}},
+		{"slice", Fields{"donors": []Donor{donor}}},
+		{"map", Fields{"input": map[string]string{"name": "Ivan", "city": "Sofia", "bloodGroup": "A+", "gender": "male"}}},
+		{"nested fields", Fields{"request": Fields{"body": map[string]interface{}{"dateOfBirth": "1990-04-12"}}}},
+		{"raw JSON", Fields{"payload": json.RawMessage(payload)}},
+		{"text", Fields{"error": "duplicate entry ivan@example.com for key email, call +359888123456"}},
+	}
+
+	for _, tt := range tests {
+		out.Reset()
+		logInfo(context.Background(), tt.name, tt.fields)
+		assertRedacted(t, out.String())
+	}
+
+	out.Reset()
+	logInfo(context.Background(), "donor", Fields{"donor": donor, "count": 2})
+	var entry struct {
+		Donor map[string]interface{} `json:"donor"`
+		Count int                    `json:"count"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Donor["id"] != "d1" || entry.Donor["city"] != redacted || entry.Count != 2 {
+		t.Errorf("logged %s, want the donor with its personal fields redacted", out)
+	}
+}
+
+func TestLogPublisherRedactsPayload(t *testing.T) {
+	out, restore := captureLog()
+	defer restore()
+
+	event, err := newEvent(DonorRegistered, donorAggregate, "d1", testDonor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (LogPublisher{}).Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), event.ID) {
+		t.Errorf("log output %s lacks the event", out)
+	}
+	assertRedacted(t, out.String())
+}
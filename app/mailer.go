@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text e-mail sent by the service
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the transport used to deliver e-mails to donors
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer delivers messages through an SMTP relay
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer for the relay at host:port, authenticating only when a username is given
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		Addr: fmt.Sprintf("%s:%s", host, port),
+		From: from,
+		Auth: auth,
+	}
+}
+
+// Send delivers the message to the relay
+func (m *SMTPMailer) Send(msg Message) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// OutboxMailer keeps sent messages in memory and optionally writes them to a directory.
+// It is meant for local development and tests, where no SMTP relay is available.
+type OutboxMailer struct {
+	dir      string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewOutboxMailer creates an outbox mailer, dir may be empty to keep messages in memory only
+func NewOutboxMailer(dir string) *OutboxMailer {
+	return &OutboxMailer{dir: dir}
+}
+
+// Send stores the message in the outbox
+func (m *OutboxMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	if m.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), len(m.messages))
+	return ioutil.WriteFile(filepath.Join(m.dir, name), formatMessage("outbox@localhost", msg), 0644)
+}
+
+// Messages returns a copy of all messages sent so far
+func (m *OutboxMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
+
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
@@ -0,0 +1,300 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMetrics collects the metrics of the service, the repositories record their calls in it
+var DefaultMetrics = NewMetrics()
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics holds the counters and histograms exposed on /metrics in the Prometheus text format
+type Metrics struct {
+	mu               sync.Mutex
+	requests         map[labels]uint64
+	requestDurations map[labels]*histogram
+	repoDurations    map[labels]*histogram
+	repoErrors       map[labels]uint64
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:         map[labels]uint64{},
+		requestDurations: map[labels]*histogram{},
+		repoDurations:    map[labels]*histogram{},
+		repoErrors:       map[labels]uint64{},
+	}
+}
+
+// ObserveRequest records a served request under its route template
+func (m *Metrics) ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[newLabels("route", route, "method", method, "status", strconv.Itoa(status))]++
+	observe(m.requestDurations, newLabels("route", route, "method", method), elapsed)
+}
+
+// ObserveRepoCall records a repository call, sql.ErrNoRows is an answer rather than an error
+func (m *Metrics) ObserveRepoCall(repository, method string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := newLabels("repository", repository, "method", method)
+	observe(m.repoDurations, key, elapsed)
+	if err != nil && err != sql.ErrNoRows {
+		m.repoErrors[key]++
+	}
+}
+
+// WriteText writes the collected metrics in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounters(w, "accounts_http_requests_total", "Requests served, by route template, method and status.", m.requests)
+	writeHistograms(w, "accounts_http_request_duration_seconds", "Time spent serving requests.", m.requestDurations)
+	writeHistograms(w, "accounts_repository_call_duration_seconds", "Time spent in repository calls.", m.repoDurations)
+	writeCounters(w, "accounts_repository_errors_total", "Repository calls that failed.", m.repoErrors)
+}
+
+// observeRepo is deferred by repository methods: defer observeRepo("DonorsMySQL", "GetAll", time.Now(), &err)
+func observeRepo(repository, method string, started time.Time, err *error) {
+	DefaultMetrics.ObserveRepoCall(repository, method, time.Since(started), *err)
+}
+
+// labels is a rendered Prometheus label set such as `route="/",method="GET"`, usable as a map key
+type labels string
+
+func newLabels(pairs ...string) labels {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabel(pairs[i+1])))
+	}
+	return labels(strings.Join(parts, ","))
+}
+
+func (l labels) with(name, value string) labels {
+	extra := newLabels(name, value)
+	if l == "" {
+		return extra
+	}
+	return l + "," + extra
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func observe(histograms map[labels]*histogram, key labels, elapsed time.Duration) {
+	h, ok := histograms[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		histograms[key] = h
+	}
+
+	seconds := elapsed.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func writeCounters(w io.Writer, name, help string, counters map[labels]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range sortedKeys(counters) {
+		if key == "" {
+			fmt.Fprintf(w, "%s %d\n", name, counters[key])
+		} else {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, key, counters[key])
+		}
+	}
+}
+
+func writeHistograms(w io.Writer, name, help string, histograms map[labels]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	keys := make([]labels, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, key := range keys {
+		h := histograms[key]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, key.with("le", strconv.FormatFloat(bound, 'g', -1, 64)), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, key.with("le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, key, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, key, h.count)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, values map[labels]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+
+	keys := make([]labels, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(w, "%s %g\n", name, values[key])
+		} else {
+			fmt.Fprintf(w, "%s{%s} %g\n", name, key, values[key])
+		}
+	}
+}
+
+func sortedKeys(counters map[labels]uint64) []labels {
+	keys := make([]labels, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// statusRecorder remembers the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+// unmatchedRoute labels requests whose route has no path template. The path itself is chosen by the client
+// and would add a metric series, log value and span name per path.
+const unmatchedRoute = "unmatched"
+
+// routeTemplate is the matched route in its OpenAPI form, so paths differing in IDs are grouped
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return specPath(template)
+		}
+	}
+	return unmatchedRoute
+}
+
+// withMetrics records every request matched by the router under its route template
+func (app *App) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		DefaultMetrics.ObserveRequest(route, r.Method, recorder.status, time.Since(started))
+	})
+}
+
+func (app *App) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	DefaultMetrics.WriteText(w)
+	if app.Database != nil {
+		writePoolStats(w, app.Database.Stats())
+	}
+	if app.Cluster != nil {
+		app.Cluster.writeReplicaGauges(w)
+	}
+	app.writeAccountGauges(w, r)
+}
+
+// writePoolStats exposes the sql.DBStats of the connection pool
+func writePoolStats(w io.Writer, stats sql.DBStats) {
+	gauges := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"accounts_db_max_open_connections", "Maximum number of open connections.", float64(stats.MaxOpenConnections)},
+		{"accounts_db_open_connections", "Established connections, in use or idle.", float64(stats.OpenConnections)},
+		{"accounts_db_in_use_connections", "Connections currently in use.", float64(stats.InUse)},
+		{"accounts_db_idle_connections", "Idle connections.", float64(stats.Idle)},
+	}
+	for _, gauge := range gauges {
+		writeGauge(w, gauge.name, gauge.help, map[labels]float64{"": gauge.value})
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"accounts_db_wait_count_total", "Connections waited for.", uint64(stats.WaitCount)},
+		{"accounts_db_max_idle_closed_total", "Connections closed due to the idle limit.", uint64(stats.MaxIdleClosed)},
+		{"accounts_db_max_lifetime_closed_total", "Connections closed due to their maximum lifetime.", uint64(stats.MaxLifetimeClosed)},
+	}
+	for _, counter := range counters {
+		writeCounters(w, counter.name, counter.help, map[labels]uint64{"": counter.value})
+	}
+	writeGauge(w, "accounts_db_wait_duration_seconds", "Total time blocked waiting for connections.", map[labels]float64{"": stats.WaitDuration.Seconds()})
+}
+
+// writeAccountGauges exposes business figures read from the database
+func (app *App) writeAccountGauges(w io.Writer, r *http.Request) {
+	if app.DonorsRepo != nil {
+		counts, err := app.DonorsRepo.CountByBloodGroup(r.Context())
+		if err != nil {
+			logError(r.Context(), "could not count donors", err)
+		} else {
+			writeGauge(w, "accounts_donors", "Registered donors by blood group.", bloodGroupGauge(counts))
+		}
+	}
+
+	if app.AcceptorsRepo != nil {
+		counts, err := app.AcceptorsRepo.CountByBloodGroup(r.Context())
+		if err != nil {
+			logError(r.Context(), "could not count acceptors", err)
+		} else {
+			writeGauge(w, "accounts_acceptors", "Registered acceptors by blood group.", bloodGroupGauge(counts))
+		}
+	}
+}
+
+func bloodGroupGauge(counts map[string]int) map[labels]float64 {
+	values := map[labels]float64{}
+	for bloodGroup, count := range counts {
+		values[newLabels("bloodGroup", bloodGroup)] = float64(count)
+	}
+	return values
+}
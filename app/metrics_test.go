@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	var got string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = routeTemplate(r) })
+	router.Methods("GET").Path("/accounts/donors/{id:[a-zA-Z0-9]+}").Handler(record)
+	router.Methods("PATCH").Handler(record)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/accounts/donors/d1", "/accounts/donors/{id}"},
+		{"PATCH", "/accounts/donors/d1", unmatchedRoute},
+		{"PATCH", "/anything/chosen/by/the/client", unmatchedRoute},
+	}
+	for _, tt := range tests {
+		got = ""
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if got != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWithMetricsGroupsUnmatchedPaths(t *testing.T) {
+	app := &App{}
+	router := mux.NewRouter()
+	router.Use(app.withMetrics)
+	// a route without a path template, matching any path
+	router.Methods("PATCH").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/x1", "/accounts/donors/zz1", "/accounts/donors/zz2", "/no/such/path"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PATCH", path, nil))
+	}
+
+	var out bytes.Buffer
+	DefaultMetrics.WriteText(&out)
+	series := 0
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "accounts_http_requests_total{") && strings.Contains(line, `method="PATCH"`) {
+			series++
+			if !strings.Contains(line, `route="unmatched"`) || !strings.HasSuffix(line, " 4") {
+				t.Errorf("series %s, want the 4 requests under route=\"unmatched\"", line)
+			}
+		}
+	}
+	if series != 1 {
+		t.Errorf("%d PATCH series, want 1:\n%s", series, out.String())
+	}
+}
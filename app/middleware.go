@@ -0,0 +1,20 @@
+package app
+
+import (
+	"context"
+	"net/http"
+)
+
+// withRequestTimeout gives every request a deadline, so slow queries are cancelled once it passes
+func (app *App) withRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.RequestTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
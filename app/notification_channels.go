@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Notice is the message sent to donors who can help an acceptor
+type Notice struct {
+	Acceptor Acceptor
+	Subject  string
+	Text     string
+}
+
+// NotificationChannel delivers notices to donors
+type NotificationChannel interface {
+	// Name identifies the channel in delivery records
+	Name() string
+	// Reachable reports whether the donor can be contacted over the channel
+	Reachable(donor Donor) bool
+	Notify(ctx context.Context, donor Donor, notice Notice) error
+}
+
+// EmailChannel sends notices to verified donor e-mail addresses
+type EmailChannel struct {
+	Mailer Mailer
+}
+
+// Name of the channel
+func (c EmailChannel) Name() string { return "email" }
+
+// Reachable only donors with a verified e-mail address
+func (c EmailChannel) Reachable(donor Donor) bool { return donor.Email != "" && donor.EmailVerified }
+
+// Notify sends the notice by e-mail
+func (c EmailChannel) Notify(ctx context.Context, donor Donor, notice Notice) error {
+	return c.Mailer.Send(Message{To: donor.Email, Subject: notice.Subject, Body: notice.Text})
+}
+
+// SMSChannel sends notices to verified donor phone numbers
+type SMSChannel struct {
+	Sender SMSSender
+}
+
+// Name of the channel
+func (c SMSChannel) Name() string { return "sms" }
+
+// Reachable only donors with a verified phone number
+func (c SMSChannel) Reachable(donor Donor) bool {
+	return donor.PhoneNumber != "" && donor.PhoneVerified
+}
+
+// Notify sends the notice by SMS
+func (c SMSChannel) Notify(ctx context.Context, donor Donor, notice Notice) error {
+	return c.Sender.SendSMS(donor.PhoneNumber, notice.Text)
+}
+
+// WebhookChannel posts every notice as JSON to an external service, e.g. a push notification gateway
+type WebhookChannel struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookChannel creates a webhook channel posting to url
+func NewWebhookChannel(url string) *WebhookChannel {
+	return &WebhookChannel{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name of the channel
+func (c *WebhookChannel) Name() string { return "webhook" }
+
+// Reachable every donor, the receiving service decides how to contact them
+func (c *WebhookChannel) Reachable(donor Donor) bool { return true }
+
+// Notify posts the notice and expects a 2xx answer
+func (c *WebhookChannel) Notify(ctx context.Context, donor Donor, notice Notice) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"donorId":    donor.ID,
+		"acceptorId": notice.Acceptor.ID,
+		"bloodGroup": notice.Acceptor.BloodGroup,
+		"city":       notice.Acceptor.City,
+		"subject":    notice.Subject,
+		"text":       notice.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	InjectTraceHeaders(ctx, req.Header)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
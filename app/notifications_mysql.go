@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Delivery statuses of a notification sent to a single donor over a single channel
+const (
+	DeliverySent      = "sent"
+	DeliveryFailed    = "failed"
+	DeliveryThrottled = "throttled"
+)
+
+// NotificationDelivery records the outcome of notifying one donor about an acceptor in need
+type NotificationDelivery struct {
+	ID         string    `json:"id"`
+	AcceptorID string    `json:"acceptorId"`
+	DonorID    string    `json:"donorId"`
+	Channel    string    `json:"channel"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+//NotificationsMySQL mysql repo
+type NotificationsMySQL struct {
+	statements *statements
+
+	create          *stmt
+	getByAcceptorID *stmt
+	lastSentAt      *stmt
+}
+
+//NewNotificationsMySQL create new repository, preparing its statements
+func NewNotificationsMySQL(db *sql.DB) (*NotificationsMySQL, error) {
+	s := newStatements(db)
+	r := &NotificationsMySQL{
+		statements: s,
+
+		create: s.prepare(`INSERT INTO notification_deliveries (id, acceptorId, donorId, channel, status, error, createdAt)
+		VALUES (?,?,?,?,?,?,?);`),
+		getByAcceptorID: s.prepare(`SELECT id, acceptorId, donorId, channel, status, error, createdAt
+		FROM notification_deliveries WHERE acceptorId=? ORDER BY createdAt`),
+		lastSentAt: s.prepare(`SELECT MAX(createdAt) FROM notification_deliveries WHERE donorId=? AND status=?`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *NotificationsMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//Create record a delivery
+func (r *NotificationsMySQL) Create(ctx context.Context, delivery NotificationDelivery) (err error) {
+	defer observeRepo("NotificationsMySQL", "Create", time.Now(), &err)
+	_, err = r.create.exec(ctx,
+		delivery.ID, delivery.AcceptorID, delivery.DonorID, delivery.Channel, delivery.Status, nullString(delivery.Error), delivery.CreatedAt)
+	return err
+}
+
+//GetByAcceptorID list the deliveries triggered by an acceptor
+func (r *NotificationsMySQL) GetByAcceptorID(ctx context.Context, acceptorID string) (_ []NotificationDelivery, err error) {
+	defer observeRepo("NotificationsMySQL", "GetByAcceptorID", time.Now(), &err)
+	deliveries := make([]NotificationDelivery, 0)
+	rows, err := r.getByAcceptorID.query(ctx, acceptorID)
+	if err != nil {
+		logError(ctx, "NotificationsMySQL.GetByAcceptorID failed", err)
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery := NotificationDelivery{}
+		var deliveryErr sql.NullString
+		err := rows.Scan(&delivery.ID, &delivery.AcceptorID, &delivery.DonorID, &delivery.Channel, &delivery.Status, &deliveryErr, &delivery.CreatedAt)
+		if err != nil {
+			logError(ctx, "NotificationsMySQL.GetByAcceptorID failed", err)
+			return deliveries, err
+		}
+		delivery.Error = deliveryErr.String
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+//LastSentAt time of the most recent successful delivery to the donor, zero if there is none
+func (r *NotificationsMySQL) LastSentAt(ctx context.Context, donorID string) (_ time.Time, err error) {
+	defer observeRepo("NotificationsMySQL", "LastSentAt", time.Now(), &err)
+	var lastSentAt sql.NullTime
+	err = r.lastSentAt.queryRow(ctx, donorID, DeliverySent).Scan(&lastSentAt)
+
+	return lastSentAt.Time, err
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+)
+
+const (
+	minDonorAge = 18
+	maxDonorAge = 65
+)
+
+// Notifier asks compatible, eligible and opted-in donors near an acceptor to donate
+type Notifier struct {
+	donors     *DonorsMySQL
+	deliveries *NotificationsMySQL
+	channels   []NotificationChannel
+	throttle   time.Duration
+	now        func() time.Time
+}
+
+// NewNotifier creates a notifier, a donor is contacted at most once per throttle period
+func NewNotifier(donors *DonorsMySQL, deliveries *NotificationsMySQL, channels []NotificationChannel, throttle time.Duration) *Notifier {
+	return &Notifier{
+		donors:     donors,
+		deliveries: deliveries,
+		channels:   channels,
+		throttle:   throttle,
+		now:        time.Now,
+	}
+}
+
+// NotifyCompatibleDonors contacts the donors who can help the acceptor and records a delivery per donor and channel
+func (n *Notifier) NotifyCompatibleDonors(ctx context.Context, acceptor Acceptor) ([]NotificationDelivery, error) {
+	candidates, err := n.donors.GetNotificationCandidates(ctx, NearbyCities(acceptor.City))
+	if err != nil {
+		return nil, err
+	}
+
+	notice := Notice{
+		Acceptor: acceptor,
+		Subject:  fmt.Sprintf("Blood group %s is needed in %s", acceptor.BloodGroup, acceptor.City),
+		Text: fmt.Sprintf("A patient in %s needs blood group %s. You are a compatible donor, please visit %s if you can help. Thank you, LifeBlood",
+			acceptor.City, acceptor.BloodGroup, acceptor.BloodCenter),
+	}
+
+	deliveries := make([]NotificationDelivery, 0)
+	for _, donor := range candidates {
+		if !CanDonate(donor.BloodGroup, acceptor.BloodGroup) || !isEligibleDonor(donor, n.now()) {
+			continue
+		}
+
+		throttled, err := n.recentlyNotified(ctx, donor)
+		if err != nil {
+			return deliveries, err
+		}
+
+		for _, channel := range n.channels {
+			if !channel.Reachable(donor) {
+				continue
+			}
+
+			delivery := NotificationDelivery{
+				ID:         shortuuid.New(),
+				AcceptorID: acceptor.ID,
+				DonorID:    donor.ID,
+				Channel:    channel.Name(),
+				Status:     DeliveryThrottled,
+				CreatedAt:  n.now().UTC().Truncate(time.Second),
+			}
+			if !throttled {
+				delivery.Status = DeliverySent
+				if err := channel.Notify(ctx, donor, notice); err != nil {
+					logWarn(ctx, "notifying donor failed", Fields{"donorId": donor.ID, "channel": channel.Name(), "error": err})
+					delivery.Status = DeliveryFailed
+					delivery.Error = err.Error()
+				}
+			}
+
+			if err := n.deliveries.Create(ctx, delivery); err != nil {
+				return deliveries, err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+// recentlyNotified reports whether the donor was successfully contacted within the throttle period
+func (n *Notifier) recentlyNotified(ctx context.Context, donor Donor) (bool, error) {
+	lastSentAt, err := n.deliveries.LastSentAt(ctx, donor.ID)
+	if err != nil || lastSentAt.IsZero() {
+		return false, err
+	}
+	return n.now().Before(lastSentAt.Add(n.throttle)), nil
+}
+
+// isEligibleDonor checks the donor age against the limits for blood donation, donors without a date of birth are skipped
+func isEligibleDonor(donor Donor, now time.Time) bool {
+	age, ok := donor.Age(now)
+	return ok && age >= minDonorAge && age <= maxDonorAge
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+type recordingChannel struct {
+	notified []string
+}
+
+func (c *recordingChannel) Name() string {
+	return "test"
+}
+
+func (c *recordingChannel) Reachable(donor Donor) bool {
+	return true
+}
+
+func (c *recordingChannel) Notify(ctx context.Context, donor Donor, notice Notice) error {
+	c.notified = append(c.notified, donor.ID)
+	return nil
+}
+
+func TestNotifierStoresDeliveryTimesInUTC(t *testing.T) {
+	sofia := time.FixedZone("EEST", 3*3600)
+	now := time.Date(2021, 6, 1, 9, 30, 15, 500, sofia)
+	tests := []struct {
+		name       string
+		lastSentAt driver.Value
+		want       string
+	}{
+		{"never notified", nil, DeliverySent},
+		{"notified within the throttle period", now.Add(-time.Hour).UTC(), DeliveryThrottled},
+		{"notified before the throttle period", now.Add(-25 * time.Hour).UTC(), DeliverySent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &sqlfake.Recorder{}
+			db := sqlfake.Open(recorder.Record(func(query string, args []driver.Value) sqlfake.Result {
+				switch {
+				case strings.Contains(query, "MAX(createdAt)"):
+					return sqlfake.Rows([]string{"createdAt"}, []driver.Value{tt.lastSentAt})
+				case strings.Contains(query, "notificationsOptIn=true"):
+					row := donorRow("d1", true)
+					row[6], row[8] = "1990-04-12", "O-"
+					return sqlfake.Rows(strings.Split(donorColumns, ", "), row)
+				}
+				return sqlfake.Result{RowsAffected: 1}
+			}))
+			donors, err := NewDonorsMySQL(NewDBCluster(db))
+			if err != nil {
+				t.Fatal(err)
+			}
+			deliveries, err := NewNotificationsMySQL(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			channel := &recordingChannel{}
+			notifier := NewNotifier(donors, deliveries, []NotificationChannel{channel}, 24*time.Hour)
+			notifier.now = func() time.Time { return now }
+
+			sent, err := notifier.NotifyCompatibleDonors(context.Background(), Acceptor{ID: "a1", BloodGroup: "A+", City: "Sofia"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sent) != 1 || sent[0].Status != tt.want {
+				t.Fatalf("deliveries %+v, want one %s", sent, tt.want)
+			}
+
+			inserts := recorder.Matching("INSERT INTO notification_deliveries")
+			if len(inserts) != 1 {
+				t.Fatalf("%d deliveries stored, want 1", len(inserts))
+			}
+			createdAt, ok := inserts[0].Args[6].(time.Time)
+			if !ok || !createdAt.Equal(now.Truncate(time.Second)) || createdAt.Location() != time.UTC {
+				t.Errorf("stored createdAt %v, want %v in UTC", inserts[0].Args[6], now.Truncate(time.Second).UTC())
+			}
+		})
+	}
+}
+
+func TestNotifyAcceptorDonorsRequiresAdmin(t *testing.T) {
+	acceptors, err := NewAcceptorsMySQL(NewDBCluster(sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		return sqlfake.Rows(strings.Split(acceptorColumns, ", "))
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{Router: mux.NewRouter(), AcceptorsRepo: acceptors, AdminToken: "admin-secret"}
+	app.SetupRouter()
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"admin", "Bearer admin-secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/accounts/acceptors/a1/notifications", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		app.Router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOperation documents a single route of the API
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	tag     string
+	// request is the schema of the JSON body, if any
+	request string
+	// response is the schema of the success body, "[]Name" for arrays and "" for no body
+	response string
+	status   int
+	errors   []int
+	query    []string
+	// optionalQuery are query parameters that may be left out
+	optionalQuery []string
+	admin         bool
+	// session operations take the access token of a donor as bearer token, with admin the admin token as well
+	session bool
+}
+
+// apiOperations lists every route registered in SetupRouter
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/", summary: "Welcome page", tag: "service", response: "text", status: http.StatusOK},
+	{method: "GET", path: "/healthz", summary: "Liveness probe, answers while the process is up", tag: "service", response: "Health", status: http.StatusOK},
+	{method: "GET", path: "/readyz", summary: "Readiness probe checking the database, the schema version and the background workers, answers 503 with the same body when a check fails",
+		tag: "service", response: "Health", status: http.StatusOK},
+	{method: "GET", path: "/metrics", summary: "Request, repository, connection pool and account metrics in the Prometheus text format", tag: "service", response: "text", status: http.StatusOK},
+	{method: "GET", path: "/openapi.json", summary: "This OpenAPI document", tag: "service", response: "object", status: http.StatusOK},
+	{method: "GET", path: "/docs", summary: "Human readable API documentation", tag: "service", response: "html", status: http.StatusOK},
+
+	{method: "POST", path: "/auth/register", summary: "Mail a single-use registration token to the e-mail address, when a single donor verified it and has no password yet. The answer does not tell whether a token was sent",
+		tag: "auth", request: "RegistrationInput", status: http.StatusAccepted, errors: []int{http.StatusBadRequest, http.StatusInternalServerError}},
+	{method: "POST", path: "/auth/register/confirm", summary: "Redeem a registration token: set the password of its donor and log in", tag: "auth", request: "RegistrationConfirmation", response: "TokenPair", status: http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError}},
+	{method: "POST", path: "/auth/login", summary: "Log in with the e-mail address and password of a donor", tag: "auth", request: "Credentials", response: "TokenPair", status: http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError}},
+	{method: "POST", path: "/auth/refresh", summary: "Exchange a refresh token for a new token pair, a token exchanged twice revokes the session", tag: "auth", request: "RefreshTokenInput", response: "TokenPair", status: http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError}},
+	{method: "POST", path: "/auth/logout", summary: "End the session of the access token, or of the refreshToken given in the body", tag: "auth", status: http.StatusNoContent,
+		errors: []int{http.StatusUnauthorized, http.StatusInternalServerError}, session: true},
+	{method: "GET", path: "/auth/me", summary: "Get the donor the access token was issued to", tag: "auth", response: "Donor", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusInternalServerError}, session: true},
+	{method: "GET", path: "/accounts/search", summary: "Search donors and acceptors by name, e-mail, phone or city. Case, Cyrillic or Latin spelling and small typos do not matter, the best matches come first. Without an access token e-mails and phones are neither searched nor shown",
+		tag: "search", response: "[]SearchResult", status: http.StatusOK, query: []string{"q"}, optionalQuery: []string{"limit"},
+		errors: []int{http.StatusBadRequest, http.StatusInternalServerError}},
+
+	{method: "GET", path: "/accounts/persons/{id}", summary: "Get a person with the donor and acceptor accounts it holds", tag: "persons", response: "PersonAccounts", status: http.StatusOK,
+		errors: []int{http.StatusNotFound, http.StatusInternalServerError}},
+
+	{method: "GET", path: "/accounts/donors", summary: "List donors, all of them unless limit is given", tag: "donors", response: "[]Donor", status: http.StatusOK,
+		optionalQuery: []string{"limit", "offset"}, errors: []int{http.StatusBadRequest, http.StatusInternalServerError}},
+	{method: "POST", path: "/accounts/donors", summary: "Register a donor, the Location header points to it", tag: "donors", request: "DonorInput", response: "Donor", status: http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError}},
+	{method: "GET", path: "/accounts/donors/{id}", summary: "Get a donor", tag: "donors", response: "Donor", status: http.StatusOK,
+		errors: []int{http.StatusNotFound, http.StatusInternalServerError}},
+	{method: "PUT", path: "/accounts/donors/{id}", summary: "Update a donor, only the given fields change. Needs the access token of the donor or the admin token", tag: "donors", request: "DonorInput", response: "Donor", status: http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError}, admin: true, session: true},
+	{method: "DELETE", path: "/accounts/donors/{id}", summary: "Delete a donor. Needs the access token of the donor or the admin token", tag: "donors", status: http.StatusNoContent,
+		errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError}, admin: true, session: true},
+	{method: "GET", path: "/accounts/donors/bloodtype/{bloodGroup}", summary: "List donors of a blood group", tag: "donors", response: "[]Donor", status: http.StatusOK,
+		errors: []int{http.StatusInternalServerError}},
+	{method: "GET", path: "/accounts/verify-email", summary: "Confirm a donor e-mail address with the token sent to it", tag: "donors", response: "Donor", status: http.StatusOK,
+		query: []string{"token"}, errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusGone, http.StatusInternalServerError}},
+	{method: "POST", path: "/accounts/donors/{id}/phone/verification", summary: "Send an SMS verification code to the donor phone", tag: "donors", status: http.StatusAccepted,
+		errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError}},
+	{method: "POST", path: "/accounts/donors/{id}/phone/verify", summary: "Confirm the donor phone with the SMS code", tag: "donors", request: "PhoneCode", response: "Donor", status: http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusGone, http.StatusTooManyRequests, http.StatusInternalServerError}},
+
+	{method: "GET", path: "/accounts/acceptors", summary: "List acceptors, all of them unless limit is given", tag: "acceptors", response: "[]Acceptor", status: http.StatusOK,
+		optionalQuery: []string{"limit", "offset"}, errors: []int{http.StatusBadRequest, http.StatusInternalServerError}},
+	{method: "POST", path: "/accounts/acceptors", summary: "Register an acceptor and notify compatible donors, the Location header points to it", tag: "acceptors", request: "AcceptorInput", response: "Acceptor", status: http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError}},
+	{method: "GET", path: "/accounts/acceptors/{id}", summary: "Get an acceptor", tag: "acceptors", response: "Acceptor", status: http.StatusOK,
+		errors: []int{http.StatusNotFound, http.StatusInternalServerError}},
+	{method: "PUT", path: "/accounts/acceptors/{id}", summary: "Update an acceptor, only the given fields change. The name of a person who is also a donor is refused with 403", tag: "acceptors", request: "AcceptorInput", response: "Acceptor", status: http.StatusOK,
+		errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError}},
+	{method: "DELETE", path: "/accounts/acceptors/{id}", summary: "Delete an acceptor", tag: "acceptors", status: http.StatusNoContent,
+		errors: []int{http.StatusInternalServerError}},
+	{method: "GET", path: "/accounts/acceptors/bloodtype/{bloodGroup}", summary: "List acceptors of a blood group", tag: "acceptors", response: "[]Acceptor", status: http.StatusOK,
+		errors: []int{http.StatusInternalServerError}},
+	{method: "GET", path: "/accounts/acceptors/{id}/notifications", summary: "List the donor notifications sent for an acceptor", tag: "acceptors", response: "[]NotificationDelivery", status: http.StatusOK,
+		errors: []int{http.StatusInternalServerError}},
+	{method: "POST", path: "/accounts/acceptors/{id}/notifications", summary: "Notify compatible donors about an acceptor again", tag: "acceptors", response: "[]NotificationDelivery", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusServiceUnavailable, http.StatusInternalServerError}, admin: true},
+
+	{method: "GET", path: "/admin/webhooks", summary: "List webhook subscriptions", tag: "webhooks", response: "[]WebhookSubscription", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusInternalServerError}, admin: true},
+	{method: "POST", path: "/admin/webhooks", summary: "Subscribe a partner endpoint, the response is the only one showing the secret", tag: "webhooks", request: "WebhookSubscriptionInput", response: "WebhookSubscription", status: http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError}, admin: true},
+	{method: "GET", path: "/admin/webhooks/{id}", summary: "Get a webhook subscription", tag: "webhooks", response: "WebhookSubscription", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError}, admin: true},
+	{method: "PUT", path: "/admin/webhooks/{id}", summary: "Update a webhook subscription", tag: "webhooks", request: "WebhookSubscriptionInput", response: "WebhookSubscription", status: http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError}, admin: true},
+	{method: "DELETE", path: "/admin/webhooks/{id}", summary: "Delete a webhook subscription and its deliveries", tag: "webhooks", status: http.StatusNoContent,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError}, admin: true},
+	{method: "GET", path: "/admin/webhooks/{id}/deliveries", summary: "List the deliveries of a subscription", tag: "webhooks", response: "[]WebhookDelivery", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError}, admin: true},
+	{method: "GET", path: "/admin/webhooks/deliveries/{id}", summary: "Get a delivery with all its attempts", tag: "webhooks", response: "WebhookDelivery", status: http.StatusOK,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError}, admin: true},
+	{method: "POST", path: "/admin/webhooks/deliveries/{id}/retry", summary: "Requeue a dead-lettered delivery", tag: "webhooks", status: http.StatusAccepted,
+		errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}, admin: true},
+}
+
+// apiSchemas are the JSON shapes exchanged with the API
+var apiSchemas = map[string]interface{}{
+	"Error": object(map[string]interface{}{
+		"error": object(map[string]interface{}{
+			"status":  prop("integer", "HTTP status code"),
+			"message": prop("string", "Human readable reason"),
+		}, "status", "message"),
+	}, "error"),
+	"Health": object(map[string]interface{}{
+		"status": enum(HealthOK, HealthUnavailable),
+		"checks": map[string]interface{}{
+			"type":        "object",
+			"description": "Readiness only, keyed by check name",
+			"additionalProperties": object(map[string]interface{}{
+				"status":     enum(HealthOK, HealthUnavailable),
+				"error":      prop("string", "Why the check failed"),
+				"durationMs": prop("integer", ""),
+			}, "status", "durationMs"),
+		},
+	}, "status"),
+	"Person": object(map[string]interface{}{
+		"id":        prop("string", "Generated identifier"),
+		"name":      prop("string", "First name"),
+		"lastName":  prop("string", ""),
+		"phone":     prop("string", "E.164 phone number, empty unless the person is a donor"),
+		"email":     prop("string", "Empty unless the person is a donor"),
+		"createdAt": formatted("string", "date-time", "Registration of the first role in UTC"),
+	}, "id", "name", "lastName", "createdAt"),
+	"PersonAccounts": map[string]interface{}{
+		"allOf": []interface{}{
+			ref("Person"),
+			object(map[string]interface{}{
+				"roles":    array(enum(RoleDonor, RoleAcceptor)),
+				"donor":    ref("Donor"),
+				"acceptor": ref("Acceptor"),
+			}, "roles"),
+		},
+	},
+	"Donor": object(map[string]interface{}{
+		"id":                 prop("string", "Generated identifier"),
+		"personId":           prop("string", "The person holding the account, its name, phone and e-mail are shared with its other roles"),
+		"name":               prop("string", "First name"),
+		"lastName":           prop("string", ""),
+		"phone":              prop("string", "E.164 phone number"),
+		"email":              prop("string", ""),
+		"dateOfBirth":        formatted("string", "date", "Absent for donors registered before it was asked"),
+		"age":                prop("integer", "Computed from dateOfBirth"),
+		"gender":             prop("string", ""),
+		"bloodGroup":         prop("string", "e.g. A-, AB+, 0"),
+		"city":               prop("string", ""),
+		"regDate":            formatted("string", "date-time", "Registration time in UTC"),
+		"emailVerified":      prop("boolean", ""),
+		"emailVerifiedAt":    formatted("string", "date-time", "Absent until the e-mail is verified"),
+		"phoneVerified":      prop("boolean", ""),
+		"phoneVerifiedAt":    formatted("string", "date-time", "Absent until the phone is verified"),
+		"notificationsOptIn": prop("boolean", "Whether the donor agreed to be asked to donate"),
+	}, "id", "personId", "name", "lastName", "bloodGroup", "regDate"),
+	"DonorInput": object(map[string]interface{}{
+		"personId":           prop("string", "On registration, makes an existing person a donor; its name, phone and e-mail are taken over unchanged"),
+		"name":               prop("string", "First name"),
+		"lastName":           prop("string", ""),
+		"phone":              prop("string", "National or international format, stored as E.164"),
+		"email":              prop("string", "A verification e-mail is sent to new addresses"),
+		"dateOfBirth":        formatted("string", "date", "e.g. 1990-04-21"),
+		"gender":             prop("string", ""),
+		"bloodGroup":         prop("string", "Only taken into account on registration"),
+		"city":               prop("string", ""),
+		"notificationsOptIn": prop("boolean", ""),
+	}),
+	"Acceptor": object(map[string]interface{}{
+		"id":          prop("string", "Generated identifier"),
+		"personId":    prop("string", "The person holding the account, its name is shared with its other roles"),
+		"name":        prop("string", "First name"),
+		"lastName":    prop("string", ""),
+		"bloodGroup":  prop("string", "e.g. A-, AB+, 0"),
+		"city":        prop("string", ""),
+		"bloodCenter": prop("string", ""),
+		"regDate":     formatted("string", "date-time", "Registration time in UTC"),
+		"urgent":      prop("boolean", ""),
+	}, "id", "personId", "name", "lastName", "bloodGroup", "regDate"),
+	"AcceptorInput": object(map[string]interface{}{
+		"personId":    prop("string", "On registration, makes an existing person an acceptor; its name is taken over unchanged"),
+		"name":        prop("string", "First name"),
+		"lastName":    prop("string", ""),
+		"bloodGroup":  prop("string", "Only taken into account on registration"),
+		"city":        prop("string", ""),
+		"bloodCenter": prop("string", ""),
+		"urgent":      prop("boolean", "Turning it on notifies compatible donors again"),
+	}),
+	"Credentials": object(map[string]interface{}{
+		"email":    prop("string", "Verified e-mail address of the donor"),
+		"password": prop("string", ""),
+	}, "email", "password"),
+	"RegistrationInput": object(map[string]interface{}{
+		"email": prop("string", "Verified e-mail address of the donor"),
+	}, "email"),
+	"RegistrationConfirmation": object(map[string]interface{}{
+		"token":    prop("string", "Registration token mailed by /auth/register, valid for AUTH_REGISTRATION_TOKEN_TTL"),
+		"password": prop("string", "At least AUTH_PASSWORD_MIN_LENGTH characters and at most 72 bytes, not a common password"),
+	}, "token", "password"),
+	"RefreshTokenInput": object(map[string]interface{}{
+		"refreshToken": prop("string", ""),
+	}, "refreshToken"),
+	"TokenPair": object(map[string]interface{}{
+		"donorId":      prop("string", ""),
+		"accessToken":  prop("string", "Bearer token identifying the donor"),
+		"refreshToken": prop("string", "Exchanged once for a new pair at /auth/refresh"),
+		"tokenType":    enum("Bearer"),
+		"expiresIn":    prop("integer", "Seconds the access token is valid for"),
+	}, "donorId", "accessToken", "refreshToken", "tokenType", "expiresIn"),
+	"SearchResult": object(map[string]interface{}{
+		"type":          enum(SearchDonor, SearchAcceptor),
+		"id":            prop("string", ""),
+		"score":         prop("number", "From 0 to 1, 1 for an exact match of every word"),
+		"matchedFields": array(prop("string", "")),
+		"donor":         ref("Donor"),
+		"acceptor":      ref("Acceptor"),
+	}, "type", "id", "score", "matchedFields"),
+	"PhoneCode": object(map[string]interface{}{
+		"code": prop("string", "Code received by SMS"),
+	}, "code"),
+	"NotificationDelivery": object(map[string]interface{}{
+		"id":         prop("string", ""),
+		"acceptorId": prop("string", ""),
+		"donorId":    prop("string", ""),
+		"channel":    enum("email", "sms", "webhook"),
+		"status":     enum(DeliverySent, DeliveryFailed, DeliveryThrottled),
+		"error":      prop("string", "Present for failed deliveries"),
+		"createdAt":  formatted("string", "date-time", "Time of the delivery in UTC"),
+	}, "id", "acceptorId", "donorId", "channel", "status", "createdAt"),
+	"WebhookSubscription": object(map[string]interface{}{
+		"id":           prop("string", ""),
+		"url":          prop("string", ""),
+		"secret":       prop("string", "HMAC-SHA256 key, only returned on creation"),
+		"eventTypes":   array(enum(toInterfaces(eventTypes)...)),
+		"bloodCenters": array(prop("string", "")),
+		"active":       prop("boolean", ""),
+		"createdAt":    prop("string", ""),
+	}, "id", "url", "eventTypes", "bloodCenters", "active", "createdAt"),
+	"WebhookSubscriptionInput": object(map[string]interface{}{
+		"url":          prop("string", "Absolute http(s) URL"),
+		"secret":       prop("string", "Generated when omitted on creation"),
+		"eventTypes":   array(enum(toInterfaces(eventTypes)...)),
+		"bloodCenters": array(prop("string", "Only acceptor events of these centers are delivered")),
+		"active":       prop("boolean", ""),
+	}),
+	"WebhookDelivery": object(map[string]interface{}{
+		"id":             prop("string", ""),
+		"subscriptionId": prop("string", ""),
+		"eventId":        prop("string", ""),
+		"eventType":      prop("string", ""),
+		"payload":        map[string]interface{}{"type": "object", "description": "The event as posted"},
+		"status":         enum(WebhookPending, WebhookSucceeded, WebhookDead),
+		"attempts":       prop("integer", ""),
+		"nextAttemptAt":  prop("integer", "Unix time of the next attempt"),
+		"lastError":      prop("string", ""),
+		"createdAt":      prop("string", ""),
+		"attemptLog":     array(ref("WebhookAttempt")),
+	}, "id", "subscriptionId", "eventId", "eventType", "status", "attempts", "createdAt"),
+	"WebhookAttempt": object(map[string]interface{}{
+		"id":          prop("string", ""),
+		"deliveryId":  prop("string", ""),
+		"attemptedAt": prop("string", ""),
+		"statusCode":  prop("integer", "Absent when no response was received"),
+		"error":       prop("string", ""),
+		"durationMs":  prop("integer", ""),
+	}, "id", "deliveryId", "attemptedAt", "durationMs"),
+}
+
+const apiDescription = "CRUD operations for blood donors and acceptors. Every error response has the Error shape. " +
+	"The X-Request-ID header is echoed in every response, one is generated when the request has none. " +
+	"A POST sent with an Idempotency-Key header is answered once, retries with the same key and body get the first response replayed, " +
+	"except for the /auth routes."
+
+// OpenAPISpec builds the OpenAPI 3 document describing the API
+func OpenAPISpec() map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		item, ok := paths[op.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = op.document()
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "LifeBlood accounts service",
+			"description": apiDescription,
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": apiSchemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"accessToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Access token of a donor from /auth/login"},
+			},
+		},
+	}
+}
+
+// document renders the OpenAPI operation object
+func (op apiOperation) document() map[string]interface{} {
+	parameters := make([]interface{}, 0)
+	for _, name := range pathParameters(op.path) {
+		parameters = append(parameters, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": prop("string", ""),
+		})
+	}
+	for _, name := range op.query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": name, "in": "query", "required": true, "schema": prop("string", ""),
+		})
+	}
+	for _, name := range op.optionalQuery {
+		parameters = append(parameters, map[string]interface{}{
+			"name": name, "in": "query", "required": false, "schema": prop("integer", ""),
+		})
+	}
+	errors := op.errors
+	if idempotentRoute(op.method, op.path) {
+		parameters = append(parameters, map[string]interface{}{
+			"name": IdempotencyKeyHeader, "in": "header", "required": false,
+			"schema": prop("string", "Unique key of the request, retries with the same key and body are answered with the first response"),
+		})
+		errors = append(errors, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+
+	success := map[string]interface{}{"description": http.StatusText(op.status)}
+	switch {
+	case op.response == "text":
+		success["content"] = map[string]interface{}{"text/plain": map[string]interface{}{"schema": prop("string", "")}}
+	case op.response == "html":
+		success["content"] = map[string]interface{}{"text/html": map[string]interface{}{"schema": prop("string", "")}}
+	case op.response == "object":
+		success["content"] = jsonContent(map[string]interface{}{"type": "object"})
+	case strings.HasPrefix(op.response, "[]"):
+		success["content"] = jsonContent(array(ref(strings.TrimPrefix(op.response, "[]"))))
+	case op.response != "":
+		success["content"] = jsonContent(ref(op.response))
+	}
+
+	if op.status == http.StatusCreated {
+		success["headers"] = map[string]interface{}{
+			"Location": map[string]interface{}{"description": "Path of the created resource", "schema": prop("string", "")},
+		}
+	}
+
+	responses := map[string]interface{}{fmt.Sprint(op.status): success}
+	// every route is rate limited
+	for _, status := range append(errors, http.StatusTooManyRequests) {
+		responses[fmt.Sprint(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     jsonContent(ref("Error")),
+		}
+	}
+
+	doc := map[string]interface{}{
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+		"operationId": operationID(op),
+		"parameters":  parameters,
+		"responses":   responses,
+	}
+	if op.request != "" {
+		doc["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent(ref(op.request))}
+	}
+	// either token is accepted by operations taking both
+	security := make([]interface{}, 0)
+	if op.admin {
+		security = append(security, map[string]interface{}{"adminToken": []string{}})
+	}
+	if op.session {
+		security = append(security, map[string]interface{}{"accessToken": []string{}})
+	}
+	if len(security) > 0 {
+		doc["security"] = security
+	}
+	return doc
+}
+
+var routeVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// specPath turns a mux path template such as /donors/{id:[a-z]+} into the OpenAPI form /donors/{id}
+func specPath(template string) string {
+	return routeVariable.ReplaceAllString(template, "{$1}")
+}
+
+func pathParameters(path string) []string {
+	names := make([]string, 0)
+	for _, match := range routeVariable.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.method)
+	for _, segment := range strings.Split(op.path, "/") {
+		segment = strings.Trim(segment, "{}")
+		segment = strings.Replace(strings.Replace(segment, "-", "", -1), ".", "", -1)
+		if segment != "" {
+			id += strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return id
+}
+
+// UndocumentedRoutes lists the "METHOD /path" pairs served by the router that are missing from the OpenAPI document.
+// OPTIONS is left out as it only answers CORS preflight requests.
+func (app *App) UndocumentedRoutes() ([]string, error) {
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.method+" "+op.path] = true
+	}
+
+	missing := make([]string, 0)
+	err := app.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// routes without methods, such as path prefixes of subrouters, serve nothing themselves
+			return nil
+		}
+
+		for _, method := range methods {
+			key := method + " " + specPath(template)
+			if method != http.MethodOptions && !documented[key] {
+				missing = append(missing, key)
+			}
+		}
+		return nil
+	})
+
+	sort.Strings(missing)
+	return missing, err
+}
+
+func (app *App) getOpenAPISpec(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPISpec())
+}
+
+func (app *App) getDocs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, docsPage)
+}
+
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func prop(kind, description string) map[string]interface{} {
+	schema := map[string]interface{}{"type": kind}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+func formatted(kind, format, description string) map[string]interface{} {
+	schema := prop(kind, description)
+	schema["format"] = format
+	return schema
+}
+
+func enum(values ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values}
+}
+
+func array(items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+func ref(schema string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + schema}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newDocumentedApp() *App {
+	app := &App{Router: mux.NewRouter()}
+	app.SetupRouter()
+	return app
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	undocumented, err := newDocumentedApp().UndocumentedRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undocumented) > 0 {
+		t.Errorf("routes missing from apiOperations: %v", undocumented)
+	}
+}
+
+func TestEveryDocumentedOperationIsRouted(t *testing.T) {
+	app := newDocumentedApp()
+	parameter := regexp.MustCompile(`\{[^}]+\}`)
+	for _, op := range apiOperations {
+		path := parameter.ReplaceAllString(op.path, "abc123")
+		var match mux.RouteMatch
+		if !app.Router.Match(httptest.NewRequest(op.method, path, nil), &match) || match.MatchErr != nil {
+			t.Errorf("%s %s is documented but not routed", op.method, op.path)
+		}
+	}
+}
+
+func TestOpenAPISchemaReferencesResolve(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newDocumentedApp().Router.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("/openapi.json is not JSON: %v", err)
+	}
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("openapi version %v", spec["openapi"])
+	}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				if _, ok := apiSchemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+					t.Errorf("unresolved reference %s", ref)
+				}
+			}
+			for _, nested := range v {
+				walk(nested)
+			}
+		case []interface{}:
+			for _, nested := range v {
+				walk(nested)
+			}
+		}
+	}
+	walk(spec)
+}
+
+func TestOperationIDsAreUnique(t *testing.T) {
+	seen := map[string]string{}
+	for _, op := range apiOperations {
+		id := operationID(op)
+		if previous, ok := seen[id]; ok {
+			t.Errorf("%s %s and %s share the operationId %s", op.method, op.path, previous, id)
+		}
+		seen[id] = op.method + " " + op.path
+	}
+}
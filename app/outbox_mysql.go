@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//appendEventSQL is prepared by every repository writing events
+const appendEventSQL = `INSERT INTO outbox (id, type, aggregateType, aggregateId, payload, occurredAt)
+		VALUES (?,?,?,?,?,?);`
+
+//appendEvent stores the event in the outbox with the appendEventSQL statement of the repository,
+//ctx must carry the transaction of the change the event describes
+func appendEvent(ctx context.Context, insert *stmt, eventType, aggregateType, aggregateID string, payload interface{}) error {
+	event, err := newEvent(eventType, aggregateType, aggregateID, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = insert.exec(ctx,
+		event.ID, event.Type, event.AggregateType, event.AggregateID, string(event.Payload), event.OccurredAt)
+	return err
+}
+
+//OutboxMySQL mysql repo
+type OutboxMySQL struct {
+	statements *statements
+
+	getUnpublished *stmt
+	markPublished  *stmt
+	recordFailure  *stmt
+}
+
+//NewOutboxMySQL create new repository, preparing its statements
+func NewOutboxMySQL(db *sql.DB) (*OutboxMySQL, error) {
+	s := newStatements(db)
+	r := &OutboxMySQL{
+		statements: s,
+
+		getUnpublished: s.prepare(`SELECT id, type, aggregateType, aggregateId, payload, occurredAt
+		FROM outbox WHERE publishedAt IS NULL ORDER BY seq LIMIT ?`),
+		markPublished: s.prepare(`UPDATE outbox SET publishedAt=?, lastError=NULL WHERE id=?;`),
+		recordFailure: s.prepare(`UPDATE outbox SET attempts=attempts+1, lastError=? WHERE id=?;`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *OutboxMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//GetUnpublished oldest events not yet published, in the order they were written
+func (r *OutboxMySQL) GetUnpublished(ctx context.Context, limit int) (_ []Event, err error) {
+	defer observeRepo("OutboxMySQL", "GetUnpublished", time.Now(), &err)
+	events := make([]Event, 0)
+	rows, err := r.getUnpublished.query(ctx, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := Event{}
+		var payload string
+		err := rows.Scan(&event.ID, &event.Type, &event.AggregateType, &event.AggregateID, &payload, &event.OccurredAt)
+		if err != nil {
+			return events, err
+		}
+		event.Payload = []byte(payload)
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+//MarkPublished flag the event as delivered to the publisher
+func (r *OutboxMySQL) MarkPublished(ctx context.Context, id string, publishedAt string) (err error) {
+	defer observeRepo("OutboxMySQL", "MarkPublished", time.Now(), &err)
+	_, err = r.markPublished.exec(ctx, publishedAt, id)
+	return err
+}
+
+//RecordFailure remember a failed publishing attempt
+func (r *OutboxMySQL) RecordFailure(ctx context.Context, id string, reason string) (err error) {
+	defer observeRepo("OutboxMySQL", "RecordFailure", time.Now(), &err)
+	_, err = r.recordFailure.exec(ctx, reason, id)
+	return err
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Publisher hands domain events over to other services
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// LogPublisher writes events to the log, it is meant for local development
+type LogPublisher struct{}
+
+// Publish logs the event
+func (LogPublisher) Publish(ctx context.Context, event Event) error {
+	logInfo(ctx, "event published", Fields{
+		"eventId":     event.ID,
+		"type":        event.Type,
+		"aggregateId": event.AggregateID,
+		"payload":     event.Payload,
+	})
+	return nil
+}
+
+// MultiPublisher hands every event to all of its publishers, failing if any of them fails
+type MultiPublisher []Publisher
+
+// Publish the event to every publisher
+func (m MultiPublisher) Publish(ctx context.Context, event Event) error {
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutboxRelay periodically publishes the events stored in the outbox.
+// An event is marked as published only after the publisher accepted it, so delivery is at least once
+// and consumers must tolerate duplicates.
+type OutboxRelay struct {
+	outbox    *OutboxMySQL
+	publisher Publisher
+	interval  time.Duration
+	batchSize int
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	running  int32
+}
+
+// NewOutboxRelay creates a relay polling the outbox every interval
+func NewOutboxRelay(outbox *OutboxMySQL, publisher Publisher, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:    outbox,
+		publisher: publisher,
+		interval:  interval,
+		batchSize: 100,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the relay in the background until Stop is called
+func (r *OutboxRelay) Start() {
+	atomic.StoreInt32(&r.running, 1)
+	go func() {
+		defer close(r.done)
+		defer atomic.StoreInt32(&r.running, 0)
+
+		// the current batch is finished rather than cancelled on Stop, so nothing is published twice needlessly
+		ctx := context.Background()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := r.PublishPending(ctx); err != nil {
+				logError(ctx, "outbox relay failed", err)
+			}
+
+			select {
+			case <-r.stop:
+				// events written by the requests drained before Stop are published before the relay ends
+				if _, err := r.PublishPending(ctx); err != nil {
+					logError(ctx, "outbox relay failed", err)
+				}
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop signals the relay to finish and waits for it to publish the pending events
+func (r *OutboxRelay) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+// Running reports whether the loop started by Start is still going
+func (r *OutboxRelay) Running() bool {
+	return atomic.LoadInt32(&r.running) == 1
+}
+
+// PublishPending publishes unpublished events in order and returns how many were published.
+// It stops at the first failure so events of the same account are never reordered.
+func (r *OutboxRelay) PublishPending(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		events, err := r.outbox.GetUnpublished(ctx, r.batchSize)
+		if err != nil {
+			return published, err
+		}
+
+		for _, event := range events {
+			if err := r.publisher.Publish(ctx, event); err != nil {
+				if recordErr := r.outbox.RecordFailure(ctx, event.ID, err.Error()); recordErr != nil {
+					logError(ctx, "could not record publishing failure", recordErr)
+				}
+				return published, err
+			}
+			if err := r.outbox.MarkPublished(ctx, event.ID, formatTimestamp(time.Now())); err != nil {
+				return published, err
+			}
+			published++
+		}
+
+		if len(events) < r.batchSize {
+			return published, nil
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+var outboxColumns = []string{"id", "type", "aggregateType", "aggregateId", "payload", "occurredAt", "seq", "publishedAt", "attempts", "lastError"}
+
+type channelPublisher struct {
+	mu        sync.Mutex
+	published []string
+	events    chan string
+}
+
+func (p *channelPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	p.published = append(p.published, event.ID)
+	p.mu.Unlock()
+	p.events <- event.ID
+	return nil
+}
+
+func TestOutboxRelayPublishesPendingEventsOnStop(t *testing.T) {
+	store := sqlfake.NewStore()
+	store.Insert("outbox", outboxColumns, "e1", DonorRegistered, donorAggregate, "d1", []byte(`{}`), "2021-01-01 10:00:00", int64(1), nil, int64(0), nil)
+	outbox, err := NewOutboxMySQL(sqlfake.Open(store.Handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &channelPublisher{events: make(chan string, 10)}
+	relay := NewOutboxRelay(outbox, publisher, time.Hour)
+	relay.Start()
+	select {
+	case <-publisher.events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first event was not published")
+	}
+
+	// written by a request drained during shutdown, long before the next poll
+	store.Insert("outbox", outboxColumns, "e2", DonorDeleted, donorAggregate, "d1", []byte(`{}`), "2021-01-01 10:00:01", int64(2), nil, int64(0), nil)
+	relay.Stop()
+
+	if relay.Running() {
+		t.Error("the relay is still running after Stop")
+	}
+	if len(publisher.published) != 2 || publisher.published[1] != "e2" {
+		t.Errorf("published %v, want e1 and e2", publisher.published)
+	}
+	for _, row := range store.Rows("outbox") {
+		if row["publishedAt"] == nil {
+			t.Errorf("event %v is not marked as published", row["id"])
+		}
+	}
+}
+
+func TestOutboxEventIsWrittenWithTheEntity(t *testing.T) {
+	store := sqlfake.NewStore()
+	failOutbox := true
+	db := sqlfake.OpenTx(func(query string, args []driver.Value) sqlfake.Result {
+		if failOutbox && strings.Contains(query, "INSERT INTO outbox") {
+			return sqlfake.Error(errConnectionLost)
+		}
+		return store.Handle(query, args)
+	}, store)
+	repo, err := NewDonorsMySQL(NewDBCluster(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	donor := Donor{ID: "d1", PersonID: "p1", FirstName: "Ivan", LastName: "Petrov", BloodGroup: "A+", City: "Sofia", RegistrationDate: nowUTC()}
+
+	if err := repo.Create(context.Background(), donor); err == nil {
+		t.Fatal("Create succeeded without its event")
+	}
+	for _, table := range []string{"persons", "donors", "outbox"} {
+		if rows := store.Rows(table); len(rows) != 0 {
+			t.Errorf("%s %v kept after the event could not be written", table, rows)
+		}
+	}
+
+	failOutbox = false
+	before := time.Now().UTC().Truncate(time.Second)
+	if err := repo.Create(context.Background(), donor); err != nil {
+		t.Fatal(err)
+	}
+	if rows := store.Rows("donors"); len(rows) != 1 {
+		t.Fatalf("donors %v, want d1", rows)
+	}
+	events := store.Rows("outbox")
+	if len(events) != 1 || events[0]["type"] != DonorRegistered || events[0]["aggregateId"] != "d1" {
+		t.Fatalf("outbox %v, want the DonorRegistered event of d1", events)
+	}
+	occurredAt, err := time.Parse(time.RFC3339, events[0]["occurredAt"].(string))
+	if err != nil || occurredAt.Location() != time.UTC || occurredAt.Before(before) {
+		t.Errorf("occurredAt %v, want the UTC time of the change in RFC 3339: %v", events[0]["occurredAt"], err)
+	}
+}
+
+// failingPublisher refuses the event fail and publishes every other one
+type failingPublisher struct {
+	fail      string
+	published []string
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, event Event) error {
+	if event.ID == p.fail {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+func TestPublishPendingMarksEventsPublished(t *testing.T) {
+	store := sqlfake.NewStore()
+	store.Insert("outbox", outboxColumns, "e0", DonorRegistered, donorAggregate, "d1", []byte(`{}`), "2021-01-01T10:00:00Z", int64(1), "2021-01-01T10:00:01Z", int64(0), nil)
+	store.Insert("outbox", outboxColumns, "e1", DonorUpdated, donorAggregate, "d1", []byte(`{}`), "2021-01-01T10:00:02Z", int64(2), nil, int64(0), nil)
+	store.Insert("outbox", outboxColumns, "e2", DonorDeleted, donorAggregate, "d1", []byte(`{}`), "2021-01-01T10:00:03Z", int64(3), nil, int64(0), nil)
+	store.Insert("outbox", outboxColumns, "e3", DonorRegistered, donorAggregate, "d2", []byte(`{}`), "2021-01-01T10:00:04Z", int64(4), nil, int64(0), nil)
+	outbox, err := NewOutboxMySQL(sqlfake.Open(store.Handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &failingPublisher{fail: "e2"}
+	relay := NewOutboxRelay(outbox, publisher, time.Hour)
+
+	published, err := relay.PublishPending(context.Background())
+	if err == nil || published != 1 || len(publisher.published) != 1 || publisher.published[0] != "e1" {
+		t.Fatalf("PublishPending = %d, %v, published %v, want e1 and a stop at e2", published, err, publisher.published)
+	}
+	rows := make(map[string]map[string]driver.Value)
+	for _, row := range store.Rows("outbox") {
+		rows[row["id"].(string)] = row
+	}
+	if publishedAt, err := time.Parse(time.RFC3339, rows["e1"]["publishedAt"].(string)); err != nil || publishedAt.Location() != time.UTC {
+		t.Errorf("e1 published at %v, want a UTC time in RFC 3339", rows["e1"]["publishedAt"])
+	}
+	if rows["e2"]["publishedAt"] != nil || rows["e2"]["lastError"] != "broker unavailable" || rows["e2"]["attempts"] != int64(1) || rows["e3"]["publishedAt"] != nil {
+		t.Errorf("outbox %v, want e2 failed and e3 left for later", rows)
+	}
+
+	publisher.fail = ""
+	if published, err := relay.PublishPending(context.Background()); err != nil || published != 2 {
+		t.Fatalf("PublishPending = %d, %v, want e2 and e3", published, err)
+	}
+	if len(publisher.published) != 3 || publisher.published[1] != "e2" || publisher.published[2] != "e3" {
+		t.Errorf("published %v, want every event once and in order", publisher.published)
+	}
+	for _, row := range store.Rows("outbox") {
+		if row["publishedAt"] == nil || row["lastError"] != nil {
+			t.Errorf("event %v is not marked as published", row)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// getPersonByID answers the person with the donor and acceptor accounts it holds
+func (app *App) getPersonByID(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	person, err := app.PersonsRepo.GetByID(ctx, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "person not found")
+		return
+	}
+	if err != nil {
+		logError(ctx, "could not load person", err)
+		writeError(w, http.StatusInternalServerError, "could not load person")
+		return
+	}
+
+	accounts := PersonAccounts{Person: person, Roles: make([]string, 0)}
+	donor, err := app.DonorsRepo.GetByPersonID(ctx, person.ID)
+	switch {
+	case err == nil:
+		accounts.Roles = append(accounts.Roles, RoleDonor)
+		accounts.Donor = &donor
+	case err != sql.ErrNoRows:
+		logError(ctx, "could not load donor", err)
+		writeError(w, http.StatusInternalServerError, "could not load person")
+		return
+	}
+	acceptor, err := app.AcceptorsRepo.GetByPersonID(ctx, person.ID)
+	switch {
+	case err == nil:
+		accounts.Roles = append(accounts.Roles, RoleAcceptor)
+		accounts.Acceptor = &acceptor
+	case err != sql.ErrNoRows:
+		logError(ctx, "could not load acceptor", err)
+		writeError(w, http.StatusInternalServerError, "could not load person")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+// loadPersonForRole loads the person a new account is registered for, answering the request when the
+// person does not exist or already holds the role
+func (app *App) loadPersonForRole(w http.ResponseWriter, r *http.Request, personID, role string) (Person, bool) {
+	ctx := r.Context()
+	person, err := app.PersonsRepo.GetByID(ctx, personID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "personId does not name a registered person")
+		return person, false
+	}
+	if err != nil {
+		logError(ctx, "could not load person", err)
+		writeError(w, http.StatusInternalServerError, "could not load person")
+		return person, false
+	}
+
+	if role == RoleDonor {
+		_, err = app.DonorsRepo.GetByPersonID(ctx, personID)
+	} else {
+		_, err = app.AcceptorsRepo.GetByPersonID(ctx, personID)
+	}
+	switch {
+	case err == nil:
+		writeError(w, http.StatusConflict, "the person already is a "+role)
+		return person, false
+	case err != sql.ErrNoRows:
+		logError(ctx, "could not load "+role, err)
+		writeError(w, http.StatusInternalServerError, "could not load person")
+		return person, false
+	}
+	return person, true
+}
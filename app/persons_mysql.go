@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//deleteOrphanPersonSQL removes a person left without any role, prepared by the donor and acceptor repositories
+//and run after deleting a role in the same transaction
+const deleteOrphanPersonSQL = `DELETE FROM persons WHERE id=?
+		AND NOT EXISTS (SELECT 1 FROM donors WHERE personId=?) AND NOT EXISTS (SELECT 1 FROM acceptors WHERE personId=?);`
+
+//deleteOrphanPerson runs the deleteOrphanPersonSQL statement of a repository
+func deleteOrphanPerson(ctx context.Context, statement *stmt, personID string) error {
+	_, err := statement.exec(ctx, personID, personID, personID)
+	return err
+}
+
+//PersonsMySQL mysql repo
+type PersonsMySQL struct {
+	statements *statements
+
+	getByID *stmt
+}
+
+//NewPersonsMySQL create new repository, preparing its statements. Persons are written by the donor and
+//acceptor repositories together with their roles.
+func NewPersonsMySQL(db *sql.DB) (*PersonsMySQL, error) {
+	s := newStatements(db)
+	r := &PersonsMySQL{
+		statements: s,
+
+		getByID: s.prepare(`SELECT id, name, lastName, phone, email, createdAt FROM persons WHERE id=?`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *PersonsMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//GetByID Retrieve a person by Id
+func (r *PersonsMySQL) GetByID(ctx context.Context, id string) (_ Person, err error) {
+	defer observeRepo("PersonsMySQL", "GetByID", time.Now(), &err)
+	person := Person{}
+	err = r.getByID.queryRow(ctx, id).Scan(
+		&person.ID,
+		&person.FirstName,
+		&person.LastName,
+		&person.PhoneNumber,
+		&person.Email,
+		&person.CreatedAt)
+
+	return person, err
+}
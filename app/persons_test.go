@@ -0,0 +1,238 @@
+package app
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+// newPersonsApp registers accounts on an in-memory database holding person p1 Ivan Petrov, an acceptor
+// only. While raced is set, a second request seems to have made p1 a donor in the meantime.
+func newPersonsApp(t *testing.T, raced *int32) (*App, *sqlfake.Store) {
+	t.Helper()
+	store := sqlfake.NewStore()
+	registered := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	store.SetDefaults("donors", map[string]driver.Value{"emailVerified": false, "phoneVerified": false})
+	store.Insert("persons", []string{"id", "name", "lastName", "phone", "email", "createdAt"},
+		"p1", "Ivan", "Petrov", "+359888123456", "ivan@example.com", registered)
+	store.Insert("acceptors", []string{"id", "personId", "bloodGroup", "city", "bloodCenter", "regDate", "urgent"},
+		"a1", "p1", "A+", "Sofia", "Sofia Blood Center", registered, false)
+
+	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		if atomic.LoadInt32(raced) == 1 && strings.HasPrefix(query, "INSERT INTO donors") {
+			return sqlfake.Error(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'p1' for key 'personId'"})
+		}
+		return store.Handle(query, args)
+	})
+	return newAccountsApp(t, db), store
+}
+
+// newAccountsApp serves the account routes from db
+func newAccountsApp(t *testing.T, db *sql.DB) *App {
+	t.Helper()
+	cluster := NewDBCluster(db)
+	donors, err := NewDonorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptors, err := NewAcceptorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persons, err := NewPersonsMySQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &App{Database: db, Cluster: cluster, DonorsRepo: donors, AcceptorsRepo: acceptors, PersonsRepo: persons, PhoneRegion: "BG"}
+}
+
+func TestAddDonorAttachLeavesPersonUnchanged(t *testing.T) {
+	var raced int32
+	app, store := newPersonsApp(t, &raced)
+
+	body := `{"personId": "p1", "name": "Mallory", "lastName": "X", "phone": "0877000111", "email": "mallory@example.com", "bloodGroup": "A+", "city": "Sofia"}`
+	w := httptest.NewRecorder()
+	app.addDonor(w, httptest.NewRequest(http.MethodPost, "/accounts/donors", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var donor Donor
+	if err := json.NewDecoder(w.Body).Decode(&donor); err != nil {
+		t.Fatal(err)
+	}
+	if donor.PersonID != "p1" || donor.FirstName != "Ivan" || donor.LastName != "Petrov" || donor.PhoneNumber != "+359888123456" || donor.Email != "ivan@example.com" {
+		t.Errorf("donor %+v, want the identity of person p1", donor)
+	}
+
+	persons := store.Rows("persons")
+	if len(persons) != 1 {
+		t.Fatalf("%d persons, want the existing one only", len(persons))
+	}
+	for column, want := range map[string]string{"name": "Ivan", "lastName": "Petrov", "phone": "+359888123456", "email": "ivan@example.com"} {
+		if persons[0][column] != driver.Value(want) {
+			t.Errorf("person %s = %v, want %s unchanged", column, persons[0][column], want)
+		}
+	}
+}
+
+func TestAddAcceptorForNewPersonCreatesIt(t *testing.T) {
+	var raced int32
+	app, store := newPersonsApp(t, &raced)
+
+	w := httptest.NewRecorder()
+	app.addAcceptor(w, httptest.NewRequest(http.MethodPost, "/accounts/acceptors",
+		strings.NewReader(`{"name": "Maria", "lastName": "Ivanova", "bloodGroup": "0-", "city": "Varna"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if persons := store.Rows("persons"); len(persons) != 2 || persons[1]["name"] != driver.Value("Maria") {
+		t.Errorf("persons %v, want Maria added", persons)
+	}
+}
+
+func TestAddDonorRacingForPersonConflicts(t *testing.T) {
+	raced := int32(1)
+	app, _ := newPersonsApp(t, &raced)
+
+	w := httptest.NewRecorder()
+	app.addDonor(w, httptest.NewRequest(http.MethodPost, "/accounts/donors",
+		strings.NewReader(`{"personId": "p1", "bloodGroup": "A+", "city": "Sofia"}`)))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "already is a donor") {
+		t.Errorf("status %d: %s, want 409", w.Code, w.Body)
+	}
+}
+
+func TestUpdateAcceptorLeavesDonorNameUnchanged(t *testing.T) {
+	var raced int32
+	app, store := newPersonsApp(t, &raced)
+	store.Insert("donors", []string{"id", "personId", "dateOfBirth", "gender", "bloodGroup", "city", "regDate", "emailVerified", "phoneVerified", "notificationsOptIn"},
+		"d1", "p1", "1990-04-12", "male", "A+", "Sofia", time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC), false, false, true)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		router := mux.NewRouter()
+		router.Path("/accounts/acceptors/{id}").HandlerFunc(app.updateAcceptorByID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/accounts/acceptors/a1", strings.NewReader(body)))
+		return w
+	}
+
+	if w := update(`{"name": "Mallory", "lastName": "X"}`); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous rename of a donor = %d: %s, want 403", w.Code, w.Body)
+	}
+	if w := update(`{"name": "Ivan", "city": "Plovdiv"}`); w.Code != http.StatusOK {
+		t.Errorf("update keeping the name = %d: %s, want 200", w.Code, w.Body)
+	}
+
+	persons := store.Rows("persons")
+	if len(persons) != 1 || persons[0]["name"] != driver.Value("Ivan") || persons[0]["lastName"] != driver.Value("Petrov") {
+		t.Errorf("persons %v, want Ivan Petrov unchanged", persons)
+	}
+	if acceptors := store.Rows("acceptors"); acceptors[0]["city"] != driver.Value("Plovdiv") {
+		t.Errorf("acceptor city %v, want Plovdiv", acceptors[0]["city"])
+	}
+}
+
+func TestUpdateAcceptorRenamesAcceptorOnlyPerson(t *testing.T) {
+	var raced int32
+	app, store := newPersonsApp(t, &raced)
+
+	router := mux.NewRouter()
+	router.Path("/accounts/acceptors/{id}").HandlerFunc(app.updateAcceptorByID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/accounts/acceptors/a1", strings.NewReader(`{"name": "Ivo"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if persons := store.Rows("persons"); persons[0]["name"] != driver.Value("Ivo") {
+		t.Errorf("person name %v, want Ivo", persons[0]["name"])
+	}
+}
+
+func TestUpdateAndDeleteAnswers(t *testing.T) {
+	out, restore := captureLog()
+	defer restore()
+	store := sqlfake.NewStore()
+	registered := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	store.Insert("persons", []string{"id", "name", "lastName", "phone", "email", "createdAt"},
+		"p1", "Ivan", "Petrov", "+359888123456", "ivan@example.com", registered)
+	store.Insert("donors", []string{"id", "personId", "dateOfBirth", "gender", "bloodGroup", "city", "regDate", "emailVerified", "phoneVerified", "notificationsOptIn"},
+		"d1", "p1", "1990-04-12", "male", "A+", "Sofia", registered, false, false, true)
+	store.Insert("acceptors", []string{"id", "personId", "bloodGroup", "city", "bloodCenter", "regDate", "urgent"},
+		"a1", "p1", "A+", "Sofia", "Sofia Blood Center", registered, false)
+	failing := false
+	app := newAccountsApp(t, sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		if failing && strings.HasPrefix(query, "UPDATE") {
+			return sqlfake.Error(errConnectionLost)
+		}
+		if strings.Contains(query, "NOT EXISTS") || strings.HasPrefix(query, "DELETE credentials") {
+			return sqlfake.Result{}
+		}
+		return store.Handle(query, args)
+	}))
+
+	router := mux.NewRouter()
+	router.Methods(http.MethodPut).Path("/accounts/donors/{id}").HandlerFunc(app.updateDonorByID)
+	router.Methods(http.MethodDelete).Path("/accounts/donors/{id}").HandlerFunc(app.deleteDonorByID)
+	router.Methods(http.MethodPut).Path("/accounts/acceptors/{id}").HandlerFunc(app.updateAcceptorByID)
+	router.Methods(http.MethodDelete).Path("/accounts/acceptors/{id}").HandlerFunc(app.deleteAcceptorByID)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	failing = true
+	for _, path := range []string{"/accounts/donors/d1", "/accounts/acceptors/a1"} {
+		out.Reset()
+		if w := serve(http.MethodPut, path, `{"city": "Plovdiv"}`); w.Code != http.StatusInternalServerError {
+			t.Errorf("failed PUT %s = %d: %s, want 500", path, w.Code, w.Body)
+		}
+		if !strings.Contains(out.String(), `"msg":"could not update`) {
+			t.Errorf("failed PUT %s logged %s, want the error", path, out)
+		}
+	}
+	failing = false
+
+	w := serve(http.MethodPut, "/accounts/acceptors/a1", `{"city": "Plovdiv", "urgent": false}`)
+	var acceptor Acceptor
+	if err := json.NewDecoder(w.Body).Decode(&acceptor); w.Code != http.StatusOK || err != nil || acceptor.ID != "a1" || acceptor.City != "Plovdiv" {
+		t.Errorf("PUT acceptor = %d %+v, %v, want the stored acceptor", w.Code, acceptor, err)
+	}
+
+	for _, path := range []string{"/accounts/donors/d1", "/accounts/acceptors/a1"} {
+		if w := serve(http.MethodDelete, path, ""); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("DELETE %s = %d %q, want an empty 204", path, w.Code, w.Body)
+		}
+	}
+	if donors, acceptors := store.Rows("donors"), store.Rows("acceptors"); len(donors) != 0 || len(acceptors) != 0 {
+		t.Errorf("donors %v and acceptors %v left after DELETE", donors, acceptors)
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&mysql.MySQLError{Number: 1062}, true},
+		{&mysql.MySQLError{Number: 1213}, false},
+		{errConnectionLost, false},
+	}
+	for _, tt := range tests {
+		if got := isDuplicateKey(tt.err); got != tt.want {
+			t.Errorf("isDuplicateKey(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
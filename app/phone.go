@@ -0,0 +1,116 @@
+package app
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidPhone is returned when a phone number cannot be normalized to E.164
+var ErrInvalidPhone = errors.New("phone number is invalid")
+
+// DefaultPhoneRegion is used for national numbers when no region is configured
+const DefaultPhoneRegion = "BG"
+
+// phoneRegion describes the numbering plan of a country
+type phoneRegion struct {
+	countryCode string
+	trunkPrefix string
+	// minLen and maxLen bound the length of the national significant number
+	minLen int
+	maxLen int
+	// mobileLen is the exact length of mobile numbers, identified by mobilePrefixes
+	mobileLen      int
+	mobilePrefixes []string
+}
+
+var phoneRegions = map[string]phoneRegion{
+	"BG": {countryCode: "359", trunkPrefix: "0", minLen: 8, maxLen: 9, mobileLen: 9, mobilePrefixes: []string{"87", "88", "89", "98", "99"}},
+	"RO": {countryCode: "40", trunkPrefix: "0", minLen: 9, maxLen: 9},
+	"GR": {countryCode: "30", minLen: 10, maxLen: 10},
+	"MK": {countryCode: "389", trunkPrefix: "0", minLen: 8, maxLen: 8},
+	"RS": {countryCode: "381", trunkPrefix: "0", minLen: 8, maxLen: 9},
+	"TR": {countryCode: "90", trunkPrefix: "0", minLen: 10, maxLen: 10},
+	"DE": {countryCode: "49", trunkPrefix: "0", minLen: 6, maxLen: 13},
+	"GB": {countryCode: "44", trunkPrefix: "0", minLen: 9, maxLen: 10},
+}
+
+// IsSupportedPhoneRegion reports whether national numbers of the region can be parsed
+func IsSupportedPhoneRegion(region string) bool {
+	_, ok := phoneRegions[strings.ToUpper(region)]
+	return ok
+}
+
+// NormalizePhone converts a phone number written in international or national format to E.164.
+// National numbers are interpreted in defaultRegion. An empty number is returned unchanged.
+func NormalizePhone(raw string, defaultRegion string) (string, error) {
+	number := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if number == "" {
+		return "", nil
+	}
+
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+	international := strings.HasPrefix(number, "+")
+	number = strings.TrimPrefix(number, "+")
+	if !isDigits(number) {
+		return "", ErrInvalidPhone
+	}
+
+	if international {
+		for _, region := range phoneRegions {
+			if strings.HasPrefix(number, region.countryCode) {
+				return region.format(number[len(region.countryCode):])
+			}
+		}
+		// E.164 numbers are at most 15 digits including the country code
+		if len(number) < 8 || len(number) > 15 {
+			return "", ErrInvalidPhone
+		}
+		return "+" + number, nil
+	}
+
+	region, ok := phoneRegions[strings.ToUpper(defaultRegion)]
+	if !ok {
+		return "", ErrInvalidPhone
+	}
+	if region.trunkPrefix != "" {
+		if !strings.HasPrefix(number, region.trunkPrefix) {
+			return "", ErrInvalidPhone
+		}
+		number = number[len(region.trunkPrefix):]
+	}
+	return region.format(number)
+}
+
+// format validates a national significant number and prefixes it with the country code
+func (region phoneRegion) format(nsn string) (string, error) {
+	if len(nsn) < region.minLen || len(nsn) > region.maxLen {
+		return "", ErrInvalidPhone
+	}
+	for _, prefix := range region.mobilePrefixes {
+		if strings.HasPrefix(nsn, prefix) && len(nsn) != region.mobileLen {
+			return "", ErrInvalidPhone
+		}
+	}
+
+	return "+" + region.countryCode + nsn, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
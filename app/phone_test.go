@@ -0,0 +1,52 @@
+package app
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		raw     string
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{raw: "", region: "BG", want: ""},
+		{raw: "   ", region: "BG", want: ""},
+		{raw: "0888 123 456", region: "BG", want: "+359888123456"},
+		{raw: "(0888) 123-456", region: "bg", want: "+359888123456"},
+		{raw: "+359 888 123 456", region: "BG", want: "+359888123456"},
+		{raw: "00359888123456", region: "RO", want: "+359888123456"},
+		{raw: "02 123 4567", region: "BG", want: "+35921234567"},
+		{raw: "08881234", region: "BG", wantErr: true},
+		{raw: "888123456", region: "BG", wantErr: true},
+		{raw: "0712 345 678", region: "RO", want: "+40712345678"},
+		{raw: "210 123 4567", region: "GR", want: "+302101234567"},
+		{raw: "+1 202 555 0123", region: "BG", want: "+12025550123"},
+		{raw: "+1234", region: "BG", wantErr: true},
+		{raw: "+1234567890123456", region: "BG", wantErr: true},
+		{raw: "0888 12a 456", region: "BG", wantErr: true},
+		{raw: "+", region: "BG", wantErr: true},
+		{raw: "0888123456", region: "XX", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.raw, tt.region)
+		if tt.wantErr {
+			if err != ErrInvalidPhone {
+				t.Errorf("NormalizePhone(%q, %q) = %q, %v, want ErrInvalidPhone", tt.raw, tt.region, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizePhone(%q, %q) = %q, %v, want %q", tt.raw, tt.region, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsSupportedPhoneRegion(t *testing.T) {
+	tests := map[string]bool{"BG": true, "bg": true, "GB": true, "US": false, "": false}
+	for region, want := range tests {
+		if got := IsSupportedPhoneRegion(region); got != want {
+			t.Errorf("IsSupportedPhoneRegion(%q) = %v, want %v", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrNoPendingCode is returned when the donor has not requested a code for the current phone number
+	ErrNoPendingCode = errors.New("no verification code was sent to this phone number")
+	// ErrCodeExpired is returned when the code is past its expiry
+	ErrCodeExpired = errors.New("verification code has expired")
+	// ErrCodeMismatch is returned when a wrong code is entered
+	ErrCodeMismatch = errors.New("verification code does not match")
+	// ErrTooManyAttempts is returned once the allowed number of wrong codes is exhausted
+	ErrTooManyAttempts = errors.New("too many verification attempts")
+	// ErrCodeRecentlySent is returned when a new code is requested too soon after the previous one
+	ErrCodeRecentlySent = errors.New("a verification code was sent recently")
+)
+
+const phoneCodeDigits = 6
+
+// PhoneVerifier sends one-time SMS codes and checks them with attempt limits and expiry
+type PhoneVerifier struct {
+	repo         *PhoneVerificationsMySQL
+	sender       SMSSender
+	ttl          time.Duration
+	resendAfter  time.Duration
+	maxAttempts  int
+	now          func() time.Time
+	generateCode func() (string, error)
+}
+
+// NewPhoneVerifier creates a verifier, codes are valid for ttl and accept at most maxAttempts wrong entries
+func NewPhoneVerifier(repo *PhoneVerificationsMySQL, sender SMSSender, ttl time.Duration, maxAttempts int) *PhoneVerifier {
+	return &PhoneVerifier{
+		repo:         repo,
+		sender:       sender,
+		ttl:          ttl,
+		resendAfter:  time.Minute,
+		maxAttempts:  maxAttempts,
+		now:          time.Now,
+		generateCode: randomCode,
+	}
+}
+
+// Start sends a fresh code to the donor phone, replacing any pending one but keeping its wrong attempts
+func (v *PhoneVerifier) Start(ctx context.Context, donor Donor) error {
+	now := v.now()
+	pending, err := v.repo.GetByDonorID(ctx, donor.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	attempts := 0
+	if err == nil && pending.Phone == donor.PhoneNumber && !now.After(time.Unix(pending.ExpiresAt, 0)) {
+		if now.Before(time.Unix(pending.SentAt, 0).Add(v.resendAfter)) {
+			return ErrCodeRecentlySent
+		}
+		// wrong codes count until the pending code expires, so resending does not buy more guesses
+		if pending.Attempts >= v.maxAttempts {
+			return ErrTooManyAttempts
+		}
+		attempts = pending.Attempts
+	}
+
+	code, err := v.generateCode()
+	if err != nil {
+		return err
+	}
+
+	err = v.repo.Save(ctx, PhoneVerification{
+		DonorID:   donor.ID,
+		Phone:     donor.PhoneNumber,
+		CodeHash:  v.hash(donor, code),
+		SentAt:    now.Unix(),
+		ExpiresAt: now.Add(v.ttl).Unix(),
+		Attempts:  attempts,
+	})
+	if err != nil {
+		return err
+	}
+
+	return v.sender.SendSMS(donor.PhoneNumber, fmt.Sprintf("Your LifeBlood verification code is %s", code))
+}
+
+// Check verifies the code entered by the donor, the pending code is consumed on success. Run inside a
+// transaction, the pending code stays locked until it ends so concurrent checks cannot share attempts
+func (v *PhoneVerifier) Check(ctx context.Context, donor Donor, code string) error {
+	pending, err := v.repo.GetByDonorIDForUpdate(ctx, donor.ID)
+	if err == sql.ErrNoRows || (err == nil && pending.Phone != donor.PhoneNumber) {
+		return ErrNoPendingCode
+	}
+	if err != nil {
+		return err
+	}
+
+	if v.now().After(time.Unix(pending.ExpiresAt, 0)) {
+		return ErrCodeExpired
+	}
+	if pending.Attempts >= v.maxAttempts {
+		return ErrTooManyAttempts
+	}
+	if !hmac.Equal([]byte(pending.CodeHash), []byte(v.hash(donor, code))) {
+		if err := v.repo.IncrementAttempts(ctx, donor.ID); err != nil {
+			return err
+		}
+		if pending.Attempts+1 >= v.maxAttempts {
+			return ErrTooManyAttempts
+		}
+		return ErrCodeMismatch
+	}
+
+	return v.repo.DeleteByDonorID(ctx, donor.ID)
+}
+
+// hash binds the code to the donor and phone so a stored hash cannot be reused elsewhere
+func (v *PhoneVerifier) hash(donor Donor, code string) string {
+	sum := sha256.Sum256([]byte(donor.ID + "\n" + donor.PhoneNumber + "\n" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < phoneCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", phoneCodeDigits, n), nil
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+// phoneVerificationsTable keeps the rows of phone_verifications for the statements of the repository
+type phoneVerificationsTable struct {
+	mu      sync.Mutex
+	rows    map[string]PhoneVerification
+	queries []string
+}
+
+func (t *phoneVerificationsTable) handle(query string, args []driver.Value) sqlfake.Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queries = append(t.queries, query)
+	switch {
+	case strings.HasPrefix(query, "REPLACE"):
+		t.rows[args[0].(string)] = PhoneVerification{
+			DonorID:   args[0].(string),
+			Phone:     args[1].(string),
+			CodeHash:  args[2].(string),
+			SentAt:    args[3].(int64),
+			ExpiresAt: args[4].(int64),
+			Attempts:  int(args[5].(int64)),
+		}
+		return sqlfake.Result{RowsAffected: 1}
+	case strings.HasPrefix(query, "SELECT"):
+		columns := []string{"donorId", "phone", "codeHash", "sentAt", "expiresAt", "attempts"}
+		v, ok := t.rows[args[0].(string)]
+		if !ok {
+			return sqlfake.Rows(columns)
+		}
+		return sqlfake.Rows(columns, []driver.Value{v.DonorID, v.Phone, v.CodeHash, v.SentAt, v.ExpiresAt, int64(v.Attempts)})
+	case strings.HasPrefix(query, "UPDATE"):
+		v := t.rows[args[0].(string)]
+		v.Attempts++
+		t.rows[args[0].(string)] = v
+		return sqlfake.Result{RowsAffected: 1}
+	case strings.HasPrefix(query, "DELETE"):
+		delete(t.rows, args[0].(string))
+		return sqlfake.Result{RowsAffected: 1}
+	}
+	return sqlfake.Result{}
+}
+
+type sentSMS struct {
+	to, text string
+}
+
+type recordingSMSSender struct {
+	sent []sentSMS
+}
+
+func (s *recordingSMSSender) SendSMS(to string, text string) error {
+	s.sent = append(s.sent, sentSMS{to: to, text: text})
+	return nil
+}
+
+func newTestPhoneVerifier(t *testing.T) (*PhoneVerifier, *phoneVerificationsTable, *recordingSMSSender, *time.Time) {
+	table := &phoneVerificationsTable{rows: map[string]PhoneVerification{}}
+	repo, err := NewPhoneVerificationsMySQL(sqlfake.Open(table.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &recordingSMSSender{}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier := NewPhoneVerifier(repo, sender, 10*time.Minute, 3)
+	verifier.now = func() time.Time { return now }
+	verifier.generateCode = func() (string, error) { return "123456", nil }
+	return verifier, table, sender, &now
+}
+
+func TestPhoneVerifierHash(t *testing.T) {
+	verifier := NewPhoneVerifier(nil, nil, time.Minute, 3)
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+
+	hash := verifier.hash(donor, "123456")
+	tests := []struct {
+		name  string
+		donor Donor
+		code  string
+		same  bool
+	}{
+		{"same donor, phone and code", donor, "123456", true},
+		{"other code", donor, "123457", false},
+		{"other donor", Donor{ID: "d2", PhoneNumber: donor.PhoneNumber}, "123456", false},
+		{"other phone", Donor{ID: donor.ID, PhoneNumber: "+359888123457"}, "123456", false},
+	}
+	for _, tt := range tests {
+		if got := verifier.hash(tt.donor, tt.code); (got == hash) != tt.same {
+			t.Errorf("%s: hash equal = %v, want %v", tt.name, got == hash, tt.same)
+		}
+	}
+	if strings.Contains(hash, "123456") || len(hash) != 64 {
+		t.Errorf("hash %q is not a sha256 hex digest of the code", hash)
+	}
+}
+
+func TestRandomCode(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		code, err := randomCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != phoneCodeDigits || !isDigits(code) {
+			t.Fatalf("randomCode() = %q, want %d digits", code, phoneCodeDigits)
+		}
+	}
+}
+
+func TestPhoneVerifierCheck(t *testing.T) {
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		phone   string
+		codes   []string
+		want    []error
+	}{
+		{"right code", 0, donor.PhoneNumber, []string{"123456"}, []error{nil}},
+		{"right code just before expiry", 10 * time.Minute, donor.PhoneNumber, []string{"123456"}, []error{nil}},
+		{"expired code", 10*time.Minute + time.Second, donor.PhoneNumber, []string{"123456"}, []error{ErrCodeExpired}},
+		{"code consumed", 0, donor.PhoneNumber, []string{"123456", "123456"}, []error{nil, ErrNoPendingCode}},
+		{"phone changed", 0, "+359888999999", []string{"123456"}, []error{ErrNoPendingCode}},
+		{"wrong codes", 0, donor.PhoneNumber, []string{"000000", "111111", "222222", "123456"},
+			[]error{ErrCodeMismatch, ErrCodeMismatch, ErrTooManyAttempts, ErrTooManyAttempts}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, _, sender, now := newTestPhoneVerifier(t)
+			if err := verifier.Start(context.Background(), donor); err != nil {
+				t.Fatal(err)
+			}
+			if len(sender.sent) != 1 || sender.sent[0].to != donor.PhoneNumber || !strings.Contains(sender.sent[0].text, "123456") {
+				t.Fatalf("sent %+v, want the code sent to %s", sender.sent, donor.PhoneNumber)
+			}
+
+			*now = now.Add(tt.elapsed)
+			checked := Donor{ID: donor.ID, PhoneNumber: tt.phone}
+			for i, code := range tt.codes {
+				if err := verifier.Check(context.Background(), checked, code); err != tt.want[i] {
+					t.Errorf("Check %d (%s) = %v, want %v", i, code, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPhoneVerifierStartWithoutPendingCode(t *testing.T) {
+	verifier, table, _, _ := newTestPhoneVerifier(t)
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+	if err := verifier.Check(context.Background(), donor, "123456"); err != ErrNoPendingCode {
+		t.Fatalf("Check without a code = %v, want ErrNoPendingCode", err)
+	}
+	if err := verifier.Start(context.Background(), donor); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := table.rows[donor.ID]
+	if stored.CodeHash != verifier.hash(donor, "123456") {
+		t.Errorf("stored hash %q is not the hash of the code", stored.CodeHash)
+	}
+	if got := time.Unix(stored.ExpiresAt, 0).Sub(time.Unix(stored.SentAt, 0)); got != 10*time.Minute {
+		t.Errorf("code valid for %s, want 10m", got)
+	}
+}
+
+func TestPhoneVerifierCheckLocksPendingCode(t *testing.T) {
+	verifier, table, _, _ := newTestPhoneVerifier(t)
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+	if err := verifier.Start(context.Background(), donor); err != nil {
+		t.Fatal(err)
+	}
+
+	table.queries = nil
+	if err := verifier.Check(context.Background(), donor, "000000"); err != ErrCodeMismatch {
+		t.Fatalf("Check = %v, want ErrCodeMismatch", err)
+	}
+	if len(table.queries) == 0 || !strings.HasPrefix(table.queries[0], "SELECT") || !strings.HasSuffix(table.queries[0], "FOR UPDATE") {
+		t.Errorf("Check read the pending code with %q, want a locking SELECT ... FOR UPDATE", table.queries)
+	}
+}
+
+func TestPhoneVerifierStartKeepsAttempts(t *testing.T) {
+	verifier, table, sender, now := newTestPhoneVerifier(t)
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+	ctx := context.Background()
+	if err := verifier.Start(ctx, donor); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"000000", "111111"} {
+		if err := verifier.Check(ctx, donor, code); err != ErrCodeMismatch {
+			t.Fatalf("Check(%s) = %v, want ErrCodeMismatch", code, err)
+		}
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if err := verifier.Start(ctx, donor); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.rows[donor.ID].Attempts; got != 2 {
+		t.Fatalf("attempts after resending = %d, want 2", got)
+	}
+	if err := verifier.Check(ctx, donor, "222222"); err != ErrTooManyAttempts {
+		t.Fatalf("third wrong code after resending = %v, want ErrTooManyAttempts", err)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if err := verifier.Start(ctx, donor); err != ErrTooManyAttempts {
+		t.Fatalf("Start with attempts used up = %v, want ErrTooManyAttempts", err)
+	}
+	if len(sender.sent) != 2 {
+		t.Errorf("sent %d codes, want no code once attempts are used up", len(sender.sent))
+	}
+
+	// once the pending code expired the donor starts over
+	*now = now.Add(10 * time.Minute)
+	if err := verifier.Start(ctx, donor); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.rows[donor.ID].Attempts; got != 0 {
+		t.Errorf("attempts after the code expired = %d, want 0", got)
+	}
+	if err := verifier.Check(ctx, donor, "123456"); err != nil {
+		t.Errorf("Check of the new code = %v", err)
+	}
+}
+
+func TestPhoneVerifierStartForOtherPhoneResetsAttempts(t *testing.T) {
+	verifier, table, _, _ := newTestPhoneVerifier(t)
+	donor := Donor{ID: "d1", PhoneNumber: "+359888123456"}
+	ctx := context.Background()
+	if err := verifier.Start(ctx, donor); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Check(ctx, donor, "000000"); err != ErrCodeMismatch {
+		t.Fatalf("Check = %v, want ErrCodeMismatch", err)
+	}
+
+	donor.PhoneNumber = "+359888999999"
+	if err := verifier.Start(ctx, donor); err != nil {
+		t.Fatalf("Start for a changed phone = %v, want a code sent at once", err)
+	}
+	if got := table.rows[donor.ID]; got.Attempts != 0 || got.Phone != donor.PhoneNumber {
+		t.Errorf("pending code = %+v, want one for the new phone without attempts", got)
+	}
+}
+
+func TestLogSMSSenderLeavesOutText(t *testing.T) {
+	var out bytes.Buffer
+	defaultLogger := DefaultLogger
+	DefaultLogger = NewLogger(&out, LevelInfo)
+	defer func() { DefaultLogger = defaultLogger }()
+
+	if err := (LogSMSSender{}).SendSMS("+359888123456", "Your LifeBlood verification code is 654321"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "654321") || strings.Contains(out.String(), "888123456") {
+		t.Errorf("log entry %q reveals the message", out.String())
+	}
+	if !strings.Contains(out.String(), "SMS sent") {
+		t.Errorf("log entry %q does not record the message", out.String())
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//PhoneVerification pending SMS code sent to a donor
+type PhoneVerification struct {
+	DonorID   string
+	Phone     string
+	CodeHash  string
+	SentAt    int64
+	ExpiresAt int64
+	Attempts  int
+}
+
+const phoneVerificationColumns = `donorId, phone, codeHash, sentAt, expiresAt, attempts`
+
+//PhoneVerificationsMySQL mysql repo
+type PhoneVerificationsMySQL struct {
+	statements *statements
+
+	save              *stmt
+	getByDonorID      *stmt
+	getForUpdate      *stmt
+	incrementAttempts *stmt
+	deleteByDonorID   *stmt
+}
+
+//NewPhoneVerificationsMySQL create new repository, preparing its statements
+func NewPhoneVerificationsMySQL(db *sql.DB) (*PhoneVerificationsMySQL, error) {
+	s := newStatements(db)
+	r := &PhoneVerificationsMySQL{
+		statements: s,
+
+		save: s.prepare(`REPLACE INTO phone_verifications (donorId, phone, codeHash, sentAt, expiresAt, attempts)
+		VALUES (?,?,?,?,?,?);`),
+		getByDonorID:      s.prepare(`SELECT ` + phoneVerificationColumns + ` FROM phone_verifications WHERE donorId=?`),
+		getForUpdate:      s.prepare(`SELECT ` + phoneVerificationColumns + ` FROM phone_verifications WHERE donorId=? FOR UPDATE`),
+		incrementAttempts: s.prepare(`UPDATE phone_verifications SET attempts=attempts+1 WHERE donorId=?;`),
+		deleteByDonorID:   s.prepare(`DELETE FROM phone_verifications WHERE donorId=?;`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Close the prepared statements of the repository
+func (r *PhoneVerificationsMySQL) Close() error {
+	return r.statements.Close()
+}
+
+//Save replaces the pending verification of the donor
+func (r *PhoneVerificationsMySQL) Save(ctx context.Context, verification PhoneVerification) (err error) {
+	defer observeRepo("PhoneVerificationsMySQL", "Save", time.Now(), &err)
+	_, err = r.save.exec(ctx,
+		verification.DonorID, verification.Phone, verification.CodeHash, verification.SentAt, verification.ExpiresAt, verification.Attempts)
+	return err
+}
+
+//GetByDonorID Retrieve the pending verification of a donor
+func (r *PhoneVerificationsMySQL) GetByDonorID(ctx context.Context, donorID string) (_ PhoneVerification, err error) {
+	defer observeRepo("PhoneVerificationsMySQL", "GetByDonorID", time.Now(), &err)
+	return scanPhoneVerification(r.getByDonorID.queryRow(ctx, donorID))
+}
+
+//GetByDonorIDForUpdate Retrieve the pending verification of a donor, locking it until the transaction of ctx ends
+func (r *PhoneVerificationsMySQL) GetByDonorIDForUpdate(ctx context.Context, donorID string) (_ PhoneVerification, err error) {
+	defer observeRepo("PhoneVerificationsMySQL", "GetByDonorIDForUpdate", time.Now(), &err)
+	return scanPhoneVerification(r.getForUpdate.queryRow(ctx, donorID))
+}
+
+func scanPhoneVerification(row rowScanner) (PhoneVerification, error) {
+	verification := PhoneVerification{}
+	err := row.Scan(
+		&verification.DonorID,
+		&verification.Phone,
+		&verification.CodeHash,
+		&verification.SentAt,
+		&verification.ExpiresAt,
+		&verification.Attempts)
+
+	return verification, err
+}
+
+//IncrementAttempts record a failed attempt to enter the code
+func (r *PhoneVerificationsMySQL) IncrementAttempts(ctx context.Context, donorID string) (err error) {
+	defer observeRepo("PhoneVerificationsMySQL", "IncrementAttempts", time.Now(), &err)
+	_, err = r.incrementAttempts.exec(ctx, donorID)
+	return err
+}
+
+//DeleteByDonorID remove the pending verification of a donor
+func (r *PhoneVerificationsMySQL) DeleteByDonorID(ctx context.Context, donorID string) (err error) {
+	defer observeRepo("PhoneVerificationsMySQL", "DeleteByDonorID", time.Now(), &err)
+	_, err = r.deleteByDonorID.exec(ctx, donorID)
+	return err
+}
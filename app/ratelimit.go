@@ -0,0 +1,257 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route groups sharing a rate limit
+const (
+	RateLimitDefault      = "default"
+	RateLimitRegistration = "registration"
+	RateLimitVerification = "verification"
+	RateLimitAdmin        = "admin"
+)
+
+// RateLimit allows Requests per Period to every client, spent as a token bucket refilled continuously
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRateLimit reads limits written as "<requests>/<period>", e.g. "5/1h"
+func ParseRateLimit(value string) (RateLimit, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like 100/1m", value)
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || requests < 1 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must allow a positive number of requests", value)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must have a positive period", value)
+	}
+	return RateLimit{Requests: requests, Period: period}, nil
+}
+
+// RateLimiter keeps a token bucket per route group and client
+type RateLimiter struct {
+	limits map[string]RateLimit
+	// trustedProxies are allowed to report the client address in X-Forwarded-For
+	trustedProxies []*net.IPNet
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter, groups without a limit fall back to the default group and are unlimited without one
+func NewRateLimiter(limits map[string]RateLimit, trustedProxies []*net.IPNet) *RateLimiter {
+	return &RateLimiter{
+		limits:         limits,
+		trustedProxies: trustedProxies,
+		buckets:        map[string]*tokenBucket{},
+		now:            time.Now,
+	}
+}
+
+// rateLimitDecision is the outcome of taking a token
+type rateLimitDecision struct {
+	allowed    bool
+	limit      RateLimit
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// take spends a token of the client in the group
+func (l *RateLimiter) take(group, client string) (rateLimitDecision, bool) {
+	limit, ok := l.limits[group]
+	if !ok {
+		group = RateLimitDefault
+		if limit, ok = l.limits[group]; !ok {
+			return rateLimitDecision{}, false
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	capacity := float64(limit.Requests)
+	perToken := limit.Period / time.Duration(limit.Requests)
+	key := group + "|" + client
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.updated))/float64(perToken))
+	bucket.updated = now
+
+	decision := rateLimitDecision{limit: limit}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.allowed = true
+	} else {
+		decision.retryAfter = time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+	decision.remaining = int(bucket.tokens)
+	decision.reset = time.Duration((capacity - bucket.tokens) * float64(perToken))
+	return decision, true
+}
+
+// sweep forgets buckets that have been full for a while, so idle clients do not pile up
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		group := key[:strings.IndexByte(key, '|')]
+		if limit, ok := l.limits[group]; ok && now.Sub(bucket.updated) > limit.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP is the address of the client, taken from X-Forwarded-For only when the request came through trusted proxies
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !l.trusted(host) {
+		return host
+	}
+
+	// the right-most address not added by one of our proxies is the client
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !l.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (l *RateLimiter) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitGroup sorts a request into the group whose limit applies to it
+func rateLimitGroup(r *http.Request) string {
+	route := routeTemplate(r)
+	switch {
+	case strings.HasPrefix(route, "/admin/"):
+		return RateLimitAdmin
+	case r.Method == http.MethodPost && (route == "/accounts/donors" || route == "/accounts/acceptors" || route == "/auth/register"):
+		return RateLimitRegistration
+	// password and registration token guesses are limited like verification codes
+	case strings.Contains(route, "/phone/") || route == "/accounts/verify-email" || route == "/auth/login" || route == "/auth/register/confirm":
+		return RateLimitVerification
+	default:
+		return RateLimitDefault
+	}
+}
+
+// principal identifies the caller by the subject of a verified token, "admin:<token hash>" or
+// "donor:<id>", and otherwise by the client address
+func (app *App) principal(r *http.Request) string {
+	if app.isAdmin(r) {
+		return "admin:" + hashToken(app.AdminToken)[:16]
+	}
+	if token := bearerToken(r); token != "" && app.Authenticator != nil {
+		// an invalid token or a failed lookup leaves the caller anonymous
+		if session, err := app.Authenticator.Authenticate(r.Context(), token); err == nil {
+			return "donor:" + session.DonorID
+		}
+	}
+	return "ip:" + app.clientIP(r)
+}
+
+// authenticated reports whether the request carries a valid admin or donor access token
+func (app *App) authenticated(r *http.Request) bool {
+	return !strings.HasPrefix(app.principal(r), "ip:")
+}
+
+// clientIP is the address of the client, read through the trusted proxies of the rate limiter
+func (app *App) clientIP(r *http.Request) string {
+	if app.RateLimiter != nil {
+		return app.RateLimiter.clientIP(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withRateLimit answers 429 once the client used up the limit of the route group, clients are
+// token subjects or else client addresses
+func (app *App) withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.RateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := app.principal(r)
+		decision, limited := app.RateLimiter.take(rateLimitGroup(r), client)
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(decision.limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", decision.limit.Requests, ceilSeconds(decision.limit.Period)))
+
+		if !decision.allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(decision.retryAfter)))
+			logWarn(r.Context(), "rate limit exceeded", Fields{"group": rateLimitGroup(r)})
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(map[string]RateLimit{
+		RateLimitDefault:      {Requests: 10, Period: time.Minute},
+		RateLimitRegistration: {Requests: 2, Period: time.Hour},
+	}, nil)
+	limiter.now = func() time.Time { return now }
+
+	for i, remaining := range []int{1, 0} {
+		decision, limited := limiter.take(RateLimitRegistration, "ip:10.0.0.1")
+		if !limited || !decision.allowed || decision.remaining != remaining {
+			t.Fatalf("request %d = %+v, %v, want allowed with %d remaining", i, decision, limited, remaining)
+		}
+	}
+
+	decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.1")
+	if decision.allowed {
+		t.Fatal("third request within the hour was allowed")
+	}
+	if decision.retryAfter != 30*time.Minute || decision.reset != time.Hour {
+		t.Errorf("retry after %v, reset %v, want 30m and 1h", decision.retryAfter, decision.reset)
+	}
+	if decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.2"); !decision.allowed {
+		t.Error("another client shares the bucket")
+	}
+
+	// half a period refills one token
+	now = now.Add(30 * time.Minute)
+	if decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.1"); !decision.allowed {
+		t.Error("refilled token was not allowed")
+	}
+	if decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.1"); decision.allowed {
+		t.Error("bucket refilled more than one token")
+	}
+
+	// a full period later the bucket is full again, not fuller
+	now = now.Add(5 * time.Hour)
+	for i := 0; i < 2; i++ {
+		if decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.1"); !decision.allowed {
+			t.Fatalf("request %d after the refill was not allowed", i)
+		}
+	}
+	if decision, _ := limiter.take(RateLimitRegistration, "ip:10.0.0.1"); decision.allowed {
+		t.Error("bucket holds more tokens than the limit")
+	}
+}
+
+func TestRateLimiterTakeFallsBackToDefault(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{RateLimitDefault: {Requests: 1, Period: time.Minute}}, nil)
+	if decision, limited := limiter.take(RateLimitAdmin, "ip:10.0.0.1"); !limited || decision.limit.Requests != 1 {
+		t.Errorf("group without a limit = %+v, %v, want the default limit", decision, limited)
+	}
+	if decision, _ := limiter.take(RateLimitVerification, "ip:10.0.0.1"); decision.allowed {
+		t.Error("groups falling back to the default do not share its bucket")
+	}
+
+	unlimited := NewRateLimiter(map[string]RateLimit{RateLimitAdmin: {Requests: 1, Period: time.Minute}}, nil)
+	if _, limited := unlimited.take(RateLimitVerification, "ip:10.0.0.1"); limited {
+		t.Error("group is limited without its own or a default limit")
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRateLimiter(nil, []*net.IPNet{proxies})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"203.0.113.7:4000", "", "203.0.113.7"},
+		// only trusted proxies report the client
+		{"203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"10.0.0.5:4000", "", "10.0.0.5"},
+		{"10.0.0.5:4000", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.5:4000", "198.51.100.1, 10.0.0.9", "198.51.100.1"},
+		// addresses left of the first untrusted hop may be forged by the client
+		{"10.0.0.5:4000", "192.0.2.66, 198.51.100.1, 10.0.0.9", "198.51.100.1"},
+		{"10.0.0.5:4000", "10.0.0.8, 10.0.0.9", "10.0.0.8"},
+		{"10.0.0.5:4000", "198.51.100.1, garbage", "10.0.0.5"},
+		{"10.0.0.5:4000", "garbage, 198.51.100.1", "198.51.100.1"},
+		{"[2001:db8::1]:4000", "198.51.100.1", "2001:db8::1"},
+		{"203.0.113.7", "", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/accounts/donors", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := limiter.clientIP(r); got != tt.want {
+			t.Errorf("client of %s forwarded for %q = %s, want %s", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+// newTestAuthenticator accepts the access token "s1.secret" of donor d1 and "s2.secret" of donor d2
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	store := sqlfake.NewStore()
+	expires := time.Date(2020, 5, 1, 11, 0, 0, 0, time.UTC)
+	columns := []string{"id", "donorId", "accessHash", "accessExpiresAt", "createdAt"}
+	store.Insert("auth_sessions", columns, "s1", "d1", hashToken("s1.secret"), expires, expires.Add(-time.Hour))
+	store.Insert("auth_sessions", columns, "s2", "d2", hashToken("s2.secret"), expires, expires.Add(-time.Hour))
+
+	repo, err := NewAuthMySQL(sqlfake.Open(store.Handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(repo, time.Hour, time.Hour, time.Hour, 10, 4)
+	authenticator.now = func() time.Time { return expires.Add(-time.Minute) }
+	return authenticator
+}
+
+func TestPrincipal(t *testing.T) {
+	app := &App{AdminToken: "admin-secret", Authenticator: newTestAuthenticator(t)}
+
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"admin-secret", "admin:" + hashToken("admin-secret")[:16]},
+		{"s1.secret", "donor:d1"},
+		{"s2.secret", "donor:d2"},
+		// unverified tokens fall back to the client address
+		{"s1.forged", "ip:203.0.113.7"},
+		{"unknown.secret", "ip:203.0.113.7"},
+		{"", "ip:203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/accounts/donors", nil)
+		r.RemoteAddr = "203.0.113.7:4000"
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if got := app.principal(r); got != tt.want {
+			t.Errorf("principal of %q = %s, want %s", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestWithRateLimitBucketsPerPrincipal(t *testing.T) {
+	app := &App{
+		Authenticator: newTestAuthenticator(t),
+		RateLimiter:   NewRateLimiter(map[string]RateLimit{RateLimitDefault: {Requests: 1, Period: time.Minute}}, nil),
+	}
+	handler := app.withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusNoContent},
+		{"", http.StatusTooManyRequests},
+		// donors behind the same address have buckets of their own
+		{"s1.secret", http.StatusNoContent},
+		{"s2.secret", http.StatusNoContent},
+		{"s1.secret", http.StatusTooManyRequests},
+		// a made up token does not buy a fresh bucket
+		{"s3.secret", http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/accounts/donors", nil)
+		r.RemoteAddr = "203.0.113.7:4000"
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("request %d with %q = %d, want %d", i, tt.token, w.Code, tt.want)
+		}
+	}
+}
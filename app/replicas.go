@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DBCluster is the primary database with any number of read replicas. Writes and most reads go to the
+// primary, the query-heavy listings are spread over the healthy replicas in turn.
+type DBCluster struct {
+	primary  *sql.DB
+	replicas []*Replica
+	next     uint32
+
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	running  int32
+}
+
+// Replica is a read-only copy of the primary
+type Replica struct {
+	Name string
+	DB   *sql.DB
+
+	healthy int32
+}
+
+// NewReplica creates a replica that is considered healthy until a check or a query fails
+func NewReplica(name string, db *sql.DB) *Replica {
+	return &Replica{Name: name, DB: db, healthy: 1}
+}
+
+// Healthy reports whether reads are sent to the replica
+func (r *Replica) Healthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *Replica) setHealthy(ctx context.Context, healthy bool, err error) {
+	value := int32(0)
+	if healthy {
+		value = 1
+	}
+	if atomic.SwapInt32(&r.healthy, value) == value {
+		return
+	}
+	if healthy {
+		logInfo(ctx, "replica is back, reads are sent to it again", Fields{"replica": r.Name})
+	} else {
+		logWarn(ctx, "replica failed, reads fall back to the primary", Fields{"replica": r.Name, "error": err})
+	}
+}
+
+// NewDBCluster creates a cluster, without replicas every read goes to the primary
+func NewDBCluster(primary *sql.DB, replicas ...*Replica) *DBCluster {
+	return &DBCluster{
+		primary:  primary,
+		replicas: replicas,
+		interval: 10 * time.Second,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Primary is the database taking the writes
+func (c *DBCluster) Primary() *sql.DB {
+	return c.primary
+}
+
+// Replicas are the read replicas of the cluster
+func (c *DBCluster) Replicas() []*Replica {
+	return c.replicas
+}
+
+// reader picks the database for a read that may be served by a replica: the next healthy replica,
+// or the primary inside a unit of work, once the request changed data, so it reads its own writes,
+// or when no replica is healthy
+func (c *DBCluster) reader(ctx context.Context) *Replica {
+	if len(c.replicas) == 0 || wroteInRequest(ctx) || txFromContext(ctx) != nil {
+		return nil
+	}
+
+	start := atomic.AddUint32(&c.next, 1)
+	for i := range c.replicas {
+		replica := c.replicas[(int(start)+i)%len(c.replicas)]
+		if replica.Healthy() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// queryReplica runs a read on a replica, the statement prepared on the primary answers when there is
+// no healthy replica or the replica fails
+func (c *DBCluster) queryReplica(ctx context.Context, statement *stmt, args ...interface{}) (*sql.Rows, error) {
+	replica := c.reader(ctx)
+	if replica == nil {
+		return statement.query(ctx, args...)
+	}
+
+	rows, err := querySQL(ctx, replica.DB, statement.text, args...)
+	if err != nil && ctx.Err() == nil {
+		// the replica only stops receiving reads when it is unreachable, not for an error of the statement
+		if pingErr := replica.DB.PingContext(ctx); pingErr != nil {
+			replica.setHealthy(ctx, false, pingErr)
+		}
+		return statement.query(ctx, args...)
+	}
+	return rows, err
+}
+
+// SetCheckInterval changes how often Start pings the replicas
+func (c *DBCluster) SetCheckInterval(interval time.Duration) {
+	c.interval = interval
+}
+
+// CheckReplicas pings every replica and updates its health
+func (c *DBCluster) CheckReplicas(ctx context.Context) {
+	for _, replica := range c.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, c.interval)
+		err := replica.DB.PingContext(checkCtx)
+		cancel()
+		replica.setHealthy(ctx, err == nil, err)
+	}
+}
+
+// Start checks the replicas in the background until Stop is called, so failed replicas are used again once they recover
+func (c *DBCluster) Start() {
+	atomic.StoreInt32(&c.running, 1)
+	go func() {
+		defer close(c.done)
+		defer atomic.StoreInt32(&c.running, 0)
+
+		ctx := context.Background()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.CheckReplicas(ctx)
+
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the replica checks
+func (c *DBCluster) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// Running reports whether the loop started by Start is still going
+func (c *DBCluster) Running() bool {
+	return atomic.LoadInt32(&c.running) == 1
+}
+
+// Close closes the primary and every replica
+func (c *DBCluster) Close() error {
+	err := c.primary.Close()
+	for _, replica := range c.replicas {
+		if closeErr := replica.DB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// writeReplicaGauges exposes whether every replica receives reads
+func (c *DBCluster) writeReplicaGauges(w io.Writer) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	values := map[labels]float64{}
+	for _, replica := range c.replicas {
+		up := 0.0
+		if replica.Healthy() {
+			up = 1
+		}
+		values[newLabels("replica", replica.Name)] = up
+	}
+	writeGauge(w, "accounts_db_replica_up", "Whether the replica is healthy and receives reads.", values)
+}
+
+// requestWrites is shared by everything serving one request, once set reads go to the primary
+type requestWrites struct {
+	wrote int32
+}
+
+type requestWritesKey struct{}
+
+// markWritten records that the request changed data. Every successful write calls it: stmt.exec for
+// statements run on their own, WithTx for the statements of a unit of work once it commits.
+func markWritten(ctx context.Context) {
+	if writes, ok := ctx.Value(requestWritesKey{}).(*requestWrites); ok {
+		atomic.StoreInt32(&writes.wrote, 1)
+	}
+}
+
+func wroteInRequest(ctx context.Context) bool {
+	writes, ok := ctx.Value(requestWritesKey{}).(*requestWrites)
+	return ok && atomic.LoadInt32(&writes.wrote) == 1
+}
+
+// withReadYourWrites lets a request read its own writes, replicas may lag behind the primary
+func (app *App) withReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestWritesKey{}, &requestWrites{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+// countingDB answers every statement with one row and counts the statements per database, writes to
+// the donor "broken" fail
+type countingDB struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingDB) open(name string) *sql.DB {
+	return sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		c.mu.Lock()
+		c.counts[name]++
+		c.mu.Unlock()
+		if len(args) == 2 && args[1] == "broken" {
+			return sqlfake.Error(errConnectionLost)
+		}
+		return sqlfake.Rows([]string{"id"}, []driver.Value{"d1"})
+	})
+}
+
+func (c *countingDB) take() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = map[string]int{}
+	return counts
+}
+
+func newTestCluster(t *testing.T) (*DBCluster, *stmt, *stmt, *countingDB) {
+	t.Helper()
+	dbs := &countingDB{counts: map[string]int{}}
+	cluster := NewDBCluster(dbs.open("primary"), NewReplica("r1", dbs.open("r1")), NewReplica("r2", dbs.open("r2")))
+	statements := newStatements(cluster.Primary())
+	read := statements.prepare("SELECT id FROM donors")
+	write := statements.prepare("UPDATE donors SET city=? WHERE id=?")
+	if err := statements.check(); err != nil {
+		t.Fatal(err)
+	}
+	return cluster, read, write, dbs
+}
+
+func readFrom(t *testing.T, ctx context.Context, cluster *DBCluster, read *stmt) {
+	t.Helper()
+	rows, err := cluster.queryReplica(ctx, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+}
+
+func TestReplicasTakeReadsInTurn(t *testing.T) {
+	cluster, read, _, dbs := newTestCluster(t)
+	for i := 0; i < 4; i++ {
+		readFrom(t, context.Background(), cluster, read)
+	}
+	if counts := dbs.take(); counts["r1"] != 2 || counts["r2"] != 2 || counts["primary"] != 0 {
+		t.Errorf("reads %v, want two on each replica", counts)
+	}
+}
+
+func TestReadsFallBackToThePrimary(t *testing.T) {
+	cluster, read, _, dbs := newTestCluster(t)
+	_, restore := captureLog()
+	defer restore()
+
+	// a replica that cannot be reached answers no more reads, the one failed read is answered by the primary
+	cluster.Replicas()[0].DB.Close()
+	for i := 0; i < 4; i++ {
+		readFrom(t, context.Background(), cluster, read)
+	}
+	if cluster.Replicas()[0].Healthy() || !cluster.Replicas()[1].Healthy() {
+		t.Errorf("replicas healthy %v, %v, want r1 failed only", cluster.Replicas()[0].Healthy(), cluster.Replicas()[1].Healthy())
+	}
+	if counts := dbs.take(); counts["r2"] != 3 || counts["primary"] != 1 {
+		t.Errorf("reads %v, want r2 to take the reads of r1", counts)
+	}
+
+	cluster.Replicas()[1].DB.Close()
+	cluster.CheckReplicas(context.Background())
+	if cluster.Replicas()[1].Healthy() {
+		t.Error("CheckReplicas left an unreachable replica healthy")
+	}
+	readFrom(t, context.Background(), cluster, read)
+	if counts := dbs.take(); counts["primary"] != 1 {
+		t.Errorf("reads %v, want the primary to answer without healthy replicas", counts)
+	}
+}
+
+func TestRequestsReadTheirOwnWrites(t *testing.T) {
+	cluster, read, write, dbs := newTestCluster(t)
+	app := &App{}
+
+	tests := []struct {
+		name  string
+		serve func(ctx context.Context)
+		want  map[string]int
+	}{
+		{"reads only", func(ctx context.Context) {
+			readFrom(t, ctx, cluster, read)
+			readFrom(t, ctx, cluster, read)
+		}, map[string]int{"replicas": 2}},
+		{"read after a write", func(ctx context.Context) {
+			readFrom(t, ctx, cluster, read)
+			if _, err := write.exec(ctx, "Sofia", "d1"); err != nil {
+				t.Fatal(err)
+			}
+			readFrom(t, ctx, cluster, read)
+		}, map[string]int{"primary": 2, "replicas": 1}},
+		{"failed write", func(ctx context.Context) {
+			if _, err := write.exec(ctx, "Sofia", "broken"); err == nil {
+				t.Fatal("the write did not fail")
+			}
+			readFrom(t, ctx, cluster, read)
+		}, map[string]int{"primary": 1, "replicas": 1}},
+		{"read inside and after a unit of work", func(ctx context.Context) {
+			err := WithTx(ctx, cluster.Primary(), func(ctx context.Context) error {
+				readFrom(t, ctx, cluster, read)
+				_, err := write.exec(ctx, "Sofia", "d1")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			readFrom(t, ctx, cluster, read)
+		}, map[string]int{"primary": 3}},
+		// the window ends with the request
+		{"next request", func(ctx context.Context) {
+			readFrom(t, ctx, cluster, read)
+		}, map[string]int{"replicas": 1}},
+	}
+
+	for _, tt := range tests {
+		handler := app.withReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tt.serve(r.Context())
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		counts := dbs.take()
+		if counts["primary"] != tt.want["primary"] || counts["r1"]+counts["r2"] != tt.want["replicas"] {
+			t.Errorf("%s: statements %v, want %v", tt.name, counts, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+var errConnectionLost = errors.New("connection lost")
+
+func donorRow(id string, emailVerified driver.Value) []driver.Value {
+	regDate := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	return []driver.Value{id, "person-" + id, "Ivan", "Petrov", "+359888123456", "ivan@example.com",
+		nil, "male", "A+", "Sofia", regDate, emailVerified, nil, false, nil, true}
+}
+
+func acceptorRow(id string, urgent driver.Value) []driver.Value {
+	regDate := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	return []driver.Value{id, "person-" + id, "Maria", "Ivanova", "A+", "Sofia", "Sofia Blood Center", regDate, urgent}
+}
+
+func TestDonorsListingsReportBrokenRows(t *testing.T) {
+	columns := strings.Split(donorColumns, ", ")
+	tests := []struct {
+		name    string
+		result  sqlfake.Result
+		wantErr bool
+		count   int
+	}{
+		{"all rows", sqlfake.Rows(columns, donorRow("1", true), donorRow("2", false)), false, 2},
+		{"unreadable row", sqlfake.Rows(columns, donorRow("1", true), donorRow("2", "maybe")), true, 1},
+		{"interrupted rows", sqlfake.Result{Columns: columns, Rows: [][]driver.Value{donorRow("1", true)}, RowsErr: errConnectionLost}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+				return tt.result
+			})
+			defer db.Close()
+			repo, err := NewDonorsMySQL(NewDBCluster(db))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+
+			lists := map[string]func() ([]Donor, error){
+				"GetAll":          func() ([]Donor, error) { return repo.GetAll(context.Background()) },
+				"GetByBloodGroup": func() ([]Donor, error) { return repo.GetByBloodGroup(context.Background(), "A+") },
+			}
+			for method, list := range lists {
+				donors, err := list()
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s: err = %v, want error %v", method, err, tt.wantErr)
+				}
+				if len(donors) != tt.count {
+					t.Errorf("%s: got %d donors, want %d", method, len(donors), tt.count)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceptorsListingsReportBrokenRows(t *testing.T) {
+	columns := strings.Split(acceptorColumns, ", ")
+	tests := []struct {
+		name    string
+		result  sqlfake.Result
+		wantErr bool
+		count   int
+	}{
+		{"all rows", sqlfake.Rows(columns, acceptorRow("1", true), acceptorRow("2", false)), false, 2},
+		{"unreadable row", sqlfake.Rows(columns, acceptorRow("1", true), acceptorRow("2", "maybe")), true, 1},
+		{"interrupted rows", sqlfake.Result{Columns: columns, Rows: [][]driver.Value{acceptorRow("1", true)}, RowsErr: errConnectionLost}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+				return tt.result
+			})
+			defer db.Close()
+			repo, err := NewAcceptorsMySQL(NewDBCluster(db))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+
+			lists := map[string]func() ([]Acceptor, error){
+				"GetAll":          func() ([]Acceptor, error) { return repo.GetAll(context.Background()) },
+				"GetByBloodGroup": func() ([]Acceptor, error) { return repo.GetByBloodGroup(context.Background(), "A+") },
+			}
+			for method, list := range lists {
+				acceptors, err := list()
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s: err = %v, want error %v", method, err, tt.wantErr)
+				}
+				if len(acceptors) != tt.count {
+					t.Errorf("%s: got %d acceptors, want %d", method, len(acceptors), tt.count)
+				}
+			}
+		})
+	}
+}
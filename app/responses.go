@@ -0,0 +1,25 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// apiError is the body of every error response: {"error": {"status": 404, "message": "donor not found"}}
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]apiError{"error": {Status: status, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logError(context.Background(), "could not write response", err)
+	}
+}
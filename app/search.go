@@ -0,0 +1,383 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Types of the search results
+const (
+	SearchDonor    = "donor"
+	SearchAcceptor = "acceptor"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// minSearchScore drops results matching the query too loosely to be of use
+	minSearchScore = 0.5
+	// maxSearchCandidates bounds the donors and the acceptors read from the database to be scored
+	maxSearchCandidates = 500
+	// searchPrefixLength is how many letters of a query word the candidates must contain, few enough for
+	// a typo later in the word
+	searchPrefixLength = 2
+)
+
+// SearchResult is a donor or an acceptor found by a search, Score is between 0 and 1
+type SearchResult struct {
+	Type          string    `json:"type"`
+	ID            string    `json:"id"`
+	Score         float64   `json:"score"`
+	MatchedFields []string  `json:"matchedFields"`
+	Donor         *Donor    `json:"donor,omitempty"`
+	Acceptor      *Acceptor `json:"acceptor,omitempty"`
+}
+
+// searchField is a field of an account with the weight of a match in it
+type searchField struct {
+	name   string
+	value  string
+	weight float64
+}
+
+// cyrillicToLatin follows the Bulgarian streamlined system, with the Russian letters it lacks
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sht", 'ъ': "a", 'ь': "y", 'ю': "yu", 'я': "ya",
+	'ё': "yo", 'ы': "y", 'э': "e", 'ѝ': "i",
+}
+
+// latinToCyrillic spells the first letters of a Latin word in Cyrillic for looking up candidates, the
+// letters cyrillicToLatin writes the same way get the more common of them
+var latinToCyrillic = map[string]string{
+	"zh": "ж", "ch": "ч", "sh": "ш", "ts": "ц", "yu": "ю", "ya": "я", "yo": "ё",
+	"a": "а", "b": "б", "v": "в", "g": "г", "d": "д", "e": "е", "z": "з", "i": "и", "y": "й", "k": "к",
+	"l": "л", "m": "м", "n": "н", "o": "о", "p": "п", "r": "р", "s": "с", "t": "т", "u": "у", "f": "ф", "h": "х",
+}
+
+// searchWords folds the case of text and splits it into words of letters and digits
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTokens folds the case of text, writes Cyrillic in Latin letters and splits it into words,
+// so "Иван Петров" and "ivan.petrov@abv.bg" both contain "ivan" and "petrov"
+func searchTokens(text string) []string {
+	tokens := make([]string, 0)
+	for _, word := range searchWords(text) {
+		var latin strings.Builder
+		runes := []rune(word)
+		for i, r := range runes {
+			// a final "ия" is written "ia" as in Sofia
+			if r == 'и' && i == len(runes)-2 && runes[i+1] == 'я' {
+				latin.WriteString("ia")
+				break
+			}
+			if transliterated, ok := cyrillicToLatin[r]; ok {
+				latin.WriteString(transliterated)
+			} else {
+				latin.WriteRune(r)
+			}
+		}
+		tokens = append(tokens, latin.String())
+	}
+	return tokens
+}
+
+// otherAlphabet spells a word in Latin letters when it has Cyrillic ones and in Cyrillic otherwise
+func otherAlphabet(word string) string {
+	var spelled strings.Builder
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return searchTokens(word)[0]
+		}
+	}
+	for rest := word; rest != ""; {
+		if len(rest) >= 2 && latinToCyrillic[rest[:2]] != "" {
+			spelled.WriteString(latinToCyrillic[rest[:2]])
+			rest = rest[2:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		if cyrillic, ok := latinToCyrillic[string(r)]; ok {
+			spelled.WriteString(cyrillic)
+		} else {
+			spelled.WriteRune(r)
+		}
+		rest = rest[size:]
+	}
+	return spelled.String()
+}
+
+// escapeLike makes text match itself in a LIKE pattern
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+}
+
+// searchPatterns are the LIKE patterns the candidates of a query are read with: the digits of a phone
+// number, or the first letters of the longest word of the query and their spelling in the other alphabet.
+// Every word must match for a result, so the candidates hold every result unless the longest word has a
+// typo in its first letters.
+func searchPatterns(query string) (string, string) {
+	if isPhoneQuery(query) {
+		digits := "%" + strings.TrimLeft(digitsOf(query), "0") + "%"
+		return digits, digits
+	}
+
+	longest := ""
+	for _, word := range searchWords(query) {
+		if len([]rune(word)) > len([]rune(longest)) {
+			longest = word
+		}
+	}
+	// digits are matched whole, in phone numbers
+	prefix := []rune(longest)
+	if len(prefix) > searchPrefixLength && digitsOf(longest) != longest {
+		prefix = prefix[:searchPrefixLength]
+	}
+	return "%" + escapeLike(string(prefix)) + "%", "%" + escapeLike(otherAlphabet(string(prefix))) + "%"
+}
+
+// digitsOf keeps the digits of a phone number
+func digitsOf(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// isPhoneQuery tells a query made of a phone number, e.g. "+359 888 123 456", from one made of words
+func isPhoneQuery(query string) bool {
+	for _, r := range query {
+		if !unicode.IsDigit(r) && !strings.ContainsRune(" +-()/", r) {
+			return false
+		}
+	}
+	return len(digitsOf(query)) >= 3
+}
+
+// typosAllowed grows with the length of a word, short words must be spelled right
+func typosAllowed(word string) int {
+	switch length := len([]rune(word)); {
+	case length < 3:
+		return 0
+	case length < 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the optimal string alignment distance: insertions, deletions, substitutions and
+// swaps of neighbouring letters each count as one edit
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	rows := make([][]int, len(s)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(t)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d := min3(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && rows[i-2][j-2]+1 < d {
+				d = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(s)][len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// matchWord scores how well a query word matches a word of a field: 1 when equal, less for a prefix
+// or a word with typos, 0 when they do not match
+func matchWord(query, word string) float64 {
+	if query == word {
+		return 1
+	}
+	queryLength, wordLength := len([]rune(query)), len([]rune(word))
+	if queryLength >= 2 && strings.HasPrefix(word, query) {
+		return 0.8 + 0.2*float64(queryLength)/float64(wordLength)
+	}
+	if distance := editDistance(query, word); distance <= typosAllowed(query) {
+		longest := math.Max(float64(queryLength), float64(wordLength))
+		return 0.9 * (1 - float64(distance)/longest)
+	}
+	return 0
+}
+
+// scoreAccount matches every query word against the fields, all of them must match somewhere. The score
+// is the mean of the best match of each word, weighted by the field it matched in.
+func scoreAccount(query string, fields []searchField) (float64, []string) {
+	matched := make([]string, 0)
+	addMatched := func(name string) {
+		for _, existing := range matched {
+			if existing == name {
+				return
+			}
+		}
+		matched = append(matched, name)
+	}
+
+	if isPhoneQuery(query) {
+		digits := strings.TrimLeft(digitsOf(query), "0")
+		for _, field := range fields {
+			if field.name == "phone" && digits != "" && strings.Contains(digitsOf(field.value), digits) {
+				addMatched(field.name)
+				return field.weight, matched
+			}
+		}
+		return 0, matched
+	}
+
+	words := searchTokens(query)
+	if len(words) == 0 {
+		return 0, matched
+	}
+
+	total := 0.0
+	for _, word := range words {
+		best, bestField := 0.0, ""
+		for _, field := range fields {
+			if field.name == "phone" {
+				if len(word) >= 3 && digitsOf(word) == word && strings.Contains(digitsOf(field.value), word) && field.weight > best {
+					best, bestField = field.weight, field.name
+				}
+				continue
+			}
+			for _, candidate := range searchTokens(field.value) {
+				if score := matchWord(word, candidate) * field.weight; score > best {
+					best, bestField = score, field.name
+				}
+			}
+		}
+		if best == 0 {
+			return 0, matched
+		}
+		total += best
+		addMatched(bestField)
+	}
+	return total / float64(len(words)), matched
+}
+
+func donorSearchFields(donor Donor) []searchField {
+	return []searchField{
+		{"name", donor.FirstName, 1},
+		{"lastName", donor.LastName, 1},
+		{"email", donor.Email, 0.9},
+		{"phone", donor.PhoneNumber, 1},
+		{"city", donor.City, 0.7},
+	}
+}
+
+func acceptorSearchFields(acceptor Acceptor) []searchField {
+	return []searchField{
+		{"name", acceptor.FirstName, 1},
+		{"lastName", acceptor.LastName, 1},
+		{"city", acceptor.City, 0.7},
+		{"bloodCenter", acceptor.BloodCenter, 0.6},
+	}
+}
+
+// rankAccounts scores every donor and acceptor against the query, best matches first
+func rankAccounts(query string, donors []Donor, acceptors []Acceptor, limit int) []SearchResult {
+	results := make([]SearchResult, 0)
+	for i := range donors {
+		if score, matched := scoreAccount(query, donorSearchFields(donors[i])); score >= minSearchScore {
+			results = append(results, SearchResult{Type: SearchDonor, ID: donors[i].ID, Score: roundScore(score), MatchedFields: matched, Donor: &donors[i]})
+		}
+	}
+	for i := range acceptors {
+		if score, matched := scoreAccount(query, acceptorSearchFields(acceptors[i])); score >= minSearchScore {
+			results = append(results, SearchResult{Type: SearchAcceptor, ID: acceptors[i].ID, Score: roundScore(score), MatchedFields: matched, Acceptor: &acceptors[i]})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Type != results[j].Type {
+			return results[i].Type == SearchDonor
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// searchAccounts finds donors and acceptors by name, e-mail, phone or city. The candidates containing
+// the searchPatterns are read from the replicas and scored in memory. Anonymous callers neither see nor
+// find the e-mail addresses and phone numbers of the donors.
+func (app *App) searchAccounts(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(query)) < 2 {
+		writeError(w, http.StatusBadRequest, "q must have at least 2 characters")
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	pattern, spelling := searchPatterns(query)
+	donors, err := app.DonorsRepo.GetMatching(r.Context(), pattern, spelling, maxSearchCandidates)
+	if err != nil {
+		logError(r.Context(), "could not load donors", err)
+		writeError(w, http.StatusInternalServerError, "could not search accounts")
+		return
+	}
+	acceptors, err := app.AcceptorsRepo.GetMatching(r.Context(), pattern, spelling, maxSearchCandidates)
+	if err != nil {
+		logError(r.Context(), "could not load acceptors", err)
+		writeError(w, http.StatusInternalServerError, "could not search accounts")
+		return
+	}
+	if !app.authenticated(r) {
+		for i := range donors {
+			donors[i].Email, donors[i].PhoneNumber = "", ""
+		}
+	}
+
+	writeJSON(w, http.StatusOK, rankAccounts(query, donors, acceptors, limit))
+}
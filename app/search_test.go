@@ -0,0 +1,190 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"ivan", "ivan", 0},
+		{"ivan", "", 4},
+		{"ivan", "iva", 1},
+		{"ivan", "ivann", 1},
+		{"ivan", "ivam", 1},
+		// a swap of neighbouring letters is one edit
+		{"ivan", "ivna", 1},
+		{"petrov", "petorv", 1},
+		{"ab", "ba", 1},
+		{"ivan", "ivanov", 2},
+		{"kitten", "sitting", 3},
+		// no letter is edited twice, unlike the Damerau-Levenshtein distance of 2
+		{"ca", "abc", 3},
+		// letters, not bytes
+		{"иван", "ивн", 1},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Иван Петров", []string{"ivan", "petrov"}},
+		{"ivan.petrov@abv.bg", []string{"ivan", "petrov", "abv", "bg"}},
+		{"ЦВЕТАНА Жечева", []string{"tsvetana", "zhecheva"}},
+		{"Щерю Юлиянов", []string{"shteryu", "yuliyanov"}},
+		// a final "ия" is "ia"
+		{"София, Мария", []string{"sofia", "maria"}},
+		{"Ъглен Ѝ", []string{"aglen", "i"}},
+		{"+359 888-123", []string{"359", "888", "123"}},
+		{" - ", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := searchTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPatterns(t *testing.T) {
+	tests := []struct {
+		query    string
+		pattern  string
+		spelling string
+	}{
+		{"Ivan Petrov", "%pe%", "%пе%"},
+		{"Петров", "%пе%", "%pe%"},
+		{"zhecho", "%zh%", "%ж%"},
+		{"Жечо", "%же%", "%zhe%"},
+		{"yulia", "%yu%", "%ю%"},
+		{"0888 123 456", "%888123456%", "%888123456%"},
+		{"+359888123456", "%359888123456%", "%359888123456%"},
+		// digits among words are matched whole
+		{"ivan 0888123", "%0888123%", "%0888123%"},
+		{"iv", "%iv%", "%ив%"},
+	}
+
+	for _, tt := range tests {
+		pattern, spelling := searchPatterns(tt.query)
+		if pattern != tt.pattern || spelling != tt.spelling {
+			t.Errorf("searchPatterns(%q) = %q, %q, want %q, %q", tt.query, pattern, spelling, tt.pattern, tt.spelling)
+		}
+	}
+
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("escapeLike = %s", got)
+	}
+}
+
+// newSearchApp serves the search from an in-memory database, with the access token "s1.secret" of donor d1
+func newSearchApp(t *testing.T) (*App, *sqlfake.Recorder) {
+	t.Helper()
+	store := sqlfake.NewStore()
+	registered := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	persons := []string{"id", "name", "lastName", "phone", "email", "createdAt"}
+	donors := []string{"id", "personId", "dateOfBirth", "gender", "bloodGroup", "city", "regDate", "emailVerified", "emailVerifiedAt", "phoneVerified", "phoneVerifiedAt", "notificationsOptIn"}
+	acceptors := []string{"id", "personId", "bloodGroup", "city", "bloodCenter", "regDate", "urgent"}
+	store.Insert("persons", persons, "p1", "Ivan", "Petrov", "+359888123456", "ivan.petrov@abv.bg", registered)
+	store.Insert("donors", donors, "d1", "p1", "1990-04-12", "male", "A+", "Sofia", registered, true, registered, true, registered, true)
+	store.Insert("persons", persons, "p2", "Мария", "Иванова", "", "", registered)
+	store.Insert("acceptors", acceptors, "a1", "p2", "B+", "Пловдив", "Пловдив", registered, false)
+	store.Insert("persons", persons, "p3", "Georgi", "Georgiev", "+359877000111", "georgi@example.com", registered)
+	store.Insert("donors", donors, "d3", "p3", "1985-01-30", "male", "0-", "Varna", registered, false, nil, false, nil, true)
+
+	recorder := &sqlfake.Recorder{}
+	cluster := NewDBCluster(sqlfake.Open(recorder.Record(store.Handle)))
+	donorsRepo, err := NewDonorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptorsRepo, err := NewAcceptorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &App{DonorsRepo: donorsRepo, AcceptorsRepo: acceptorsRepo, Authenticator: newTestAuthenticator(t)}, recorder
+}
+
+func TestSearchAccounts(t *testing.T) {
+	app, recorder := newSearchApp(t)
+
+	tests := []struct {
+		name  string
+		query string
+		token string
+		ids   []string
+		email string
+		phone string
+	}{
+		{"anonymous", "petrov", "", []string{"d1"}, "", ""},
+		{"donor", "petrov", "s1.secret", []string{"d1"}, "ivan.petrov@abv.bg", "+359888123456"},
+		{"typo", "petorv", "s1.secret", []string{"d1"}, "ivan.petrov@abv.bg", "+359888123456"},
+		{"cyrillic query", "Петров", "", []string{"d1"}, "", ""},
+		{"latin query", "maria ivanova", "", []string{"a1"}, "", ""},
+		{"phone", "0888 123 456", "s1.secret", []string{"d1"}, "ivan.petrov@abv.bg", "+359888123456"},
+		{"anonymous phone", "0888 123 456", "", []string{}, "", ""},
+		{"anonymous e-mail", "abv", "", []string{}, "", ""},
+		{"no match", "zzz", "s1.secret", []string{}, "", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/accounts/search?q="+url.QueryEscape(tt.query), nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		app.searchAccounts(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, w.Code, w.Body)
+		}
+
+		var results []SearchResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0)
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: found %v, want %v", tt.name, ids, tt.ids)
+			continue
+		}
+		if len(results) == 1 && results[0].Donor != nil && (results[0].Donor.Email != tt.email || results[0].Donor.PhoneNumber != tt.phone) {
+			t.Errorf("%s: contact %q, %q, want %q, %q", tt.name, results[0].Donor.Email, results[0].Donor.PhoneNumber, tt.email, tt.phone)
+		}
+	}
+
+	// only the candidates are read, never every account
+	reads := recorder.Matching("FROM donors")
+	if len(reads) == 0 {
+		t.Fatal("no donors were read")
+	}
+	for _, statement := range reads {
+		if last := statement.Args[len(statement.Args)-1]; last != driver.Value(int64(maxSearchCandidates)) {
+			t.Errorf("%s read with %v, want LIMIT %d", statement.Query, statement.Args, maxSearchCandidates)
+		}
+	}
+}
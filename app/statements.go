@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDuplicateEntry is the MySQL error number of a row repeating a unique key
+const mysqlDuplicateEntry = 1062
+
+// isDuplicateKey reports whether MySQL refused a write because another row has the same unique key
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry
+}
+
+// stmt is a statement prepared once on the primary, the text is kept for tracing and for reads sent to replicas
+type stmt struct {
+	prepared *sql.Stmt
+	text     string
+}
+
+// statements prepares the statements of a repository when it is created and closes them with it.
+// The first error is kept, so a constructor prepares everything and checks once.
+type statements struct {
+	db       *sql.DB
+	prepared []*stmt
+	err      error
+}
+
+func newStatements(db *sql.DB) *statements {
+	return &statements{db: db}
+}
+
+// prepare the query, nothing is prepared anymore once a statement failed
+func (s *statements) prepare(query string) *stmt {
+	if s.err != nil {
+		return nil
+	}
+
+	prepared, err := s.db.Prepare(query)
+	if err != nil {
+		s.err = fmt.Errorf("preparing %q: %s", strings.Join(strings.Fields(query), " "), err.Error())
+		return nil
+	}
+	statement := &stmt{prepared: prepared, text: query}
+	s.prepared = append(s.prepared, statement)
+	return statement
+}
+
+// check reports the first failed statement, closing the ones already prepared
+func (s *statements) check() error {
+	if s.err != nil {
+		s.Close()
+	}
+	return s.err
+}
+
+// Close the prepared statements, releasing them on the server
+func (s *statements) Close() error {
+	var err error
+	for _, statement := range s.prepared {
+		if closeErr := statement.prepared.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.prepared = nil
+	return err
+}
+
+// in binds the statement to the transaction of the unit of work in ctx, if there is one
+func (s *stmt) in(ctx context.Context) *sql.Stmt {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.StmtContext(ctx, s.prepared)
+	}
+	return s.prepared
+}
+
+// exec runs the statement in a span. A write outside a unit of work marks the request as written at once,
+// one inside is marked by WithTx when it commits.
+func (s *stmt) exec(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	ctx, span := startSQLSpan(ctx, s.text)
+	defer span.End()
+
+	result, err := s.in(ctx).ExecContext(ctx, args...)
+	span.SetError(err)
+	if err == nil && txFromContext(ctx) == nil {
+		markWritten(ctx)
+	}
+	return result, err
+}
+
+// query runs the statement in a span, the span covers the query but not the reading of the rows
+func (s *stmt) query(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startSQLSpan(ctx, s.text)
+	defer span.End()
+
+	rows, err := s.in(ctx).QueryContext(ctx, args...)
+	span.SetError(err)
+	return rows, err
+}
+
+// queryRow runs the statement in a span, errors only surface on Scan and are not recorded
+func (s *stmt) queryRow(ctx context.Context, args ...interface{}) *sql.Row {
+	ctx, span := startSQLSpan(ctx, s.text)
+	defer span.End()
+
+	return s.in(ctx).QueryRowContext(ctx, args...)
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C trace context headers, see https://www.w3.org/TR/trace-context/
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Span kinds
+const (
+	SpanServer   = "server"
+	SpanClient   = "client"
+	SpanInternal = "internal"
+)
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID    string
+	SpanID     string
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether the IDs are well formed and not all zeros
+func (sc SpanContext) IsValid() bool {
+	return isHexID(sc.TraceID, 32) && isHexID(sc.SpanID, 16)
+}
+
+// Traceparent renders the traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads a traceparent header value, only version 00 fields are used
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || !isHex(parts[3], 2) {
+		return SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{TraceID: parts[1], SpanID: parts[2], Sampled: flags[0]&1 == 1}
+	return sc, sc.IsValid()
+}
+
+func isHexID(id string, length int) bool {
+	return isHex(id, length) && strings.Trim(id, "0") != ""
+}
+
+// isHex reports whether value is length lower case hex digits, as the header fields are written
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, c := range value {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Service      string                 `json:"service"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMs   float64                `json:"durationMs"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// SpanExporter receives every finished, sampled span
+type SpanExporter interface {
+	ExportSpan(span SpanData) error
+}
+
+// Span is an operation being timed
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	data    SpanData
+	mu      sync.Mutex
+	ended   bool
+}
+
+// Context is the span context to propagate to children and other services
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetAttribute records a value describing the operation
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed, sql.ErrNoRows is an answer rather than a failure
+func (s *Span) SetError(err error) {
+	if err == nil || err == sql.ErrNoRows {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = "error"
+	s.data.Error = redactText(err.Error())
+}
+
+// End finishes the span and exports it, later calls do nothing
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.DurationMs = float64(s.data.End.Sub(s.data.Start).Microseconds()) / 1000
+	data := s.data
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+// Tracer starts spans and hands the finished ones to its exporter
+type Tracer struct {
+	service  string
+	exporter SpanExporter
+}
+
+// NewTracer creates a tracer for the service, a nil exporter drops the spans but still propagates the context
+func NewTracer(service string, exporter SpanExporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// DefaultTracer is used by the whole service
+var DefaultTracer = NewTracer("accounts-service", nil)
+
+// Start begins a span, a child of the span in ctx if there is one
+func (t *Tracer) Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	return t.start(ctx, name, kind, parent)
+}
+
+// StartRemote begins a span continuing the trace of another service
+func (t *Tracer) StartRemote(ctx context.Context, name, kind string, remote SpanContext) (context.Context, *Span) {
+	return t.start(ctx, name, kind, remote)
+}
+
+func (t *Tracer) start(ctx context.Context, name, kind string, parent SpanContext) (context.Context, *Span) {
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: randomHex(8), Sampled: true}
+	parentSpanID := ""
+	if parent.IsValid() {
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+		parentSpanID = parent.SpanID
+	} else {
+		sc.TraceID = randomHex(16)
+	}
+
+	span := &Span{
+		tracer:  t,
+		context: sc,
+		data: SpanData{
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parentSpanID,
+			Name:         name,
+			Kind:         kind,
+			Service:      t.service,
+			Start:        time.Now(),
+			Status:       "ok",
+		},
+	}
+	return ContextWithSpan(ctx, sc), span
+}
+
+func (t *Tracer) export(span SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	if err := t.exporter.ExportSpan(span); err != nil {
+		DefaultLogger.Warn(context.Background(), "exporting span failed", Fields{"error": err})
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a context whose spans and outgoing requests continue sc
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanFromContext is the current span context, invalid when ctx is not traced
+func SpanFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// InjectTraceHeaders adds the trace context of ctx to an outgoing request
+func InjectTraceHeaders(ctx context.Context, header http.Header) {
+	sc := SpanFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// extractTraceHeaders reads the trace context sent by the caller, if any
+func extractTraceHeaders(header http.Header) SpanContext {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return SpanContext{}
+	}
+	sc.TraceState = header.Get(TracestateHeader)
+	return sc
+}
+
+// withTracing continues the trace of the caller with a server span per request
+func (app *App) withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		ctx, span := DefaultTracer.StartRemote(r.Context(), r.Method+" "+route, SpanServer, extractTraceHeaders(r.Header))
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.SetError(fmt.Errorf("answered %d", recorder.status))
+		}
+	})
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// startSQLSpan traces a statement, the bound values are left out as they hold personal data
+func startSQLSpan(ctx context.Context, query string) (context.Context, *Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+	operation := statement
+	if i := strings.IndexByte(statement, ' '); i > 0 {
+		operation = statement[:i]
+	}
+
+	ctx, span := DefaultTracer.Start(ctx, "sql "+strings.ToUpper(operation), SpanClient)
+	span.SetAttribute("db.system", "mysql")
+	span.SetAttribute("db.statement", statement)
+	return ctx, span
+}
+
+// querySQL runs QueryContext in a span, the span covers the query but not the reading of the rows
+func querySQL(ctx context.Context, db sqlQueryer, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startSQLSpan(ctx, query)
+	defer span.End()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	span.SetError(err)
+	return rows, err
+}
+
+func randomHex(bytes int) string {
+	id := make([]byte, bytes)
+	for {
+		if _, err := rand.Read(id); err != nil {
+			panic(err)
+		}
+		if encoded := hex.EncodeToString(id); strings.Trim(encoded, "0") != "" {
+			return encoded
+		}
+	}
+}
+
+// WriterExporter writes every span as a JSON line, to stdout or a file, and works without any collector
+type WriterExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterExporter creates an exporter writing to out
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	return &WriterExporter{out: out}
+}
+
+// NewFileExporter creates an exporter appending to the file at path
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterExporter(file), nil
+}
+
+// ExportSpan writes the span
+func (e *WriterExporter) ExportSpan(span SpanData) error {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file, if the exporter writes to one
+func (e *WriterExporter) Close() error {
+	if closer, ok := e.out.(io.Closer); ok && e.out != os.Stdout && e.out != os.Stderr {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03 ", true, true},
+		// later versions may append fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"0G-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false, false},
+	}
+
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if sc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID != "00f067aa0ba902b7" || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v", tt.value, sc)
+		}
+	}
+}
+
+func TestInjectTraceHeaders(t *testing.T) {
+	header := http.Header{}
+	InjectTraceHeaders(context.Background(), header)
+	if len(header) != 0 {
+		t.Errorf("untraced context injected %v", header)
+	}
+
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true, TraceState: "lifeblood=accounts"}
+	InjectTraceHeaders(ContextWithSpan(context.Background(), sc), header)
+	if header.Get(TraceparentHeader) != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" || header.Get(TracestateHeader) != "lifeblood=accounts" {
+		t.Errorf("injected %v", header)
+	}
+	if extracted := extractTraceHeaders(header); extracted != sc {
+		t.Errorf("extracted %+v, want %+v", extracted, sc)
+	}
+}
+
+func TestWebhookPostCarriesTrace(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	parent := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	ctx, span := NewTracer("test", nil).StartRemote(context.Background(), "deliver", SpanInternal, parent)
+	defer span.End()
+
+	worker := &WebhookWorker{client: server.Client()}
+	subscription := WebhookSubscription{URL: server.URL, Secret: "secret"}
+	if _, err := worker.post(ctx, subscription, WebhookDelivery{ID: "w1", Payload: []byte(`{}`)}, span.data.Start); err != nil {
+		t.Fatal(err)
+	}
+	if got := received.Get(TraceparentHeader); got != span.Context().Traceparent() {
+		t.Errorf("traceparent %q, want %q", got, span.Context().Traceparent())
+	}
+}
+
+// recordSpans makes DefaultTracer export to the returned buffer until restore is called
+func recordSpans() (spans *bytes.Buffer, restore func()) {
+	previous := DefaultTracer
+	spans = &bytes.Buffer{}
+	DefaultTracer = NewTracer("test", NewWriterExporter(spans))
+	return spans, func() { DefaultTracer = previous }
+}
+
+func decodeSpans(t *testing.T, spans *bytes.Buffer) []SpanData {
+	t.Helper()
+	decoded := make([]SpanData, 0)
+	for _, line := range strings.Split(strings.TrimSpace(spans.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var span SpanData
+		if err := json.Unmarshal([]byte(line), &span); err != nil {
+			t.Fatalf("span %s: %v", line, err)
+		}
+		decoded = append(decoded, span)
+	}
+	return decoded
+}
+
+func TestSQLSpans(t *testing.T) {
+	spans, restore := recordSpans()
+	defer restore()
+
+	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		if strings.HasPrefix(query, "SELECT") {
+			return sqlfake.Error(errors.New("connection lost"))
+		}
+		return sqlfake.Result{}
+	})
+	defer db.Close()
+	statements := newStatements(db)
+	update := statements.prepare("UPDATE persons SET email=?\n\tWHERE id=?")
+	if err := statements.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := DefaultTracer.Start(context.Background(), "PUT /accounts/donors/{id}", SpanServer)
+	if _, err := update.exec(ctx, "ivan@example.com", "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := querySQL(ctx, db, "SELECT id FROM persons WHERE email=?", "ivan@example.com"); err == nil {
+		t.Fatal("querySQL did not fail")
+	}
+	parent.End()
+
+	if strings.Contains(spans.String(), "ivan@example.com") {
+		t.Errorf("spans hold a bound value: %s", spans)
+	}
+	exported := decodeSpans(t, spans)
+	if len(exported) != 3 {
+		t.Fatalf("%d spans exported, want 3", len(exported))
+	}
+	updateSpan, selectSpan := exported[0], exported[1]
+	if updateSpan.Name != "sql UPDATE" || updateSpan.Kind != SpanClient || updateSpan.Status != "ok" ||
+		updateSpan.Attributes["db.statement"] != "UPDATE persons SET email=? WHERE id=?" || updateSpan.Attributes["db.system"] != "mysql" {
+		t.Errorf("update span %+v", updateSpan)
+	}
+	if selectSpan.Name != "sql SELECT" || selectSpan.Status != "error" || selectSpan.Error == "" {
+		t.Errorf("failed select span %+v", selectSpan)
+	}
+	for _, span := range exported[:2] {
+		if span.TraceID != parent.Context().TraceID || span.ParentSpanID != parent.Context().SpanID {
+			t.Errorf("span %s is not a child of the request span", span.Name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithTx runs fn as one unit of work: repository calls made with the context passed to fn join a single
+// transaction, committed when fn returns nil and rolled back otherwise. A WithTx inside another joins the outer one,
+// and database/sql rolls the transaction back if ctx is cancelled before the commit.
+func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			logError(ctx, "could not roll back transaction", rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	markWritten(ctx)
+	return nil
+}
+
+type txKey struct{}
+
+func txFromContext(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+// conn is the transaction of the unit of work in ctx, or db outside of one
+func conn(ctx context.Context, db *sql.DB) sqlQueryer {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid is returned for malformed tokens or tokens with a bad signature
+	ErrTokenInvalid = errors.New("verification token is invalid")
+	// ErrTokenExpired is returned for well-formed tokens past their expiry
+	ErrTokenExpired = errors.New("verification token has expired")
+)
+
+// EmailClaims is the content of an e-mail verification token
+type EmailClaims struct {
+	DonorID   string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// EmailVerifier issues and checks signed, expiring e-mail verification tokens
+type EmailVerifier struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewEmailVerifier creates a verifier signing tokens with secret, valid for ttl
+func NewEmailVerifier(secret []byte, ttl time.Duration) *EmailVerifier {
+	return &EmailVerifier{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// NewToken issues a token binding the donor to the e-mail address it was sent to
+func (v *EmailVerifier) NewToken(donorID, email string) string {
+	expiresAt := v.now().Add(v.ttl).Unix()
+	payload := strings.Join([]string{donorID, email, strconv.FormatInt(expiresAt, 10)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encoded + "." + v.sign(encoded)
+}
+
+// ParseToken checks the signature and expiry of a token and returns its claims
+func (v *EmailVerifier) ParseToken(token string) (EmailClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return EmailClaims{}, ErrTokenInvalid
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(v.sign(parts[0]))) {
+		return EmailClaims{}, ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return EmailClaims{}, ErrTokenInvalid
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 3 {
+		return EmailClaims{}, ErrTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return EmailClaims{}, ErrTokenInvalid
+	}
+
+	claims := EmailClaims{
+		DonorID:   fields[0],
+		Email:     fields[1],
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}
+	if v.now().After(claims.ExpiresAt) {
+		return claims, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+func (v *EmailVerifier) sign(payload string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lithammer/shortuuid"
+)
+
+// webhookRequest is the body accepted when creating or updating a subscription
+type webhookRequest struct {
+	URL          *string   `json:"url"`
+	Secret       *string   `json:"secret"`
+	EventTypes   *[]string `json:"eventTypes"`
+	BloodCenters *[]string `json:"bloodCenters"`
+	Active       *bool     `json:"active"`
+}
+
+// requireAdmin only lets through requests carrying the ADMIN_TOKEN as bearer token
+func (app *App) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAdmin(r) {
+			writeError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether the request carries the ADMIN_TOKEN as bearer token
+func (app *App) isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return app.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(app.AdminToken)) == 1
+}
+
+func (app *App) getWebhooks(w http.ResponseWriter, r *http.Request) {
+
+	subscriptions, err := app.WebhooksRepo.GetSubscriptions(r.Context())
+	if err != nil {
+		logError(r.Context(), "could not load webhook subscriptions", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhook subscriptions")
+		return
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+func (app *App) addWebhook(w http.ResponseWriter, r *http.Request) {
+
+	req := webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body is not valid JSON")
+		return
+	}
+
+	subscription := WebhookSubscription{
+		ID:           shortuuid.New(),
+		EventTypes:   []string{},
+		BloodCenters: []string{},
+		Active:       true,
+		CreatedAt:    formatTimestamp(time.Now()),
+	}
+	if !applyWebhookRequest(&subscription, req) {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http(s) URL and eventTypes must be known event types")
+		return
+	}
+	if subscription.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			logError(r.Context(), "could not create webhook subscription", err)
+			writeError(w, http.StatusInternalServerError, "could not create webhook subscription")
+			return
+		}
+		subscription.Secret = secret
+	}
+
+	if err := app.WebhooksRepo.CreateSubscription(r.Context(), subscription); err != nil {
+		logError(r.Context(), "could not create webhook subscription", err)
+		writeError(w, http.StatusInternalServerError, "could not create webhook subscription")
+		return
+	}
+
+	// the secret is only ever shown in the response to its creation
+	w.Header().Set("Location", "/admin/webhooks/"+subscription.ID)
+	writeJSON(w, http.StatusCreated, subscription)
+}
+
+func (app *App) getWebhookByID(w http.ResponseWriter, r *http.Request) {
+
+	subscription, ok := app.loadWebhook(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+func (app *App) updateWebhookByID(w http.ResponseWriter, r *http.Request) {
+
+	subscription, ok := app.loadWebhook(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	req := webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !applyWebhookRequest(&subscription, req) {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http(s) URL and eventTypes must be known event types")
+		return
+	}
+
+	if err := app.WebhooksRepo.UpdateSubscription(r.Context(), subscription); err != nil {
+		logError(r.Context(), "could not update webhook subscription", err)
+		writeError(w, http.StatusInternalServerError, "could not update webhook subscription")
+		return
+	}
+
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+func (app *App) deleteWebhookByID(w http.ResponseWriter, r *http.Request) {
+
+	if _, ok := app.loadWebhook(w, r, mux.Vars(r)["id"]); !ok {
+		return
+	}
+
+	if err := app.WebhooksRepo.DeleteSubscription(r.Context(), mux.Vars(r)["id"]); err != nil {
+		logError(r.Context(), "could not delete webhook subscription", err)
+		writeError(w, http.StatusInternalServerError, "could not delete webhook subscription")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *App) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+
+	if _, ok := app.loadWebhook(w, r, mux.Vars(r)["id"]); !ok {
+		return
+	}
+
+	deliveries, err := app.WebhooksRepo.GetDeliveriesBySubscription(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		logError(r.Context(), "could not load webhook deliveries", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhook deliveries")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (app *App) getWebhookDeliveryByID(w http.ResponseWriter, r *http.Request) {
+
+	delivery, err := app.WebhooksRepo.GetDeliveryByID(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "webhook delivery not found")
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load webhook delivery", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhook delivery")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func (app *App) retryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+
+	delivery, err := app.WebhooksRepo.GetDeliveryByID(r.Context(), mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "webhook delivery not found")
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "could not load webhook delivery", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhook delivery")
+		return
+	}
+	if delivery.Status != WebhookDead {
+		writeError(w, http.StatusConflict, "only dead-lettered deliveries can be retried")
+		return
+	}
+
+	if err := app.WebhooksRepo.Requeue(r.Context(), delivery.ID, time.Now().Unix()); err != nil {
+		logError(r.Context(), "could not retry webhook delivery", err)
+		writeError(w, http.StatusInternalServerError, "could not retry webhook delivery")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// loadWebhook fetches a subscription, answering 404 or 500 itself when that fails
+func (app *App) loadWebhook(w http.ResponseWriter, r *http.Request, id string) (WebhookSubscription, bool) {
+	subscription, err := app.WebhooksRepo.GetSubscriptionByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "webhook subscription not found")
+		return subscription, false
+	}
+	if err != nil {
+		logError(r.Context(), "could not load webhook subscription", err)
+		writeError(w, http.StatusInternalServerError, "could not load webhook subscription")
+		return subscription, false
+	}
+	return subscription, true
+}
+
+// applyWebhookRequest copies the given fields onto the subscription and reports whether the result is valid
+func applyWebhookRequest(subscription *WebhookSubscription, req webhookRequest) bool {
+	if req.URL != nil {
+		subscription.URL = *req.URL
+	}
+	if req.Secret != nil {
+		subscription.Secret = *req.Secret
+	}
+	if req.EventTypes != nil {
+		subscription.EventTypes = *req.EventTypes
+	}
+	if req.BloodCenters != nil {
+		subscription.BloodCenters = *req.BloodCenters
+	}
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return false
+	}
+	for _, eventType := range subscription.EventTypes {
+		if !isEventType(eventType) {
+			return false
+		}
+	}
+	return true
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,313 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+)
+
+// Headers sent with every webhook request
+const (
+	WebhookEventHeader     = "X-LifeBlood-Event"
+	WebhookDeliveryHeader  = "X-LifeBlood-Delivery"
+	WebhookTimestampHeader = "X-LifeBlood-Timestamp"
+	// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>"
+	WebhookSignatureHeader = "X-LifeBlood-Signature"
+)
+
+// SignWebhook computes the signature header value for a request body sent at timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher is a Publisher queueing a delivery for every subscription matching an event
+type WebhookDispatcher struct {
+	repo *WebhooksMySQL
+}
+
+// NewWebhookDispatcher creates a dispatcher storing deliveries in repo
+func NewWebhookDispatcher(repo *WebhooksMySQL) *WebhookDispatcher {
+	return &WebhookDispatcher{repo: repo}
+}
+
+// Publish queues the event for the matching subscriptions, an event published twice is queued once
+func (d *WebhookDispatcher) Publish(ctx context.Context, event Event) error {
+	subscriptions, err := d.repo.GetSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.Active || !subscription.matches(event) {
+			continue
+		}
+
+		err := d.repo.EnqueueDelivery(ctx, WebhookDelivery{
+			ID:             shortuuid.New(),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         WebhookPending,
+			NextAttemptAt:  time.Now().Unix(),
+			CreatedAt:      formatTimestamp(time.Now()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches applies the event type and blood center filters of the subscription
+func (s WebhookSubscription) matches(event Event) bool {
+	if len(s.EventTypes) > 0 && !containsString(s.EventTypes, event.Type) {
+		return false
+	}
+	if len(s.BloodCenters) == 0 {
+		return true
+	}
+
+	var payload struct {
+		BloodCenter string `json:"bloodCenter"`
+	}
+	if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.BloodCenter == "" {
+		return false
+	}
+	return containsString(s.BloodCenters, payload.BloodCenter)
+}
+
+// WebhookWorker posts queued deliveries, retrying failures with exponential backoff
+// until maxAttempts is reached and the delivery is dead-lettered.
+type WebhookWorker struct {
+	repo        *WebhooksMySQL
+	client      *http.Client
+	interval    time.Duration
+	timeout     time.Duration
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	running  int32
+}
+
+// NewWebhookWorker creates a worker polling for due deliveries every interval
+func NewWebhookWorker(repo *WebhooksMySQL, interval time.Duration, maxAttempts int) *WebhookWorker {
+	return &WebhookWorker{
+		repo:        repo,
+		client:      &http.Client{},
+		interval:    interval,
+		timeout:     10 * time.Second,
+		maxAttempts: maxAttempts,
+		baseBackoff: 30 * time.Second,
+		maxBackoff:  6 * time.Hour,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs the worker in the background until Stop is called
+func (w *WebhookWorker) Start() {
+	atomic.StoreInt32(&w.running, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(w.done)
+		defer atomic.StoreInt32(&w.running, 0)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := w.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+				logError(ctx, "webhook worker failed", err)
+			}
+
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels the current batch and waits for the worker to finish, interrupted deliveries stay due
+func (w *WebhookWorker) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// Running reports whether the loop started by Start is still going
+func (w *WebhookWorker) Running() bool {
+	return atomic.LoadInt32(&w.running) == 1
+}
+
+// DeliverDue makes one attempt for every delivery that is due until ctx is done. A delivery that cannot be
+// attempted is logged and left to the next round, it does not hold up the others.
+func (w *WebhookWorker) DeliverDue(ctx context.Context) error {
+	deliveries, err := w.repo.GetDueDeliveries(ctx, w.now().Unix(), 50)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.deliver(ctx, delivery); err != nil && ctx.Err() == nil {
+			logWarn(ctx, "webhook delivery failed", Fields{"deliveryId": delivery.ID, "error": err})
+		}
+	}
+
+	return ctx.Err()
+}
+
+// deliver attempts a delivery to its subscription. Deliveries of removed or inactive subscriptions are
+// dead-lettered without an attempt, they can be requeued once the subscription is active again.
+func (w *WebhookWorker) deliver(ctx context.Context, delivery WebhookDelivery) error {
+	subscription, err := w.repo.GetSubscriptionByID(ctx, delivery.SubscriptionID)
+	switch {
+	case err == sql.ErrNoRows:
+		return w.deadLetter(ctx, delivery, "subscription no longer exists")
+	case err != nil:
+		return err
+	case !subscription.Active:
+		return w.deadLetter(ctx, delivery, "subscription is inactive")
+	}
+	return w.attempt(ctx, subscription, delivery)
+}
+
+func (w *WebhookWorker) deadLetter(ctx context.Context, delivery WebhookDelivery, reason string) error {
+	delivery.Status = WebhookDead
+	delivery.LastError = reason
+	logWarn(ctx, "webhook delivery dead-lettered", Fields{"deliveryId": delivery.ID, "attempts": delivery.Attempts, "error": reason})
+	return w.repo.UpdateDelivery(ctx, delivery)
+}
+
+// attempt posts the delivery once and records the outcome. A post interrupted by ctx is not recorded,
+// the delivery stays due and is attempted again after the next start.
+func (w *WebhookWorker) attempt(ctx context.Context, subscription WebhookSubscription, delivery WebhookDelivery) error {
+	started := w.now()
+	postCtx, span := DefaultTracer.Start(ctx, "POST webhook", SpanClient)
+	span.SetAttribute("webhook.event", delivery.EventType)
+	statusCode, err := w.post(postCtx, subscription, delivery, started)
+	span.SetAttribute("http.status_code", statusCode)
+	span.SetError(err)
+	span.End()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	attempt := WebhookAttempt{
+		ID:          shortuuid.New(),
+		DeliveryID:  delivery.ID,
+		AttemptedAt: formatTimestamp(started),
+		StatusCode:  statusCode,
+		DurationMs:  int64(w.now().Sub(started) / time.Millisecond),
+	}
+
+	delivery.Attempts++
+	if err == nil {
+		delivery.Status = WebhookSucceeded
+		delivery.LastError = ""
+	} else {
+		attempt.Error = err.Error()
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= w.maxAttempts {
+			delivery.Status = WebhookDead
+			logWarn(ctx, "webhook delivery dead-lettered", Fields{"deliveryId": delivery.ID, "attempts": delivery.Attempts, "error": err})
+		} else {
+			delivery.NextAttemptAt = started.Add(w.backoff(delivery.Attempts)).Unix()
+		}
+	}
+
+	return w.repo.RecordAttempt(ctx, delivery, attempt)
+}
+
+// post sends the signed payload and returns the response status code
+func (w *WebhookWorker) post(ctx context.Context, subscription WebhookSubscription, delivery WebhookDelivery, now time.Time) (int, error) {
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, timestamp, delivery.Payload))
+	InjectTraceHeaders(ctx, req.Header)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the wait after every failed attempt, capped at maxBackoff
+func (w *WebhookWorker) backoff(attempts int) time.Duration {
+	wait := w.baseBackoff
+	for i := 1; i < attempts && wait < w.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > w.maxBackoff {
+		wait = w.maxBackoff
+	}
+	return wait
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookDead      = "dead"
+)
+
+// WebhookSubscription is a partner endpoint receiving account events.
+// Empty EventTypes match every event, non-empty BloodCenters only match acceptor events of those centers.
+type WebhookSubscription struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Secret       string   `json:"secret,omitempty"`
+	EventTypes   []string `json:"eventTypes"`
+	BloodCenters []string `json:"bloodCenters"`
+	Active       bool     `json:"active"`
+	CreatedAt    string   `json:"createdAt"`
+}
+
+// WebhookDelivery is one event to be posted to one subscription
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID string           `json:"subscriptionId"`
+	EventID        string           `json:"eventId"`
+	EventType      string           `json:"eventType"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  int64            `json:"nextAttemptAt,omitempty"`
+	LastError      string           `json:"lastError,omitempty"`
+	CreatedAt      string           `json:"createdAt"`
+	AttemptLog     []WebhookAttempt `json:"attemptLog,omitempty"`
+}
+
+// WebhookAttempt is the outcome of a single POST of a delivery
+type WebhookAttempt struct {
+	ID          string `json:"id"`
+	DeliveryID  string `json:"deliveryId"`
+	AttemptedAt string `json:"attemptedAt"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Error       string `json:"error,omitempty"`
+	DurationMs  int64  `json:"durationMs"`
+}
+
+const webhookDeliveryColumns = `id, subscriptionId, eventId, eventType, payload, status, attempts, nextAttemptAt, lastError, createdAt`
+
+// WebhooksMySQL mysql repo
+type WebhooksMySQL struct {
+	db         *sql.DB
+	statements *statements
+
+	createSubscription          *stmt
+	updateSubscription          *stmt
+	getSubscriptions            *stmt
+	getSubscriptionByID         *stmt
+	deleteSubscriptionAttempts  *stmt
+	deleteSubscriptionDelivery  *stmt
+	deleteSubscription          *stmt
+	enqueueDelivery             *stmt
+	getDueDeliveries            *stmt
+	getDeliveriesBySubscription *stmt
+	getDeliveryByID             *stmt
+	getAttempts                 *stmt
+	createAttempt               *stmt
+	updateDelivery              *stmt
+	requeue                     *stmt
+}
+
+// NewWebhooksMySQL create new repository, preparing its statements
+func NewWebhooksMySQL(db *sql.DB) (*WebhooksMySQL, error) {
+	s := newStatements(db)
+	r := &WebhooksMySQL{
+		db:         db,
+		statements: s,
+
+		createSubscription: s.prepare(`INSERT INTO webhook_subscriptions (id, url, secret, eventTypes, bloodCenters, active, createdAt)
+		VALUES (?,?,?,?,?,?,?);`),
+		updateSubscription:         s.prepare(`UPDATE webhook_subscriptions SET url=?, secret=?, eventTypes=?, bloodCenters=?, active=? WHERE id=?;`),
+		getSubscriptions:           s.prepare(`SELECT id, url, secret, eventTypes, bloodCenters, active, createdAt FROM webhook_subscriptions ORDER BY createdAt`),
+		getSubscriptionByID:        s.prepare(`SELECT id, url, secret, eventTypes, bloodCenters, active, createdAt FROM webhook_subscriptions WHERE id=?`),
+		deleteSubscriptionAttempts: s.prepare(`DELETE a FROM webhook_attempts a JOIN webhook_deliveries d ON a.deliveryId = d.id WHERE d.subscriptionId=?`),
+		deleteSubscriptionDelivery: s.prepare(`DELETE FROM webhook_deliveries WHERE subscriptionId=?`),
+		deleteSubscription:         s.prepare(`DELETE FROM webhook_subscriptions WHERE id=?`),
+		enqueueDelivery: s.prepare(`INSERT IGNORE INTO webhook_deliveries (` + webhookDeliveryColumns + `)
+		VALUES (?,?,?,?,?,?,?,?,?,?);`),
+		getDueDeliveries: s.prepare(`SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+		WHERE status=? AND nextAttemptAt<=? ORDER BY nextAttemptAt LIMIT ?`),
+		getDeliveriesBySubscription: s.prepare(`SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+		WHERE subscriptionId=? ORDER BY createdAt DESC`),
+		getDeliveryByID: s.prepare(`SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id=?`),
+		getAttempts: s.prepare(`SELECT id, deliveryId, attemptedAt, statusCode, error, durationMs
+		FROM webhook_attempts WHERE deliveryId=? ORDER BY attemptedAt`),
+		createAttempt: s.prepare(`INSERT INTO webhook_attempts (id, deliveryId, attemptedAt, statusCode, error, durationMs)
+			VALUES (?,?,?,?,?,?);`),
+		updateDelivery: s.prepare(`UPDATE webhook_deliveries SET status=?, attempts=?, nextAttemptAt=?, lastError=? WHERE id=?;`),
+		requeue:        s.prepare(`UPDATE webhook_deliveries SET status=?, attempts=0, nextAttemptAt=? WHERE id=?;`),
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close the prepared statements of the repository
+func (r *WebhooksMySQL) Close() error {
+	return r.statements.Close()
+}
+
+// scanSubscription reads a single subscription row
+func scanSubscription(row rowScanner) (WebhookSubscription, error) {
+	subscription := WebhookSubscription{}
+	var eventTypes, bloodCenters string
+	err := row.Scan(&subscription.ID, &subscription.URL, &subscription.Secret, &eventTypes, &bloodCenters, &subscription.Active, &subscription.CreatedAt)
+	if err != nil {
+		return subscription, err
+	}
+
+	if err := json.Unmarshal([]byte(eventTypes), &subscription.EventTypes); err != nil {
+		return subscription, err
+	}
+	err = json.Unmarshal([]byte(bloodCenters), &subscription.BloodCenters)
+	return subscription, err
+}
+
+// scanWebhookDelivery reads a single delivery row selected with webhookDeliveryColumns
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	delivery := WebhookDelivery{}
+	var payload string
+	var lastError sql.NullString
+	err := row.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType, &payload,
+		&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &lastError, &delivery.CreatedAt)
+	delivery.Payload = []byte(payload)
+	delivery.LastError = lastError.String
+
+	return delivery, err
+}
+
+// CreateSubscription store a new subscription
+func (r *WebhooksMySQL) CreateSubscription(ctx context.Context, subscription WebhookSubscription) (err error) {
+	defer observeRepo("WebhooksMySQL", "CreateSubscription", time.Now(), &err)
+	eventTypes, bloodCenters, err := encodeFilters(subscription)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.createSubscription.exec(ctx,
+		subscription.ID, subscription.URL, subscription.Secret, eventTypes, bloodCenters, subscription.Active, subscription.CreatedAt)
+	return err
+}
+
+// UpdateSubscription change the url, secret, filters and state of a subscription
+func (r *WebhooksMySQL) UpdateSubscription(ctx context.Context, subscription WebhookSubscription) (err error) {
+	defer observeRepo("WebhooksMySQL", "UpdateSubscription", time.Now(), &err)
+	eventTypes, bloodCenters, err := encodeFilters(subscription)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.updateSubscription.exec(ctx,
+		subscription.URL, subscription.Secret, eventTypes, bloodCenters, subscription.Active, subscription.ID)
+	return err
+}
+
+// GetSubscriptions list all subscriptions
+func (r *WebhooksMySQL) GetSubscriptions(ctx context.Context) (_ []WebhookSubscription, err error) {
+	defer observeRepo("WebhooksMySQL", "GetSubscriptions", time.Now(), &err)
+	subscriptions := make([]WebhookSubscription, 0)
+	rows, err := r.getSubscriptions.query(ctx)
+	if err != nil {
+		logError(ctx, "WebhooksMySQL.GetSubscriptions failed", err)
+		return subscriptions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return subscriptions, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// GetSubscriptionByID Retrieve a subscription by Id
+func (r *WebhooksMySQL) GetSubscriptionByID(ctx context.Context, id string) (_ WebhookSubscription, err error) {
+	defer observeRepo("WebhooksMySQL", "GetSubscriptionByID", time.Now(), &err)
+	return scanSubscription(r.getSubscriptionByID.queryRow(ctx, id))
+}
+
+// DeleteSubscription remove a subscription together with its deliveries
+func (r *WebhooksMySQL) DeleteSubscription(ctx context.Context, id string) (err error) {
+	defer observeRepo("WebhooksMySQL", "DeleteSubscription", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		if _, err := r.deleteSubscriptionAttempts.exec(ctx, id); err != nil {
+			return err
+		}
+		if _, err := r.deleteSubscriptionDelivery.exec(ctx, id); err != nil {
+			return err
+		}
+		_, err := r.deleteSubscription.exec(ctx, id)
+		return err
+	})
+}
+
+// EnqueueDelivery store a delivery unless the event was already queued for the subscription
+func (r *WebhooksMySQL) EnqueueDelivery(ctx context.Context, delivery WebhookDelivery) (err error) {
+	defer observeRepo("WebhooksMySQL", "EnqueueDelivery", time.Now(), &err)
+	_, err = r.enqueueDelivery.exec(ctx,
+		delivery.ID, delivery.SubscriptionID, delivery.EventID, delivery.EventType, string(delivery.Payload),
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, nullString(delivery.LastError), delivery.CreatedAt)
+	return err
+}
+
+// GetDueDeliveries pending deliveries whose next attempt is due
+func (r *WebhooksMySQL) GetDueDeliveries(ctx context.Context, now int64, limit int) (_ []WebhookDelivery, err error) {
+	defer observeRepo("WebhooksMySQL", "GetDueDeliveries", time.Now(), &err)
+	return r.queryDeliveries(ctx, r.getDueDeliveries, WebhookPending, now, limit)
+}
+
+// GetDeliveriesBySubscription list the deliveries of a subscription, newest first
+func (r *WebhooksMySQL) GetDeliveriesBySubscription(ctx context.Context, subscriptionID string) (_ []WebhookDelivery, err error) {
+	defer observeRepo("WebhooksMySQL", "GetDeliveriesBySubscription", time.Now(), &err)
+	return r.queryDeliveries(ctx, r.getDeliveriesBySubscription, subscriptionID)
+}
+
+// GetDeliveryByID Retrieve a delivery with its attempts
+func (r *WebhooksMySQL) GetDeliveryByID(ctx context.Context, id string) (_ WebhookDelivery, err error) {
+	defer observeRepo("WebhooksMySQL", "GetDeliveryByID", time.Now(), &err)
+	delivery, err := scanWebhookDelivery(r.getDeliveryByID.queryRow(ctx, id))
+	if err != nil {
+		return delivery, err
+	}
+
+	rows, err := r.getAttempts.query(ctx, id)
+	if err != nil {
+		return delivery, err
+	}
+	defer rows.Close()
+
+	delivery.AttemptLog = make([]WebhookAttempt, 0)
+	for rows.Next() {
+		attempt := WebhookAttempt{}
+		var attemptErr sql.NullString
+		var statusCode sql.NullInt64
+		if err := rows.Scan(&attempt.ID, &attempt.DeliveryID, &attempt.AttemptedAt, &statusCode, &attemptErr, &attempt.DurationMs); err != nil {
+			return delivery, err
+		}
+		attempt.StatusCode = int(statusCode.Int64)
+		attempt.Error = attemptErr.String
+		delivery.AttemptLog = append(delivery.AttemptLog, attempt)
+	}
+
+	return delivery, rows.Err()
+}
+
+// RecordAttempt store an attempt and the resulting state of its delivery atomically
+func (r *WebhooksMySQL) RecordAttempt(ctx context.Context, delivery WebhookDelivery, attempt WebhookAttempt) (err error) {
+	defer observeRepo("WebhooksMySQL", "RecordAttempt", time.Now(), &err)
+	return WithTx(ctx, r.db, func(ctx context.Context) error {
+		statusCode := sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: attempt.StatusCode != 0}
+		_, err := r.createAttempt.exec(ctx,
+			attempt.ID, attempt.DeliveryID, attempt.AttemptedAt, statusCode, nullString(attempt.Error), attempt.DurationMs)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.updateDelivery.exec(ctx,
+			delivery.Status, delivery.Attempts, delivery.NextAttemptAt, nullString(delivery.LastError), delivery.ID)
+		return err
+	})
+}
+
+// UpdateDelivery store the state of a delivery that was not attempted, e.g. dead-lettered
+func (r *WebhooksMySQL) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) (err error) {
+	defer observeRepo("WebhooksMySQL", "UpdateDelivery", time.Now(), &err)
+	_, err = r.updateDelivery.exec(ctx,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, nullString(delivery.LastError), delivery.ID)
+	return err
+}
+
+// Requeue schedule a delivery for an immediate new round of attempts
+func (r *WebhooksMySQL) Requeue(ctx context.Context, id string, now int64) (err error) {
+	defer observeRepo("WebhooksMySQL", "Requeue", time.Now(), &err)
+	_, err = r.requeue.exec(ctx, WebhookPending, now, id)
+	return err
+}
+
+func (r *WebhooksMySQL) queryDeliveries(ctx context.Context, statement *stmt, args ...interface{}) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+	rows, err := statement.query(ctx, args...)
+	if err != nil {
+		logError(ctx, "WebhooksMySQL.queryDeliveries failed", err)
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func encodeFilters(subscription WebhookSubscription) (string, string, error) {
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	if subscription.BloodCenters == nil {
+		subscription.BloodCenters = []string{}
+	}
+
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return "", "", err
+	}
+	bloodCenters, err := json.Marshal(subscription.BloodCenters)
+	return string(eventTypes), string(bloodCenters), err
+}
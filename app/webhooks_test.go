@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// computed independently with: printf '%s' '1588413600.{"id":"e1"}' | openssl dgst -sha256 -hmac s3cret
+	want := "sha256=e8a68ce5fd7b86b59340996cd322f7a6a68c02ad046f458af45c1a2b0e885100"
+	if got := SignWebhook("s3cret", 1588413600, []byte(`{"id":"e1"}`)); got != want {
+		t.Errorf("SignWebhook = %s, want %s", got, want)
+	}
+
+	for _, changed := range []string{
+		SignWebhook("other", 1588413600, []byte(`{"id":"e1"}`)),
+		SignWebhook("s3cret", 1588413601, []byte(`{"id":"e1"}`)),
+		SignWebhook("s3cret", 1588413600, []byte(`{"id":"e2"}`)),
+	} {
+		if changed == want {
+			t.Error("the signature does not cover the secret, timestamp and body")
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	worker := NewWebhookWorker(nil, time.Minute, 20)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := worker.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff after %d attempts = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookSubscriptionMatches(t *testing.T) {
+	donorRegistered := Event{Type: DonorRegistered, Payload: json.RawMessage(`{"id":"d1"}`)}
+	acceptorRegistered := Event{Type: AcceptorRegistered, Payload: json.RawMessage(`{"id":"a1","bloodCenter":"Sofia"}`)}
+
+	tests := []struct {
+		subscription WebhookSubscription
+		event        Event
+		want         bool
+	}{
+		{WebhookSubscription{}, donorRegistered, true},
+		{WebhookSubscription{EventTypes: []string{DonorRegistered, DonorUpdated}}, donorRegistered, true},
+		{WebhookSubscription{EventTypes: []string{DonorUpdated}}, donorRegistered, false},
+		{WebhookSubscription{BloodCenters: []string{"Sofia"}}, acceptorRegistered, true},
+		{WebhookSubscription{BloodCenters: []string{"Plovdiv"}}, acceptorRegistered, false},
+		{WebhookSubscription{BloodCenters: []string{"Sofia"}}, donorRegistered, false},
+		{WebhookSubscription{EventTypes: []string{AcceptorRegistered}, BloodCenters: []string{"Sofia"}}, acceptorRegistered, true},
+		{WebhookSubscription{EventTypes: []string{AcceptorUpdated}, BloodCenters: []string{"Sofia"}}, acceptorRegistered, false},
+	}
+	for _, tt := range tests {
+		if got := tt.subscription.matches(tt.event); got != tt.want {
+			t.Errorf("%+v matches %s = %v, want %v", tt.subscription, tt.event.Payload, got, tt.want)
+		}
+	}
+}
+
+// webhookEndpoint answers /ok with 200 and anything else with 500, recording the requests
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mu.Lock()
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, string(body))
+	e.mu.Unlock()
+	if r.URL.Path != "/ok" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// newWebhookWorker delivers the deliveries of store to endpoint at a fixed time, failing lookups of
+// the subscription "broken"
+func newWebhookWorker(t *testing.T, store *sqlfake.Store, endpoint *webhookEndpoint, now time.Time) (*WebhookWorker, *httptest.Server) {
+	t.Helper()
+	server := httptest.NewServer(endpoint)
+	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		if strings.Contains(query, "FROM webhook_subscriptions WHERE id=?") && args[0] == "broken" {
+			return sqlfake.Error(errConnectionLost)
+		}
+		return store.Handle(query, args)
+	})
+	repo, err := NewWebhooksMySQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := NewWebhookWorker(repo, time.Minute, 5)
+	worker.client = server.Client()
+	worker.now = func() time.Time { return now }
+
+	columns := []string{"id", "url", "secret", "eventTypes", "bloodCenters", "active", "createdAt"}
+	store.Insert("webhook_subscriptions", columns, "ok", server.URL+"/ok", "s3cret", "[]", "[]", true, "2020-05-01T10:00:00Z")
+	store.Insert("webhook_subscriptions", columns, "down", server.URL+"/down", "s3cret", "[]", "[]", true, "2020-05-01T10:00:00Z")
+	store.Insert("webhook_subscriptions", columns, "paused", server.URL+"/ok", "s3cret", "[]", "[]", false, "2020-05-01T10:00:00Z")
+	return worker, server
+}
+
+func insertDelivery(store *sqlfake.Store, id, subscriptionID string, attempts int, nextAttemptAt int64) {
+	store.Insert("webhook_deliveries", strings.Split(strings.Replace(webhookDeliveryColumns, " ", "", -1), ","),
+		id, subscriptionID, "e-"+id, DonorRegistered, `{"id":"`+id+`"}`, WebhookPending, int64(attempts), nextAttemptAt, nil, "2020-05-01T10:00:00Z")
+}
+
+func deliveriesByID(store *sqlfake.Store) map[string]map[string]driver.Value {
+	deliveries := make(map[string]map[string]driver.Value)
+	for _, row := range store.Rows("webhook_deliveries") {
+		deliveries[row["id"].(string)] = row
+	}
+	return deliveries
+}
+
+func TestDeliverDue(t *testing.T) {
+	now := time.Date(2020, 5, 2, 10, 0, 0, 0, time.FixedZone("EEST", 3*60*60))
+	store := sqlfake.NewStore()
+	endpoint := &webhookEndpoint{}
+	worker, server := newWebhookWorker(t, store, endpoint, now)
+	defer server.Close()
+
+	insertDelivery(store, "w1", "ok", 0, now.Unix()-60)
+	insertDelivery(store, "w2", "down", 0, now.Unix())
+	insertDelivery(store, "w3", "down", 4, now.Unix())
+	insertDelivery(store, "w4", "paused", 0, now.Unix())
+	insertDelivery(store, "w5", "removed", 0, now.Unix())
+	insertDelivery(store, "w6", "broken", 0, now.Unix())
+	insertDelivery(store, "w7", "ok", 0, now.Unix()+60)
+
+	if err := worker.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		status        string
+		attempts      int64
+		nextAttemptAt int64
+		lastError     string
+	}{
+		"w1": {WebhookSucceeded, 1, now.Unix() - 60, ""},
+		"w2": {WebhookPending, 1, now.Add(30 * time.Second).Unix(), "endpoint answered 500 Internal Server Error"},
+		"w3": {WebhookDead, 5, now.Unix(), "endpoint answered 500 Internal Server Error"},
+		"w4": {WebhookDead, 0, now.Unix(), "subscription is inactive"},
+		"w5": {WebhookDead, 0, now.Unix(), "subscription no longer exists"},
+		// a subscription that could not be read leaves the delivery for the next round
+		"w6": {WebhookPending, 0, now.Unix(), ""},
+		"w7": {WebhookPending, 0, now.Unix() + 60, ""},
+	}
+	deliveries := deliveriesByID(store)
+	for id, want := range want {
+		row := deliveries[id]
+		lastError, _ := row["lastError"].(string)
+		if row["status"] != want.status || row["attempts"] != want.attempts || row["nextAttemptAt"] != want.nextAttemptAt || lastError != want.lastError {
+			t.Errorf("delivery %s = %v, want %+v", id, row, want)
+		}
+	}
+
+	if len(endpoint.requests) != 3 {
+		t.Fatalf("%d requests, want w1, w2 and w3 posted", len(endpoint.requests))
+	}
+	for i, r := range endpoint.requests {
+		timestamp := r.Header.Get(WebhookTimestampHeader)
+		if timestamp != strconv.FormatInt(now.Unix(), 10) || r.Header.Get(WebhookEventHeader) != DonorRegistered {
+			t.Errorf("request headers %v", r.Header)
+		}
+		if signature := SignWebhook("s3cret", now.Unix(), []byte(endpoint.bodies[i])); r.Header.Get(WebhookSignatureHeader) != signature {
+			t.Errorf("signature %s, want %s", r.Header.Get(WebhookSignatureHeader), signature)
+		}
+	}
+
+	attempts := store.Rows("webhook_attempts")
+	if len(attempts) != 3 {
+		t.Fatalf("%d attempts recorded, want 3", len(attempts))
+	}
+	for _, attempt := range attempts {
+		if attempt["attemptedAt"] != "2020-05-02T07:00:00Z" {
+			t.Errorf("attempt recorded at %v, want the UTC time", attempt["attemptedAt"])
+		}
+	}
+}
+
+func TestDeliverDueStopsWithContext(t *testing.T) {
+	now := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	store := sqlfake.NewStore()
+	endpoint := &webhookEndpoint{}
+	worker, server := newWebhookWorker(t, store, endpoint, now)
+	defer server.Close()
+	insertDelivery(store, "w1", "ok", 0, now.Unix())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := worker.DeliverDue(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeliverDue = %v, want context.Canceled", err)
+	}
+	if len(endpoint.requests) != 0 || len(store.Rows("webhook_attempts")) != 0 {
+		t.Errorf("a cancelled worker posted %d requests", len(endpoint.requests))
+	}
+	if row := deliveriesByID(store)["w1"]; row["status"] != WebhookPending || row["attempts"] != int64(0) {
+		t.Errorf("delivery %v, want it left due", row)
+	}
+}
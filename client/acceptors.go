@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// AcceptorsService wraps the /accounts/acceptors endpoints
+type AcceptorsService struct {
+	client *Client
+}
+
+// List returns every acceptor in a single request
+func (s *AcceptorsService) List(ctx context.Context) ([]Acceptor, error) {
+	acceptors := make([]Acceptor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/acceptors", nil, nil, &acceptors)
+	return acceptors, err
+}
+
+// Page returns at most limit acceptors starting at offset
+func (s *AcceptorsService) Page(ctx context.Context, limit, offset int) ([]Acceptor, error) {
+	acceptors := make([]Acceptor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/acceptors", pageQuery(limit, offset), nil, &acceptors)
+	return acceptors, err
+}
+
+// Iterate walks all acceptors, fetching pageSize of them per request
+func (s *AcceptorsService) Iterate(pageSize int) *AcceptorIterator {
+	return &AcceptorIterator{service: s, pageSize: pageSize}
+}
+
+// Get returns a single acceptor
+func (s *AcceptorsService) Get(ctx context.Context, id string) (*Acceptor, error) {
+	acceptor := &Acceptor{}
+	if err := s.client.do(ctx, http.MethodGet, "/accounts/acceptors/"+url.PathEscape(id), nil, nil, acceptor); err != nil {
+		return nil, err
+	}
+	return acceptor, nil
+}
+
+// Create registers an acceptor and returns it with its generated ID
+func (s *AcceptorsService) Create(ctx context.Context, input AcceptorInput) (*Acceptor, error) {
+	acceptor := &Acceptor{}
+	if err := s.client.do(ctx, http.MethodPost, "/accounts/acceptors", nil, input, acceptor); err != nil {
+		return nil, err
+	}
+	return acceptor, nil
+}
+
+// Update changes the non-nil fields of the input and returns the updated acceptor
+func (s *AcceptorsService) Update(ctx context.Context, id string, input AcceptorInput) (*Acceptor, error) {
+	acceptor := &Acceptor{}
+	if err := s.client.do(ctx, http.MethodPut, "/accounts/acceptors/"+url.PathEscape(id), nil, input, acceptor); err != nil {
+		return nil, err
+	}
+	return acceptor, nil
+}
+
+// Delete removes an acceptor
+func (s *AcceptorsService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/accounts/acceptors/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ByBloodGroup returns the acceptors of a blood group
+func (s *AcceptorsService) ByBloodGroup(ctx context.Context, bloodGroup string) ([]Acceptor, error) {
+	acceptors := make([]Acceptor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/acceptors/bloodtype/"+url.PathEscape(bloodGroup), nil, nil, &acceptors)
+	return acceptors, err
+}
+
+// AcceptorIterator pages through acceptors:
+//
+//	it := c.Acceptors().Iterate(100)
+//	for it.Next(ctx) {
+//		acceptor := it.Acceptor()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type AcceptorIterator struct {
+	service  *AcceptorsService
+	pageSize int
+	offset   int
+	page     []Acceptor
+	current  Acceptor
+	done     bool
+	err      error
+}
+
+// Next advances to the next acceptor, fetching a new page when needed
+func (it *AcceptorIterator) Next(ctx context.Context) bool {
+	if len(it.page) == 0 && !it.done && it.err == nil {
+		it.page, it.err = it.service.Page(ctx, it.pageSize, it.offset)
+		it.offset += len(it.page)
+		it.done = len(it.page) < it.pageSize
+	}
+	if it.err != nil || len(it.page) == 0 {
+		return false
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Acceptor returns the acceptor Next advanced to
+func (it *AcceptorIterator) Acceptor() Acceptor { return it.current }
+
+// Err returns the error that stopped the iteration, if any
+func (it *AcceptorIterator) Err() error { return it.err }
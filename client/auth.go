@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// AuthService wraps the /auth endpoints donors log in with. Its requests are never retried.
+type AuthService struct {
+	client *Client
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type registrationInput struct {
+	Email string `json:"email"`
+}
+
+type confirmationInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type refreshTokenInput struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Register asks for a registration token mailed to the e-mail address. It is only sent when a single donor
+// verified the address and has no password yet, the service answers alike either way.
+func (s *AuthService) Register(ctx context.Context, email string) error {
+	return s.client.doOnce(ctx, http.MethodPost, "/auth/register", "", registrationInput{email}, nil)
+}
+
+// ConfirmRegistration redeems the mailed registration token, setting the password and logging the donor in
+func (s *AuthService) ConfirmRegistration(ctx context.Context, token, password string) (*TokenPair, error) {
+	pair := &TokenPair{}
+	if err := s.client.doOnce(ctx, http.MethodPost, "/auth/register/confirm", "", confirmationInput{token, password}, pair); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Login opens a session for the donor with the e-mail address and password
+func (s *AuthService) Login(ctx context.Context, email, password string) (*TokenPair, error) {
+	pair := &TokenPair{}
+	if err := s.client.doOnce(ctx, http.MethodPost, "/auth/login", "", credentials{email, password}, pair); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Refresh exchanges the refresh token for a new pair, the old refresh token must not be used again
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	pair := &TokenPair{}
+	if err := s.client.doOnce(ctx, http.MethodPost, "/auth/refresh", "", refreshTokenInput{refreshToken}, pair); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Logout ends the session of the access token
+func (s *AuthService) Logout(ctx context.Context, accessToken string) error {
+	return s.client.doOnce(ctx, http.MethodPost, "/auth/logout", accessToken, nil, nil)
+}
+
+// Me returns the donor the access token was issued to
+func (s *AuthService) Me(ctx context.Context, accessToken string) (*Donor, error) {
+	donor := &Donor{}
+	if err := s.client.doOnce(ctx, http.MethodGet, "/auth/me", accessToken, nil, donor); err != nil {
+		return nil, err
+	}
+	return donor, nil
+}
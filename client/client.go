@@ -0,0 +1,287 @@
+// Package client is a Go client for the LifeBlood accounts service API.
+//
+//	c, err := client.New("http://localhost:4200", client.WithToken(token))
+//	donor, err := c.Donors().Get(ctx, "12")
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one accounts service instance
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      string
+	maxRetries int
+	backoff    time.Duration
+	userAgent  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client, e.g. to set transport level timeouts
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken sends the token as bearer token with every request
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries retries requests up to maxRetries times on network errors, 429 and 5xx answers. POST requests
+// are sent with an Idempotency-Key, so a retry of a request the service already handled is not applied twice.
+// The wait before retry n is backoff * 2^(n-1), or longer when the service answers with a Retry-After header.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// WithUserAgent sets the User-Agent header, useful to tell calling services apart in the logs
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// New creates a client for the service at baseURL, e.g. http://accounts:4200
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q must be absolute", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    parsed,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 2,
+		backoff:    200 * time.Millisecond,
+		userAgent:  "lifeblood-accounts-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+type traceContextKey struct{}
+
+type traceContext struct {
+	traceparent string
+	tracestate  string
+}
+
+// ContextWithTrace returns a context whose requests carry the W3C traceparent and tracestate header values,
+// so the service continues the trace of the caller. An empty tracestate is left out.
+func ContextWithTrace(ctx context.Context, traceparent, tracestate string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext{traceparent: traceparent, tracestate: tracestate})
+}
+
+// injectTrace adds the trace context of ctx, if there is one, to an outgoing request
+func injectTrace(ctx context.Context, header http.Header) {
+	trace, ok := ctx.Value(traceContextKey{}).(traceContext)
+	if !ok || trace.traceparent == "" {
+		return
+	}
+	header.Set("traceparent", trace.traceparent)
+	if trace.tracestate != "" {
+		header.Set("tracestate", trace.tracestate)
+	}
+}
+
+// Donors gives access to the donor endpoints
+func (c *Client) Donors() *DonorsService {
+	return &DonorsService{client: c}
+}
+
+// Acceptors gives access to the acceptor endpoints
+func (c *Client) Acceptors() *AcceptorsService {
+	return &AcceptorsService{client: c}
+}
+
+// Persons gives access to the person endpoints
+func (c *Client) Persons() *PersonsService {
+	return &PersonsService{client: c}
+}
+
+// Auth gives access to the donor login endpoints
+func (c *Client) Auth() *AuthService {
+	return &AuthService{client: c}
+}
+
+// Search finds donors and acceptors by name, e-mail, phone or city, best matches first. Zero limit
+// leaves the number of results to the service.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	params := url.Values{"q": []string{query}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	results := make([]SearchResult, 0)
+	if err := c.do(ctx, http.MethodGet, "/accounts/search", params, nil, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// do sends the request and decodes a JSON answer into out, which may be nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	header := http.Header{}
+	if method == http.MethodPost {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return err
+		}
+		header.Set("Idempotency-Key", key)
+	}
+
+	attempts := 1
+	if isIdempotent(method) || header.Get("Idempotency-Key") != "" {
+		attempts += c.maxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := c.backoff << uint(attempt-1)
+			if apiErr, ok := lastErr.(*Error); ok && apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+		}
+
+		retry, err := c.send(ctx, method, target.String(), header, body, out)
+		if err == nil || !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// doOnce sends the request without retrying it, with token as bearer token when given. The /auth routes
+// ignore Idempotency-Key, a retried refresh would present a used refresh token and revoke the session.
+func (c *Client) doOnce(ctx context.Context, method, path, token string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	target := *c.baseURL
+	target.Path += path
+
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	_, err := c.send(ctx, method, target.String(), header, body, out)
+	return err
+}
+
+// send makes a single attempt and reports whether a failure is worth retrying
+func (c *Client) send(ctx context.Context, method, target string, header http.Header, body []byte, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return false, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	injectTrace(ctx, req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// a cancelled or expired context must not be retried
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	if resp.StatusCode >= 400 {
+		apiErr := newError(resp, data)
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, apiErr
+	}
+
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return false, nil
+	}
+	return false, json.Unmarshal(data, out)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// newIdempotencyKey is a random key shared by all attempts of one request
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
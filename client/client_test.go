@@ -0,0 +1,497 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/life-blood/accounts-service/app"
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+var (
+	personColumns   = []string{"id", "name", "lastName", "phone", "email", "createdAt"}
+	donorColumns    = []string{"id", "personId", "dateOfBirth", "gender", "bloodGroup", "city", "regDate", "emailVerified", "emailVerifiedAt", "phoneVerified", "phoneVerifiedAt", "notificationsOptIn"}
+	acceptorColumns = []string{"id", "personId", "bloodGroup", "city", "bloodCenter", "regDate", "urgent"}
+)
+
+// testService is the accounts service on an in-memory database, with a client talking to it
+type testService struct {
+	store   *sqlfake.Store
+	server  *httptest.Server
+	client  *Client
+	mailbox *mailbox
+	// failing makes every statement fail while it is set
+	failing    int32
+	statements int32
+}
+
+func newTestService(t *testing.T, opts ...Option) *testService {
+	t.Helper()
+	service := &testService{store: sqlfake.NewStore(), mailbox: &mailbox{}}
+	registered := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	service.store.SetDefaults("persons", map[string]driver.Value{"phone": "", "email": ""})
+	service.store.SetDefaults("donors", map[string]driver.Value{"emailVerified": false, "phoneVerified": false})
+	service.store.Insert("persons", personColumns, "p1", "Ivan", "Petrov", "+359888123456", "ivan@example.com", registered)
+	service.store.Insert("donors", donorColumns, "d1", "p1", "1990-04-12", "male", "Bpos", "Sofia", registered, true, registered, false, nil, true)
+	service.store.Insert("persons", personColumns, "p2", "Maria", "Ivanova", "", "", registered)
+	service.store.Insert("acceptors", acceptorColumns, "a1", "p2", "A+", "Plovdiv", "Plovdiv Blood Center", registered, false)
+
+	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+		atomic.AddInt32(&service.statements, 1)
+		switch {
+		case atomic.LoadInt32(&service.failing) == 1:
+			return sqlfake.Error(errors.New("connection refused"))
+		case strings.Contains(query, "NOT EXISTS"), strings.Contains(query, "DELETE credentials"):
+			// persons of deleted accounts and passwords are not looked at by these tests
+			return sqlfake.Result{}
+		}
+		return service.store.Handle(query, args)
+	})
+	cluster := app.NewDBCluster(db)
+	donors, err := app.NewDonorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptors, err := app.NewAcceptorsMySQL(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persons, err := app.NewPersonsMySQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := app.NewAuthMySQL(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &app.App{
+		Router:        mux.NewRouter(),
+		Database:      db,
+		Cluster:       cluster,
+		DonorsRepo:    donors,
+		AcceptorsRepo: acceptors,
+		PersonsRepo:   persons,
+		Authenticator: app.NewAuthenticator(auth, 15*time.Minute, time.Hour, time.Hour, 10, 4),
+		Mailer:        service.mailbox,
+		PhoneRegion:   "BG",
+	}
+	a.SetupRouter()
+	service.server = httptest.NewServer(a.Router)
+
+	service.client, err = New(service.server.URL, append([]Option{WithRetries(2, time.Millisecond)}, opts...)...)
+	if err != nil {
+		service.Close()
+		t.Fatal(err)
+	}
+	return service
+}
+
+func (s *testService) Close() {
+	s.server.Close()
+}
+
+// loggedIn returns a client sending the access token of a session opened for the donor
+func (s *testService) loggedIn(t *testing.T, donorID string) *Client {
+	t.Helper()
+	now := time.Now().UTC()
+	token := "s" + donorID + ".secret"
+	s.store.Insert("auth_sessions", []string{"id", "donorId", "accessHash", "accessExpiresAt", "createdAt"},
+		"s"+donorID, donorID, hashToken(token), now.Add(time.Hour), now)
+
+	c, err := New(s.server.URL, WithToken(token), WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// mailbox keeps the messages sent by the service
+type mailbox struct {
+	mu       sync.Mutex
+	messages []app.Message
+}
+
+func (m *mailbox) Send(msg app.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *mailbox) sent() []app.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]app.Message(nil), m.messages...)
+}
+
+func TestDonorsService(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	ctx := context.Background()
+	donors := service.client.Donors()
+
+	all, err := donors.List(ctx)
+	if err != nil || len(all) != 1 || all[0].ID != "d1" || all[0].FirstName != "Ivan" {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+
+	created, err := donors.Create(ctx, DonorInput{
+		FirstName:   String("Georgi"),
+		LastName:    String("Dimitrov"),
+		PhoneNumber: String("0888 765 432"),
+		DateOfBirth: String("1985-09-30"),
+		BloodGroup:  String("Bpos"),
+		City:        String("Varna"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.PhoneNumber != "+359888765432" || created.DateOfBirth != "1985-09-30" {
+		t.Errorf("Create = %+v", created)
+	}
+
+	got, err := donors.Get(ctx, created.ID)
+	if err != nil || got.FirstName != "Georgi" || got.City != "Varna" || got.PersonID != created.PersonID {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	// only the donor changes its own profile
+	owner := service.loggedIn(t, created.ID)
+	if _, err := donors.Update(ctx, created.ID, DonorInput{City: String("Burgas")}); StatusCode(err) != http.StatusUnauthorized {
+		t.Errorf("Update without a token = %v, want 401", err)
+	}
+	if _, err := service.loggedIn(t, "d1").Donors().Update(ctx, created.ID, DonorInput{City: String("Burgas")}); StatusCode(err) != http.StatusForbidden {
+		t.Errorf("Update by another donor = %v, want 403", err)
+	}
+	updated, err := owner.Donors().Update(ctx, created.ID, DonorInput{City: String("Burgas"), NotificationsOptIn: Bool(true)})
+	if err != nil || updated.City != "Burgas" || !updated.NotificationsOptIn || updated.FirstName != "Georgi" {
+		t.Errorf("Update = %+v, %v", updated, err)
+	}
+
+	page, err := donors.Page(ctx, 1, 1)
+	if err != nil || len(page) != 1 || page[0].ID != created.ID {
+		t.Errorf("Page(1, 1) = %+v, %v", page, err)
+	}
+
+	byGroup, err := donors.ByBloodGroup(ctx, "Bpos")
+	if err != nil || len(byGroup) != 2 {
+		t.Errorf("ByBloodGroup = %+v, %v", byGroup, err)
+	}
+
+	iterated := make([]string, 0)
+	it := donors.Iterate(1)
+	for it.Next(ctx) {
+		iterated = append(iterated, it.Donor().ID)
+	}
+	if it.Err() != nil || len(iterated) != 2 || iterated[0] != "d1" || iterated[1] != created.ID {
+		t.Errorf("Iterate = %v, %v", iterated, it.Err())
+	}
+
+	if err := donors.Delete(ctx, created.ID); StatusCode(err) != http.StatusUnauthorized {
+		t.Errorf("Delete without a token = %v, want 401", err)
+	}
+	if err := service.loggedIn(t, "d1").Donors().Delete(ctx, created.ID); StatusCode(err) != http.StatusForbidden {
+		t.Errorf("Delete by another donor = %v, want 403", err)
+	}
+	if err := owner.Donors().Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := donors.Get(ctx, created.ID); !IsNotFound(err) {
+		t.Errorf("Get after Delete = %v, want not found", err)
+	}
+}
+
+func TestAuthRegistration(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	ctx := context.Background()
+	auth := service.client.Auth()
+
+	// unknown addresses are answered alike, without a mail
+	if err := auth.Register(ctx, "nobody@example.com"); err != nil {
+		t.Fatalf("Register of an unknown address = %v", err)
+	}
+	if sent := service.mailbox.sent(); len(sent) != 0 {
+		t.Fatalf("mailed %+v for an unknown address", sent)
+	}
+
+	if err := auth.Register(ctx, "ivan@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	sent := service.mailbox.sent()
+	if len(sent) != 1 || sent[0].To != "ivan@example.com" {
+		t.Fatalf("mailed %+v, want the registration token to ivan@example.com", sent)
+	}
+	token := strings.Split(sent[0].Body, "\r\n")[4]
+
+	if _, err := auth.ConfirmRegistration(ctx, "made-up", "correct horse battery"); StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("ConfirmRegistration with a made up token = %v, want 400", err)
+	}
+	if _, err := auth.ConfirmRegistration(ctx, token, "short"); StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("ConfirmRegistration with a short password = %v, want 400", err)
+	}
+	pair, err := auth.ConfirmRegistration(ctx, token, "correct horse battery")
+	if err != nil || pair.DonorID != "d1" || pair.AccessToken == "" {
+		t.Fatalf("ConfirmRegistration = %+v, %v", pair, err)
+	}
+	if _, err := auth.ConfirmRegistration(ctx, token, "another horse battery"); StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("ConfirmRegistration with a used token = %v, want 400", err)
+	}
+
+	me, err := auth.Me(ctx, pair.AccessToken)
+	if err != nil || me.ID != "d1" {
+		t.Errorf("Me = %+v, %v", me, err)
+	}
+	login, err := auth.Login(ctx, "ivan@example.com", "correct horse battery")
+	if err != nil || login.DonorID != "d1" {
+		t.Errorf("Login = %+v, %v", login, err)
+	}
+
+	// a donor with a password gets no further token
+	if err := auth.Register(ctx, "ivan@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := service.mailbox.sent(); len(sent) != 1 {
+		t.Errorf("mailed %d messages, want no token for a registered donor", len(sent))
+	}
+}
+
+func TestAcceptorsService(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	ctx := context.Background()
+	acceptors := service.client.Acceptors()
+
+	all, err := acceptors.List(ctx)
+	if err != nil || len(all) != 1 || all[0].ID != "a1" {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+
+	created, err := acceptors.Create(ctx, AcceptorInput{
+		FirstName:   String("Elena"),
+		LastName:    String("Georgieva"),
+		BloodGroup:  String("A+"),
+		City:        String("Sofia"),
+		BloodCenter: String("Alexandrovska"),
+	})
+	if err != nil || created.ID == "" || created.FirstName != "Elena" {
+		t.Fatalf("Create = %+v, %v", created, err)
+	}
+
+	got, err := acceptors.Get(ctx, created.ID)
+	if err != nil || got.BloodCenter != "Alexandrovska" {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	updated, err := acceptors.Update(ctx, created.ID, AcceptorInput{BloodCenter: String("Pirogov")})
+	if err != nil || updated.BloodCenter != "Pirogov" || updated.City != "Sofia" {
+		t.Errorf("Update = %+v, %v", updated, err)
+	}
+
+	page, err := acceptors.Page(ctx, 5, 0)
+	if err != nil || len(page) != 2 {
+		t.Errorf("Page(5, 0) = %+v, %v", page, err)
+	}
+
+	byGroup, err := acceptors.ByBloodGroup(ctx, "Bpos")
+	if err != nil || len(byGroup) != 0 {
+		t.Errorf("ByBloodGroup = %+v, %v", byGroup, err)
+	}
+
+	iterated := 0
+	it := acceptors.Iterate(10)
+	for it.Next(ctx) {
+		if it.Acceptor().ID == "" {
+			t.Error("iterated an empty acceptor")
+		}
+		iterated++
+	}
+	if it.Err() != nil || iterated != 2 {
+		t.Errorf("Iterate went over %d acceptors, %v", iterated, it.Err())
+	}
+
+	if err := acceptors.Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acceptors.Get(ctx, created.ID); !IsNotFound(err) {
+		t.Errorf("Get after Delete = %v, want not found", err)
+	}
+}
+
+func TestPersonsService(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	ctx := context.Background()
+
+	acceptor, err := service.client.Acceptors().Create(ctx, AcceptorInput{PersonID: String("p1"), BloodGroup: String("Bpos"), City: String("Sofia")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acceptor.PersonID != "p1" || acceptor.FirstName != "Ivan" {
+		t.Errorf("acceptor registered for p1 = %+v", acceptor)
+	}
+
+	person, err := service.client.Persons().Get(ctx, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(person.Roles, ",") != "donor,acceptor" || person.Donor == nil || person.Donor.ID != "d1" || person.Acceptor == nil {
+		t.Errorf("Get = %+v", person)
+	}
+
+	if _, err := service.client.Persons().Get(ctx, "nobody"); !IsNotFound(err) {
+		t.Errorf("Get of an unknown person = %v, want not found", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+
+	results, err := service.client.Search(context.Background(), "petrov", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Type != "donor" || results[0].Donor == nil || results[0].Donor.ID != "d1" {
+		t.Errorf("Search = %+v", results)
+	}
+
+	_, err = service.client.Search(context.Background(), "p", 0)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Message, "2 characters") {
+		t.Errorf("Search with a short query = %v, want 400", err)
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	ctx := context.Background()
+
+	_, err := service.client.Donors().Get(ctx, "missing")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "donor not found" {
+		t.Errorf("Get of a missing donor = %#v", err)
+	}
+	if err.Error() != "accounts service: 404 donor not found" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	_, err = service.client.Donors().Create(ctx, DonorInput{PhoneNumber: String("not a phone")})
+	if !IsBadRequest(err) || !strings.Contains(err.Error(), app.ErrInvalidPhone.Error()) {
+		t.Errorf("Create with an invalid phone = %v, want 400", err)
+	}
+
+	_, err = service.client.Donors().Page(ctx, -1, 0)
+	if !IsBadRequest(err) {
+		t.Errorf("Page with a negative limit = %v, want 400", err)
+	}
+
+	if StatusCode(errors.New("dial tcp: connection refused")) != 0 || IsNotFound(nil) {
+		t.Error("errors that are not answers of the service have no status")
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	service := newTestService(t)
+	defer service.Close()
+	atomic.StoreInt32(&service.failing, 1)
+	atomic.StoreInt32(&service.statements, 0)
+
+	_, err := service.client.Donors().List(context.Background())
+	if StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("List with the database down = %v, want 500", err)
+	}
+	if statements := atomic.LoadInt32(&service.statements); statements != 3 {
+		t.Errorf("%d attempts, want the request and 2 retries", statements)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	var mu sync.Mutex
+	headers := make([]http.Header, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		attempt := len(headers)
+		mu.Unlock()
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"d9"}`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL+"/", WithToken("secret"), WithUserAgent("donor-portal"), WithRetries(1, time.Millisecond), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := ContextWithTrace(context.Background(), traceparent, "lifeblood=portal")
+	donor, err := c.Donors().Create(ctx, DonorInput{FirstName: String("Ivan")})
+	if err != nil || donor.ID != "d9" {
+		t.Fatalf("Create = %+v, %v", donor, err)
+	}
+
+	if len(headers) != 2 {
+		t.Fatalf("%d attempts, want 2", len(headers))
+	}
+	for _, header := range headers {
+		if header.Get("Authorization") != "Bearer secret" || header.Get("User-Agent") != "donor-portal" || header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers %v", header)
+		}
+		if header.Get("traceparent") != traceparent || header.Get("tracestate") != "lifeblood=portal" {
+			t.Errorf("trace headers %q, %q, want the trace of the context", header.Get("traceparent"), header.Get("tracestate"))
+		}
+	}
+	if key := headers[0].Get("Idempotency-Key"); key == "" || key != headers[1].Get("Idempotency-Key") {
+		t.Errorf("Idempotency-Key %q then %q, want the same key on the retry", key, headers[1].Get("Idempotency-Key"))
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Donors().Get(context.Background(), "d1")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Message != "Too Many Requests" || apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("error = %#v", err)
+	}
+}
+
+func TestNewRequiresAbsoluteURL(t *testing.T) {
+	for _, baseURL := range []string{"localhost:4200", "/accounts", "http://%zz"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("New(%q) accepted a relative or invalid URL", baseURL)
+		}
+	}
+}
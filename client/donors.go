@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DonorsService wraps the /accounts/donors endpoints
+type DonorsService struct {
+	client *Client
+}
+
+// List returns every donor in a single request
+func (s *DonorsService) List(ctx context.Context) ([]Donor, error) {
+	donors := make([]Donor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/donors", nil, nil, &donors)
+	return donors, err
+}
+
+// Page returns at most limit donors starting at offset
+func (s *DonorsService) Page(ctx context.Context, limit, offset int) ([]Donor, error) {
+	donors := make([]Donor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/donors", pageQuery(limit, offset), nil, &donors)
+	return donors, err
+}
+
+// Iterate walks all donors, fetching pageSize of them per request
+func (s *DonorsService) Iterate(pageSize int) *DonorIterator {
+	return &DonorIterator{service: s, pageSize: pageSize}
+}
+
+// Get returns a single donor
+func (s *DonorsService) Get(ctx context.Context, id string) (*Donor, error) {
+	donor := &Donor{}
+	if err := s.client.do(ctx, http.MethodGet, "/accounts/donors/"+url.PathEscape(id), nil, nil, donor); err != nil {
+		return nil, err
+	}
+	return donor, nil
+}
+
+// Create registers a donor and returns it with its generated ID
+func (s *DonorsService) Create(ctx context.Context, input DonorInput) (*Donor, error) {
+	donor := &Donor{}
+	if err := s.client.do(ctx, http.MethodPost, "/accounts/donors", nil, input, donor); err != nil {
+		return nil, err
+	}
+	return donor, nil
+}
+
+// Update changes the non-nil fields of the input and returns the updated donor. The client needs the access
+// token of the donor, or the admin token, see WithToken.
+func (s *DonorsService) Update(ctx context.Context, id string, input DonorInput) (*Donor, error) {
+	donor := &Donor{}
+	if err := s.client.do(ctx, http.MethodPut, "/accounts/donors/"+url.PathEscape(id), nil, input, donor); err != nil {
+		return nil, err
+	}
+	return donor, nil
+}
+
+// Delete removes a donor, with the access token of the donor or the admin token like Update
+func (s *DonorsService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/accounts/donors/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ByBloodGroup returns the donors of a blood group
+func (s *DonorsService) ByBloodGroup(ctx context.Context, bloodGroup string) ([]Donor, error) {
+	donors := make([]Donor, 0)
+	err := s.client.do(ctx, http.MethodGet, "/accounts/donors/bloodtype/"+url.PathEscape(bloodGroup), nil, nil, &donors)
+	return donors, err
+}
+
+// DonorIterator pages through donors:
+//
+//	it := c.Donors().Iterate(100)
+//	for it.Next(ctx) {
+//		donor := it.Donor()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type DonorIterator struct {
+	service  *DonorsService
+	pageSize int
+	offset   int
+	page     []Donor
+	current  Donor
+	done     bool
+	err      error
+}
+
+// Next advances to the next donor, fetching a new page when needed
+func (it *DonorIterator) Next(ctx context.Context) bool {
+	if len(it.page) == 0 && !it.done && it.err == nil {
+		it.page, it.err = it.service.Page(ctx, it.pageSize, it.offset)
+		it.offset += len(it.page)
+		it.done = len(it.page) < it.pageSize
+	}
+	if it.err != nil || len(it.page) == 0 {
+		return false
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Donor returns the donor Next advanced to
+func (it *DonorIterator) Donor() Donor { return it.current }
+
+// Err returns the error that stopped the iteration, if any
+func (it *DonorIterator) Err() error { return it.err }
+
+func pageQuery(limit, offset int) url.Values {
+	return url.Values{
+		"limit":  []string{strconv.Itoa(limit)},
+		"offset": []string{strconv.Itoa(offset)},
+	}
+}
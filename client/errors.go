@@ -0,0 +1,60 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error is an error answer of the service, it mirrors the {"error": {"status", "message"}} envelope
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the wait the service asked for with a 429 or 503 answer, zero when it did not ask
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("accounts service: %d %s", e.StatusCode, e.Message)
+}
+
+// newError decodes the error envelope, falling back to the HTTP status text for bodies in another shape
+func newError(resp *http.Response, body []byte) *Error {
+	envelope := struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		apiErr.Message = envelope.Error.Message
+	}
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of an *Error, or 0 for any other error
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether the service answered 404
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsBadRequest reports whether the service rejected the request content
+func IsBadRequest(err error) bool {
+	return StatusCode(err) == http.StatusBadRequest
+}
@@ -0,0 +1,104 @@
+package client
+
+import "time"
+
+// Donor as returned by the service, DateOfBirth is a day such as 1990-04-21 and Age is computed from it
+type Donor struct {
+	ID                 string     `json:"id"`
+	PersonID           string     `json:"personId"`
+	FirstName          string     `json:"name"`
+	LastName           string     `json:"lastName"`
+	PhoneNumber        string     `json:"phone"`
+	Email              string     `json:"email"`
+	DateOfBirth        string     `json:"dateOfBirth,omitempty"`
+	Age                int        `json:"age,omitempty"`
+	Gender             string     `json:"gender"`
+	BloodGroup         string     `json:"bloodGroup"`
+	City               string     `json:"city"`
+	RegistrationDate   time.Time  `json:"regDate"`
+	EmailVerified      bool       `json:"emailVerified"`
+	EmailVerifiedAt    *time.Time `json:"emailVerifiedAt,omitempty"`
+	PhoneVerified      bool       `json:"phoneVerified"`
+	PhoneVerifiedAt    *time.Time `json:"phoneVerifiedAt,omitempty"`
+	NotificationsOptIn bool       `json:"notificationsOptIn"`
+}
+
+// DonorInput holds the fields sent on create and update, nil fields are left out so updates keep their value
+type DonorInput struct {
+	// PersonID registers the donor for an existing person, only taken into account on create
+	PersonID           *string `json:"personId,omitempty"`
+	FirstName          *string `json:"name,omitempty"`
+	LastName           *string `json:"lastName,omitempty"`
+	PhoneNumber        *string `json:"phone,omitempty"`
+	Email              *string `json:"email,omitempty"`
+	DateOfBirth        *string `json:"dateOfBirth,omitempty"`
+	Gender             *string `json:"gender,omitempty"`
+	BloodGroup         *string `json:"bloodGroup,omitempty"`
+	City               *string `json:"city,omitempty"`
+	NotificationsOptIn *bool   `json:"notificationsOptIn,omitempty"`
+}
+
+// Acceptor as returned by the service
+type Acceptor struct {
+	ID               string    `json:"id"`
+	PersonID         string    `json:"personId"`
+	FirstName        string    `json:"name"`
+	LastName         string    `json:"lastName"`
+	BloodGroup       string    `json:"bloodGroup"`
+	City             string    `json:"city"`
+	BloodCenter      string    `json:"bloodCenter"`
+	RegistrationDate time.Time `json:"regDate"`
+	Urgent           bool      `json:"urgent"`
+}
+
+// AcceptorInput holds the fields sent on create and update, nil fields are left out so updates keep their value
+type AcceptorInput struct {
+	// PersonID registers the acceptor for an existing person, only taken into account on create
+	PersonID    *string `json:"personId,omitempty"`
+	FirstName   *string `json:"name,omitempty"`
+	LastName    *string `json:"lastName,omitempty"`
+	BloodGroup  *string `json:"bloodGroup,omitempty"`
+	City        *string `json:"city,omitempty"`
+	BloodCenter *string `json:"bloodCenter,omitempty"`
+	Urgent      *bool   `json:"urgent,omitempty"`
+}
+
+// Person is the identity shared by the donor and acceptor accounts of one human, with the accounts it holds
+type Person struct {
+	ID          string    `json:"id"`
+	FirstName   string    `json:"name"`
+	LastName    string    `json:"lastName"`
+	PhoneNumber string    `json:"phone"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Roles lists "donor" and "acceptor" for the accounts that are set
+	Roles    []string  `json:"roles"`
+	Donor    *Donor    `json:"donor,omitempty"`
+	Acceptor *Acceptor `json:"acceptor,omitempty"`
+}
+
+// SearchResult is a donor or an acceptor found by Client.Search, Type tells which of the two is set
+type SearchResult struct {
+	Type          string    `json:"type"`
+	ID            string    `json:"id"`
+	Score         float64   `json:"score"`
+	MatchedFields []string  `json:"matchedFields"`
+	Donor         *Donor    `json:"donor,omitempty"`
+	Acceptor      *Acceptor `json:"acceptor,omitempty"`
+}
+
+// TokenPair is the answer of the /auth routes, AccessToken identifies the donor for ExpiresIn seconds and
+// RefreshToken is exchanged once for a new pair
+type TokenPair struct {
+	DonorID      string `json:"donorId"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// String returns a pointer to s, for filling the optional fields of the input types
+func String(s string) *string { return &s }
+
+// Bool returns a pointer to b, for filling the optional fields of the input types
+func Bool(b bool) *bool { return &b }
@@ -0,0 +1,21 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// PersonsService wraps the /accounts/persons endpoints
+type PersonsService struct {
+	client *Client
+}
+
+// Get returns a person with its donor and acceptor accounts
+func (s *PersonsService) Get(ctx context.Context, id string) (*Person, error) {
+	person := &Person{}
+	if err := s.client.do(ctx, http.MethodGet, "/accounts/persons/"+url.PathEscape(id), nil, nil, person); err != nil {
+		return nil, err
+	}
+	return person, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+	"github.com/life-blood/accounts-service/client"
+	"github.com/lithammer/shortuuid"
+)
+
+// backend is where accountsctl reads and writes accounts: the HTTP API or the repository directly
+type backend interface {
+	ListDonors(ctx context.Context) ([]client.Donor, error)
+	GetDonor(ctx context.Context, id string) (*client.Donor, error)
+	CreateDonor(ctx context.Context, input client.DonorInput) (*client.Donor, error)
+	UpdateDonor(ctx context.Context, id string, input client.DonorInput) (*client.Donor, error)
+	DeleteDonor(ctx context.Context, id string) error
+
+	ListAcceptors(ctx context.Context) ([]client.Acceptor, error)
+	GetAcceptor(ctx context.Context, id string) (*client.Acceptor, error)
+	CreateAcceptor(ctx context.Context, input client.AcceptorInput) (*client.Acceptor, error)
+	UpdateAcceptor(ctx context.Context, id string, input client.AcceptorInput) (*client.Acceptor, error)
+	DeleteAcceptor(ctx context.Context, id string) error
+}
+
+// apiBackend goes through the HTTP API, so everything the service does on a change also happens
+type apiBackend struct {
+	client *client.Client
+}
+
+func (b apiBackend) ListDonors(ctx context.Context) ([]client.Donor, error) {
+	donors := make([]client.Donor, 0)
+	it := b.client.Donors().Iterate(200)
+	for it.Next(ctx) {
+		donors = append(donors, it.Donor())
+	}
+	return donors, it.Err()
+}
+
+func (b apiBackend) GetDonor(ctx context.Context, id string) (*client.Donor, error) {
+	return b.client.Donors().Get(ctx, id)
+}
+
+func (b apiBackend) CreateDonor(ctx context.Context, input client.DonorInput) (*client.Donor, error) {
+	return b.client.Donors().Create(ctx, input)
+}
+
+func (b apiBackend) UpdateDonor(ctx context.Context, id string, input client.DonorInput) (*client.Donor, error) {
+	return b.client.Donors().Update(ctx, id, input)
+}
+
+func (b apiBackend) DeleteDonor(ctx context.Context, id string) error {
+	return b.client.Donors().Delete(ctx, id)
+}
+
+func (b apiBackend) ListAcceptors(ctx context.Context) ([]client.Acceptor, error) {
+	acceptors := make([]client.Acceptor, 0)
+	it := b.client.Acceptors().Iterate(200)
+	for it.Next(ctx) {
+		acceptors = append(acceptors, it.Acceptor())
+	}
+	return acceptors, it.Err()
+}
+
+func (b apiBackend) GetAcceptor(ctx context.Context, id string) (*client.Acceptor, error) {
+	return b.client.Acceptors().Get(ctx, id)
+}
+
+func (b apiBackend) CreateAcceptor(ctx context.Context, input client.AcceptorInput) (*client.Acceptor, error) {
+	return b.client.Acceptors().Create(ctx, input)
+}
+
+func (b apiBackend) UpdateAcceptor(ctx context.Context, id string, input client.AcceptorInput) (*client.Acceptor, error) {
+	return b.client.Acceptors().Update(ctx, id, input)
+}
+
+func (b apiBackend) DeleteAcceptor(ctx context.Context, id string) error {
+	return b.client.Acceptors().Delete(ctx, id)
+}
+
+// directBackend uses the MySQL repositories of the configured database, exports are read from its replicas.
+// Domain events are still written to the outbox, but no verification e-mails or donor notifications are sent.
+type directBackend struct {
+	donors      *app.DonorsMySQL
+	acceptors   *app.AcceptorsMySQL
+	persons     *app.PersonsMySQL
+	phoneRegion string
+}
+
+func newDirectBackend(cluster *app.DBCluster, phoneRegion string) (directBackend, error) {
+	donors, err := app.NewDonorsMySQL(cluster)
+	if err != nil {
+		return directBackend{}, err
+	}
+	acceptors, err := app.NewAcceptorsMySQL(cluster)
+	if err != nil {
+		donors.Close()
+		return directBackend{}, err
+	}
+	persons, err := app.NewPersonsMySQL(cluster.Primary())
+	if err != nil {
+		donors.Close()
+		acceptors.Close()
+		return directBackend{}, err
+	}
+
+	return directBackend{
+		donors:      donors,
+		acceptors:   acceptors,
+		persons:     persons,
+		phoneRegion: phoneRegion,
+	}, nil
+}
+
+func (b directBackend) ListDonors(ctx context.Context) ([]client.Donor, error) {
+	donors, err := b.donors.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]client.Donor, 0)
+	return result, convert(donors, &result)
+}
+
+func (b directBackend) GetDonor(ctx context.Context, id string) (*client.Donor, error) {
+	donor, err := b.donors.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, &client.Error{StatusCode: 404, Message: "donor not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &client.Donor{}
+	return result, convert(donor, result)
+}
+
+func (b directBackend) CreateDonor(ctx context.Context, input client.DonorInput) (*client.Donor, error) {
+	donor := app.Donor{
+		ID:               shortuuid.New(),
+		PersonID:         shortuuid.New(),
+		RegistrationDate: time.Now().UTC().Truncate(time.Second),
+	}
+	if input.PersonID != nil {
+		person, err := b.personWithout(ctx, *input.PersonID, app.RoleDonor)
+		if err != nil {
+			return nil, err
+		}
+		donor.AttachTo(person)
+	}
+	if err := b.applyDonorInput(&donor, input); err != nil {
+		return nil, err
+	}
+	if input.BloodGroup != nil {
+		donor.BloodGroup = *input.BloodGroup
+	}
+	if err := b.donors.Create(ctx, donor); err != nil {
+		return nil, err
+	}
+	result := &client.Donor{}
+	return result, convert(donor, result)
+}
+
+func (b directBackend) UpdateDonor(ctx context.Context, id string, input client.DonorInput) (*client.Donor, error) {
+	donor, err := b.donors.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, &client.Error{StatusCode: 404, Message: "donor not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := b.applyDonorInput(&donor, input); err != nil {
+		return nil, err
+	}
+	if donor, err = b.donors.Update(ctx, donor); err != nil {
+		return nil, err
+	}
+	result := &client.Donor{}
+	return result, convert(donor, result)
+}
+
+func (b directBackend) DeleteDonor(ctx context.Context, id string) error {
+	return b.donors.DeleteByID(ctx, id)
+}
+
+func (b directBackend) ListAcceptors(ctx context.Context) ([]client.Acceptor, error) {
+	acceptors, err := b.acceptors.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]client.Acceptor, 0)
+	return result, convert(acceptors, &result)
+}
+
+func (b directBackend) GetAcceptor(ctx context.Context, id string) (*client.Acceptor, error) {
+	acceptor, err := b.acceptors.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, &client.Error{StatusCode: 404, Message: "acceptor not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &client.Acceptor{}
+	return result, convert(acceptor, result)
+}
+
+func (b directBackend) CreateAcceptor(ctx context.Context, input client.AcceptorInput) (*client.Acceptor, error) {
+	acceptor := app.Acceptor{
+		ID:               shortuuid.New(),
+		PersonID:         shortuuid.New(),
+		RegistrationDate: time.Now().UTC().Truncate(time.Second),
+	}
+	if input.PersonID != nil {
+		person, err := b.personWithout(ctx, *input.PersonID, app.RoleAcceptor)
+		if err != nil {
+			return nil, err
+		}
+		acceptor.AttachTo(person)
+	}
+	applyAcceptorInput(&acceptor, input)
+	if input.BloodGroup != nil {
+		acceptor.BloodGroup = *input.BloodGroup
+	}
+	if err := b.acceptors.Create(ctx, acceptor); err != nil {
+		return nil, err
+	}
+	result := &client.Acceptor{}
+	return result, convert(acceptor, result)
+}
+
+func (b directBackend) UpdateAcceptor(ctx context.Context, id string, input client.AcceptorInput) (*client.Acceptor, error) {
+	acceptor, err := b.acceptors.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, &client.Error{StatusCode: 404, Message: "acceptor not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	applyAcceptorInput(&acceptor, input)
+	if acceptor, err = b.acceptors.Update(ctx, acceptor); err != nil {
+		return nil, err
+	}
+	result := &client.Acceptor{}
+	return result, convert(acceptor, result)
+}
+
+func (b directBackend) DeleteAcceptor(ctx context.Context, id string) error {
+	return b.acceptors.DeleteByID(ctx, id)
+}
+
+// personWithout loads the person a new account is registered for, which must not hold the role yet
+func (b directBackend) personWithout(ctx context.Context, id, role string) (app.Person, error) {
+	person, err := b.persons.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return person, &client.Error{StatusCode: 400, Message: "personId does not name a registered person"}
+	}
+	if err != nil {
+		return person, err
+	}
+
+	if role == app.RoleDonor {
+		_, err = b.donors.GetByPersonID(ctx, id)
+	} else {
+		_, err = b.acceptors.GetByPersonID(ctx, id)
+	}
+	if err == nil {
+		return person, &client.Error{StatusCode: 409, Message: "the person already is a " + role}
+	}
+	if err != sql.ErrNoRows {
+		return person, err
+	}
+	return person, nil
+}
+
+// applyDonorInput copies the updatable fields, the blood group is only set on creation like the API does
+func (b directBackend) applyDonorInput(donor *app.Donor, input client.DonorInput) error {
+	setString(&donor.FirstName, input.FirstName)
+	setString(&donor.LastName, input.LastName)
+	setString(&donor.Gender, input.Gender)
+	setString(&donor.City, input.City)
+	if input.Email != nil && *input.Email != donor.Email {
+		donor.Email = *input.Email
+		donor.EmailVerified = false
+		donor.EmailVerifiedAt = nil
+	}
+	if input.PhoneNumber != nil {
+		phone, err := app.NormalizePhone(*input.PhoneNumber, b.phoneRegion)
+		if err != nil {
+			return err
+		}
+		if phone != donor.PhoneNumber {
+			donor.PhoneNumber = phone
+			donor.PhoneVerified = false
+			donor.PhoneVerifiedAt = nil
+		}
+	}
+	if input.DateOfBirth != nil {
+		dateOfBirth, err := app.ParseDateOfBirth(*input.DateOfBirth, time.Now())
+		if err != nil {
+			return err
+		}
+		donor.DateOfBirth = dateOfBirth
+	}
+	if input.NotificationsOptIn != nil {
+		donor.NotificationsOptIn = *input.NotificationsOptIn
+	}
+	return nil
+}
+
+func applyAcceptorInput(acceptor *app.Acceptor, input client.AcceptorInput) {
+	setString(&acceptor.FirstName, input.FirstName)
+	setString(&acceptor.LastName, input.LastName)
+	setString(&acceptor.City, input.City)
+	setString(&acceptor.BloodCenter, input.BloodCenter)
+	if input.Urgent != nil {
+		acceptor.Urgent = *input.Urgent
+	}
+}
+
+func setString(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// convert copies between the service and client representations, which share their JSON form
+func convert(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
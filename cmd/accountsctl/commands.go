@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/life-blood/accounts-service/client"
+)
+
+// command runs the subcommands against a backend
+type command struct {
+	backend backend
+	printer printer
+	// stderr receives the summaries of import and seed, stdout is left to the records
+	stderr io.Writer
+}
+
+func (c *command) donors(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		donors, err := c.backend.ListDonors(ctx)
+		if err != nil {
+			return err
+		}
+		return c.printer.donors(donors)
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		donor, err := c.backend.GetDonor(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return c.printer.donors([]client.Donor{*donor})
+	case "create":
+		input, err := parseDonorInput(c.flagSet("donors create"), args[1:])
+		if err != nil {
+			return err
+		}
+		donor, err := c.backend.CreateDonor(ctx, input)
+		if err != nil {
+			return err
+		}
+		return c.printer.donors([]client.Donor{*donor})
+	case "update":
+		if len(args) < 2 {
+			return errUsage
+		}
+		input, err := parseDonorInput(c.flagSet("donors update"), args[2:])
+		if err != nil {
+			return err
+		}
+		donor, err := c.backend.UpdateDonor(ctx, args[1], input)
+		if err != nil {
+			return err
+		}
+		return c.printer.donors([]client.Donor{*donor})
+	case "delete":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return c.backend.DeleteDonor(ctx, args[1])
+	default:
+		return errUsage
+	}
+}
+
+func (c *command) acceptors(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		acceptors, err := c.backend.ListAcceptors(ctx)
+		if err != nil {
+			return err
+		}
+		return c.printer.acceptors(acceptors)
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		acceptor, err := c.backend.GetAcceptor(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return c.printer.acceptors([]client.Acceptor{*acceptor})
+	case "create":
+		input, err := parseAcceptorInput(c.flagSet("acceptors create"), args[1:])
+		if err != nil {
+			return err
+		}
+		acceptor, err := c.backend.CreateAcceptor(ctx, input)
+		if err != nil {
+			return err
+		}
+		return c.printer.acceptors([]client.Acceptor{*acceptor})
+	case "update":
+		if len(args) < 2 {
+			return errUsage
+		}
+		input, err := parseAcceptorInput(c.flagSet("acceptors update"), args[2:])
+		if err != nil {
+			return err
+		}
+		acceptor, err := c.backend.UpdateAcceptor(ctx, args[1], input)
+		if err != nil {
+			return err
+		}
+		return c.printer.acceptors([]client.Acceptor{*acceptor})
+	case "delete":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return c.backend.DeleteAcceptor(ctx, args[1])
+	default:
+		return errUsage
+	}
+}
+
+// export writes every account of a kind, use -format csv or json for a file that import accepts
+func (c *command) export(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return c.withKind(ctx, args[0], "list")
+}
+
+func (c *command) withKind(ctx context.Context, kind string, subcommand string) error {
+	switch kind {
+	case "donors":
+		return c.donors(ctx, []string{subcommand})
+	case "acceptors":
+		return c.acceptors(ctx, []string{subcommand})
+	default:
+		return errUsage
+	}
+}
+
+// importFile creates an account for every record of a JSON array or a CSV file with a header row.
+// Identifiers and registration dates in the file are ignored, new ones are assigned.
+func (c *command) importFile(ctx context.Context, args []string) error {
+	if len(args) != 2 || (args[0] != "donors" && args[0] != "acceptors") {
+		return errUsage
+	}
+
+	records, err := readRecords(args[1])
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	for i, record := range records {
+		if args[0] == "donors" {
+			input := client.DonorInput{}
+			if err = json.Unmarshal(record, &input); err == nil {
+				_, err = c.backend.CreateDonor(ctx, input)
+			}
+		} else {
+			input := client.AcceptorInput{}
+			if err = json.Unmarshal(record, &input); err == nil {
+				_, err = c.backend.CreateAcceptor(ctx, input)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %s (%d imported)", i+1, err.Error(), created)
+		}
+		created++
+	}
+
+	fmt.Fprintf(c.stderr, "%d %s imported\n", created, args[0])
+	return nil
+}
+
+// stats counts donors and acceptors per blood group
+func (c *command) stats(ctx context.Context) error {
+	donors, err := c.backend.ListDonors(ctx)
+	if err != nil {
+		return err
+	}
+	acceptors, err := c.backend.ListAcceptors(ctx)
+	if err != nil {
+		return err
+	}
+
+	type stat struct {
+		Kind       string `json:"kind"`
+		BloodGroup string `json:"bloodGroup"`
+		Count      int    `json:"count"`
+	}
+	counts := map[[2]string]int{}
+	for _, d := range donors {
+		counts[[2]string{"donors", d.BloodGroup}]++
+	}
+	for _, a := range acceptors {
+		counts[[2]string{"acceptors", a.BloodGroup}]++
+	}
+
+	stats := make([]stat, 0, len(counts))
+	for key, count := range counts {
+		stats = append(stats, stat{Kind: key[0], BloodGroup: key[1], Count: count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind > stats[j].Kind
+		}
+		return stats[i].BloodGroup < stats[j].BloodGroup
+	})
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, []string{s.Kind, s.BloodGroup, strconv.Itoa(s.Count)})
+	}
+	return c.printer.print(stats, []string{"kind", "bloodGroup", "count"}, rows)
+}
+
+var (
+	seedFirstNames = []string{"Ivan", "Georgi", "Dimitar", "Nikolay", "Petar", "Maria", "Elena", "Ivanka", "Yordanka", "Desislava"}
+	seedLastNames  = []string{"Petrov", "Ivanov", "Georgiev", "Dimitrov", "Nikolov", "Stoyanov", "Todorov", "Kolev"}
+	seedCities     = []string{"Sofia", "Plovdiv", "Varna", "Burgas", "Ruse", "Stara Zagora", "Pleven", "Pernik"}
+	seedGroups     = []string{"0-", "0+", "A-", "A+", "B-", "B+", "AB-", "AB+"}
+	seedCenters    = []string{"РЦ по трансфузионна хематология - Пловдив", "РЦ по трансфузионна хематология - Варна", "НЦ по трансфузионна хематология - София"}
+)
+
+// seed creates random accounts for development environments
+func (c *command) seed(ctx context.Context, args []string) error {
+	fs := c.flagSet("seed")
+	donors := fs.Int("donors", 20, "number of donors to create")
+	acceptors := fs.Int("acceptors", 5, "number of acceptors to create")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := func(values []string) *string { return client.String(values[random.Intn(len(values))]) }
+
+	for i := 0; i < *donors; i++ {
+		first, last := pick(seedFirstNames), pick(seedLastNames)
+		_, err := c.backend.CreateDonor(ctx, client.DonorInput{
+			FirstName:          first,
+			LastName:           last,
+			PhoneNumber:        client.String(fmt.Sprintf("08%d%07d", 7+random.Intn(3), random.Intn(10000000))),
+			Email:              client.String(fmt.Sprintf("%s.%s.%d@example.com", strings.ToLower(*first), strings.ToLower(*last), random.Intn(10000))),
+			DateOfBirth:        client.String(time.Now().AddDate(-18-random.Intn(48), 0, -random.Intn(365)).Format("2006-01-02")),
+			Gender:             pick([]string{"MALE", "FEMALE"}),
+			BloodGroup:         pick(seedGroups),
+			City:               pick(seedCities),
+			NotificationsOptIn: client.Bool(random.Intn(2) == 0),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < *acceptors; i++ {
+		_, err := c.backend.CreateAcceptor(ctx, client.AcceptorInput{
+			FirstName:   pick(seedFirstNames),
+			LastName:    pick(seedLastNames),
+			BloodGroup:  pick(seedGroups),
+			City:        pick(seedCities),
+			BloodCenter: pick(seedCenters),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.stderr, "%d donors and %d acceptors created\n", *donors, *acceptors)
+	return nil
+}
+
+func parseDonorInput(fs *flag.FlagSet, args []string) (client.DonorInput, error) {
+	fields := map[string]*string{}
+	for _, field := range []string{"personId", "name", "lastName", "phone", "email", "dateOfBirth", "gender", "bloodGroup", "city"} {
+		fields[field] = fs.String(field, "", "donor "+field)
+	}
+	optIn := fs.Bool("notificationsOptIn", false, "whether the donor agrees to be notified")
+	given, err := parseGiven(fs, args)
+	if err != nil {
+		return client.DonorInput{}, err
+	}
+	optional := optionalFields(fields, given)
+
+	input := client.DonorInput{
+		PersonID:    optional("personId"),
+		FirstName:   optional("name"),
+		LastName:    optional("lastName"),
+		PhoneNumber: optional("phone"),
+		Email:       optional("email"),
+		DateOfBirth: optional("dateOfBirth"),
+		Gender:      optional("gender"),
+		BloodGroup:  optional("bloodGroup"),
+		City:        optional("city"),
+	}
+	if given["notificationsOptIn"] {
+		input.NotificationsOptIn = optIn
+	}
+	return input, nil
+}
+
+func parseAcceptorInput(fs *flag.FlagSet, args []string) (client.AcceptorInput, error) {
+	fields := map[string]*string{}
+	for _, field := range []string{"personId", "name", "lastName", "bloodGroup", "city", "bloodCenter"} {
+		fields[field] = fs.String(field, "", "acceptor "+field)
+	}
+	urgent := fs.Bool("urgent", false, "whether the need is urgent")
+	given, err := parseGiven(fs, args)
+	if err != nil {
+		return client.AcceptorInput{}, err
+	}
+	optional := optionalFields(fields, given)
+
+	input := client.AcceptorInput{
+		PersonID:    optional("personId"),
+		FirstName:   optional("name"),
+		LastName:    optional("lastName"),
+		BloodGroup:  optional("bloodGroup"),
+		City:        optional("city"),
+		BloodCenter: optional("bloodCenter"),
+	}
+	if given["urgent"] {
+		input.Urgent = urgent
+	}
+	return input, nil
+}
+
+// flagSet flags of a subcommand, their errors and help go to the command's stderr
+func (c *command) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parseGiven parses the flags and returns the names of those present on the command line,
+// so updates only send the fields the operator asked to change
+func parseGiven(fs *flag.FlagSet, args []string) (map[string]bool, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given, nil
+}
+
+func optionalFields(fields map[string]*string, given map[string]bool) func(name string) *string {
+	return func(name string) *string {
+		if given[name] {
+			return fields[name]
+		}
+		return nil
+	}
+}
+
+// boolColumns are CSV columns converted to JSON booleans on import
+var boolColumns = map[string]bool{"notificationsOptIn": true, "urgent": true}
+
+// readRecords returns the records of a JSON array or CSV file as JSON objects
+func readRecords(path string) ([]json.RawMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]json.RawMessage, 0)
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err := json.NewDecoder(file).Decode(&records)
+		return records, err
+	}
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := map[string]interface{}{}
+		for i, column := range header {
+			if i >= len(row) || row[i] == "" {
+				continue
+			}
+			if boolColumns[column] {
+				value, err := strconv.ParseBool(row[i])
+				if err != nil {
+					return nil, fmt.Errorf("column %s: %s", column, err.Error())
+				}
+				record[column] = value
+			} else {
+				record[column] = row[i]
+			}
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, data)
+	}
+}
@@ -0,0 +1,157 @@
+// Command accountsctl lets operators manage LifeBlood accounts from the command line.
+//
+// Usage:
+//
+//	accountsctl [global flags] donors list|get|create|update|delete [flags] [id]
+//	accountsctl [global flags] acceptors list|get|create|update|delete [flags] [id]
+//	accountsctl [global flags] import donors|acceptors <file.json|file.csv>
+//	accountsctl [global flags] export donors|acceptors
+//	accountsctl [global flags] stats
+//	accountsctl [global flags] seed [-donors n] [-acceptors n]
+//
+// By default the HTTP API at -api is used. With -direct the database configured in the .env file, or the
+// configuration file given by -config, is used instead.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/life-blood/accounts-service/client"
+	db "github.com/life-blood/accounts-service/config"
+)
+
+// errUsage is returned for malformed command lines, the usage is printed instead of the error
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(execute(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// execute runs the command line and returns the exit status: 0 on success, 2 for a malformed command
+// line, whose usage is printed, and 1 for any other error
+func execute(args []string, stdout, stderr io.Writer) int {
+	err := run(args, stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case err == errUsage:
+		usage(stderr)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "accountsctl: %s\n", err.Error())
+		return 1
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	global := flag.NewFlagSet("accountsctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	// the usage is printed once, by execute
+	global.Usage = func() {}
+	apiURL := global.String("api", envOr("ACCOUNTS_API_URL", "http://localhost:4200"), "base URL of the accounts service")
+	token := global.String("token", os.Getenv("ACCOUNTS_API_TOKEN"), "bearer token sent to the API")
+	direct := global.Bool("direct", false, "use the configured database instead of the HTTP API")
+	envFile := global.String("env", ".env", "environment file used with -direct")
+	configFile := global.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON configuration file used with -direct")
+	format := global.String("format", formatTable, "output format: table, json or csv")
+	timeout := global.Duration("timeout", time.Minute, "timeout of the whole command")
+	if err := global.Parse(args); err != nil {
+		return errUsage
+	}
+	if global.NArg() == 0 {
+		return errUsage
+	}
+
+	b, err := newBackend(*direct, *envFile, *configFile, *apiURL, *token)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cmd := &command{backend: b, printer: printer{out: stdout, format: *format}, stderr: stderr}
+	rest := global.Args()[1:]
+	switch global.Arg(0) {
+	case "donors":
+		return cmd.donors(ctx, rest)
+	case "acceptors":
+		return cmd.acceptors(ctx, rest)
+	case "import":
+		return cmd.importFile(ctx, rest)
+	case "export":
+		return cmd.export(ctx, rest)
+	case "stats":
+		return cmd.stats(ctx)
+	case "seed":
+		return cmd.seed(ctx, rest)
+	default:
+		return errUsage
+	}
+}
+
+// newBackend opens the backend selected by the global flags, tests replace it
+var newBackend = openBackend
+
+func openBackend(direct bool, envFile, configFile, apiURL, token string) (backend, error) {
+	if !direct {
+		c, err := client.New(apiURL, client.WithToken(token), client.WithUserAgent("accountsctl"))
+		if err != nil {
+			return nil, err
+		}
+		return apiBackend{client: c}, nil
+	}
+
+	if err := godotenv.Load(envFile); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading %s: %s", envFile, err.Error())
+	}
+	config, err := db.LoadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := db.CreateDBCluster(config.Database)
+	if err != nil {
+		return nil, err
+	}
+	//a single check is enough for a command, replicas that are down are skipped
+	cluster.CheckReplicas(context.Background())
+	return newDirectBackend(cluster, config.Phone.DefaultRegion)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: accountsctl [global flags] <command> [arguments]
+
+Commands:
+  donors list|get <id>|create|update <id>|delete <id>
+  acceptors list|get <id>|create|update <id>|delete <id>
+  import donors|acceptors <file.json|file.csv>
+  export donors|acceptors
+  stats
+  seed [-donors n] [-acceptors n]
+
+Global flags:
+  -api URL        base URL of the accounts service (ACCOUNTS_API_URL, default http://localhost:4200)
+  -token TOKEN    bearer token sent to the API (ACCOUNTS_API_TOKEN)
+  -direct         use the database configured in -env or -config instead of the API
+  -env FILE       environment file used with -direct, if it exists (default .env)
+  -config FILE    YAML or JSON configuration file used with -direct (CONFIG_FILE)
+  -format FORMAT  table, json or csv (default table)
+  -timeout D      timeout of the whole command (default 1m)
+
+Run "accountsctl donors create -h" to see the fields of an account.
+`)
+}
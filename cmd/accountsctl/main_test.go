@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/client"
+)
+
+var registered = time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// fakeBackend keeps accounts in memory, unknown ids answer like the API does
+type fakeBackend struct {
+	donors    []client.Donor
+	acceptors []client.Acceptor
+	updates   []client.DonorInput
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		donors: []client.Donor{
+			{ID: "d1", PersonID: "p1", FirstName: "Ivan", LastName: "Petrov", PhoneNumber: "+359888123456", Email: "ivan@example.com",
+				DateOfBirth: "1990-04-12", Age: 30, Gender: "MALE", BloodGroup: "A+", City: "Sofia", RegistrationDate: registered, EmailVerified: true},
+			{ID: "d2", PersonID: "p2", FirstName: "Maria", LastName: "Ivanova", BloodGroup: "0-", City: "Varna", RegistrationDate: registered},
+		},
+		acceptors: []client.Acceptor{
+			{ID: "a1", PersonID: "p3", FirstName: "Elena", LastName: "Koleva", BloodGroup: "A+", City: "Plovdiv",
+				BloodCenter: "РЦ по трансфузионна хематология - Пловдив", RegistrationDate: registered, Urgent: true},
+		},
+	}
+}
+
+func notFound(kind string) error {
+	return &client.Error{StatusCode: http.StatusNotFound, Message: kind + " not found"}
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (b *fakeBackend) ListDonors(ctx context.Context) ([]client.Donor, error) {
+	return b.donors, nil
+}
+
+func (b *fakeBackend) GetDonor(ctx context.Context, id string) (*client.Donor, error) {
+	for i := range b.donors {
+		if b.donors[i].ID == id {
+			return &b.donors[i], nil
+		}
+	}
+	return nil, notFound("donor")
+}
+
+func (b *fakeBackend) CreateDonor(ctx context.Context, input client.DonorInput) (*client.Donor, error) {
+	if input.Email != nil && !strings.Contains(*input.Email, "@") {
+		return nil, &client.Error{StatusCode: http.StatusBadRequest, Message: "invalid email"}
+	}
+	donor := client.Donor{ID: fmt.Sprintf("d%d", len(b.donors)+1), FirstName: value(input.FirstName), LastName: value(input.LastName),
+		Email: value(input.Email), BloodGroup: value(input.BloodGroup), City: value(input.City), RegistrationDate: registered}
+	b.donors = append(b.donors, donor)
+	return &donor, nil
+}
+
+func (b *fakeBackend) UpdateDonor(ctx context.Context, id string, input client.DonorInput) (*client.Donor, error) {
+	donor, err := b.GetDonor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	b.updates = append(b.updates, input)
+	if input.City != nil {
+		donor.City = *input.City
+	}
+	return donor, nil
+}
+
+func (b *fakeBackend) DeleteDonor(ctx context.Context, id string) error {
+	for i := range b.donors {
+		if b.donors[i].ID == id {
+			b.donors = append(b.donors[:i], b.donors[i+1:]...)
+			return nil
+		}
+	}
+	return notFound("donor")
+}
+
+func (b *fakeBackend) ListAcceptors(ctx context.Context) ([]client.Acceptor, error) {
+	return b.acceptors, nil
+}
+
+func (b *fakeBackend) GetAcceptor(ctx context.Context, id string) (*client.Acceptor, error) {
+	for i := range b.acceptors {
+		if b.acceptors[i].ID == id {
+			return &b.acceptors[i], nil
+		}
+	}
+	return nil, notFound("acceptor")
+}
+
+func (b *fakeBackend) CreateAcceptor(ctx context.Context, input client.AcceptorInput) (*client.Acceptor, error) {
+	acceptor := client.Acceptor{ID: fmt.Sprintf("a%d", len(b.acceptors)+1), FirstName: value(input.FirstName), LastName: value(input.LastName),
+		BloodGroup: value(input.BloodGroup), City: value(input.City), BloodCenter: value(input.BloodCenter), RegistrationDate: registered}
+	b.acceptors = append(b.acceptors, acceptor)
+	return &acceptor, nil
+}
+
+func (b *fakeBackend) UpdateAcceptor(ctx context.Context, id string, input client.AcceptorInput) (*client.Acceptor, error) {
+	acceptor, err := b.GetAcceptor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.Urgent != nil {
+		acceptor.Urgent = *input.Urgent
+	}
+	return acceptor, nil
+}
+
+func (b *fakeBackend) DeleteAcceptor(ctx context.Context, id string) error {
+	for i := range b.acceptors {
+		if b.acceptors[i].ID == id {
+			b.acceptors = append(b.acceptors[:i], b.acceptors[i+1:]...)
+			return nil
+		}
+	}
+	return notFound("acceptor")
+}
+
+// runFake runs the command line against fake and returns the exit status and both outputs
+func runFake(t *testing.T, fake *fakeBackend, args ...string) (int, string, string) {
+	t.Helper()
+	previous := newBackend
+	defer func() { newBackend = previous }()
+	newBackend = func(direct bool, envFile, configFile, apiURL, token string) (backend, error) {
+		if direct {
+			return nil, fmt.Errorf("no database in tests")
+		}
+		return fake, nil
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := execute(args, stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"donors list", []string{"donors", "list"}, 0,
+			"ID  PERSONID  NAME   LASTNAME  PHONE          EMAIL             DATEOFBIRTH  AGE  GENDER  BLOODGROUP  CITY   REGDATE               EMAILVERIFIED  PHONEVERIFIED  NOTIFICATIONSOPTIN\n" +
+				"d1  p1        Ivan   Petrov    +359888123456  ivan@example.com  1990-04-12   30   MALE    A+          Sofia  2020-05-01T10:00:00Z  true           false          false\n" +
+				"d2  p2        Maria  Ivanova                                                              0-          Varna  2020-05-01T10:00:00Z  false          false          false\n", ""},
+		{"acceptor as CSV", []string{"-format", "csv", "acceptors", "get", "a1"}, 0,
+			"id,personId,name,lastName,bloodGroup,city,bloodCenter,regDate,urgent\n" +
+				"a1,p3,Elena,Koleva,A+,Plovdiv,РЦ по трансфузионна хематология - Пловдив,2020-05-01T10:00:00Z,true\n", ""},
+		{"export as CSV", []string{"-format", "csv", "export", "acceptors"}, 0,
+			"id,personId,name,lastName,bloodGroup,city,bloodCenter,regDate,urgent\n" +
+				"a1,p3,Elena,Koleva,A+,Plovdiv,РЦ по трансфузионна хематология - Пловдив,2020-05-01T10:00:00Z,true\n", ""},
+		{"stats", []string{"stats"}, 0,
+			"KIND       BLOODGROUP  COUNT\ndonors     0-          1\ndonors     A+          1\nacceptors  A+          1\n", ""},
+		{"create", []string{"-format", "csv", "donors", "create", "-name", "Petar", "-email", "petar@example.com", "-city", "Ruse"}, 0,
+			strings.Join(donorHeader, ",") + "\nd3,,Petar,,,petar@example.com,,,,,Ruse,2020-05-01T10:00:00Z,false,false,false\n", ""},
+		{"delete", []string{"donors", "delete", "d2"}, 0, "", ""},
+
+		{"unknown donor", []string{"donors", "get", "d9"}, 1, "", "accountsctl: accounts service: 404 donor not found\n"},
+		{"refused input", []string{"donors", "create", "-email", "petar"}, 1, "", "accountsctl: accounts service: 400 invalid email\n"},
+		{"unknown format", []string{"-format", "xml", "donors", "list"}, 1, "", "accountsctl: unknown output format \"xml\", use table, json or csv\n"},
+		{"backend failing to open", []string{"-direct", "stats"}, 1, "", "accountsctl: no database in tests\n"},
+
+		{"no command", nil, 2, "", "Usage: accountsctl"},
+		{"unknown command", []string{"donate"}, 2, "", "Usage: accountsctl"},
+		{"unknown subcommand", []string{"donors", "merge"}, 2, "", "Usage: accountsctl"},
+		{"missing id", []string{"acceptors", "get"}, 2, "", "Usage: accountsctl"},
+		{"unknown global flag", []string{"-verbose", "stats"}, 2, "", "flag provided but not defined: -verbose\nUsage: accountsctl"},
+		{"unknown field", []string{"donors", "update", "d1", "-nickname", "Vanko"}, 2, "", "flag provided but not defined: -nickname\n"},
+		{"unknown kind", []string{"export", "persons"}, 2, "", "Usage: accountsctl"},
+	}
+
+	for _, tt := range tests {
+		code, stdout, stderr := runFake(t, newFakeBackend(), tt.args...)
+		if code != tt.wantCode {
+			t.Errorf("%s: exit status %d, want %d, stderr %s", tt.name, code, tt.wantCode, stderr)
+		}
+		if stdout != tt.wantStdout {
+			t.Errorf("%s: stdout\n%s\nwant\n%s", tt.name, stdout, tt.wantStdout)
+		}
+		if !strings.HasPrefix(stderr, tt.wantStderr) || (tt.wantStderr == "" && tt.wantCode != 2 && stderr != "") {
+			t.Errorf("%s: stderr %q, want it to start with %q", tt.name, stderr, tt.wantStderr)
+		}
+		// the usage is printed once
+		if strings.Count(stderr, "Usage: accountsctl") > 1 {
+			t.Errorf("%s: usage printed more than once", tt.name)
+		}
+	}
+}
+
+func TestExecuteChangesAccounts(t *testing.T) {
+	backend := newFakeBackend()
+
+	code, stdout, _ := runFake(t, backend, "-format", "json", "donors", "update", "d1", "-city", "Plovdiv")
+	var donors []client.Donor
+	if code != 0 || json.Unmarshal([]byte(stdout), &donors) != nil || len(donors) != 1 || donors[0].ID != "d1" || donors[0].City != "Plovdiv" {
+		t.Errorf("update = %d %s, want the donor as JSON", code, stdout)
+	}
+	// only the flags given are sent
+	if len(backend.updates) != 1 || backend.updates[0] != (client.DonorInput{City: backend.updates[0].City}) {
+		t.Errorf("update sent %+v, want the city only", backend.updates)
+	}
+
+	if code, _, _ := runFake(t, backend, "donors", "delete", "d1"); code != 0 {
+		t.Errorf("delete = %d", code)
+	}
+	if code, _, stderr := runFake(t, backend, "donors", "delete", "d1"); code != 1 || !strings.Contains(stderr, "404") {
+		t.Errorf("second delete = %d %s, want the 404 reported", code, stderr)
+	}
+	if len(backend.donors) != 1 || backend.donors[0].ID != "d2" {
+		t.Errorf("donors %+v, want d2 left", backend.donors)
+	}
+}
+
+func TestExecuteImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accountsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name       string
+		kind       string
+		file       string
+		wantCode   int
+		wantStderr string
+		wantIDs    []string
+	}{
+		{"CSV", "donors", write("donors.csv", "id,name,email,city,notificationsOptIn\nx1,Petar,petar@example.com,Ruse,true\nx2,Nikol,,Pleven,\n"),
+			0, "2 donors imported\n", []string{"d1", "d2", "d3", "d4"}},
+		{"JSON", "acceptors", write("acceptors.json", `[{"name": "Georgi", "bloodCenter": "НЦ по трансфузионна хематология - София", "urgent": true}]`),
+			0, "1 acceptors imported\n", []string{"a1", "a2"}},
+		// the records before the refused one stay imported
+		{"refused record", "donors", write("refused.json", `[{"name": "Petar"}, {"email": "petar"}, {"name": "Nikol"}]`),
+			1, "accountsctl: record 2: accounts service: 400 invalid email (1 imported)\n", []string{"d1", "d2", "d3"}},
+		{"CSV with a bad flag", "donors", write("bad.csv", "name,notificationsOptIn\nPetar,maybe\n"),
+			1, "accountsctl: column notificationsOptIn: ", []string{"d1", "d2"}},
+		{"missing file", "donors", filepath.Join(dir, "missing.csv"), 1, "accountsctl: open ", []string{"d1", "d2"}},
+	}
+
+	for _, tt := range tests {
+		backend := newFakeBackend()
+		code, stdout, stderr := runFake(t, backend, "import", tt.kind, tt.file)
+		if code != tt.wantCode || stdout != "" || !strings.HasPrefix(stderr, tt.wantStderr) {
+			t.Errorf("%s: %d, stdout %q, stderr %q, want %d and %q", tt.name, code, stdout, stderr, tt.wantCode, tt.wantStderr)
+		}
+
+		ids := make([]string, 0)
+		if tt.kind == "donors" {
+			for _, d := range backend.donors {
+				ids = append(ids, d.ID)
+			}
+		} else {
+			for _, a := range backend.acceptors {
+				ids = append(ids, a.ID)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+			t.Errorf("%s: %s %v, want %v", tt.name, tt.kind, ids, tt.wantIDs)
+		}
+	}
+
+	backend := newFakeBackend()
+	runFake(t, backend, "import", "donors", filepath.Join(dir, "donors.csv"))
+	if imported := backend.donors[2]; imported.FirstName != "Petar" || imported.Email != "petar@example.com" || imported.City != "Ruse" {
+		t.Errorf("imported %+v, want the fields of the first row", imported)
+	}
+}
+
+func TestExecuteSeed(t *testing.T) {
+	backend := newFakeBackend()
+	code, stdout, stderr := runFake(t, backend, "seed", "-donors", "3", "-acceptors", "2")
+	if code != 0 || stdout != "" || stderr != "3 donors and 2 acceptors created\n" {
+		t.Errorf("seed = %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if len(backend.donors) != 5 || len(backend.acceptors) != 3 {
+		t.Errorf("%d donors and %d acceptors, want 3 and 2 more", len(backend.donors), len(backend.acceptors))
+	}
+	for _, d := range backend.donors[2:] {
+		if d.FirstName == "" || d.LastName == "" || !strings.HasSuffix(d.Email, "@example.com") || d.BloodGroup == "" || d.City == "" {
+			t.Errorf("seeded donor %+v", d)
+		}
+	}
+
+	if code, _, _ := runFake(t, backend, "seed", "-donors", "many"); code != 2 {
+		t.Errorf("seed with a malformed count = %d, want 2", code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/life-blood/accounts-service/client"
+)
+
+// Output formats selected with -format
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+var donorHeader = []string{"id", "personId", "name", "lastName", "phone", "email", "dateOfBirth", "age", "gender", "bloodGroup", "city", "regDate", "emailVerified", "phoneVerified", "notificationsOptIn"}
+
+var acceptorHeader = []string{"id", "personId", "name", "lastName", "bloodGroup", "city", "bloodCenter", "regDate", "urgent"}
+
+func donorRow(d client.Donor) []string {
+	return []string{d.ID, d.PersonID, d.FirstName, d.LastName, d.PhoneNumber, d.Email, d.DateOfBirth, optionalInt(d.Age), d.Gender, d.BloodGroup, d.City, d.RegistrationDate.Format(time.RFC3339),
+		strconv.FormatBool(d.EmailVerified), strconv.FormatBool(d.PhoneVerified), strconv.FormatBool(d.NotificationsOptIn)}
+}
+
+func acceptorRow(a client.Acceptor) []string {
+	return []string{a.ID, a.PersonID, a.FirstName, a.LastName, a.BloodGroup, a.City, a.BloodCenter, a.RegistrationDate.Format(time.RFC3339), strconv.FormatBool(a.Urgent)}
+}
+
+// optionalInt leaves zero values, which the API omits, empty
+func optionalInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// printer writes records in the selected format
+type printer struct {
+	out    io.Writer
+	format string
+}
+
+// print writes value as JSON, or header and rows as a table or CSV
+func (p printer) print(value interface{}, header []string, rows [][]string) error {
+	switch p.format {
+	case formatJSON:
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case formatCSV:
+		writer := csv.NewWriter(p.out)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	case formatTable:
+		writer := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q, use table, json or csv", p.format)
+	}
+}
+
+func (p printer) donors(donors []client.Donor) error {
+	rows := make([][]string, 0, len(donors))
+	for _, d := range donors {
+		rows = append(rows, donorRow(d))
+	}
+	return p.print(donors, donorHeader, rows)
+}
+
+func (p printer) acceptors(acceptors []client.Acceptor) error {
+	rows := make([][]string, 0, len(acceptors))
+	for _, a := range acceptors {
+		rows = append(rows, acceptorRow(a))
+	}
+	return p.print(acceptors, acceptorHeader, rows)
+}
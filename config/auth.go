@@ -0,0 +1,43 @@
+package config
+
+import (
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	authAccessTokenTTL       = "AUTH_ACCESS_TOKEN_TTL"
+	authRefreshTokenTTL      = "AUTH_REFRESH_TOKEN_TTL"
+	authRegistrationTokenTTL = "AUTH_REGISTRATION_TOKEN_TTL"
+	authPasswordMinLength    = "AUTH_PASSWORD_MIN_LENGTH"
+	authBcryptCost           = "AUTH_BCRYPT_COST"
+)
+
+const (
+	defaultAuthAccessTokenTTL       = 15 * time.Minute
+	defaultAuthRefreshTokenTTL      = 30 * 24 * time.Hour
+	defaultAuthRegistrationTokenTTL = time.Hour
+	defaultAuthPasswordMinLength    = 10
+	defaultAuthBcryptCost           = 12
+)
+
+//AuthConfig donor passwords and the sessions opened with them
+type AuthConfig struct {
+	//AccessTokenTTL how long an access token is accepted
+	AccessTokenTTL time.Duration
+	//RefreshTokenTTL how long a refresh token can be exchanged, every exchange starts it again
+	RefreshTokenTTL time.Duration
+	//RegistrationTokenTTL how long the token mailed to set the first password can be redeemed
+	RegistrationTokenTTL time.Duration
+	PasswordMinLength    int
+	//BcryptCost work factor of the password hashes, raising it applies to passwords set afterwards
+	BcryptCost int
+}
+
+//CreateAuthenticator build the password and session service
+func CreateAuthenticator(config AuthConfig, repo *app.AuthMySQL) *app.Authenticator {
+	return app.NewAuthenticator(repo, config.AccessTokenTTL, config.RefreshTokenTTL, config.RegistrationTokenTTL,
+		config.PasswordMinLength, config.BcryptCost)
+}
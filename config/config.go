@@ -0,0 +1,506 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	configFile         = "CONFIG_FILE"
+	storageBackend     = "STORAGE_BACKEND"
+	featureResetSchema = "FEATURE_RESET_SCHEMA"
+	featureMockData    = "FEATURE_MOCK_DATA"
+)
+
+//Config settings of the service, layered from defaults, a YAML or JSON file, environment variables and flags,
+//every later layer overriding the earlier ones
+type Config struct {
+	HTTP          HTTPConfig
+	Database      DatabaseConfig
+	Storage       StorageConfig
+	Features      FeaturesConfig
+	Log           LogConfig
+	Tracing       TracingConfig
+	Mail          MailConfig
+	Phone         PhoneConfig
+	Notifications NotificationsConfig
+	Events        EventsConfig
+	Webhooks      WebhooksConfig
+	CORS          CORSConfig
+	RateLimit     RateLimitConfig
+	Idempotency   IdempotencyConfig
+	Auth          AuthConfig
+
+	//sources remembers which layer set every key, keys left at their default are missing
+	sources map[string]string
+}
+
+//StorageConfig where accounts are stored, only "mysql" is available
+type StorageConfig struct {
+	Backend string
+}
+
+//FeaturesConfig toggles of optional behaviour
+type FeaturesConfig struct {
+	//ResetSchema drops and recreates the tables on startup, off by default and meant for local development
+	ResetSchema bool
+	//MockData inserts sample accounts on startup, off by default like ResetSchema
+	MockData bool
+	//RateLimit limits the requests of every client
+	RateLimit bool
+}
+
+//Secret value such as a password, redacted whenever it is printed
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+//Defaults configuration used for every setting no layer overrides
+func Defaults() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:              defaultHTTPAddr,
+			RequestTimeout:    defaultRequestTimeout,
+			ShutdownTimeout:   defaultShutdownTimeout,
+			ReadinessTimeout:  defaultReadyTimeout,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			ReadTimeout:       defaultReadTimeout,
+			IdleTimeout:       defaultIdleTimeout,
+		},
+		Database: DatabaseConfig{
+			Host:            defaultDBHost,
+			Port:            defaultDBPort,
+			Name:            defaultDBName,
+			Charset:         defaultDBCharset,
+			Collation:       defaultDBCollation,
+			ParseTime:       true,
+			Loc:             defaultDBLoc,
+			MaxOpenConns:    defaultDBMaxOpenConns,
+			MaxIdleConns:    defaultDBMaxIdleConns,
+			ConnMaxLifetime: defaultDBConnMaxLifetime,
+			ConnectAttempts: defaultDBConnectAttempts,
+			ConnectBackoff:  defaultDBConnectBackoff,
+
+			ReplicaCheckInterval: defaultDBReplicaCheckInterval,
+		},
+		Storage:  StorageConfig{Backend: "mysql"},
+		Features: FeaturesConfig{RateLimit: true},
+		Log:      LogConfig{Level: app.LevelInfo.String()},
+		Tracing:  TracingConfig{Exporter: "none", File: defaultTraceFile},
+		Mail: MailConfig{
+			Transport: "outbox",
+			SMTPPort:  defaultSMTPPort,
+			TokenTTL:  defaultEmailTokenTTL,
+		},
+		Phone: PhoneConfig{
+			DefaultRegion: app.DefaultPhoneRegion,
+			SMSTransport:  "log",
+			CodeTTL:       defaultSMSCodeTTL,
+			MaxAttempts:   defaultSMSMaxAttempts,
+		},
+		Notifications: NotificationsConfig{
+			Channels: []string{"email", "sms"},
+			Throttle: defaultNotifyThrottle,
+		},
+		Events: EventsConfig{Publisher: "log", PollInterval: defaultOutboxPollInterval},
+		Webhooks: WebhooksConfig{
+			PollInterval: defaultWebhookPollInterval,
+			MaxAttempts:  defaultWebhookMaxAttempts,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: defaultCORSAllowedOrigins,
+			AllowedMethods: defaultCORSAllowedMethods,
+			AllowedHeaders: defaultCORSAllowedHeaders,
+			ExposedHeaders: defaultCORSExposedHeaders,
+			MaxAge:         defaultCORSMaxAge,
+		},
+		RateLimit: RateLimitConfig{
+			Default:      defaultRateLimit,
+			Registration: defaultRateLimitRegistration,
+			Verification: defaultRateLimitVerification,
+			Admin:        defaultRateLimitAdmin,
+		},
+		Idempotency: IdempotencyConfig{TTL: defaultIdempotencyTTL},
+		Auth: AuthConfig{
+			AccessTokenTTL:       defaultAuthAccessTokenTTL,
+			RefreshTokenTTL:      defaultAuthRefreshTokenTTL,
+			RegistrationTokenTTL: defaultAuthRegistrationTokenTTL,
+			PasswordMinLength:    defaultAuthPasswordMinLength,
+			BcryptCost:           defaultAuthBcryptCost,
+		},
+		sources: map[string]string{},
+	}
+}
+
+//binding ties a setting to its key in configuration files and its environment variable, the flag is
+//named after the variable, e.g. --db-host for DB_HOST
+type binding struct {
+	key    string
+	env    string
+	target interface{}
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"http.addr", httpAddr, &c.HTTP.Addr},
+		{"http.publicBaseURL", publicBaseURL, &c.HTTP.PublicBaseURL},
+		{"http.requestTimeout", requestTimeout, &c.HTTP.RequestTimeout},
+		{"http.shutdownTimeout", shutdownTimeout, &c.HTTP.ShutdownTimeout},
+		{"http.readinessTimeout", readyTimeout, &c.HTTP.ReadinessTimeout},
+		{"http.readHeaderTimeout", httpReadHeaderTimeout, &c.HTTP.ReadHeaderTimeout},
+		{"http.readTimeout", httpReadTimeout, &c.HTTP.ReadTimeout},
+		{"http.idleTimeout", httpIdleTimeout, &c.HTTP.IdleTimeout},
+
+		{"database.host", dbHost, &c.Database.Host},
+		{"database.port", dbPort, &c.Database.Port},
+		{"database.user", dbUser, &c.Database.User},
+		{"database.password", dbPass, &c.Database.Password},
+		{"database.name", dbName, &c.Database.Name},
+		{"database.tls", dbTLS, &c.Database.TLS},
+		{"database.tlsCA", dbTLSCA, &c.Database.TLSCA},
+		{"database.tlsCert", dbTLSCert, &c.Database.TLSCert},
+		{"database.tlsKey", dbTLSKey, &c.Database.TLSKey},
+		{"database.tlsServerName", dbTLSServerName, &c.Database.TLSServerName},
+		{"database.charset", dbCharset, &c.Database.Charset},
+		{"database.collation", dbCollation, &c.Database.Collation},
+		{"database.parseTime", dbParseTime, &c.Database.ParseTime},
+		{"database.loc", dbLoc, &c.Database.Loc},
+		{"database.maxOpenConns", dbMaxOpenConns, &c.Database.MaxOpenConns},
+		{"database.maxIdleConns", dbMaxIdleConns, &c.Database.MaxIdleConns},
+		{"database.connMaxLifetime", dbConnMaxLifetime, &c.Database.ConnMaxLifetime},
+		{"database.connectAttempts", dbConnectAttempts, &c.Database.ConnectAttempts},
+		{"database.connectBackoff", dbConnectBackoff, &c.Database.ConnectBackoff},
+		{"database.replicas", dbReplicas, &c.Database.Replicas},
+		{"database.replicaCheckInterval", dbReplicaCheckInterval, &c.Database.ReplicaCheckInterval},
+
+		{"storage.backend", storageBackend, &c.Storage.Backend},
+
+		{"features.resetSchema", featureResetSchema, &c.Features.ResetSchema},
+		{"features.mockData", featureMockData, &c.Features.MockData},
+		{"features.rateLimit", rateLimitEnabled, &c.Features.RateLimit},
+
+		{"log.level", logLevel, &c.Log.Level},
+
+		{"tracing.exporter", traceExporter, &c.Tracing.Exporter},
+		{"tracing.file", traceFile, &c.Tracing.File},
+
+		{"mail.transport", mailTransport, &c.Mail.Transport},
+		{"mail.from", mailFrom, &c.Mail.From},
+		{"mail.outboxDir", mailOutboxDir, &c.Mail.OutboxDir},
+		{"mail.smtpHost", smtpHost, &c.Mail.SMTPHost},
+		{"mail.smtpPort", smtpPort, &c.Mail.SMTPPort},
+		{"mail.smtpUser", smtpUser, &c.Mail.SMTPUser},
+		{"mail.smtpPassword", smtpPass, &c.Mail.SMTPPassword},
+		{"mail.tokenSecret", emailTokenSecret, &c.Mail.TokenSecret},
+		{"mail.tokenTTL", emailTokenTTL, &c.Mail.TokenTTL},
+
+		{"phone.defaultRegion", phoneDefaultRegion, &c.Phone.DefaultRegion},
+		{"phone.smsTransport", smsTransport, &c.Phone.SMSTransport},
+		{"phone.codeTTL", smsCodeTTL, &c.Phone.CodeTTL},
+		{"phone.maxAttempts", smsMaxAttempts, &c.Phone.MaxAttempts},
+
+		{"notifications.channels", notifyChannels, &c.Notifications.Channels},
+		{"notifications.throttle", notifyThrottle, &c.Notifications.Throttle},
+		{"notifications.webhookURL", notifyWebhookURL, &c.Notifications.WebhookURL},
+
+		{"events.publisher", outboxPublisher, &c.Events.Publisher},
+		{"events.pollInterval", outboxPollInterval, &c.Events.PollInterval},
+
+		{"webhooks.adminToken", adminToken, &c.Webhooks.AdminToken},
+		{"webhooks.pollInterval", webhookPollInterval, &c.Webhooks.PollInterval},
+		{"webhooks.maxAttempts", webhookMaxAttempts, &c.Webhooks.MaxAttempts},
+
+		{"cors.allowedOrigins", corsAllowedOrigins, &c.CORS.AllowedOrigins},
+		{"cors.allowedMethods", corsAllowedMethods, &c.CORS.AllowedMethods},
+		{"cors.allowedHeaders", corsAllowedHeaders, &c.CORS.AllowedHeaders},
+		{"cors.exposedHeaders", corsExposedHeaders, &c.CORS.ExposedHeaders},
+		{"cors.allowCredentials", corsAllowCredentials, &c.CORS.AllowCredentials},
+		{"cors.maxAge", corsMaxAge, &c.CORS.MaxAge},
+
+		{"rateLimit.default", rateLimitDefault, &c.RateLimit.Default},
+		{"rateLimit.registration", rateLimitRegistration, &c.RateLimit.Registration},
+		{"rateLimit.verification", rateLimitVerification, &c.RateLimit.Verification},
+		{"rateLimit.admin", rateLimitAdmin, &c.RateLimit.Admin},
+		{"rateLimit.trustedProxies", trustedProxies, &c.RateLimit.TrustedProxies},
+		{"idempotency.ttl", idempotencyTTL, &c.Idempotency.TTL},
+
+		{"auth.accessTokenTTL", authAccessTokenTTL, &c.Auth.AccessTokenTTL},
+		{"auth.refreshTokenTTL", authRefreshTokenTTL, &c.Auth.RefreshTokenTTL},
+		{"auth.registrationTokenTTL", authRegistrationTokenTTL, &c.Auth.RegistrationTokenTTL},
+		{"auth.passwordMinLength", authPasswordMinLength, &c.Auth.PasswordMinLength},
+		{"auth.bcryptCost", authBcryptCost, &c.Auth.BcryptCost},
+	}
+}
+
+func (c *Config) binding(key string) (binding, bool) {
+	for _, b := range c.bindings() {
+		if b.key == key {
+			return b, true
+		}
+	}
+	return binding{}, false
+}
+
+//set parses value into the setting, value is a string or, from configuration files, a []string;
+//name is the key, variable or flag the value came from
+func (b binding) set(name string, value interface{}) error {
+	raw, isString := value.(string)
+	if target, ok := b.target.(*[]string); ok {
+		items, _ := value.([]string)
+		if isString {
+			items = splitList(raw)
+		}
+		*target = items
+		return nil
+	}
+	if !isString {
+		return fmt.Errorf("%s must be a single value", name)
+	}
+
+	raw = strings.TrimSpace(raw)
+	switch target := b.target.(type) {
+	case *string:
+		*target = raw
+	case *Secret:
+		*target = Secret(raw)
+	case *int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", name)
+		}
+		*target = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", name)
+		}
+		*target = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 30s", name)
+		}
+		*target = parsed
+	default:
+		return fmt.Errorf("%s has an unsupported type %T", name, b.target)
+	}
+	return nil
+}
+
+//format renders the value as YAML, secrets redacted
+func (b binding) format() string {
+	switch target := b.target.(type) {
+	case *string:
+		return strconv.Quote(*target)
+	case *Secret:
+		return strconv.Quote(target.String())
+	case *int:
+		return strconv.Itoa(*target)
+	case *bool:
+		return strconv.FormatBool(*target)
+	case *time.Duration:
+		return strconv.Quote(target.String())
+	case *[]string:
+		quoted := make([]string, len(*target))
+		for i, item := range *target {
+			quoted[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(b.target)
+	}
+}
+
+func (b binding) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(b.env, "_", "-"))
+}
+
+//splitList splits a comma separated value
+func splitList(raw string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+//Load layered configuration: defaults, the file given by --config or CONFIG_FILE, environment variables and
+//the flags in args. A flag is registered on fs for every setting.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	path := fs.String("config", os.Getenv(configFile), "YAML or JSON configuration file")
+	overrides := make([]flagOverride, 0)
+	for _, b := range Defaults().bindings() {
+		fs.Var(&flagValue{binding: b, overrides: &overrides}, b.flagName(), fmt.Sprintf("%s, overrides %s", b.key, b.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config, err := load(*path)
+	if err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		b, _ := config.binding(override.key)
+		if err := b.set("--"+b.flagName(), override.value); err != nil {
+			return nil, err
+		}
+		config.sources[b.key] = "flag"
+	}
+
+	config.complete()
+	return config, config.Validate()
+}
+
+//LoadFile configuration from defaults, the file at path, if any, and environment variables
+func LoadFile(path string) (*Config, error) {
+	config, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	config.complete()
+	return config, config.Validate()
+}
+
+func load(path string) (*Config, error) {
+	config := Defaults()
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			//secrets may name a file holding them instead, e.g. database.passwordFile
+			if b, ok := config.secretBinding(strings.TrimSuffix(key, "File")); ok && strings.HasSuffix(key, "File") {
+				if err := b.setFromFile(key, value); err != nil {
+					return nil, fmt.Errorf("%s: %s", path, err.Error())
+				}
+				config.sources[b.key] = "secret file"
+				continue
+			}
+
+			b, ok := config.binding(key)
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown setting %s", path, key)
+			}
+			if err := b.set(key, value); err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err.Error())
+			}
+			config.sources[key] = "file"
+		}
+	}
+
+	for _, b := range config.bindings() {
+		if raw := os.Getenv(b.env); raw != "" {
+			if err := b.set(b.env, raw); err != nil {
+				return nil, err
+			}
+			config.sources[b.key] = "env"
+		}
+
+		//Docker and Kubernetes secrets are mounted as files, e.g. DB_PASS_FILE=/run/secrets/db_pass
+		if _, isSecret := b.target.(*Secret); isSecret {
+			if path := os.Getenv(b.env + "_FILE"); path != "" {
+				if err := b.setFromFile(b.env+"_FILE", path); err != nil {
+					return nil, err
+				}
+				config.sources[b.key] = "secret file"
+			}
+		}
+	}
+	return config, nil
+}
+
+func (c *Config) secretBinding(key string) (binding, bool) {
+	b, ok := c.binding(key)
+	if !ok {
+		return binding{}, false
+	}
+	_, isSecret := b.target.(*Secret)
+	return b, isSecret
+}
+
+//setFromFile sets a secret to the content of the file at path, without the trailing line break
+func (b binding) setFromFile(name string, path interface{}) error {
+	file, ok := path.(string)
+	if !ok || file == "" {
+		return fmt.Errorf("%s must be a file path", name)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	*b.target.(*Secret) = Secret(strings.TrimRight(string(content), "\r\n"))
+	return nil
+}
+
+//complete fills the settings derived from others
+func (c *Config) complete() {
+	if c.HTTP.PublicBaseURL == "" {
+		if _, port, err := net.SplitHostPort(c.HTTP.Addr); err == nil {
+			c.HTTP.PublicBaseURL = "http://localhost:" + port
+		}
+	}
+	c.Phone.DefaultRegion = strings.ToUpper(c.Phone.DefaultRegion)
+}
+
+//Print writes the configuration as YAML noting where every value came from, secrets are redacted
+func (c *Config) Print(w io.Writer) {
+	section := ""
+	for _, b := range c.bindings() {
+		parts := strings.SplitN(b.key, ".", 2)
+		if parts[0] != section {
+			section = parts[0]
+			fmt.Fprintf(w, "%s:\n", section)
+		}
+
+		source, ok := c.sources[b.key]
+		if !ok {
+			source = "default"
+		}
+		fmt.Fprintf(w, "  %s: %s # %s, %s\n", parts[1], b.format(), source, b.env)
+	}
+}
+
+type flagOverride struct {
+	key   string
+	value string
+}
+
+//flagValue records the flags in the order they are given, they are applied once the lower layers are loaded
+type flagValue struct {
+	binding   binding
+	overrides *[]flagOverride
+}
+
+func (v *flagValue) String() string {
+	return ""
+}
+
+func (v *flagValue) Set(value string) error {
+	*v.overrides = append(*v.overrides, flagOverride{key: v.binding.key, value: value})
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	_, ok := v.binding.target.(*bool)
+	return ok
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		want    Secret
+		wantErr string
+	}{
+		{"env", write("env", "s3cret"), "", "s3cret", ""},
+		{"env trailing newline", write("newline", "s3cret\n"), "", "s3cret", ""},
+		{"env trailing CRLF", write("crlf", "s3cret\r\n\r\n"), "", "s3cret", ""},
+		//only the line breaks at the end are dropped
+		{"env inner spaces", write("spaces", " s3 cret \n"), "", " s3 cret ", ""},
+		{"env missing file", filepath.Join(dir, "missing"), "", "", dbPass + "_FILE: "},
+		{"config file", "", "database:\n  passwordFile: " + write("file", "s3cret\n") + "\n", "s3cret", ""},
+		{"config file missing", "", "database:\n  passwordFile: " + filepath.Join(dir, "missing") + "\n", "", "database.passwordFile: "},
+		{"config file empty path", "", "database:\n  passwordFile: ''\n", "", "database.passwordFile must be a file path"},
+	}
+
+	for _, tt := range tests {
+		path := ""
+		if tt.file != "" {
+			path = write("config.yaml", tt.file)
+		}
+		if tt.env != "" {
+			os.Setenv(dbPass+"_FILE", tt.env)
+		}
+		config, err := load(path)
+		os.Unsetenv(dbPass + "_FILE")
+
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if config.Database.Password != tt.want || config.sources["database.password"] != "secret file" {
+			t.Errorf("%s: password %q from %q, want %q from a secret file", tt.name, string(config.Database.Password),
+				config.sources["database.password"], string(tt.want))
+		}
+	}
+}
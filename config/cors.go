@@ -0,0 +1,48 @@
+package config
+
+import (
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file, lists are comma separated
+const (
+	corsAllowedOrigins   = "CORS_ALLOWED_ORIGINS"
+	corsAllowedMethods   = "CORS_ALLOWED_METHODS"
+	corsAllowedHeaders   = "CORS_ALLOWED_HEADERS"
+	corsExposedHeaders   = "CORS_EXPOSED_HEADERS"
+	corsAllowCredentials = "CORS_ALLOW_CREDENTIALS"
+	corsMaxAge           = "CORS_MAX_AGE"
+)
+
+var (
+	defaultCORSAllowedOrigins = []string{"*"}
+	defaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultCORSAllowedHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "X-Request-ID", "traceparent", "tracestate", "Idempotency-Key"}
+	defaultCORSExposedHeaders = []string{"X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Idempotent-Replayed", "Location"}
+)
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+//CORSConfig which browser origins may call the API, see app.CORSConfig
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+//CreateCORSConfig CORS policy of the router, any origin by default
+func CreateCORSConfig(config CORSConfig) app.CORSConfig {
+	return app.CORSConfig{
+		AllowedOrigins:   config.AllowedOrigins,
+		AllowedMethods:   config.AllowedMethods,
+		AllowedHeaders:   config.AllowedHeaders,
+		ExposedHeaders:   config.ExposedHeaders,
+		AllowCredentials: config.AllowCredentials,
+		MaxAge:           config.MaxAge,
+	}
+}
@@ -1,32 +1,282 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/life-blood/accounts-service/app"
 )
 
-//Configured from .env configuration filed
+//Configured from .env configuration filed, DB_PASS may be read from the file named by DB_PASS_FILE
 const (
+	dbHost = "DB_HOST"
 	dbUser = "DB_USER"
 	dbPass = "DB_PASS"
 	dbPort = "DB_PORT"
 	dbName = "DB_NAME"
+
+	dbTLS           = "DB_TLS"
+	dbTLSCA         = "DB_TLS_CA"
+	dbTLSCert       = "DB_TLS_CERT"
+	dbTLSKey        = "DB_TLS_KEY"
+	dbTLSServerName = "DB_TLS_SERVER_NAME"
+
+	dbCharset   = "DB_CHARSET"
+	dbCollation = "DB_COLLATION"
+	dbParseTime = "DB_PARSE_TIME"
+	dbLoc       = "DB_LOC"
+
+	dbMaxOpenConns    = "DB_MAX_OPEN_CONNS"
+	dbMaxIdleConns    = "DB_MAX_IDLE_CONNS"
+	dbConnMaxLifetime = "DB_CONN_MAX_LIFETIME"
+
+	dbConnectAttempts = "DB_CONNECT_ATTEMPTS"
+	dbConnectBackoff  = "DB_CONNECT_BACKOFF"
+
+	dbReplicas             = "DB_REPLICAS"
+	dbReplicaCheckInterval = "DB_REPLICA_CHECK_INTERVAL"
 )
 
+const (
+	defaultDBHost            = "localhost"
+	defaultDBPort            = 3306
+	defaultDBName            = "accounts"
+	defaultDBCharset         = "utf8mb4"
+	defaultDBCollation       = "utf8mb4_unicode_ci"
+	defaultDBLoc             = "UTC"
+	defaultDBMaxOpenConns    = 25
+	defaultDBMaxIdleConns    = 25
+	defaultDBConnMaxLifetime = 30 * time.Minute
+	defaultDBConnectAttempts = 10
+	defaultDBConnectBackoff  = time.Second
+	maxDBConnectBackoff      = 30 * time.Second
+
+	defaultDBReplicaCheckInterval = 10 * time.Second
+)
+
+//dbTLSConfigName the custom TLS configuration of every server is registered with the MySQL driver under
+//this name followed by the address, as the expected server name differs
+const dbTLSConfigName = "accounts"
+
+//DatabaseConfig MySQL server, credentials and connection pool
+type DatabaseConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password Secret
+	Name     string
+	//TLS "false", "true", "skip-verify" or "preferred", as understood by the MySQL driver
+	TLS string
+	//TLSCA verifies the server against this PEM bundle instead of the system roots
+	TLSCA string
+	//TLSCert and TLSKey are the PEM client certificate and key, for servers requiring X509 authentication
+	TLSCert       string
+	TLSKey        string
+	TLSServerName string
+	//Charset and Collation of the connection, utf8mb4 keeps Cyrillic names and any other Unicode text intact
+	Charset   string
+	Collation string
+	//ParseTime scans DATE and DATETIME columns into time.Time, interpreted in Loc
+	ParseTime bool
+	Loc       string
+	//MaxOpenConns limits the open connections, 0 means unlimited
+	MaxOpenConns int
+	MaxIdleConns int
+	//ConnMaxLifetime closes connections after this age, keep it below the wait_timeout of the server
+	ConnMaxLifetime time.Duration
+	//ConnectAttempts and ConnectBackoff control how long startup waits for the database
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+	//Replicas host[:port] of read replicas, they share the credentials, TLS and pool settings of the primary
+	Replicas             []string
+	ReplicaCheckInterval time.Duration
+}
+
+//customTLS whether certificates beyond the modes built into the driver are configured
+func (c DatabaseConfig) customTLS() bool {
+	return c.TLSCA != "" || c.TLSCert != "" || c.TLSServerName != ""
+}
+
+//driverConfig connection settings of the MySQL driver
+func (c DatabaseConfig) driverConfig() (*mysql.Config, error) {
+	loc, err := time.LoadLocation(c.Loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", dbLoc, err.Error())
+	}
+
+	driver := mysql.NewConfig()
+	driver.User = c.User
+	driver.Passwd = string(c.Password)
+	driver.Net = "tcp"
+	driver.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	driver.DBName = c.Name
+	driver.Collation = c.Collation
+	driver.Params = map[string]string{"charset": c.Charset}
+	driver.ParseTime = c.ParseTime
+	driver.Loc = loc
+
+	switch {
+	case c.TLS == "false":
+		//plain connection
+	case c.customTLS():
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		name := dbTLSConfigName + "-" + driver.Addr
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return nil, err
+		}
+		driver.TLSConfig = name
+	default:
+		driver.TLSConfig = c.TLS
+	}
+	return driver, nil
+}
+
+//tlsConfig TLS settings with the configured CA and client certificate
+func (c DatabaseConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLS == "skip-verify",
+	}
+	if config.ServerName == "" {
+		config.ServerName = c.Host
+	}
+
+	if c.TLSCA != "" {
+		pem, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", dbTLSCA, err.Error())
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificate in %s", dbTLSCA, c.TLSCA)
+		}
+	}
+
+	if c.TLSCert != "" {
+		certificate, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %s", dbTLSCert, dbTLSKey, err.Error())
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
 //CreateDatabaseConn retreieve database connection
-func CreateDatabaseConn() (*sql.DB, error) {
-	serverName := fmt.Sprintf("localhost:%s", os.Getenv(dbPort))
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s",
-		os.Getenv(dbUser),
-		os.Getenv(dbPass), serverName, os.Getenv(dbName))
+func CreateDatabaseConn(config DatabaseConfig) (*sql.DB, error) {
+	db, err := openDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waitForDatabase(db, config.ConnectAttempts, config.ConnectBackoff); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+//CreateDBCluster connect to the primary and open the replicas, replicas that are down at startup do not
+//hold it up, they receive reads once a health check reaches them
+func CreateDBCluster(config DatabaseConfig) (*app.DBCluster, error) {
+	primary, err := CreateDatabaseConn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	//on an error the connections opened so far are closed, the caller gets no cluster to close them with
+	replicas := make([]*app.Replica, 0, len(config.Replicas))
+	for _, address := range config.Replicas {
+		replicaConfig, err := config.replica(address)
+		if err != nil {
+			app.NewDBCluster(primary, replicas...).Close()
+			return nil, err
+		}
+		db, err := openDatabase(replicaConfig)
+		if err != nil {
+			app.NewDBCluster(primary, replicas...).Close()
+			return nil, fmt.Errorf("replica %s: %s", address, err.Error())
+		}
+		replicas = append(replicas, app.NewReplica(address, db))
+	}
+
+	cluster := app.NewDBCluster(primary, replicas...)
+	cluster.SetCheckInterval(config.ReplicaCheckInterval)
+	return cluster, nil
+}
+
+//replica settings of the replica at address, host[:port], the port of the primary is the default
+func (c DatabaseConfig) replica(address string) (DatabaseConfig, error) {
+	host, port := address, strconv.Itoa(c.Port)
+	if strings.Contains(address, ":") {
+		var err error
+		if host, port, err = net.SplitHostPort(address); err != nil {
+			return c, fmt.Errorf("%s: %q is not host:port", dbReplicas, address)
+		}
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || host == "" {
+		return c, fmt.Errorf("%s: %q is not host:port", dbReplicas, address)
+	}
 
-	db, err := sql.Open("mysql", connectionString)
+	replica := c
+	replica.Host = host
+	replica.Port = number
+	if replica.TLSServerName == c.Host {
+		replica.TLSServerName = host
+	}
+	return replica, nil
+}
+
+//openDatabase pool of connections to the configured server, no connection is made yet
+func openDatabase(config DatabaseConfig) (*sql.DB, error) {
+	driverConfig, err := config.driverConfig()
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(driverConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
 	return db, nil
 }
+
+//sleep waits between connection attempts, tests replace it
+var sleep = time.Sleep
+
+//waitForDatabase pings the database until it answers, doubling the wait after every failure
+func waitForDatabase(db *sql.DB, attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("database unreachable after %d attempts: %s", attempts, err.Error())
+		}
+
+		log.Printf("Database not reachable (attempt %d of %d), retrying in %s: %s", attempt, attempts, backoff, err.Error())
+		sleep(backoff)
+		if backoff *= 2; backoff > maxDBConnectBackoff {
+			backoff = maxDBConnectBackoff
+		}
+	}
+}
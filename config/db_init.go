@@ -3,39 +3,124 @@ package config
 import (
 	"database/sql"
 	"log"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
 )
 
+//createPersons the identity shared by the donor and acceptor roles of a human, shared with the migration adding the table
+const createPersons = `CREATE TABLE persons (
+								id varchar(32) NOT NULL,
+								name varchar(32) NOT NULL DEFAULT '',
+								lastName varchar(32) NOT NULL DEFAULT '',
+								phone varchar(32) NOT NULL DEFAULT '',
+								email varchar(32) NOT NULL DEFAULT '',
+								createdAt DATETIME NOT NULL,
+								PRIMARY KEY (id)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`
+
+//createIdempotencyKeys the answers replayed to retried POST requests, shared with the migration adding the table
+const createIdempotencyKeys = `CREATE TABLE idempotency_keys (
+								id char(64) NOT NULL,
+								requestHash char(64) NOT NULL,
+								status integer NOT NULL DEFAULT 0,
+								headers text,
+								body mediumblob,
+								createdAt DATETIME NOT NULL,
+								expiresAt DATETIME NOT NULL,
+								PRIMARY KEY (id),
+								INDEX (expiresAt)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`
+
+//createSchemaVersion the migrations applied to the database, shared with MigrateDatabase which adds it to
+//databases created before it existed
+const createSchemaVersion = `CREATE TABLE IF NOT EXISTS schema_version (
+								version integer NOT NULL,
+								appliedAt varchar(32) NOT NULL,
+								PRIMARY KEY (version)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`
+
+//createAuthTables the donor passwords and the sessions opened with them, shared with the migration adding the tables.
+//Tokens are only stored as SHA-256 hashes, used refresh tokens are kept until they expire to recognize their reuse.
+var createAuthTables = []string{
+	`CREATE TABLE credentials (
+								donorId varchar(32) NOT NULL,
+								passwordHash varchar(128) NOT NULL,
+								createdAt DATETIME NOT NULL,
+								updatedAt DATETIME NOT NULL,
+								PRIMARY KEY (donorId)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+	`CREATE TABLE auth_sessions (
+								id varchar(32) NOT NULL,
+								donorId varchar(32) NOT NULL,
+								accessHash char(64) NOT NULL,
+								accessExpiresAt DATETIME NOT NULL,
+								createdAt DATETIME NOT NULL,
+								PRIMARY KEY (id),
+								INDEX (donorId)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+	`CREATE TABLE auth_refresh_tokens (
+								tokenHash char(64) NOT NULL,
+								sessionId varchar(32) NOT NULL,
+								createdAt DATETIME NOT NULL,
+								expiresAt DATETIME NOT NULL,
+								usedAt DATETIME,
+								PRIMARY KEY (tokenHash),
+								INDEX (sessionId),
+								INDEX (expiresAt)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+}
+
+//createRegistrationTokens the single-use tokens mailed to donors setting their first password, shared with the
+//migration adding the table. Like the session tokens only their SHA-256 hashes are stored.
+const createRegistrationTokens = `CREATE TABLE registration_tokens (
+								tokenHash char(64) NOT NULL,
+								donorId varchar(32) NOT NULL,
+								email varchar(255) NOT NULL,
+								createdAt DATETIME NOT NULL,
+								expiresAt DATETIME NOT NULL,
+								PRIMARY KEY (tokenHash),
+								INDEX (donorId),
+								INDEX (expiresAt)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`
+
 //InitializeDatabase initialize database
 func InitializeDatabase(db *sql.DB) error {
-	_, err := db.Exec("USE accounts")
+	//the database itself is selected by the connection, see DatabaseConfig.DSN
+	stmt, err := db.Prepare(`DROP TABLE IF EXISTS persons, donors, acceptors, phone_verifications, notification_deliveries, outbox, webhook_subscriptions, webhook_deliveries, webhook_attempts, idempotency_keys, credentials, auth_sessions, auth_refresh_tokens, registration_tokens, schema_version`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmt.Exec()
 	if err != nil {
 		log.Fatal(err.Error())
 	} else {
-		log.Printf("DB selected successfully...")
+		log.Printf("Acceptors table dropped successfully...")
+		log.Printf("Donors table dropped successfully...")
 	}
 
-	stmt, err := db.Prepare(`DROP TABLE IF EXISTS donors, acceptors`)
-
+	stmtPersons, err := db.Prepare(createPersons)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	_, err = stmt.Exec()
+	_, err = stmtPersons.Exec()
 	if err != nil {
 		log.Fatal(err.Error())
 	} else {
-		log.Printf("Acceptors table dropped successfully...")
-		log.Printf("Donors table dropped successfully...")
+		log.Printf("Persons table created successfully...")
 	}
 
 	stmtAcceptors, err := db.Prepare(`CREATE TABLE acceptors (
 								id varchar(32) NOT NULL,
-								name varchar(32),
-								lastName varchar(32),
+								personId varchar(32) NOT NULL,
 								bloodGroup varchar(32),
 								city varchar(50),
 								bloodCenter varchar(250),
-								regDate varchar(32),
-								PRIMARY KEY (id));`)
+								regDate DATETIME NOT NULL,
+								urgent boolean NOT NULL DEFAULT false,
+								PRIMARY KEY (id),
+								UNIQUE KEY (personId)) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -48,17 +133,20 @@ func InitializeDatabase(db *sql.DB) error {
 
 	stmtDonors, err := db.Prepare(`CREATE TABLE donors (
 								id varchar(32) NOT NULL,
-								name varchar(32),
-								lastName varchar(32),
-								phone varchar(32),
-								email varchar(32),
-								age integer,
+								personId varchar(32) NOT NULL,
+								dateOfBirth DATE,
 								gender varchar(32),
 								bloodGroup varchar(32),
 								city varchar(50),
-								regDate varchar(32),
-								PRIMARY KEY (id)
-						);`)
+								regDate DATETIME NOT NULL,
+								emailVerified boolean NOT NULL DEFAULT false,
+								emailVerifiedAt DATETIME,
+								phoneVerified boolean NOT NULL DEFAULT false,
+								phoneVerifiedAt DATETIME,
+								notificationsOptIn boolean NOT NULL DEFAULT false,
+								PRIMARY KEY (id),
+								UNIQUE KEY (personId)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
 
 	if err != nil {
 		log.Fatal(err.Error())
@@ -70,13 +158,206 @@ func InitializeDatabase(db *sql.DB) error {
 		log.Printf("Donors table created successfully...")
 	}
 
+	stmtPhoneVerifications, err := db.Prepare(`CREATE TABLE phone_verifications (
+								donorId varchar(32) NOT NULL,
+								phone varchar(32) NOT NULL,
+								codeHash varchar(64) NOT NULL,
+								sentAt bigint NOT NULL,
+								expiresAt bigint NOT NULL,
+								attempts integer NOT NULL DEFAULT 0,
+								PRIMARY KEY (donorId)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtPhoneVerifications.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Phone verifications table created successfully...")
+	}
+
+	stmtNotificationDeliveries, err := db.Prepare(`CREATE TABLE notification_deliveries (
+								id varchar(32) NOT NULL,
+								acceptorId varchar(32) NOT NULL,
+								donorId varchar(32) NOT NULL,
+								channel varchar(16) NOT NULL,
+								status varchar(16) NOT NULL,
+								error varchar(250),
+								createdAt DATETIME NOT NULL,
+								PRIMARY KEY (id),
+								INDEX (acceptorId),
+								INDEX (donorId, status)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtNotificationDeliveries.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Notification deliveries table created successfully...")
+	}
+
+	stmtOutbox, err := db.Prepare(`CREATE TABLE outbox (
+								seq bigint NOT NULL AUTO_INCREMENT,
+								id varchar(32) NOT NULL,
+								type varchar(64) NOT NULL,
+								aggregateType varchar(32) NOT NULL,
+								aggregateId varchar(32) NOT NULL,
+								payload text NOT NULL,
+								occurredAt varchar(32) NOT NULL,
+								publishedAt varchar(32),
+								attempts integer NOT NULL DEFAULT 0,
+								lastError varchar(250),
+								PRIMARY KEY (seq),
+								UNIQUE KEY (id),
+								INDEX (publishedAt, seq)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtOutbox.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Outbox table created successfully...")
+	}
+
+	stmtWebhookSubscriptions, err := db.Prepare(`CREATE TABLE webhook_subscriptions (
+								id varchar(32) NOT NULL,
+								url varchar(500) NOT NULL,
+								secret varchar(128) NOT NULL,
+								eventTypes text NOT NULL,
+								bloodCenters text NOT NULL,
+								active boolean NOT NULL DEFAULT true,
+								createdAt varchar(32) NOT NULL,
+								PRIMARY KEY (id)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtWebhookSubscriptions.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Webhook subscriptions table created successfully...")
+	}
+
+	stmtWebhookDeliveries, err := db.Prepare(`CREATE TABLE webhook_deliveries (
+								id varchar(32) NOT NULL,
+								subscriptionId varchar(32) NOT NULL,
+								eventId varchar(32) NOT NULL,
+								eventType varchar(64) NOT NULL,
+								payload text NOT NULL,
+								status varchar(16) NOT NULL,
+								attempts integer NOT NULL DEFAULT 0,
+								nextAttemptAt bigint NOT NULL,
+								lastError varchar(250),
+								createdAt varchar(32) NOT NULL,
+								PRIMARY KEY (id),
+								UNIQUE KEY (subscriptionId, eventId),
+								INDEX (status, nextAttemptAt)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtWebhookDeliveries.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Webhook deliveries table created successfully...")
+	}
+
+	stmtWebhookAttempts, err := db.Prepare(`CREATE TABLE webhook_attempts (
+								id varchar(32) NOT NULL,
+								deliveryId varchar(32) NOT NULL,
+								attemptedAt varchar(32) NOT NULL,
+								statusCode integer,
+								error varchar(250),
+								durationMs bigint NOT NULL,
+								PRIMARY KEY (id),
+								INDEX (deliveryId)
+						) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtWebhookAttempts.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Webhook attempts table created successfully...")
+	}
+
+	stmtIdempotencyKeys, err := db.Prepare(createIdempotencyKeys)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtIdempotencyKeys.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Idempotency keys table created successfully...")
+	}
+
+	for _, create := range createAuthTables {
+		if _, err = db.Exec(create); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	log.Printf("Credentials and session tables created successfully...")
+
+	if _, err = db.Exec(createRegistrationTokens); err != nil {
+		log.Fatal(err.Error())
+	}
+	log.Printf("Registration tokens table created successfully...")
+
+	stmtSchemaVersion, err := db.Prepare(createSchemaVersion)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, err = stmtSchemaVersion.Exec()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	_, err = db.Exec("INSERT INTO schema_version(version, appliedAt) VALUES (?, ?)", app.SchemaVersion, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Fatal(err.Error())
+	} else {
+		log.Printf("Schema version %d recorded successfully...", app.SchemaVersion)
+	}
+
 	return nil
 }
 
-//PopulateWithMockData fill with initial mock data
+//PopulateWithMockData fill with initial mock data, registration dates are in UTC.
+//Ivan Petrov is both a donor and an acceptor.
 func PopulateWithMockData(db *sql.DB) error {
-	mockdata1, err := db.Prepare(`INSERT INTO donors(id, name, lastName, phone, email, age, gender, bloodGroup, city, regDate)
-								VALUES ('12','Ivan','Petrov','08978654321','ivanp@abv.bg','31','MALE','AB','Sofia', 'Sun Mar 15 02:44:15 EET 2019');`)
+	mockPersons, err := db.Prepare(`INSERT INTO persons(id, name, lastName, phone, email, createdAt)
+								VALUES ('12','Ivan','Petrov','+359897865432','ivanp@abv.bg', '2019-03-15 00:44:15'),
+								('1','Petka','Petrova','+359887654321','ivanp@abv.bg', '2019-03-15 00:44:15'),
+								('2','Ivaylo','Yosifov','','', '2020-03-16 00:44:15');`)
+	if err != nil {
+		log.Printf(err.Error())
+	}
+	_, err = mockPersons.Exec()
+	if err != nil {
+		log.Printf(err.Error())
+	} else {
+		log.Printf("Mock persons added...")
+	}
+
+	mockdata1, err := db.Prepare(`INSERT INTO donors(id, personId, dateOfBirth, gender, bloodGroup, city, regDate)
+								VALUES ('12','12','1988-06-02','MALE','AB','Sofia', '2019-03-15 00:44:15');`)
 	if err != nil {
 		log.Printf(err.Error())
 	}
@@ -87,8 +368,8 @@ func PopulateWithMockData(db *sql.DB) error {
 		log.Printf("Mock data 1 added...")
 	}
 
-	mockdata2, err := db.Prepare(`INSERT INTO acceptors(id, name, lastName, bloodGroup, city, bloodCenter, regDate)
-								VALUES ('12','Ivan','Petrov','AB','Sofia', 'РЦ по трансфузионна хематология - Пловдив', 'Sun Mar 15 02:44:15 EET 2019');`)
+	mockdata2, err := db.Prepare(`INSERT INTO acceptors(id, personId, bloodGroup, city, bloodCenter, regDate)
+								VALUES ('12','12','AB','Sofia', 'РЦ по трансфузионна хематология - Пловдив', '2019-03-15 00:44:15');`)
 	if err != nil {
 		log.Printf(err.Error())
 	}
@@ -99,8 +380,8 @@ func PopulateWithMockData(db *sql.DB) error {
 		log.Printf("Mock data 2 added...")
 	}
 
-	mockdata3, err := db.Prepare(`INSERT INTO donors(id, name, lastName, phone, email, age, gender, bloodGroup, city, regDate)
-								VALUES ('1','Petka','Petrova','08978654321','ivanp@abv.bg','31','MALE','B','Sofia', 'Sun Mar 15 02:44:15 EET 2019');`)
+	mockdata3, err := db.Prepare(`INSERT INTO donors(id, personId, dateOfBirth, gender, bloodGroup, city, regDate)
+								VALUES ('1','1','1988-11-23','MALE','B','Sofia', '2019-03-15 00:44:15');`)
 	if err != nil {
 		log.Printf(err.Error())
 	}
@@ -111,8 +392,8 @@ func PopulateWithMockData(db *sql.DB) error {
 		log.Printf("Mock data 3 added...")
 	}
 
-	mockdata4, err := db.Prepare(`INSERT INTO acceptors(id, name, lastName, bloodGroup, city, bloodCenter, regDate)
-								VALUES ('2','Ivaylo','Yosifov','0','Plovdiv', 'РЦ по трансфузионна хематология - Варна', 'Sun Mar 16 02:44:15 EET 2020');`)
+	mockdata4, err := db.Prepare(`INSERT INTO acceptors(id, personId, bloodGroup, city, bloodCenter, regDate)
+								VALUES ('2','2','0','Plovdiv', 'РЦ по трансфузионна хематология - Варна', '2020-03-16 00:44:15');`)
 	if err != nil {
 		log.Printf(err.Error())
 	}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//unreachable is a database refusing the first failures connections
+type unreachable struct {
+	failures int
+	attempts int
+}
+
+func (u *unreachable) Connect(context.Context) (driver.Conn, error) {
+	u.attempts++
+	if u.attempts <= u.failures {
+		return nil, errors.New("connection refused")
+	}
+	return conn{}, nil
+}
+
+func (u *unreachable) Driver() driver.Driver {
+	return nil
+}
+
+type conn struct{}
+
+func (conn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (conn) Close() error                        { return nil }
+func (conn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestWaitForDatabase(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	tests := []struct {
+		name      string
+		failures  int
+		attempts  int
+		backoff   time.Duration
+		wantErr   bool
+		wantWaits []time.Duration
+	}{
+		{"reachable", 0, 3, time.Second, false, nil},
+		{"comes up", 2, 3, time.Second, false, []time.Duration{time.Second, 2 * time.Second}},
+		{"stays down", 5, 3, time.Second, true, []time.Duration{time.Second, 2 * time.Second}},
+		{"capped backoff", 7, 8, 10 * time.Second, false,
+			[]time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second}},
+		{"single attempt", 1, 1, time.Second, true, nil},
+	}
+
+	for _, tt := range tests {
+		waits = nil
+		database := &unreachable{failures: tt.failures}
+		db := sql.OpenDB(database)
+		err := waitForDatabase(db, tt.attempts, tt.backoff)
+		db.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("%s: error %q does not name the cause", tt.name, err)
+		}
+		if want := len(tt.wantWaits) + 1; database.attempts != want {
+			t.Errorf("%s: %d attempts, want %d", tt.name, database.attempts, want)
+		}
+		if len(waits) != len(tt.wantWaits) {
+			t.Errorf("%s: waited %v, want %v", tt.name, waits, tt.wantWaits)
+			continue
+		}
+		for i := range waits {
+			if waits[i] != tt.wantWaits[i] {
+				t.Errorf("%s: waited %v, want %v", tt.name, waits, tt.wantWaits)
+				break
+			}
+		}
+	}
+}
+
+//writeCertificate writes a self-signed certificate and its key for host to dir
+func writeCertificate(t *testing.T, dir, host string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, host+".pem"), filepath.Join(dir, host+"-key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestDriverConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, _ := writeCertificate(t, dir, "db.lifeblood.bg")
+
+	tests := []struct {
+		name    string
+		change  func(c *DatabaseConfig)
+		wantTLS string
+		wantErr string
+	}{
+		{"defaults", func(c *DatabaseConfig) {}, "", ""},
+		{"plain", func(c *DatabaseConfig) { c.TLS = "false" }, "", ""},
+		{"verified", func(c *DatabaseConfig) { c.TLS = "true" }, "true", ""},
+		{"skip verify", func(c *DatabaseConfig) { c.TLS = "skip-verify" }, "skip-verify", ""},
+		{"preferred", func(c *DatabaseConfig) { c.TLS = "preferred" }, "preferred", ""},
+		{"custom CA", func(c *DatabaseConfig) { c.TLS, c.Host, c.TLSCA = "true", "db.lifeblood.bg", ca }, "accounts-db.lifeblood.bg:3306", ""},
+		{"server name", func(c *DatabaseConfig) { c.TLS, c.Host, c.TLSServerName = "true", "10.0.0.5", "db.lifeblood.bg" }, "accounts-10.0.0.5:3306", ""},
+		//"false" turns TLS off even with certificates configured
+		{"plain with CA", func(c *DatabaseConfig) { c.TLS, c.TLSCA = "false", ca }, "", ""},
+		{"unknown location", func(c *DatabaseConfig) { c.Loc = "Europe/Nowhere" }, "", dbLoc},
+		{"missing CA", func(c *DatabaseConfig) { c.TLS, c.TLSCA = "true", filepath.Join(dir, "missing.pem") }, "", dbTLSCA},
+	}
+
+	for _, tt := range tests {
+		database := Defaults().Database
+		database.User, database.Password = "accounts", "s3cret"
+		tt.change(&database)
+
+		driver, err := database.driverConfig()
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr+":") {
+				t.Errorf("%s: err = %v, want a %s error", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if driver.User != "accounts" || driver.Passwd != "s3cret" || driver.Net != "tcp" || driver.DBName != defaultDBName ||
+			driver.Addr != net.JoinHostPort(database.Host, "3306") || driver.Collation != defaultDBCollation ||
+			driver.Params["charset"] != defaultDBCharset || !driver.ParseTime || driver.Loc != time.UTC {
+			t.Errorf("%s: driver config %+v", tt.name, driver)
+		}
+		if driver.TLSConfig != tt.wantTLS {
+			t.Errorf("%s: TLS %q, want %q", tt.name, driver.TLSConfig, tt.wantTLS)
+		}
+		//the driver refuses a DSN naming a TLS configuration that was not registered
+		if _, err := mysql.ParseDSN(driver.FormatDSN()); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, _ := writeCertificate(t, dir, "ca.lifeblood.bg")
+	cert, key := writeCertificate(t, dir, "accounts.lifeblood.bg")
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		database       DatabaseConfig
+		wantServerName string
+		wantSkip       bool
+		wantRoots      bool
+		wantCerts      int
+		wantErr        string
+	}{
+		{"host as server name", DatabaseConfig{Host: "db.lifeblood.bg", TLS: "true"}, "db.lifeblood.bg", false, false, 0, ""},
+		{"server name", DatabaseConfig{Host: "10.0.0.5", TLS: "true", TLSServerName: "db.lifeblood.bg"}, "db.lifeblood.bg", false, false, 0, ""},
+		{"skip verify", DatabaseConfig{Host: "db.lifeblood.bg", TLS: "skip-verify", TLSCA: ca}, "db.lifeblood.bg", true, true, 0, ""},
+		{"CA", DatabaseConfig{Host: "db.lifeblood.bg", TLS: "true", TLSCA: ca}, "db.lifeblood.bg", false, true, 0, ""},
+		{"client certificate", DatabaseConfig{Host: "db.lifeblood.bg", TLS: "true", TLSCA: ca, TLSCert: cert, TLSKey: key}, "db.lifeblood.bg", false, true, 1, ""},
+		{"missing CA", DatabaseConfig{Host: "db.lifeblood.bg", TLSCA: filepath.Join(dir, "missing.pem")}, "", false, false, 0, dbTLSCA + ":"},
+		{"CA without certificate", DatabaseConfig{Host: "db.lifeblood.bg", TLSCA: notPEM}, "", false, false, 0, dbTLSCA + ": no PEM certificate in " + notPEM},
+		{"missing key", DatabaseConfig{Host: "db.lifeblood.bg", TLSCert: cert, TLSKey: filepath.Join(dir, "missing.pem")}, "", false, false, 0, dbTLSCert + "/" + dbTLSKey + ":"},
+		{"key of another certificate", DatabaseConfig{Host: "db.lifeblood.bg", TLSCert: cert, TLSKey: ca}, "", false, false, 0, dbTLSCert + "/" + dbTLSKey + ":"},
+	}
+
+	for _, tt := range tests {
+		config, err := tt.database.tlsConfig()
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if config.ServerName != tt.wantServerName || config.InsecureSkipVerify != tt.wantSkip ||
+			(config.RootCAs != nil) != tt.wantRoots || len(config.Certificates) != tt.wantCerts {
+			t.Errorf("%s: server name %q, skip verify %v, CA %v, %d certificates", tt.name,
+				config.ServerName, config.InsecureSkipVerify, config.RootCAs != nil, len(config.Certificates))
+		}
+	}
+}
+
+func TestReplica(t *testing.T) {
+	primary := DatabaseConfig{Host: "db1.lifeblood.bg", Port: 3306, User: "accounts", TLS: "true", TLSServerName: "db1.lifeblood.bg"}
+
+	tests := []struct {
+		address        string
+		serverName     string
+		wantHost       string
+		wantPort       int
+		wantServerName string
+		wantErr        bool
+	}{
+		{"db2.lifeblood.bg", "", "db2.lifeblood.bg", 3306, "", false},
+		{"db2.lifeblood.bg:3307", "", "db2.lifeblood.bg", 3307, "", false},
+		{"[::1]:3307", "", "::1", 3307, "", false},
+		//a server name that was the primary's host follows the replica's host, a shared one is kept
+		{"db2.lifeblood.bg", "db1.lifeblood.bg", "db2.lifeblood.bg", 3306, "db2.lifeblood.bg", false},
+		{"10.0.0.6", "db.lifeblood.bg", "10.0.0.6", 3306, "db.lifeblood.bg", false},
+		{":3307", "", "", 0, "", true},
+		{"db2.lifeblood.bg:mysql", "", "", 0, "", true},
+		{"db2.lifeblood.bg:3307:1", "", "", 0, "", true},
+	}
+
+	for _, tt := range tests {
+		config := primary
+		config.TLSServerName = tt.serverName
+		replica, err := config.replica(tt.address)
+		if tt.wantErr {
+			if want := fmt.Sprintf("%s: %q is not host:port", dbReplicas, tt.address); err == nil || err.Error() != want {
+				t.Errorf("replica(%q) err = %v, want %q", tt.address, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("replica(%q): %v", tt.address, err)
+			continue
+		}
+		if replica.Host != tt.wantHost || replica.Port != tt.wantPort || replica.TLSServerName != tt.wantServerName ||
+			replica.User != primary.User || replica.TLS != primary.TLS {
+			t.Errorf("replica(%q) = %+v", tt.address, replica)
+		}
+	}
+}
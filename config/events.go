@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	outboxPublisher    = "OUTBOX_PUBLISHER"
+	outboxPollInterval = "OUTBOX_POLL_INTERVAL"
+)
+
+const defaultOutboxPollInterval = 2 * time.Second
+
+//EventsConfig publishing of the domain events written to the outbox
+type EventsConfig struct {
+	//Publisher only "log" is available
+	Publisher string
+	//PollInterval how often the relay looks for unpublished events
+	PollInterval time.Duration
+}
+
+//CreatePublisher build the configured event publisher
+func CreatePublisher(config EventsConfig) (app.Publisher, error) {
+	switch config.Publisher {
+	case "", "log":
+		return app.LogPublisher{}, nil
+	default:
+		return nil, errors.New("unknown OUTBOX_PUBLISHER " + config.Publisher)
+	}
+}
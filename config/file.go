@@ -0,0 +1,302 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//readFile settings of a YAML or JSON configuration file, flattened to keys such as database.host,
+//values are strings or []string
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSON(data)
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	default:
+		return nil, fmt.Errorf("%s: configuration files must be .yaml, .yml or .json", path)
+	}
+}
+
+func parseJSON(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := flattenJSON("", document, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func flattenJSON(prefix string, document map[string]interface{}, values map[string]interface{}) error {
+	for key, value := range document {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flattenJSON(key, v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				scalar, ok := jsonScalar(item)
+				if !ok {
+					return fmt.Errorf("%s must be a list of plain values", key)
+				}
+				items[i] = scalar
+			}
+			values[key] = items
+		default:
+			scalar, ok := jsonScalar(v)
+			if !ok {
+				return fmt.Errorf("%s must be a plain value", key)
+			}
+			values[key] = scalar
+		}
+	}
+	return nil
+}
+
+func jsonScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+//parseYAML reads the subset of YAML configuration files need: nested mappings, block and flow lists,
+//plain and quoted scalars and comments
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	lines, err := yamlLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &yamlParser{lines: lines, values: map[string]interface{}{}}
+	if err := p.mapping("", 0); err != nil {
+		return nil, err
+	}
+	return p.values, nil
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+//yamlLines strips comments and blank lines and measures the indentation of the others
+func yamlLines(data string) ([]yamlLine, error) {
+	lines := make([]yamlLine, 0)
+	for i, text := range strings.Split(data, "\n") {
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	return lines, nil
+}
+
+//stripComment cuts a # comment that starts the line or follows a space outside quotes
+func stripComment(text string) string {
+	var quote rune
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+type yamlParser struct {
+	lines  []yamlLine
+	pos    int
+	values map[string]interface{}
+}
+
+func (p *yamlParser) mapping(prefix string, indent int) error {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			return nil
+		}
+		if line.indent > indent {
+			return fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		if isYAMLListItem(line.text) {
+			return fmt.Errorf("line %d: list item outside a list", line.number)
+		}
+
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		p.pos++
+
+		if rest != "" {
+			value, err := yamlValue(rest)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line.number, err.Error())
+			}
+			p.values[key] = value
+			continue
+		}
+
+		//a key without a value opens a nested mapping or a list, or is empty
+		if p.pos == len(p.lines) {
+			p.values[key] = ""
+			continue
+		}
+		next := p.lines[p.pos]
+		switch {
+		case isYAMLListItem(next.text) && next.indent >= indent:
+			items, err := p.list(next.indent)
+			if err != nil {
+				return err
+			}
+			p.values[key] = items
+		case next.indent > indent:
+			if err := p.mapping(key, next.indent); err != nil {
+				return err
+			}
+		default:
+			p.values[key] = ""
+		}
+	}
+	return nil
+}
+
+func (p *yamlParser) list(indent int) ([]string, error) {
+	items := make([]string, 0)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isYAMLListItem(line.text) {
+			break
+		}
+		item, err := yamlScalar(strings.TrimSpace(line.text[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line.number, err.Error())
+		}
+		items = append(items, item)
+		p.pos++
+	}
+	return items, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+//splitYAMLKey splits "key: value" at the first colon followed by a space or the end of the line
+func splitYAMLKey(text string) (string, string, bool) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != ':' || (i+1 < len(text) && text[i+1] != ' ') {
+			continue
+		}
+		key := strings.TrimSpace(text[:i])
+		if key == "" || strings.ContainsAny(key, " \"'") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(text[i+1:]), true
+	}
+	return "", "", false
+}
+
+//yamlValue reads a scalar or a flow list such as [a, "b"]
+func yamlValue(text string) (interface{}, error) {
+	if !strings.HasPrefix(text, "[") {
+		return yamlScalar(text)
+	}
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unterminated list %s", text)
+	}
+
+	items := make([]string, 0)
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return items, nil
+	}
+
+	var quote rune
+	start := 0
+	for i, c := range inner + "," {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			item, err := yamlScalar(strings.TrimSpace(inner[start:i]))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			start = i + 1
+		}
+	}
+	return items, nil
+}
+
+func yamlScalar(text string) (string, error) {
+	switch {
+	case text == "~" || text == "null":
+		return "", nil
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("malformed string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("malformed string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	default:
+		return text, nil
+	}
+}
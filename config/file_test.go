@@ -0,0 +1,108 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want map[string]interface{}
+	}{
+		{
+			name: "nested mappings",
+			yaml: "http:\n  addr: \":4200\"\ndatabase:\n  host: localhost\n  pool:\n    maxOpen: 10\n  name: accounts\nlog:\n  level: info\n",
+			want: map[string]interface{}{
+				"http.addr":             ":4200",
+				"database.host":         "localhost",
+				"database.pool.maxOpen": "10",
+				"database.name":         "accounts",
+				"log.level":             "info",
+			},
+		},
+		{
+			name: "quoting",
+			yaml: "a: \"x: #y\"\nb: 'it''s'\nc: \"tab\\tnew\"\nd: plain text\ne: ~\nf: null\ng: ''\nh: https://lifeblood.bg:8443/x\n",
+			want: map[string]interface{}{
+				"a": "x: #y",
+				"b": "it's",
+				"c": "tab\tnew",
+				"d": "plain text",
+				"e": "",
+				"f": "",
+				"g": "",
+				"h": "https://lifeblood.bg:8443/x",
+			},
+		},
+		{
+			name: "lists",
+			yaml: "cors:\n  origins:\n    - https://lifeblood.bg\n    - \"http://localhost:3000\"\n  methods: [GET, 'POST', \"a,b\"]\n  headers: []\nchannels:\n- email\n- sms\n",
+			want: map[string]interface{}{
+				"cors.origins": []string{"https://lifeblood.bg", "http://localhost:3000"},
+				"cors.methods": []string{"GET", "POST", "a,b"},
+				"cors.headers": []string{},
+				"channels":     []string{"email", "sms"},
+			},
+		},
+		{
+			name: "comments and blank lines",
+			yaml: "# heading\n---\nfeatures:\n\n  # inside\n  resetSchema: false # trailing\n  mockData: a#b\n",
+			want: map[string]interface{}{
+				"features.resetSchema": "false",
+				"features.mockData":    "a#b",
+			},
+		},
+		{
+			name: "keys without values",
+			yaml: "storage:\ntracing:\n  file:\n",
+			want: map[string]interface{}{
+				"storage":      "",
+				"tracing.file": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.yaml))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{"a: 1\n\tb: 2\n", "line 2: indent with spaces, not tabs"},
+		{"a:\n  b: 1\n    c: 2\n", "line 3: unexpected indentation"},
+		{"# comment\n\n- item\n", "line 3: list item outside a list"},
+		{"a: 1\njust text\n", "line 2: expected key: value"},
+		{"a: 1\n\"b c\": 2\n", "line 2: expected key: value"},
+		{"a:\n  b: \"open\n", "line 2: malformed string \"open"},
+		{"a: 'open\n", "line 1: malformed string 'open"},
+		{"a: [x, y\n", "line 1: unterminated list [x, y"},
+		{"a:\n  - ok\n  - \"bad\n", "line 3: malformed string \"bad"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseYAML([]byte(tt.yaml))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("parseYAML(%q) error = %v, want %s", tt.yaml, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultsLeaveDestructiveFeaturesOff(t *testing.T) {
+	features := Defaults().Features
+	if features.ResetSchema || features.MockData {
+		t.Errorf("default features = %+v, want reset schema and mock data off", features)
+	}
+}
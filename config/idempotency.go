@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+//Configured from .env configuration file
+const (
+	idempotencyTTL = "IDEMPOTENCY_TTL"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+//IdempotencyConfig how POST requests sent with an Idempotency-Key are replayed
+type IdempotencyConfig struct {
+	//TTL how long the first answer is replayed to retries with the same key
+	TTL time.Duration
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"os"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const logLevel = "LOG_LEVEL"
+
+//LogConfig level below which log entries are dropped
+type LogConfig struct {
+	Level string
+}
+
+//CreateLogger JSON logger writing to stderr, entries below the configured level are dropped
+func CreateLogger(config LogConfig) (*app.Logger, error) {
+	level, err := app.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	return app.NewLogger(os.Stderr, level), nil
+}
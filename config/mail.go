@@ -0,0 +1,66 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	mailTransport    = "MAIL_TRANSPORT"
+	mailFrom         = "MAIL_FROM"
+	mailOutboxDir    = "MAIL_OUTBOX_DIR"
+	smtpHost         = "SMTP_HOST"
+	smtpPort         = "SMTP_PORT"
+	smtpUser         = "SMTP_USER"
+	smtpPass         = "SMTP_PASS"
+	emailTokenSecret = "EMAIL_TOKEN_SECRET"
+	emailTokenTTL    = "EMAIL_TOKEN_TTL"
+	publicBaseURL    = "PUBLIC_BASE_URL"
+)
+
+const (
+	defaultEmailTokenTTL = 48 * time.Hour
+	defaultSMTPPort      = 25
+)
+
+//MailConfig mail transport and e-mail verification tokens
+type MailConfig struct {
+	//Transport "smtp" or "outbox", which writes the mails to OutboxDir
+	Transport    string
+	From         string
+	OutboxDir    string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword Secret
+	//TokenSecret signs the e-mail verification tokens, which expire after TokenTTL
+	TokenSecret Secret
+	TokenTTL    time.Duration
+}
+
+//CreateMailer build the configured mail transport
+func CreateMailer(config MailConfig) (app.Mailer, error) {
+	switch config.Transport {
+	case "smtp":
+		if config.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST is required for the smtp mail transport")
+		}
+		return app.NewSMTPMailer(config.SMTPHost, strconv.Itoa(config.SMTPPort), config.SMTPUser, string(config.SMTPPassword), config.From), nil
+	case "", "outbox":
+		return app.NewOutboxMailer(config.OutboxDir), nil
+	default:
+		return nil, errors.New("unknown MAIL_TRANSPORT " + config.Transport)
+	}
+}
+
+//CreateEmailVerifier build the e-mail token issuer
+func CreateEmailVerifier(config MailConfig) (*app.EmailVerifier, error) {
+	if config.TokenSecret == "" {
+		return nil, errors.New("EMAIL_TOKEN_SECRET is not set")
+	}
+	return app.NewEmailVerifier([]byte(config.TokenSecret), config.TokenTTL), nil
+}
@@ -0,0 +1,442 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+	"github.com/lithammer/shortuuid"
+)
+
+//migration brings the schema from the previous version to version
+type migration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, db *sql.DB) error
+}
+
+//migrations in the order they are applied, the last one matches app.SchemaVersion
+var migrations = []migration{
+	{2, "store account dates as UTC DATETIME and replace age with dateOfBirth", migrateTemporalTypes},
+	{3, "add idempotency_keys for replaying retried POST requests", migrateIdempotencyKeys},
+	{4, "move names, phones and e-mails to persons holding the donor and acceptor roles", migratePersons},
+	{5, "add credentials and sessions for donors logging in with a password", migrateAuth},
+	{6, "store notification delivery times as UTC DATETIME", migrateNotificationTimes},
+	{7, "add registration_tokens mailed to donors setting their first password", migrateRegistrationTokens},
+}
+
+//migrationLock serializes instances starting at the same time, only the first one migrates
+const migrationLock = "accounts-service-migrations"
+
+//MigrateDatabase applies the migrations a database created by an older InitializeDatabase is missing.
+//MySQL does not roll back schema changes, a migration failing halfway has to be finished by hand.
+func MigrateDatabase(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 600)", migrationLock).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("another instance is migrating the database")
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLock)
+
+	//databases created before schema_version existed have no table or no rows, they are at version 1
+	if _, err := db.ExecContext(ctx, createSchemaVersion); err != nil {
+		return err
+	}
+	var recorded sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&recorded); err != nil {
+		return err
+	}
+	version := 1
+	if recorded.Valid {
+		version = int(recorded.Int64)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		log.Printf("Migrating the schema to version %d: %s", m.version, m.description)
+		if err := m.apply(ctx, db); err != nil {
+			return fmt.Errorf("migration to version %d: %s", m.version, err.Error())
+		}
+		_, err := db.ExecContext(ctx, "INSERT INTO schema_version(version, appliedAt) VALUES (?, ?)", m.version, time.Now().UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		version = m.version
+	}
+
+	if version != app.SchemaVersion {
+		return fmt.Errorf("schema version is %d after migrating, %d is expected", version, app.SchemaVersion)
+	}
+	return nil
+}
+
+//migrateNotificationTimes converts the varchar createdAt of the notification deliveries, written in local time,
+//to a UTC DATETIME column. Like migrateTemporalTypes every value is parsed before the table changes.
+func migrateNotificationTimes(ctx context.Context, db *sql.DB) error {
+	deliveries, err := readLegacyDates(ctx, db, `SELECT id, createdAt FROM notification_deliveries`, 1)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, `ALTER TABLE notification_deliveries ADD COLUMN createdAtUTC DATETIME NULL`); err != nil {
+		return err
+	}
+
+	err = inTransaction(ctx, db, func(tx *sql.Tx) error {
+		return updateDates(ctx, tx, `UPDATE notification_deliveries SET createdAtUTC=? WHERE id=?`, deliveries)
+	})
+	if err != nil {
+		return err
+	}
+
+	steps := []string{
+		`ALTER TABLE notification_deliveries DROP COLUMN createdAt`,
+		`ALTER TABLE notification_deliveries CHANGE createdAtUTC createdAt DATETIME NOT NULL`,
+	}
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//migrateAuth creates the tables of the donor passwords and sessions
+func migrateAuth(ctx context.Context, db *sql.DB) error {
+	for _, create := range createAuthTables {
+		if _, err := db.ExecContext(ctx, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//migrateRegistrationTokens creates the table of the tokens mailed to donors setting their first password
+func migrateRegistrationTokens(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, createRegistrationTokens)
+	return err
+}
+
+//migrateIdempotencyKeys creates the table of the answers replayed to retried POST requests
+func migrateIdempotencyKeys(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, createIdempotencyKeys)
+	return err
+}
+
+//legacyPerson is the identity read from a donor or acceptor row before it moves to persons
+type legacyPerson struct {
+	id        string
+	personID  string
+	name      string
+	lastName  string
+	phone     string
+	email     string
+	createdAt time.Time
+}
+
+//migratePersons gives every donor and acceptor a person holding its identity. A donor and an acceptor
+//with the same id and the same name, such as the mock data's Ivan Petrov, become roles of one person;
+//all other rows get a person of their own, as a matching name alone does not prove it is the same human.
+func migratePersons(ctx context.Context, db *sql.DB) error {
+	donors, err := readLegacyPersons(ctx, db, `SELECT id, name, lastName, phone, email, regDate FROM donors`, true)
+	if err != nil {
+		return err
+	}
+	acceptors, err := readLegacyPersons(ctx, db, `SELECT id, name, lastName, '', '', regDate FROM acceptors`, false)
+	if err != nil {
+		return err
+	}
+
+	byDonorID := map[string]*legacyPerson{}
+	persons := make([]*legacyPerson, 0, len(donors)+len(acceptors))
+	for i := range donors {
+		donors[i].personID = shortuuid.New()
+		byDonorID[donors[i].id] = &donors[i]
+		persons = append(persons, &donors[i])
+	}
+	linked := 0
+	for i := range acceptors {
+		acceptor := &acceptors[i]
+		donor, ok := byDonorID[acceptor.id]
+		if ok && sameName(donor, acceptor) {
+			acceptor.personID = donor.personID
+			if acceptor.createdAt.Before(donor.createdAt) {
+				donor.createdAt = acceptor.createdAt
+			}
+			linked++
+			continue
+		}
+		acceptor.personID = shortuuid.New()
+		persons = append(persons, acceptor)
+	}
+
+	steps := []string{
+		createPersons,
+		`ALTER TABLE donors ADD COLUMN personId varchar(32) NULL AFTER id`,
+		`ALTER TABLE acceptors ADD COLUMN personId varchar(32) NULL AFTER id`,
+	}
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	err = inTransaction(ctx, db, func(tx *sql.Tx) error {
+		insert, err := tx.PrepareContext(ctx, `INSERT INTO persons (id, name, lastName, phone, email, createdAt) VALUES (?,?,?,?,?,?)`)
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+		for _, person := range persons {
+			if _, err := insert.ExecContext(ctx, person.personID, person.name, person.lastName, person.phone, person.email, person.createdAt); err != nil {
+				return err
+			}
+		}
+
+		if err := linkPersons(ctx, tx, `UPDATE donors SET personId=? WHERE id=?`, donors); err != nil {
+			return err
+		}
+		return linkPersons(ctx, tx, `UPDATE acceptors SET personId=? WHERE id=?`, acceptors)
+	})
+	if err != nil {
+		return err
+	}
+
+	steps = []string{
+		`ALTER TABLE donors DROP COLUMN name, DROP COLUMN lastName, DROP COLUMN phone, DROP COLUMN email,
+			MODIFY personId varchar(32) NOT NULL, ADD UNIQUE KEY (personId)`,
+		`ALTER TABLE acceptors DROP COLUMN name, DROP COLUMN lastName,
+			MODIFY personId varchar(32) NOT NULL, ADD UNIQUE KEY (personId)`,
+	}
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("%d persons created, %d of them are both donor and acceptor", len(persons), linked)
+	return nil
+}
+
+//readLegacyPersons reads the id, name, last name, phone, email and registration date of every row
+func readLegacyPersons(ctx context.Context, db *sql.DB, query string, withContacts bool) ([]legacyPerson, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]legacyPerson, 0)
+	for rows.Next() {
+		var name, lastName, phone, email sql.NullString
+		person := legacyPerson{}
+		if err := rows.Scan(&person.id, &name, &lastName, &phone, &email, &person.createdAt); err != nil {
+			return nil, err
+		}
+		person.name, person.lastName = name.String, lastName.String
+		if withContacts {
+			person.phone, person.email = phone.String, email.String
+		}
+		result = append(result, person)
+	}
+	return result, rows.Err()
+}
+
+func sameName(a, b *legacyPerson) bool {
+	return strings.EqualFold(strings.TrimSpace(a.name), strings.TrimSpace(b.name)) &&
+		strings.EqualFold(strings.TrimSpace(a.lastName), strings.TrimSpace(b.lastName))
+}
+
+func linkPersons(ctx context.Context, tx *sql.Tx, query string, rows []legacyPerson) error {
+	update, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	for _, row := range rows {
+		if _, err := update.ExecContext(ctx, row.personID, row.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//legacyDates are the dates of one row, converted to UTC
+type legacyDates struct {
+	id     string
+	values []interface{}
+}
+
+//migrateTemporalTypes converts the varchar dates, written by addDonor in local time and by the mock data
+//as e.g. "Sun Mar 15 02:44:15 EET 2019", to UTC DATETIME columns. Every value is parsed before the schema
+//changes, so a date that cannot be read stops the migration while the tables are still untouched.
+func migrateTemporalTypes(ctx context.Context, db *sql.DB) error {
+	donors, err := readLegacyDates(ctx, db, `SELECT id, regDate, emailVerifiedAt, phoneVerifiedAt FROM donors`, 3)
+	if err != nil {
+		return err
+	}
+	acceptors, err := readLegacyDates(ctx, db, `SELECT id, regDate FROM acceptors`, 1)
+	if err != nil {
+		return err
+	}
+
+	steps := []string{
+		`ALTER TABLE donors ADD COLUMN dateOfBirth DATE NULL AFTER email,
+			ADD COLUMN regDateUTC DATETIME NULL, ADD COLUMN emailVerifiedAtUTC DATETIME NULL, ADD COLUMN phoneVerifiedAtUTC DATETIME NULL`,
+		`ALTER TABLE acceptors ADD COLUMN regDateUTC DATETIME NULL`,
+	}
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	err = inTransaction(ctx, db, func(tx *sql.Tx) error {
+		if err := updateDates(ctx, tx, `UPDATE donors SET regDateUTC=?, emailVerifiedAtUTC=?, phoneVerifiedAtUTC=? WHERE id=?`, donors); err != nil {
+			return err
+		}
+		return updateDates(ctx, tx, `UPDATE acceptors SET regDateUTC=? WHERE id=?`, acceptors)
+	})
+	if err != nil {
+		return err
+	}
+
+	steps = []string{
+		`ALTER TABLE donors DROP COLUMN age, DROP COLUMN regDate, DROP COLUMN emailVerifiedAt, DROP COLUMN phoneVerifiedAt`,
+		`ALTER TABLE donors CHANGE regDateUTC regDate DATETIME NOT NULL AFTER city,
+			CHANGE emailVerifiedAtUTC emailVerifiedAt DATETIME NULL AFTER emailVerified,
+			CHANGE phoneVerifiedAtUTC phoneVerifiedAt DATETIME NULL AFTER phoneVerified`,
+		`ALTER TABLE acceptors DROP COLUMN regDate`,
+		`ALTER TABLE acceptors CHANGE regDateUTC regDate DATETIME NOT NULL AFTER bloodCenter`,
+	}
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	//an age cannot be turned back into a date of birth, those donors are asked for it again
+	log.Printf("%d donors have no date of birth until they update their account", len(donors))
+	return nil
+}
+
+//readLegacyDates reads the id and the date columns of every row, the first date, such as regDate, is required
+func readLegacyDates(ctx context.Context, db *sql.DB, query string, columns int) ([]legacyDates, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]legacyDates, 0)
+	for rows.Next() {
+		var id string
+		raw := make([]sql.NullString, columns)
+		dest := []interface{}{&id}
+		for i := range raw {
+			dest = append(dest, &raw[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := legacyDates{id: id}
+		for i, value := range raw {
+			if strings.TrimSpace(value.String) == "" {
+				if i == 0 {
+					return nil, fmt.Errorf("row %s has no date in its first column", id)
+				}
+				row.values = append(row.values, nil)
+				continue
+			}
+			parsed, err := parseLegacyTime(value.String, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("row %s: %s", id, err.Error())
+			}
+			row.values = append(row.values, parsed)
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func updateDates(ctx context.Context, tx *sql.Tx, query string, rows []legacyDates) error {
+	update, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	for _, row := range rows {
+		if _, err := update.ExecContext(ctx, append(row.values, row.id)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//legacyLayouts are the formats dates were written in before they became DATETIME columns
+var legacyLayouts = []string{"2006-01-02 15:04:05", time.UnixDate, time.RFC3339}
+
+//zoneOffsets of the abbreviations found in old rows, Go only knows the abbreviations of the local time zone
+var zoneOffsets = map[string]int{
+	"UTC":  0,
+	"GMT":  0,
+	"WET":  0,
+	"WEST": 1,
+	"CET":  1,
+	"CEST": 2,
+	"EET":  2,
+	"EEST": 3,
+}
+
+//parseLegacyTime reads a date written in one of the legacyLayouts, dates without a zone are in local
+func parseLegacyTime(value string, local *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range legacyLayouts {
+		parsed, err := time.ParseInLocation(layout, value, local)
+		if err != nil {
+			continue
+		}
+
+		//an abbreviation unknown to local is parsed with a zero offset
+		if name, offset := parsed.Zone(); offset == 0 && layout == time.UnixDate {
+			hours, ok := zoneOffsets[name]
+			if !ok {
+				return time.Time{}, fmt.Errorf("unknown time zone %s in %q", name, value)
+			}
+			parsed = time.Date(parsed.Year(), parsed.Month(), parsed.Day(), parsed.Hour(), parsed.Minute(), parsed.Second(), 0,
+				time.FixedZone(name, hours*3600))
+		}
+		return parsed.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date this migration can read", value)
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+	"github.com/life-blood/accounts-service/internal/sqlfake"
+)
+
+func TestParseLegacyTime(t *testing.T) {
+	local := time.FixedZone("EET", 2*3600)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		//dates without a zone are local
+		{"2019-03-15 02:44:15", time.Date(2019, 3, 15, 0, 44, 15, 0, time.UTC)},
+		{"  2019-03-15 02:44:15 ", time.Date(2019, 3, 15, 0, 44, 15, 0, time.UTC)},
+		{"Fri Mar 15 02:44:15 EET 2019", time.Date(2019, 3, 15, 0, 44, 15, 0, time.UTC)},
+		//abbreviations the local zone does not know come from zoneOffsets
+		{"Sun Jul 14 12:00:00 EEST 2019", time.Date(2019, 7, 14, 9, 0, 0, 0, time.UTC)},
+		{"Sun Jul 14 12:00:00 CEST 2019", time.Date(2019, 7, 14, 10, 0, 0, 0, time.UTC)},
+		{"Sun Jul 14 12:00:00 UTC 2019", time.Date(2019, 7, 14, 12, 0, 0, 0, time.UTC)},
+		{"2019-07-14T12:00:00+03:00", time.Date(2019, 7, 14, 9, 0, 0, 0, time.UTC)},
+		{"2019-07-14T12:00:00Z", time.Date(2019, 7, 14, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLegacyTime(tt.value, local)
+		if err != nil {
+			t.Errorf("parseLegacyTime(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("parseLegacyTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "15.03.2019", "Sun Jul 14 12:00:00 XYZ 2019", "2019-02-30 10:00:00"} {
+		if got, err := parseLegacyTime(value, local); err == nil {
+			t.Errorf("parseLegacyTime(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+//migrationDatabase answers the lock and the recorded version, and fails the first migration statement
+func migrationDatabase(recorded driver.Value, statements *[]string) sqlfake.Handler {
+	return func(query string, args []driver.Value) sqlfake.Result {
+		*statements = append(*statements, query)
+		switch {
+		case strings.Contains(query, "GET_LOCK"):
+			return sqlfake.Rows([]string{"locked"}, []driver.Value{int64(1)})
+		case strings.Contains(query, "RELEASE_LOCK"), strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_version"):
+			return sqlfake.Result{}
+		case strings.Contains(query, "MAX(version)"):
+			return sqlfake.Rows([]string{"version"}, []driver.Value{recorded})
+		}
+		return sqlfake.Error(errors.New("no such table"))
+	}
+}
+
+func TestMigrateDatabaseWithoutRecordedVersion(t *testing.T) {
+	var statements []string
+	err := MigrateDatabase(sqlfake.Open(migrationDatabase(nil, &statements)))
+	if err == nil || !strings.HasPrefix(err.Error(), "migration to version 2:") {
+		t.Fatalf("MigrateDatabase = %v, want the migration to version 2 to run", err)
+	}
+
+	created, read := -1, -1
+	for i, statement := range statements {
+		switch {
+		case strings.HasPrefix(statement, "CREATE TABLE IF NOT EXISTS schema_version"):
+			created = i
+		case strings.Contains(statement, "MAX(version)"):
+			read = i
+		}
+	}
+	if created < 0 || created > read {
+		t.Errorf("statements %q do not create schema_version before reading it", statements)
+	}
+}
+
+func TestMigrateDatabaseAtCurrentVersion(t *testing.T) {
+	var statements []string
+	if err := MigrateDatabase(sqlfake.Open(migrationDatabase(int64(app.SchemaVersion), &statements))); err != nil {
+		t.Fatalf("MigrateDatabase = %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	notifyChannels   = "NOTIFY_CHANNELS"
+	notifyThrottle   = "NOTIFY_THROTTLE"
+	notifyWebhookURL = "NOTIFY_WEBHOOK_URL"
+)
+
+const defaultNotifyThrottle = 72 * time.Hour
+
+//NotificationsConfig how donors are told about acceptors in need
+type NotificationsConfig struct {
+	//Channels email, sms and webhook
+	Channels []string
+	//Throttle minimal period between two notifications to the same donor
+	Throttle   time.Duration
+	WebhookURL string
+}
+
+//CreateNotificationChannels build the configured channels
+func CreateNotificationChannels(config NotificationsConfig, mailer app.Mailer, smsSender app.SMSSender) ([]app.NotificationChannel, error) {
+	channels := make([]app.NotificationChannel, 0)
+	for _, name := range config.Channels {
+		switch name {
+		case "email":
+			channels = append(channels, app.EmailChannel{Mailer: mailer})
+		case "sms":
+			channels = append(channels, app.SMSChannel{Sender: smsSender})
+		case "webhook":
+			if config.WebhookURL == "" {
+				return nil, errors.New("NOTIFY_WEBHOOK_URL is required for the webhook notification channel")
+			}
+			channels = append(channels, app.NewWebhookChannel(config.WebhookURL))
+		default:
+			return nil, errors.New("unknown notification channel " + name)
+		}
+	}
+	return channels, nil
+}
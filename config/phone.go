@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	phoneDefaultRegion = "PHONE_DEFAULT_REGION"
+	smsTransport       = "SMS_TRANSPORT"
+	smsCodeTTL         = "SMS_CODE_TTL"
+	smsMaxAttempts     = "SMS_MAX_ATTEMPTS"
+)
+
+const (
+	defaultSMSCodeTTL     = 10 * time.Minute
+	defaultSMSMaxAttempts = 5
+)
+
+//PhoneConfig phone number parsing and SMS verification codes
+type PhoneConfig struct {
+	//DefaultRegion region used to interpret national phone numbers
+	DefaultRegion string
+	//SMSTransport only "log" is available
+	SMSTransport string
+	CodeTTL      time.Duration
+	MaxAttempts  int
+}
+
+//CreateSMSSender build the configured SMS transport
+func CreateSMSSender(config PhoneConfig) (app.SMSSender, error) {
+	switch config.SMSTransport {
+	case "", "log":
+		return app.LogSMSSender{}, nil
+	default:
+		return nil, errors.New("unknown SMS_TRANSPORT " + config.SMSTransport)
+	}
+}
+
+//CreatePhoneVerifier build the SMS code verifier
+func CreatePhoneVerifier(config PhoneConfig, repo *app.PhoneVerificationsMySQL, sender app.SMSSender) *app.PhoneVerifier {
+	return app.NewPhoneVerifier(repo, sender, config.CodeTTL, config.MaxAttempts)
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file, limits are written as <requests>/<period>, e.g. 10/1h
+const (
+	rateLimitEnabled      = "RATE_LIMIT_ENABLED"
+	rateLimitDefault      = "RATE_LIMIT_DEFAULT"
+	rateLimitRegistration = "RATE_LIMIT_REGISTRATION"
+	rateLimitVerification = "RATE_LIMIT_VERIFICATION"
+	rateLimitAdmin        = "RATE_LIMIT_ADMIN"
+	trustedProxies        = "TRUSTED_PROXIES"
+)
+
+const (
+	defaultRateLimit             = "300/1m"
+	defaultRateLimitRegistration = "10/1h"
+	defaultRateLimitVerification = "20/10m"
+	defaultRateLimitAdmin        = "600/1m"
+)
+
+//RateLimitConfig limit of every route group, written as <requests>/<period>, and the proxies allowed to
+//report client addresses
+type RateLimitConfig struct {
+	Default        string
+	Registration   string
+	Verification   string
+	Admin          string
+	TrustedProxies []string
+}
+
+//CreateRateLimiter build the per-client limiter for every route group
+func CreateRateLimiter(config RateLimitConfig) (*app.RateLimiter, error) {
+	groups := []struct {
+		name  string
+		value string
+	}{
+		{app.RateLimitDefault, config.Default},
+		{app.RateLimitRegistration, config.Registration},
+		{app.RateLimitVerification, config.Verification},
+		{app.RateLimitAdmin, config.Admin},
+	}
+
+	limits := map[string]app.RateLimit{}
+	for _, group := range groups {
+		limit, err := app.ParseRateLimit(group.value)
+		if err != nil {
+			return nil, err
+		}
+		limits[group.name] = limit
+	}
+
+	proxies, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", trustedProxies, err.Error())
+	}
+	return app.NewRateLimiter(limits, proxies), nil
+}
+
+//parseNetworks reads CIDR blocks or single addresses
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0)
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"net/http"
+	"time"
+)
+
+//Configured from .env configuration file
+const (
+	httpAddr              = "HTTP_ADDR"
+	requestTimeout        = "REQUEST_TIMEOUT"
+	shutdownTimeout       = "SHUTDOWN_TIMEOUT"
+	readyTimeout          = "READINESS_TIMEOUT"
+	httpReadHeaderTimeout = "HTTP_READ_HEADER_TIMEOUT"
+	httpReadTimeout       = "HTTP_READ_TIMEOUT"
+	httpIdleTimeout       = "HTTP_IDLE_TIMEOUT"
+)
+
+const (
+	defaultHTTPAddr          = ":4200"
+	defaultRequestTimeout    = 30 * time.Second
+	defaultShutdownTimeout   = 20 * time.Second
+	defaultReadyTimeout      = 2 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+//HTTPConfig address and timeouts of the HTTP server
+type HTTPConfig struct {
+	Addr string
+	//PublicBaseURL address under which the service is reachable by its users, http://localhost:<port> by default
+	PublicBaseURL string
+	//RequestTimeout deadline applied to the handling of every request
+	RequestTimeout time.Duration
+	//ShutdownTimeout how long in-flight requests and background work may take to drain on shutdown
+	ShutdownTimeout time.Duration
+	//ReadinessTimeout how long the dependency checks of /readyz may take together
+	ReadinessTimeout  time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	IdleTimeout       time.Duration
+}
+
+//CreateServer http server with timeouts guarding against slow clients, the write timeout leaves the
+//handler its request timeout plus some time to send the answer
+func CreateServer(config HTTPConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.RequestTimeout + 10*time.Second,
+		IdleTimeout:       config.IdleTimeout,
+	}
+}
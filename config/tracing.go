@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"os"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Configured from .env configuration file
+const (
+	traceExporter = "TRACE_EXPORTER"
+	traceFile     = "TRACE_FILE"
+)
+
+const defaultTraceFile = "./traces.jsonl"
+
+//TracingConfig where finished spans are exported
+type TracingConfig struct {
+	//Exporter "none", "stdout" or "file"
+	Exporter string
+	File     string
+}
+
+//CreateSpanExporter build the configured exporter, nil when spans are not exported
+func CreateSpanExporter(config TracingConfig) (app.SpanExporter, error) {
+	switch config.Exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return app.NewWriterExporter(os.Stdout), nil
+	case "file":
+		return app.NewFileExporter(config.File)
+	default:
+		return nil, errors.New("unknown TRACE_EXPORTER " + config.Exporter)
+	}
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/life-blood/accounts-service/app"
+)
+
+//Validate check the settings together, so every mistake is reported at startup at once
+func (c *Config) Validate() error {
+	v := &validation{config: c}
+
+	v.address("http.addr", c.HTTP.Addr)
+	v.absoluteURL("http.publicBaseURL", c.HTTP.PublicBaseURL)
+	v.positive("http.requestTimeout", c.HTTP.RequestTimeout)
+	v.positive("http.shutdownTimeout", c.HTTP.ShutdownTimeout)
+	v.positive("http.readinessTimeout", c.HTTP.ReadinessTimeout)
+	v.positive("http.readHeaderTimeout", c.HTTP.ReadHeaderTimeout)
+	v.positive("http.readTimeout", c.HTTP.ReadTimeout)
+	v.positive("http.idleTimeout", c.HTTP.IdleTimeout)
+
+	v.required("database.host", c.Database.Host)
+	v.port("database.port", c.Database.Port)
+	v.required("database.user", c.Database.User)
+	v.required("database.name", c.Database.Name)
+	v.oneOf("database.tls", c.Database.TLS, "", "false", "true", "skip-verify", "preferred")
+	if c.Database.customTLS() {
+		v.check(c.Database.TLS != "false" && c.Database.TLS != "preferred", "database.tls", "must be true or skip-verify with custom certificates")
+	}
+	v.check((c.Database.TLSCert == "") == (c.Database.TLSKey == ""), "database.tlsKey", "must be set together with database.tlsCert")
+	v.required("database.charset", c.Database.Charset)
+	//accounts keep their dates in DATETIME columns, in UTC
+	v.check(c.Database.ParseTime, "database.parseTime", "must be true, dates are read as DATETIME")
+	v.check(c.Database.Loc == "UTC", "database.loc", "must be UTC, dates are stored in UTC")
+	v.check(c.Database.MaxOpenConns >= 0, "database.maxOpenConns", "must not be negative")
+	v.check(c.Database.MaxIdleConns >= 0, "database.maxIdleConns", "must not be negative")
+	v.check(c.Database.ConnMaxLifetime >= 0, "database.connMaxLifetime", "must not be negative")
+	v.check(c.Database.ConnectAttempts >= 1, "database.connectAttempts", "must be a positive number")
+	v.positive("database.connectBackoff", c.Database.ConnectBackoff)
+	for _, address := range c.Database.Replicas {
+		_, err := c.Database.replica(address)
+		v.check(err == nil, "database.replicas", fmt.Sprintf("must list host:port addresses, not %q", address))
+	}
+	v.positive("database.replicaCheckInterval", c.Database.ReplicaCheckInterval)
+
+	v.oneOf("storage.backend", c.Storage.Backend, "mysql")
+
+	_, err := app.ParseLevel(c.Log.Level)
+	v.check(err == nil, "log.level", "must be debug, info, warn or error")
+
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "file")
+	if c.Tracing.Exporter == "file" {
+		v.required("tracing.file", c.Tracing.File)
+	}
+
+	v.oneOf("mail.transport", c.Mail.Transport, "outbox", "smtp")
+	if c.Mail.Transport == "smtp" {
+		v.required("mail.smtpHost", c.Mail.SMTPHost)
+		v.port("mail.smtpPort", c.Mail.SMTPPort)
+	}
+	v.required("mail.tokenSecret", string(c.Mail.TokenSecret))
+	v.positive("mail.tokenTTL", c.Mail.TokenTTL)
+
+	v.check(app.IsSupportedPhoneRegion(c.Phone.DefaultRegion), "phone.defaultRegion", "is not a supported region")
+	v.oneOf("phone.smsTransport", c.Phone.SMSTransport, "log")
+	v.positive("phone.codeTTL", c.Phone.CodeTTL)
+	v.check(c.Phone.MaxAttempts >= 1, "phone.maxAttempts", "must be a positive number")
+
+	for _, channel := range c.Notifications.Channels {
+		v.oneOf("notifications.channels", channel, "email", "sms", "webhook")
+		if channel == "webhook" {
+			v.absoluteURL("notifications.webhookURL", c.Notifications.WebhookURL)
+		}
+	}
+	v.positive("notifications.throttle", c.Notifications.Throttle)
+
+	v.oneOf("events.publisher", c.Events.Publisher, "log")
+	v.positive("events.pollInterval", c.Events.PollInterval)
+
+	v.positive("webhooks.pollInterval", c.Webhooks.PollInterval)
+	v.check(c.Webhooks.MaxAttempts >= 1, "webhooks.maxAttempts", "must be a positive number")
+
+	v.check(c.CORS.MaxAge >= 0, "cors.maxAge", "must not be negative")
+
+	v.positive("idempotency.ttl", c.Idempotency.TTL)
+
+	v.positive("auth.accessTokenTTL", c.Auth.AccessTokenTTL)
+	v.positive("auth.refreshTokenTTL", c.Auth.RefreshTokenTTL)
+	v.check(c.Auth.RefreshTokenTTL > c.Auth.AccessTokenTTL, "auth.refreshTokenTTL", "must be longer than auth.accessTokenTTL")
+	v.positive("auth.registrationTokenTTL", c.Auth.RegistrationTokenTTL)
+	v.check(c.Auth.PasswordMinLength >= app.MinPasswordLength, "auth.passwordMinLength", fmt.Sprintf("must be at least %d", app.MinPasswordLength))
+	v.check(c.Auth.BcryptCost >= app.MinBcryptCost && c.Auth.BcryptCost <= app.MaxBcryptCost, "auth.bcryptCost",
+		fmt.Sprintf("must be between %d and %d", app.MinBcryptCost, app.MaxBcryptCost))
+
+	if c.Features.RateLimit {
+		limits := []struct{ key, value string }{
+			{"rateLimit.default", c.RateLimit.Default},
+			{"rateLimit.registration", c.RateLimit.Registration},
+			{"rateLimit.verification", c.RateLimit.Verification},
+			{"rateLimit.admin", c.RateLimit.Admin},
+		}
+		for _, limit := range limits {
+			_, err := app.ParseRateLimit(limit.value)
+			v.check(err == nil, limit.key, "must look like 100/1m")
+		}
+		_, err := parseNetworks(c.RateLimit.TrustedProxies)
+		v.check(err == nil, "rateLimit.trustedProxies", "must list addresses or CIDR blocks")
+	}
+
+	return v.err()
+}
+
+type validation struct {
+	config   *Config
+	problems []string
+}
+
+func (v *validation) check(ok bool, key, problem string) {
+	if ok {
+		return
+	}
+	//name the variable too, most deployments set it rather than the key
+	if b, found := v.config.binding(key); found {
+		key = fmt.Sprintf("%s (%s)", key, b.env)
+	}
+	v.problems = append(v.problems, key+" "+problem)
+}
+
+func (v *validation) required(key, value string) {
+	v.check(value != "", key, "is required")
+}
+
+func (v *validation) positive(key string, value time.Duration) {
+	v.check(value > 0, key, "must be a positive duration")
+}
+
+func (v *validation) port(key string, port int) {
+	v.check(port > 0 && port < 65536, key, "must be a port between 1 and 65535")
+}
+
+func (v *validation) oneOf(key, value string, allowed ...string) {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	v.check(false, key, fmt.Sprintf("must be one of %s, not %q", strings.Join(allowed, ", "), value))
+}
+
+func (v *validation) address(key, value string) {
+	_, port, err := net.SplitHostPort(value)
+	number, _ := strconv.Atoi(port)
+	v.check(err == nil && number > 0 && number < 65536, key, "must be an address such as :4200")
+}
+
+func (v *validation) absoluteURL(key, value string) {
+	parsed, err := url.Parse(value)
+	v.check(err == nil && parsed.Scheme != "" && parsed.Host != "", key, "must be an absolute URL")
+}
+
+func (v *validation) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration: " + strings.Join(v.problems, "; "))
+}
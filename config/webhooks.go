@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+//Configured from .env configuration file
+const (
+	adminToken          = "ADMIN_TOKEN"
+	webhookPollInterval = "WEBHOOK_POLL_INTERVAL"
+	webhookMaxAttempts  = "WEBHOOK_MAX_ATTEMPTS"
+)
+
+const (
+	defaultWebhookPollInterval = 5 * time.Second
+	defaultWebhookMaxAttempts  = 8
+)
+
+//WebhooksConfig administration and delivery of webhook subscriptions
+type WebhooksConfig struct {
+	//AdminToken bearer token required by the /admin endpoints, they are closed when it is empty
+	AdminToken Secret
+	//PollInterval how often the worker looks for due deliveries
+	PollInterval time.Duration
+	//MaxAttempts number of failed attempts after which a delivery is dead-lettered
+	MaxAttempts int
+}
@@ -10,4 +10,5 @@ require (
 	github.com/johan-lejdung/go-microservice-api-guide v0.0.0-20181123132308-5eb40dfd400d // indirect
 	github.com/joho/godotenv v1.3.0
 	github.com/lithammer/shortuuid v3.0.0+incompatible
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
@@ -0,0 +1,211 @@
+// Package sqlfake is a database/sql driver answering every statement from a function, so repositories and
+// handlers can be tested without a MySQL server. Statements are matched on their text, e.g.
+//
+//	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+//		if strings.Contains(query, "FROM donors") {
+//			return sqlfake.Rows([]string{"id"}, []driver.Value{"12"})
+//		}
+//		return sqlfake.Result{RowsAffected: 1}
+//	})
+package sqlfake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Result answers a statement: the rows of a query, the affected rows of an exec, or an error for both
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+	// RowsErr ends the iteration of the rows as a broken connection would, after the rows were read
+	RowsErr error
+}
+
+// Rows builds the result of a query
+func Rows(columns []string, rows ...[]driver.Value) Result {
+	return Result{Columns: columns, Rows: rows}
+}
+
+// Error fails the statement with err
+func Error(err error) Result {
+	return Result{Err: err}
+}
+
+// Handler answers the statement, args are the converted arguments
+type Handler func(query string, args []driver.Value) Result
+
+// Open returns a database whose statements are answered by handler. Preparing never fails, transactions
+// are accepted and have no effect on the answers.
+func Open(handler Handler) *sql.DB {
+	return sql.OpenDB(&connector{handler: handler})
+}
+
+// Transactions is told when a transaction begins and ends, Store implements it
+type Transactions interface {
+	Begin()
+	Commit()
+	Rollback()
+}
+
+// OpenTx is Open with the transactions passed to transactions, so a rolled back transaction can undo
+// the writes answered by handler
+func OpenTx(handler Handler, transactions Transactions) *sql.DB {
+	return sql.OpenDB(&connector{handler: handler, transactions: transactions})
+}
+
+// Recorder keeps the statements a handler was asked, for asserting on the writes of the code under test
+type Recorder struct {
+	mu         sync.Mutex
+	statements []Statement
+}
+
+// Statement is a statement run against the database
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Record wraps handler, keeping every statement before answering it
+func (r *Recorder) Record(handler Handler) Handler {
+	return func(query string, args []driver.Value) Result {
+		r.mu.Lock()
+		r.statements = append(r.statements, Statement{Query: query, Args: args})
+		r.mu.Unlock()
+		return handler(query, args)
+	}
+}
+
+// Matching returns the recorded statements containing text
+func (r *Recorder) Matching(text string) []Statement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matching := make([]Statement, 0)
+	for _, statement := range r.statements {
+		if strings.Contains(statement.Query, text) {
+			matching = append(matching, statement)
+		}
+	}
+	return matching
+}
+
+type connector struct {
+	handler      Handler
+	transactions Transactions
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{handler: c.handler, transactions: c.transactions}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("sqlfake: use sqlfake.Open")
+}
+
+type conn struct {
+	handler      Handler
+	transactions Transactions
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{handler: c.handler, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if c.transactions != nil {
+		c.transactions.Begin()
+	}
+	return tx{transactions: c.transactions}, nil
+}
+
+type tx struct {
+	transactions Transactions
+}
+
+func (t tx) Commit() error {
+	if t.transactions != nil {
+		t.transactions.Commit()
+	}
+	return nil
+}
+
+func (t tx) Rollback() error {
+	if t.transactions != nil {
+		t.transactions.Rollback()
+	}
+	return nil
+}
+
+type stmt struct {
+	handler Handler
+	query   string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput is not checked, the handler sees whatever arguments were passed
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	result := s.handler(s.query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	result := s.handler(s.query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return &rows{columns: result.Columns, values: result.Rows, err: result.RowsErr}, nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+	err     error
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,702 @@
+package sqlfake
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Store keeps tables in memory and answers the plain statements the repositories use: INSERT, INSERT
+// IGNORE, REPLACE and INSERT ... ON DUPLICATE KEY UPDATE, UPDATE and DELETE of a single table, and SELECT
+// of a table with any number of JOINs, with WHERE conditions combined by AND and parenthesized OR, ORDER BY
+// one column, LIMIT, OFFSET, MAX and COUNT(*). The first column of an INSERT is the primary key, its values
+// are placeholders or the literals true, false, NULL and integers.
+// Anything else fails, a test wraps Handle to answer it:
+//
+//	db := sqlfake.Open(func(query string, args []driver.Value) sqlfake.Result {
+//		if strings.Contains(query, "NOT EXISTS") {
+//			return sqlfake.Result{}
+//		}
+//		return store.Handle(query, args)
+//	})
+//
+// Opened with OpenTx, the store undoes the writes of a rolled back transaction. Transactions are not
+// isolated, the store expects one at a time.
+type Store struct {
+	mu       sync.Mutex
+	tables   map[string]*table
+	snapshot map[string]*table
+}
+
+type table struct {
+	key      string
+	defaults map[string]driver.Value
+	rows     []map[string]driver.Value
+}
+
+// NewStore creates an empty store, tables are created by their first insert
+func NewStore() *Store {
+	return &Store{tables: map[string]*table{}}
+}
+
+// SetDefaults gives the columns their DEFAULT value, rows inserted without them get it
+func (s *Store) SetDefaults(name string, defaults map[string]driver.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tables[name]
+	if !ok {
+		t = &table{}
+		s.tables[name] = t
+	}
+	t.defaults = defaults
+}
+
+// Insert adds a row, e.g. to seed a test, the key is the column named first in columns
+func (s *Store) Insert(name string, columns []string, values ...driver.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.table(name, columns[0])
+	row := t.newRow()
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+	t.rows = append(t.rows, row)
+}
+
+// Begin remembers the tables, for Rollback to restore them
+func (s *Store) Begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = make(map[string]*table, len(s.tables))
+	for name, t := range s.tables {
+		s.snapshot[name] = t.copy()
+	}
+}
+
+// Commit keeps the writes made since Begin
+func (s *Store) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = nil
+}
+
+// Rollback undoes the writes made since Begin
+func (s *Store) Rollback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot != nil {
+		s.tables = s.snapshot
+		s.snapshot = nil
+	}
+}
+
+func (t *table) copy() *table {
+	copied := &table{key: t.key, defaults: t.defaults, rows: make([]map[string]driver.Value, len(t.rows))}
+	for i, row := range t.rows {
+		copied.rows[i] = make(map[string]driver.Value, len(row))
+		for column, value := range row {
+			copied.rows[i][column] = value
+		}
+	}
+	return copied
+}
+
+// Rows returns copies of the rows of a table, in insertion order
+func (s *Store) Rows(name string) []map[string]driver.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]map[string]driver.Value, 0)
+	if t, ok := s.tables[name]; ok {
+		for _, row := range t.rows {
+			clone := map[string]driver.Value{}
+			for column, value := range row {
+				clone[column] = value
+			}
+			rows = append(rows, clone)
+		}
+	}
+	return rows
+}
+
+func (s *Store) table(name, key string) *table {
+	t, ok := s.tables[name]
+	if !ok {
+		t = &table{}
+		s.tables[name] = t
+	}
+	if t.key == "" {
+		t.key = key
+	}
+	return t
+}
+
+// newRow starts a row with the default values of the table
+func (t *table) newRow() map[string]driver.Value {
+	row := map[string]driver.Value{}
+	for column, value := range t.defaults {
+		row[column] = value
+	}
+	return row
+}
+
+var (
+	whitespace   = regexp.MustCompile(`\s+`)
+	insertSQL    = regexp.MustCompile(`^(INSERT|INSERT IGNORE|REPLACE) INTO (\w+) \(([^)]*)\) VALUES \(([^)]*)\)(?: ON DUPLICATE KEY UPDATE (.*))?$`)
+	updateSQL    = regexp.MustCompile(`^UPDATE (\w+) SET (.*?) WHERE (.*)$`)
+	deleteSQL    = regexp.MustCompile(`^DELETE FROM (\w+) WHERE (.*)$`)
+	selectSQL    = regexp.MustCompile(`^SELECT (.*?) FROM (\w+)((?: JOIN \w+ ON [\w.]+=[\w.]+)*)(?: WHERE (.*?))?(?: ORDER BY (.*?))?(?: LIMIT (\?|\d+)(?: OFFSET (\?|\d+))?)?(?: FOR UPDATE)?$`)
+	joinSQL      = regexp.MustCompile(` JOIN (\w+) ON ([\w.]+)=([\w.]+)`)
+	comparison   = regexp.MustCompile(`^([\w.]+) ?(=|<=|>=|<|>|LIKE) ?\?$`)
+	literal      = regexp.MustCompile(`^([\w.]+)=(true|false|NULL|\d+)$`)
+	inList       = regexp.MustCompile(`^([\w.]+) IN \(([?,]+)\)$`)
+	isNull       = regexp.MustCompile(`^([\w.]+) IS (NOT )?NULL$`)
+	increment    = regexp.MustCompile(`^(\w+)=(\w+)\+(\d+)$`)
+	valuesOf     = regexp.MustCompile(`^(\w+)=VALUES\((\w+)\)$`)
+	aggregateSQL = regexp.MustCompile(`^(MAX|COUNT)\((\*|[\w.]+)\)$`)
+)
+
+// Handle answers a statement from the tables, it is a Handler
+func (s *Store) Handle(query string, args []driver.Value) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query = strings.TrimSuffix(strings.TrimSpace(whitespace.ReplaceAllString(query, " ")), ";")
+	var result Result
+	var err error
+	switch {
+	case insertSQL.MatchString(query):
+		result, err = s.insert(insertSQL.FindStringSubmatch(query), args)
+	case updateSQL.MatchString(query):
+		result, err = s.update(updateSQL.FindStringSubmatch(query), args)
+	case deleteSQL.MatchString(query):
+		result, err = s.delete(deleteSQL.FindStringSubmatch(query), args)
+	case selectSQL.MatchString(query):
+		result, err = s.selectRows(selectSQL.FindStringSubmatch(query), args)
+	default:
+		err = fmt.Errorf("sqlfake: unsupported statement %q", query)
+	}
+	if err != nil {
+		return Error(err)
+	}
+	return result
+}
+
+func (s *Store) insert(match []string, args []driver.Value) (Result, error) {
+	verb, name, columns, values := match[1], match[2], splitList(match[3]), splitList(match[4])
+	if len(columns) != len(values) {
+		return Result{}, fmt.Errorf("sqlfake: %d columns and %d values inserted into %s", len(columns), len(values), name)
+	}
+	t := s.table(name, columns[0])
+	row := t.newRow()
+	for i, column := range columns {
+		if values[i] != "?" {
+			row[column] = literalValue(values[i])
+			continue
+		}
+		if len(args) == 0 {
+			return Result{}, fmt.Errorf("sqlfake: missing value for %s of %s", column, name)
+		}
+		row[column], args = args[0], args[1:]
+	}
+
+	for i, existing := range t.rows {
+		if !equal(existing[t.key], row[t.key]) {
+			continue
+		}
+		switch {
+		case verb == "INSERT IGNORE":
+			return Result{RowsAffected: 0}, nil
+		case verb == "REPLACE":
+			t.rows[i] = row
+			return Result{RowsAffected: 2}, nil
+		case match[5] != "":
+			for _, assignment := range splitList(match[5]) {
+				parts := valuesOf.FindStringSubmatch(assignment)
+				if parts == nil {
+					return Result{}, fmt.Errorf("sqlfake: unsupported assignment %q", assignment)
+				}
+				existing[parts[1]] = row[parts[2]]
+			}
+			return Result{RowsAffected: 2}, nil
+		}
+		return Result{}, &mysql.MySQLError{Number: 1062, Message: fmt.Sprintf("Duplicate entry '%v' for key 'PRIMARY'", row[t.key])}
+	}
+	t.rows = append(t.rows, row)
+	return Result{RowsAffected: 1}, nil
+}
+
+func (s *Store) update(match []string, args []driver.Value) (Result, error) {
+	t, ok := s.tables[match[1]]
+	if !ok {
+		return Result{}, nil
+	}
+
+	type change func(row map[string]driver.Value)
+	changes := make([]change, 0)
+	for _, assignment := range splitList(match[2]) {
+		assignment := assignment
+		switch {
+		case strings.HasSuffix(assignment, "=?"):
+			if len(args) == 0 {
+				return Result{}, fmt.Errorf("sqlfake: missing value for %q", assignment)
+			}
+			column, value := strings.TrimSuffix(assignment, "=?"), args[0]
+			args = args[1:]
+			changes = append(changes, func(row map[string]driver.Value) { row[column] = value })
+		case literal.MatchString(assignment):
+			parts := literal.FindStringSubmatch(assignment)
+			value := literalValue(parts[2])
+			changes = append(changes, func(row map[string]driver.Value) { row[parts[1]] = value })
+		case increment.MatchString(assignment):
+			parts := increment.FindStringSubmatch(assignment)
+			var by int64
+			fmt.Sscan(parts[3], &by)
+			changes = append(changes, func(row map[string]driver.Value) { row[parts[1]] = toInt(row[parts[2]]) + by })
+		default:
+			return Result{}, fmt.Errorf("sqlfake: unsupported assignment %q", assignment)
+		}
+	}
+
+	where, _, err := parseCondition(match[3], args)
+	if err != nil {
+		return Result{}, err
+	}
+	var affected int64
+	for _, row := range t.rows {
+		if where(func(column string) driver.Value { return row[unqualified(column)] }) {
+			for _, apply := range changes {
+				apply(row)
+			}
+			affected++
+		}
+	}
+	return Result{RowsAffected: affected}, nil
+}
+
+func (s *Store) delete(match []string, args []driver.Value) (Result, error) {
+	t, ok := s.tables[match[1]]
+	if !ok {
+		return Result{}, nil
+	}
+	where, _, err := parseCondition(match[2], args)
+	if err != nil {
+		return Result{}, err
+	}
+
+	kept := t.rows[:0]
+	var affected int64
+	for _, row := range t.rows {
+		if where(func(column string) driver.Value { return row[unqualified(column)] }) {
+			affected++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	t.rows = kept
+	return Result{RowsAffected: affected}, nil
+}
+
+// joined is a row of the selected table with the rows of the joined tables, if any
+type joined struct {
+	names []string
+	rows  []map[string]driver.Value
+}
+
+// get resolves a column, qualified with its table or looked up in the selected table first
+func (j joined) get(column string) driver.Value {
+	if i := strings.Index(column, "."); i >= 0 {
+		for k, name := range j.names {
+			if name == column[:i] {
+				return j.rows[k][column[i+1:]]
+			}
+		}
+		return nil
+	}
+	for _, row := range j.rows {
+		if value, ok := row[column]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+func (s *Store) selectRows(match []string, args []driver.Value) (Result, error) {
+	columns, name := splitList(match[1]), match[2]
+	candidates := make([]joined, 0)
+	if t, ok := s.tables[name]; ok {
+		for _, row := range t.rows {
+			candidates = append(candidates, joined{names: []string{name}, rows: []map[string]driver.Value{row}})
+		}
+	}
+	for _, join := range joinSQL.FindAllStringSubmatch(match[3], -1) {
+		other, ok := s.tables[join[1]]
+		if !ok {
+			other = &table{}
+		}
+		matched := make([]joined, 0)
+		for _, candidate := range candidates {
+			for _, row := range other.rows {
+				combined := joined{
+					names: append(append([]string{}, candidate.names...), join[1]),
+					rows:  append(append([]map[string]driver.Value{}, candidate.rows...), row),
+				}
+				if equal(combined.get(join[2]), combined.get(join[3])) {
+					matched = append(matched, combined)
+				}
+			}
+		}
+		candidates = matched
+	}
+
+	if match[4] != "" {
+		where, used, err := parseCondition(match[4], args)
+		if err != nil {
+			return Result{}, err
+		}
+		args = args[used:]
+		selected := make([]joined, 0)
+		for _, candidate := range candidates {
+			if where(candidate.get) {
+				selected = append(selected, candidate)
+			}
+		}
+		candidates = selected
+	}
+
+	if match[5] != "" {
+		order := strings.Fields(splitList(match[5])[0])
+		descending := len(order) > 1 && order[1] == "DESC"
+		sort.SliceStable(candidates, func(i, k int) bool {
+			c := compare(candidates[i].get(order[0]), candidates[k].get(order[0]))
+			if descending {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	if match[6] != "" {
+		limit, offset := bound(match[6], &args), 0
+		if match[7] != "" {
+			offset = bound(match[7], &args)
+		}
+		if offset > len(candidates) {
+			offset = len(candidates)
+		}
+		candidates = candidates[offset:]
+		if limit < len(candidates) {
+			candidates = candidates[:limit]
+		}
+	}
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = unqualified(column)
+	}
+	if aggregateSQL.MatchString(columns[0]) {
+		return Result{Columns: names, Rows: [][]driver.Value{aggregate(columns, candidates)}}, nil
+	}
+
+	rows := make([][]driver.Value, 0, len(candidates))
+	for _, candidate := range candidates {
+		values := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			values[i] = candidate.get(column)
+		}
+		rows = append(rows, values)
+	}
+	return Result{Columns: names, Rows: rows}, nil
+}
+
+// aggregate computes MAX(column) and COUNT(*) over the selected rows
+func aggregate(columns []string, candidates []joined) []driver.Value {
+	values := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		parts := aggregateSQL.FindStringSubmatch(column)
+		if parts == nil {
+			continue
+		}
+		if parts[1] == "COUNT" {
+			values[i] = int64(len(candidates))
+			continue
+		}
+		for _, candidate := range candidates {
+			value := candidate.get(parts[2])
+			if value != nil && (values[i] == nil || compare(value, values[i]) > 0) {
+				values[i] = value
+			}
+		}
+	}
+	return values
+}
+
+func bound(text string, args *[]driver.Value) int {
+	if text != "?" {
+		var n int
+		fmt.Sscan(text, &n)
+		return n
+	}
+	n := int(toInt((*args)[0]))
+	*args = (*args)[1:]
+	return n
+}
+
+// predicate tests a row, reading its columns with get
+type predicate func(get func(column string) driver.Value) bool
+
+// parseCondition compiles terms joined by AND, a term in parentheses holds alternatives joined by OR.
+// It returns how many arguments the condition consumed.
+func parseCondition(condition string, args []driver.Value) (predicate, int, error) {
+	terms := splitTopLevel(condition, " AND ")
+	predicates := make([]predicate, 0, len(terms))
+	used := 0
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if strings.HasPrefix(term, "(") && strings.HasSuffix(term, ")") {
+			alternatives := make([]predicate, 0)
+			for _, alternative := range splitTopLevel(term[1:len(term)-1], " OR ") {
+				p, n, err := parseCondition(alternative, args[used:])
+				if err != nil {
+					return nil, 0, err
+				}
+				alternatives = append(alternatives, p)
+				used += n
+			}
+			predicates = append(predicates, func(get func(string) driver.Value) bool {
+				for _, p := range alternatives {
+					if p(get) {
+						return true
+					}
+				}
+				return false
+			})
+			continue
+		}
+
+		p, n, err := parseTerm(term, args[used:])
+		if err != nil {
+			return nil, 0, err
+		}
+		predicates = append(predicates, p)
+		used += n
+	}
+
+	return func(get func(string) driver.Value) bool {
+		for _, p := range predicates {
+			if !p(get) {
+				return false
+			}
+		}
+		return true
+	}, used, nil
+}
+
+func parseTerm(term string, args []driver.Value) (predicate, int, error) {
+	switch {
+	case comparison.MatchString(term):
+		parts := comparison.FindStringSubmatch(term)
+		if len(args) == 0 {
+			return nil, 0, fmt.Errorf("sqlfake: missing value for %q", term)
+		}
+		column, operator, value := parts[1], parts[2], args[0]
+		return func(get func(string) driver.Value) bool {
+			actual := get(column)
+			if actual == nil || value == nil {
+				return false
+			}
+			c := compare(actual, value)
+			switch operator {
+			case "=":
+				return c == 0
+			case "<=":
+				return c <= 0
+			case ">=":
+				return c >= 0
+			case "<":
+				return c < 0
+			case ">":
+				return c > 0
+			}
+			return like(text(actual), text(value))
+		}, 1, nil
+	case literal.MatchString(term):
+		parts := literal.FindStringSubmatch(term)
+		value := literalValue(parts[2])
+		return func(get func(string) driver.Value) bool { return equal(get(parts[1]), value) }, 0, nil
+	case inList.MatchString(term):
+		parts := inList.FindStringSubmatch(term)
+		n := strings.Count(parts[2], "?")
+		if len(args) < n {
+			return nil, 0, fmt.Errorf("sqlfake: missing values for %q", term)
+		}
+		values := args[:n]
+		return func(get func(string) driver.Value) bool {
+			for _, value := range values {
+				if equal(get(parts[1]), value) {
+					return true
+				}
+			}
+			return false
+		}, n, nil
+	case isNull.MatchString(term):
+		parts := isNull.FindStringSubmatch(term)
+		return func(get func(string) driver.Value) bool { return (get(parts[1]) == nil) == (parts[2] == "") }, 0, nil
+	}
+	return nil, 0, fmt.Errorf("sqlfake: unsupported condition %q", term)
+}
+
+// like matches MySQL LIKE patterns with % and _ escaped by a backslash, case-insensitively as the
+// service's collation does
+func like(value, pattern string) bool {
+	expression := "(?is)^"
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expression += regexp.QuoteMeta(string(r))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expression += ".*"
+		case r == '_':
+			expression += "."
+		default:
+			expression += regexp.QuoteMeta(string(r))
+		}
+	}
+	return regexp.MustCompile(expression + "$").MatchString(value)
+}
+
+func literalValue(text string) driver.Value {
+	switch text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "NULL":
+		return nil
+	}
+	var n int64
+	fmt.Sscan(text, &n)
+	return n
+}
+
+func equal(a, b driver.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return compare(a, b) == 0
+}
+
+// compare orders two values, converting booleans to numbers and bytes to text as MySQL does
+func compare(a, b driver.Value) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	if isNumber(a) && isNumber(b) {
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(text(a)), strings.ToLower(text(b)))
+}
+
+func isNumber(v driver.Value) bool {
+	switch v.(type) {
+	case int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+func toFloat(v driver.Value) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toInt(v driver.Value) int64 {
+	return int64(toFloat(v))
+}
+
+func text(v driver.Value) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(bytes.TrimSpace(s))
+	case time.Time:
+		return s.UTC().Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+func unqualified(column string) string {
+	return column[strings.LastIndex(column, ".")+1:]
+}
+
+// splitList splits a comma separated list, commas inside parentheses are kept
+func splitList(list string) []string {
+	items := splitTopLevel(list, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func splitTopLevel(text, separator string) []string {
+	parts := make([]string, 0)
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(text[i:], separator) {
+			parts = append(parts, text[start:i])
+			start = i + len(separator)
+			i += len(separator) - 1
+		}
+	}
+	return append(parts, text[start:])
+}
@@ -0,0 +1,148 @@
+package sqlfake
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+	db := Open(store.Handle)
+	defer db.Close()
+	ctx := context.Background()
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	steps := []struct {
+		query string
+		args  []interface{}
+		rows  int64
+		err   bool
+	}{
+		{"INSERT INTO persons (id, name, createdAt) VALUES (?,?,?);", []interface{}{"p1", "Ivan", at}, 1, false},
+		{"INSERT INTO persons (id, name, createdAt) VALUES (?,?,?);", []interface{}{"p2", "Maria", at.Add(time.Hour)}, 1, false},
+		{"INSERT IGNORE INTO persons (id, name, createdAt) VALUES (?,?,?);", []interface{}{"p1", "Petar", at}, 0, false},
+		{"INSERT INTO donors (id, personId, bloodGroup, verified) VALUES (?,?,?,?)", []interface{}{"d1", "p1", "A+", false}, 1, false},
+		{"INSERT INTO donors (id, personId, bloodGroup, verified) VALUES (?,?,?,?)", []interface{}{"d2", "p2", "0-", false}, 1, false},
+		{"INSERT IGNORE INTO claims (id, status, note) VALUES (?,0,NULL)", []interface{}{"k1"}, 1, false},
+		{"UPDATE donors SET verified=true, bloodGroup=? WHERE id=?;", []interface{}{"B+", "d2"}, 1, false},
+		{"UPDATE donors SET bloodGroup=? WHERE (id=? OR personId=?) AND verified=false", []interface{}{"AB+", "x", "p1"}, 1, false},
+		{"SELECT id FROM donors WHERE name=?", []interface{}{"Ivan"}, 0, false},
+		{"DELETE FROM persons WHERE id=?", []interface{}{"p9"}, 0, false},
+		{"DROP TABLE persons", nil, 0, true},
+	}
+	for _, step := range steps {
+		result, err := db.ExecContext(ctx, step.query, step.args...)
+		if (err != nil) != step.err {
+			t.Fatalf("%s: err = %v, want error %v", step.query, err, step.err)
+		}
+		if err != nil {
+			continue
+		}
+		if affected, _ := result.RowsAffected(); affected != step.rows {
+			t.Errorf("%s: %d rows affected, want %d", step.query, affected, step.rows)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO persons (id, name, createdAt) VALUES (?,?,?)", "p1", "Petar", at); err == nil {
+		t.Error("a duplicate key was inserted")
+	} else if mysqlErr, ok := err.(*mysql.MySQLError); !ok || mysqlErr.Number != 1062 {
+		t.Errorf("duplicate key error %v, want MySQL error 1062", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT donors.id, name, bloodGroup, verified FROM donors JOIN persons ON persons.id=donors.personId
+		WHERE name LIKE ? ORDER BY createdAt DESC LIMIT ? OFFSET ?`, "%a%", 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make([][]string, 0)
+	for rows.Next() {
+		var id, name, bloodGroup string
+		var verified bool
+		if err := rows.Scan(&id, &name, &bloodGroup, &verified); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []string{id, name, bloodGroup})
+	}
+	want := [][]string{{"d2", "Maria", "B+"}, {"d1", "Ivan", "AB+"}}
+	if len(got) != len(want) || got[0][0] != want[0][0] || got[1][2] != want[1][2] {
+		t.Errorf("selected %v, want %v", got, want)
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO credentials (donorId, passwordHash) VALUES (?,?)", "d2", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	var donorID, name string
+	err = db.QueryRowContext(ctx, `SELECT credentials.donorId, name FROM credentials JOIN donors ON donors.id=credentials.donorId
+		JOIN persons ON persons.id=donors.personId WHERE verified=true`).Scan(&donorID, &name)
+	if err != nil || donorID != "d2" || name != "Maria" {
+		t.Errorf("two joins selected %s, %s, %v, want d2, Maria", donorID, name, err)
+	}
+
+	var latest time.Time
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT MAX(createdAt), COUNT(*) FROM persons").Scan(&latest, &count); err != nil {
+		t.Fatal(err)
+	}
+	if !latest.Equal(at.Add(time.Hour)) || count != 2 {
+		t.Errorf("MAX, COUNT = %v, %d", latest, count)
+	}
+
+	for pattern, want := range map[string]int{`%v_n%`: 1, `%v\_n%`: 0, `100\%`: 0} {
+		var matching int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM persons WHERE name LIKE ?", pattern).Scan(&matching); err != nil {
+			t.Fatal(err)
+		}
+		if matching != want {
+			t.Errorf("%d names LIKE %s, want %d", matching, pattern, want)
+		}
+	}
+
+	if rows := store.Rows("claims"); len(rows) != 1 || rows[0]["status"] != driver.Value(int64(0)) || rows[0]["note"] != nil {
+		t.Errorf("claims %v, want the literal values inserted", rows)
+	}
+	if rows := store.Rows("donors"); len(rows) != 2 || rows[1]["verified"] != driver.Value(true) {
+		t.Errorf("donors %v", rows)
+	}
+}
+
+func TestStoreTransactions(t *testing.T) {
+	store := NewStore()
+	db := OpenTx(store.Handle, store)
+	defer db.Close()
+	ctx := context.Background()
+	store.Insert("persons", []string{"id", "name"}, "p1", "Ivan")
+
+	for _, commit := range []bool{false, true} {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE persons SET name=? WHERE id=?", "Petar", "p1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO persons (id, name) VALUES (?,?)", "p2", "Maria"); err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows := store.Rows("persons")
+		if commit && (len(rows) != 2 || rows[0]["name"] != "Petar") {
+			t.Errorf("committed persons %v, want Petar and Maria", rows)
+		}
+		if !commit && (len(rows) != 1 || rows[0]["name"] != "Ivan") {
+			t.Errorf("rolled back persons %v, want Ivan only", rows)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"io"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -10,33 +15,221 @@ import (
 	db "github.com/life-blood/accounts-service/config"
 )
 
-const port = 4200
-
 func main() {
-	//load .env file from given path
-	err := godotenv.Load(".env")
+	//the .env file is optional, settings may as well come from a configuration file, the environment or flags
+	if err := godotenv.Load(".env"); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error loading .env file: %s", err.Error())
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	config, err := db.Load(flags, os.Args[1:])
+	if *printConfig && config != nil {
+		config.Print(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Configuration failed: %s", err.Error())
+	}
+	if *printConfig {
+		return
+	}
+
+	logger, err := db.CreateLogger(config.Log)
+	if err != nil {
+		log.Fatalf("Logger setup failed: %s", err.Error())
+	}
+	app.DefaultLogger = logger
+	//the remaining standard log output, e.g. of the config package, becomes JSON as well
+	log.SetFlags(0)
+	log.SetOutput(logger.StdWriter())
+
+	spanExporter, err := db.CreateSpanExporter(config.Tracing)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Tracing setup failed: %s", err.Error())
+	}
+	if spanExporter != nil {
+		app.DefaultTracer = app.NewTracer("accounts-service", spanExporter)
 	}
 
-	database, err := db.CreateDatabaseConn()
+	cluster, err := db.CreateDBCluster(config.Database)
 	if err != nil {
 		log.Fatalf("Database connection failed: %s", err.Error())
 	}
+	database := cluster.Primary()
+	if len(cluster.Replicas()) > 0 {
+		cluster.Start()
+	}
+
+	if config.Features.ResetSchema {
+		db.InitializeDatabase(database)
+	}
+	if config.Features.MockData {
+		db.PopulateWithMockData(database)
+	}
+	if err := db.MigrateDatabase(database); err != nil {
+		log.Fatalf("Database migration failed: %s", err.Error())
+	}
+
+	mailer, err := db.CreateMailer(config.Mail)
+	if err != nil {
+		log.Fatalf("Mailer setup failed: %s", err.Error())
+	}
+
+	emailVerifier, err := db.CreateEmailVerifier(config.Mail)
+	if err != nil {
+		log.Fatalf("Email verification setup failed: %s", err.Error())
+	}
+
+	smsSender, err := db.CreateSMSSender(config.Phone)
+	if err != nil {
+		log.Fatalf("SMS setup failed: %s", err.Error())
+	}
+
+	//the repositories prepare their statements once and close them on shutdown
+	donorsRepo, err := app.NewDonorsMySQL(cluster)
+	if err != nil {
+		log.Fatalf("Donors repository setup failed: %s", err.Error())
+	}
+	acceptorsRepo, err := app.NewAcceptorsMySQL(cluster)
+	if err != nil {
+		log.Fatalf("Acceptors repository setup failed: %s", err.Error())
+	}
+	personsRepo, err := app.NewPersonsMySQL(database)
+	if err != nil {
+		log.Fatalf("Persons repository setup failed: %s", err.Error())
+	}
+	phoneVerificationsRepo, err := app.NewPhoneVerificationsMySQL(database)
+	if err != nil {
+		log.Fatalf("Phone verifications repository setup failed: %s", err.Error())
+	}
+	notificationsRepo, err := app.NewNotificationsMySQL(database)
+	if err != nil {
+		log.Fatalf("Notifications repository setup failed: %s", err.Error())
+	}
+	webhooksRepo, err := app.NewWebhooksMySQL(database)
+	if err != nil {
+		log.Fatalf("Webhooks repository setup failed: %s", err.Error())
+	}
+	outboxRepo, err := app.NewOutboxMySQL(database)
+	if err != nil {
+		log.Fatalf("Outbox repository setup failed: %s", err.Error())
+	}
+	idempotencyRepo, err := app.NewIdempotencyMySQL(database)
+	if err != nil {
+		log.Fatalf("Idempotency repository setup failed: %s", err.Error())
+	}
+	authRepo, err := app.NewAuthMySQL(database)
+	if err != nil {
+		log.Fatalf("Auth repository setup failed: %s", err.Error())
+	}
+	repositories := []io.Closer{donorsRepo, acceptorsRepo, personsRepo, phoneVerificationsRepo, notificationsRepo, webhooksRepo, outboxRepo, idempotencyRepo, authRepo}
+
+	phoneVerifier := db.CreatePhoneVerifier(config.Phone, phoneVerificationsRepo, smsSender)
+
+	channels, err := db.CreateNotificationChannels(config.Notifications, mailer, smsSender)
+	if err != nil {
+		log.Fatalf("Notification setup failed: %s", err.Error())
+	}
 
-	db.InitializeDatabase(database)
-	db.PopulateWithMockData(database)
+	notifier := app.NewNotifier(donorsRepo, notificationsRepo, channels, config.Notifications.Throttle)
 
-	donorsRepo := app.NewDonorsMySQL(database)
-	acceptorsRepo := app.NewAcceptorsMySQL(database)
+	publisher, err := db.CreatePublisher(config.Events)
+	if err != nil {
+		log.Fatalf("Event publisher setup failed: %s", err.Error())
+	}
+
+	var rateLimiter *app.RateLimiter
+	if config.Features.RateLimit {
+		if rateLimiter, err = db.CreateRateLimiter(config.RateLimit); err != nil {
+			log.Fatalf("Rate limit setup failed: %s", err.Error())
+		}
+	}
+
+	webhookWorker := app.NewWebhookWorker(webhooksRepo, config.Webhooks.PollInterval, config.Webhooks.MaxAttempts)
+	webhookWorker.Start()
+
+	relay := app.NewOutboxRelay(outboxRepo, app.MultiPublisher{publisher, app.NewWebhookDispatcher(webhooksRepo)}, config.Events.PollInterval)
+	relay.Start()
 
 	app := &app.App{
 		Router:        mux.NewRouter().StrictSlash(true),
+		Database:      database,
+		Cluster:       cluster,
 		DonorsRepo:    donorsRepo,
 		AcceptorsRepo: acceptorsRepo,
+		PersonsRepo:   personsRepo,
+		Mailer:        mailer,
+		EmailVerifier: emailVerifier,
+		PhoneVerifier: phoneVerifier,
+		PhoneRegion:   config.Phone.DefaultRegion,
+		Notifier:      notifier,
+		Notifications: notificationsRepo,
+		WebhooksRepo:  webhooksRepo,
+		AdminToken:    string(config.Webhooks.AdminToken),
+		BaseURL:       config.HTTP.PublicBaseURL,
+		CORS:          db.CreateCORSConfig(config.CORS),
+		RateLimiter:   rateLimiter,
+		Idempotency:   app.NewIdempotency(idempotencyRepo, config.Idempotency.TTL),
+		Authenticator: db.CreateAuthenticator(config.Auth, authRepo),
+
+		RequestTimeout: config.HTTP.RequestTimeout,
+		ReadinessChecks: []app.HealthCheck{
+			app.DatabaseCheck(database),
+			app.SchemaCheck(database),
+			app.WorkerCheck("outboxRelay", relay),
+			app.WorkerCheck("webhookWorker", webhookWorker),
+		},
+		ReadinessTimeout: config.HTTP.ReadinessTimeout,
 	}
 
 	app.SetupRouter()
-	log.Printf("Starting accounts microservice on port %d", port)
-	log.Fatal(http.ListenAndServe(":4200", app.Router))
+
+	server := db.CreateServer(config.HTTP, app.Router)
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting accounts microservice on %s", config.HTTP.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		log.Fatalf("Server failed: %s", err.Error())
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	//stop accepting connections and let in-flight requests finish within the drain timeout
+	ctx, cancel := context.WithTimeout(context.Background(), config.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Draining requests failed: %s", err.Error())
+	}
+	if err := app.WaitBackground(ctx); err != nil {
+		log.Printf("Waiting for background work failed: %s", err.Error())
+	}
+
+	//the relay stops first and publishes the events written by the drained requests, then the webhook worker
+	//stops; the deliveries it did not get to are stored and sent after the next start
+	relay.Stop()
+	webhookWorker.Stop()
+	if cluster.Running() {
+		cluster.Stop()
+	}
+
+	for _, repository := range repositories {
+		if err := repository.Close(); err != nil {
+			log.Printf("Closing prepared statements failed: %s", err.Error())
+		}
+	}
+	if err := cluster.Close(); err != nil {
+		log.Printf("Closing the database failed: %s", err.Error())
+	}
+	if closer, ok := spanExporter.(io.Closer); ok {
+		closer.Close()
+	}
+	log.Printf("Accounts microservice stopped")
 }